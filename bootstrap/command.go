@@ -27,6 +27,7 @@ import (
 	"strings"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/pathtools"
 	"github.com/google/blueprint/proptools"
 )
 
@@ -44,6 +45,13 @@ type Args struct {
 	// Debug data json file
 	ModuleDebugFile         string
 	IncrementalBuildActions bool
+
+	// GlobListFile, if set, is the file that the results of all globs performed with GlobWithDeps
+	// are written to.  It is only rewritten when the results change, so it can be used as a restat
+	// dependency.  The file itself and the directories searched by the globs are added to the
+	// returned dependencies so that the primary builder reruns when a matching file is added or
+	// removed, or when the file is deleted.
+	GlobListFile string
 }
 
 // RegisterGoModuleTypes adds module types to build tools written in golang
@@ -152,6 +160,14 @@ func RunBlueprint(args Args, stopBefore StopBefore, ctx *blueprint.Context, conf
 		ninjaDeps = append(ninjaDeps, buildActionsDeps...)
 	}
 
+	if args.GlobListFile != "" {
+		globDeps, err := writeGlobListFile(ctx, args.GlobListFile)
+		if err != nil {
+			return nil, err
+		}
+		ninjaDeps = append(ninjaDeps, globDeps...)
+	}
+
 	if args.ModuleDebugFile != "" {
 		ctx.GenerateModuleDebugInfo(args.ModuleDebugFile)
 	}
@@ -226,6 +242,18 @@ func RunBlueprint(args Args, stopBefore StopBefore, ctx *blueprint.Context, conf
 	return ninjaDeps, nil
 }
 
+// writeGlobListFile writes the results of all globs performed with GlobWithDeps to globListFile,
+// and returns the ninja dependencies of the globs and globListFile itself.
+func writeGlobListFile(ctx *blueprint.Context, globListFile string) ([]string, error) {
+	globs := ctx.Globs()
+	err := pathtools.WriteFileIfChanged(blueprint.JoinPath(ctx.SrcDir(), globListFile),
+		globs.FileList(), blueprint.OutFilePermissions)
+	if err != nil {
+		return nil, fmt.Errorf("error writing glob list file: %s", err)
+	}
+	return append([]string{globListFile}, globs.Deps()...), nil
+}
+
 func colorizeErrs(errs []error) error {
 	red := "\x1b[31m"
 	unred := "\x1b[0m"
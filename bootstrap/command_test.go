@@ -0,0 +1,95 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/blueprint"
+)
+
+type globTestModule struct {
+	blueprint.SimpleName
+	properties struct {
+		Glob string
+	}
+}
+
+func newGlobTestModule() (blueprint.Module, []interface{}) {
+	m := &globTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *globTestModule) GenerateBuildActions(ctx blueprint.ModuleContext) {
+	if _, err := ctx.GlobWithDeps(m.properties.Glob, nil); err != nil {
+		ctx.ModuleErrorf("%s", err)
+	}
+}
+
+func TestWriteGlobListFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Android.bp": `
+			glob_test {
+				name: "A",
+				glob: "src/*.go",
+			}
+		`,
+		"src/a.go": "",
+		"src/b.go": "",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := blueprint.NewContext()
+	ctx.SetSrcDir(dir)
+	ctx.RegisterModuleType("glob_test", newGlobTestModule)
+
+	if _, errs := ctx.ParseFileList(".", []string{"Android.bp"}, nil); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+	if _, errs := ctx.ResolveDependencies(nil); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+	if _, errs := ctx.PrepareBuildActions(nil); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	deps, err := writeGlobListFile(ctx, "globs.list")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if g, w := deps, []string{"globs.list", "src"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected deps %q, got %q", w, g)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dir, "globs.list"))
+	if err != nil {
+		t.Fatalf("unexpected error reading glob list file: %s", err)
+	}
+	if g, w := string(contents), `[["src/a.go","src/b.go"]]`; g != w {
+		t.Errorf("expected glob list file contents %q, got %q", w, g)
+	}
+}
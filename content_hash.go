@@ -0,0 +1,108 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/blueprint/proptools"
+)
+
+type moduleContentHashInput struct {
+	Type           string
+	Name           string
+	Variant        string
+	PropertiesHash uint64
+	OutputFiles    map[string][]string
+	Deps           []moduleContentHashDep
+}
+
+type moduleContentHashDep struct {
+	TagType string
+	TagHash uint64
+	Hash    [32]byte
+}
+
+// ModuleContentHash returns a hash of everything that affects the build actions of a module variant: its type,
+// name and variant, the values of its properties after the mutators have run, the output files it declared with
+// ModuleContext.AddOutputFiles, and the tag and content hash of each of its direct dependencies, so that a change
+// to any transitive dependency changes the hash.  The hash is stable across runs of the same build.
+//
+// It is only valid once GenerateBuildActions has been called on the module and all of its transitive
+// dependencies, and returns an error otherwise.  It also returns an error if the properties of the module or one
+// of its dependencies, or one of the dependency tags, can't be hashed by proptools.CalculateHash.
+func (c *Context) ModuleContentHash(logicModule Module) ([32]byte, error) {
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		return [32]byte{}, fmt.Errorf("ModuleContentHash called on unknown module %v", logicModule)
+	}
+	return c.moduleContentHash(module, make(map[*moduleInfo][32]byte))
+}
+
+// moduleContentHash computes the hash for ModuleContentHash, reusing the hashes of modules already in hashes.
+// The dependency graph is acyclic after ResolveDependencies, so the recursion visits the dependencies of each
+// module before the module itself.
+func (c *Context) moduleContentHash(module *moduleInfo, hashes map[*moduleInfo][32]byte) ([32]byte, error) {
+	if hash, ok := hashes[module]; ok {
+		return hash, nil
+	}
+
+	if !module.finishedGenerateBuildActions {
+		return [32]byte{}, fmt.Errorf("content hash of module %q variant %q requested before its build actions "+
+			"were generated", module.Name(), module.variant.name)
+	}
+
+	propertiesHash, err := proptools.CalculateHash(module.properties)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("failed to hash properties of module %q variant %q: %w",
+			module.Name(), module.variant.name, err)
+	}
+
+	input := moduleContentHashInput{
+		Type:           module.typeName,
+		Name:           module.Name(),
+		Variant:        module.variant.name,
+		PropertiesHash: propertiesHash,
+		OutputFiles:    module.outputFiles,
+	}
+
+	for _, dep := range module.directDeps {
+		depHash, err := c.moduleContentHash(dep.module, hashes)
+		if err != nil {
+			return [32]byte{}, err
+		}
+		tagHash, err := proptools.CalculateHash(dep.tag)
+		if err != nil {
+			return [32]byte{}, fmt.Errorf("failed to hash dependency tag %T of module %q variant %q: %w",
+				dep.tag, module.Name(), module.variant.name, err)
+		}
+		input.Deps = append(input.Deps, moduleContentHashDep{
+			TagType: fmt.Sprintf("%T", dep.tag),
+			TagHash: tagHash,
+			Hash:    depHash,
+		})
+	}
+
+	// encoding/json writes map keys in sorted order, so the encoding is deterministic.
+	data, err := json.Marshal(input)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	hash := sha256.Sum256(data)
+	hashes[module] = hash
+	return hash, nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestModuleContentHash(t *testing.T) {
+	const bp = `
+		foo_module {
+			name: "A",
+			deps: ["B"],
+		}
+
+		foo_module {
+			name: "B",
+			deps: ["C"],
+		}
+
+		foo_module {
+			name: "C",
+			%s
+		}
+
+		foo_module {
+			name: "D",
+		}
+	`
+
+	run := func(t *testing.T, cProps string, prepare bool) *Context {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterBottomUpMutator("deps", depsMutator)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(fmt.Sprintf(bp, cProps)),
+		})
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies(nil)
+		assertNoErrors(t, errs)
+		if prepare {
+			_, errs = ctx.PrepareBuildActions(nil)
+			assertNoErrors(t, errs)
+		}
+		return ctx
+	}
+
+	hashes := func(t *testing.T, ctx *Context) map[string][32]byte {
+		t.Helper()
+		ret := make(map[string][32]byte)
+		for _, name := range []string{"A", "B", "C", "D"} {
+			hash, err := ctx.ModuleContentHash(ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule)
+			if err != nil {
+				t.Fatalf("unexpected error hashing %q: %s", name, err)
+			}
+			ret[name] = hash
+		}
+		return ret
+	}
+
+	base := hashes(t, run(t, "", true))
+	same := hashes(t, run(t, "", true))
+	changed := hashes(t, run(t, `ignored_deps: ["D"],`, true))
+
+	for _, name := range []string{"A", "B", "C", "D"} {
+		if base[name] != same[name] {
+			t.Errorf("expected hash of %q to be stable, got %x and %x", name, base[name], same[name])
+		}
+	}
+	for _, name := range []string{"A", "B", "C"} {
+		if base[name] == changed[name] {
+			t.Errorf("expected hash of %q to change when C changed", name)
+		}
+	}
+	if base["D"] != changed["D"] {
+		t.Errorf("expected hash of D to not change when C changed")
+	}
+	if base["A"] == base["B"] {
+		t.Errorf("expected different modules to have different hashes")
+	}
+
+	ctx := run(t, "", false)
+	_, err := ctx.ModuleContentHash(ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule)
+	if g, w := fmt.Sprint(err), `content hash of module "A" variant "" requested before its build actions were generated`; g != w {
+		t.Errorf("expected error %q, got %q", w, g)
+	}
+}
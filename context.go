@@ -32,6 +32,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"runtime"
 	"runtime/pprof"
 	"slices"
@@ -104,6 +105,11 @@ type Context struct {
 
 	transitionMutators []*transitionMutatorImpl
 
+	// renamedModules maps the original names of modules renamed with
+	// BottomUpMutatorContext.RenameModule to the renamed module groups, so that dependencies can
+	// still be added using the original names.
+	renamedModules map[string][]*moduleGroup
+
 	needsUpdateDependencies uint32 // positive if a mutator modified the dependencies
 
 	dependenciesReady bool // set to true on a successful ResolveDependencies
@@ -115,6 +121,95 @@ type Context struct {
 	// set by SetAllowMissingDependencies
 	allowMissingDependencies bool
 
+	// set by SetAllowEmptyPathGlobs
+	allowEmptyPathGlobs bool
+
+	// set by SetErrorOnDuplicateOutputs
+	errorOnDuplicateOutputs bool
+
+	// set by SetCheckRuleArgs
+	checkRuleArgs bool
+
+	// set by SetInternRules
+	internRules bool
+
+	// set by SetErrorOnAmbiguousFarDependencies
+	errorOnAmbiguousFarDependencies bool
+
+	// set by SetStringInterpolation
+	stringInterpolation bool
+
+	// set by SetVariantSeparator, "_" if empty
+	variantSeparator string
+
+	// set by SetDependencyPathRoot
+	dependencyPathRoot string
+
+	// set by DisableMutators
+	disabledMutators map[string]bool
+
+	// set by SnapshotAfterMutator
+	graphSnapshots map[string]*GraphSnapshot
+
+	// set by SetDeterministicDependencyOrder
+	deterministicDependencyOrder bool
+
+	// set by SetModuleVisitOrder
+	moduleVisitOrder ModuleVisitOrder
+
+	// set by SetParallelGenerateBuildActions
+	parallelGenerateBuildActions bool
+
+	// set by SetIncludeDisabledModules
+	includeDisabledModules bool
+
+	// set by SetDependencyNameResolver
+	dependencyNameResolver func(from Module, name string) string
+
+	// set by RegisterDependencyTag
+	dependencyTagNames   map[DependencyTag]string
+	dependencyTagsByName map[string]DependencyTag
+
+	// set by SetNinjaVariable
+	ninjaVariables *basicScope
+
+	// warnings found while parsing the Blueprints files, returned by Warnings
+	warnings     []error
+	warningsLock sync.Mutex
+
+	// the variables assigned in the Blueprints files, used by UnusedVariables
+	variables     []*parser.Assignment
+	variablesLock sync.Mutex
+
+	// set by SetModuleActionsWriter
+	moduleActionsWriter    StringWriterWriter
+	moduleActionsNinjaFile string
+	moduleActionsLock      sync.Mutex
+
+	// parsedFiles holds the result of parsing each Blueprints file, indexed by the path it was
+	// parsed from, so that Reparse only needs to parse the files that changed.  It is nil unless
+	// SetRetainParsedFiles was called.
+	parsedFiles     map[string]*parsedFile
+	parsedFilesLock sync.Mutex
+
+	// set by parseFileList, the arguments of the most recent parse for Reparse
+	lastParse *parseArgs
+
+	// set by parseFileList, the Blueprints files and directories the most recent parse depended on
+	parseDeps []string
+
+	// set by AddManifestRegenerationRule
+	manifestRegeneration *manifestRegeneration
+
+	// set by ModuleContext.Rule if SetInternRules was called, indexed by localRule.internKey, and reset
+	// by each PrepareBuildActions
+	internedRules     map[string]*localRule
+	internedRulesLock sync.Mutex
+
+	// set by ModuleContext.Pool, indexed by name
+	sharedPools     map[string]*sharedPool
+	sharedPoolsLock sync.Mutex
+
 	// set during PrepareBuildActions
 	nameTracker     *nameTracker
 	liveGlobals     *liveTracker
@@ -175,6 +270,15 @@ type Context struct {
 	// latter will depend on the flag above.
 	incrementalEnabled bool
 
+	// set by TraceModule
+	moduleTraces map[string]*ModuleTrace
+
+	// set by SetMutatorTraceHook
+	mutatorTraceHook func(mutatorName string, m Module, phase Phase)
+
+	// set during ResolveDependencies, used by ExplainFarMatch and BaseModuleContext
+	resolvedConfig interface{}
+
 	buildActionsToCache       BuildActionCache
 	buildActionsToCacheLock   sync.Mutex
 	buildActionsFromCache     BuildActionCache
@@ -275,6 +379,33 @@ type PropertyError struct {
 	property string
 }
 
+// A MissingVariantError describes a dependency on a module that exists but has no variant that
+// matches the requested variations.  The Requested and Available fields allow tools to offer
+// suggestions without parsing the error text.
+type MissingVariantError struct {
+	BlueprintError
+
+	// DepName is the name of the module that was depended on.
+	DepName string
+
+	// Requested is the variant that was requested, sorted in mutator registration order.
+	Requested []Variation
+
+	// Available is the list of variants of the dependency that exist, in variant order.  Each
+	// variant is sorted in mutator registration order.
+	Available [][]Variation
+}
+
+// Suggestions returns the available variants whose printed form is closest by edit distance
+// to the printed form of the requested variant, for use in "did you mean" messages.
+func (e *MissingVariantError) Suggestions() []string {
+	available := make([]string, 0, len(e.Available))
+	for _, variant := range e.Available {
+		available = append(available, prettyPrintVariations(variant))
+	}
+	return variantsLike(prettyPrintVariations(e.Requested), available)
+}
+
 func (e *BlueprintError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Pos, e.Err)
 }
@@ -316,6 +447,30 @@ type moduleGroup struct {
 	modules moduleList
 
 	namespace Namespace
+
+	// hasOrderOnlyDependents is set after the mutators have run if any module has an order-only
+	// dependency on this module.
+	hasOrderOnlyDependents bool
+
+	// variationAliases maps the alias variations created with
+	// BottomUpMutatorContext.CreateAliasVariation to the variations they alias, indexed by the name
+	// of the transition mutator.  It is protected by variationAliasesLock.
+	variationAliases     map[string]map[string]string
+	variationAliasesLock sync.Mutex
+}
+
+// applyVariationAliases replaces any variation in variant that is an alias created with
+// BottomUpMutatorContext.CreateAliasVariation with the variation it aliases.  It may modify variant.
+func (group *moduleGroup) applyVariationAliases(variant variationMap) variationMap {
+	group.variationAliasesLock.Lock()
+	defer group.variationAliasesLock.Unlock()
+
+	for mutator, aliases := range group.variationAliases {
+		if to, ok := aliases[variant.get(mutator)]; ok {
+			variant.set(mutator, to)
+		}
+	}
+	return variant
 }
 
 func (group *moduleGroup) moduleByVariantName(name string) *moduleInfo {
@@ -351,6 +506,11 @@ type moduleInfo struct {
 	forwardDeps []*moduleInfo
 	directDeps  []depInfo
 
+	// inDegree is the number of direct dependencies onto this module from other modules, which is
+	// reverseDeps without the implicit dependencies from the later variants of the same module.  It
+	// is set during updateDependencies.
+	inDegree int
+
 	// used by parallelVisit
 	waitingCount int
 
@@ -366,9 +526,59 @@ type moduleInfo struct {
 	// outgoingTransitionCache stores the final variation for each dependency, indexed by the source variation
 	// index in transitionVariations and then by the index of the dependency in directDeps
 	outgoingTransitionCache [][]string
+	// outgoingVariationCache stores the variation returned by OutgoingTransition for each dependency, indexed
+	// like outgoingTransitionCache
+	outgoingVariationCache [][]string
+
+	// markedVariations holds the variations marked as required by BottomUpMutatorContext.RequireVariation,
+	// indexed by the name of the transition mutator.  It is protected by requiredVariationsLock and may be
+	// shared with other variants of the module, so it is copied before it is modified.
+	markedVariations map[string][]string
+
+	// splitData stores the data attached to this variant by TransitionMutatorWithData.SplitWithData,
+	// indexed by the name of the transition mutator.
+	splitData map[string]any
+
+	// skippedMutators holds the names of the mutators that will not be run on this module, set by
+	// BottomUpMutatorContext.SkipMutator or when the module is disabled before a transition mutator.
+	skippedMutators map[string]bool
+
+	// disabled is set at the start of PrepareBuildActions if the module is an EnableableModule that
+	// is not enabled.
+	disabled bool
+
+	// metadata holds the values set by BaseModuleContext.SetModuleMetadata.
+	metadata map[string]string
+
+	// outputFiles holds the paths added by ModuleContext.AddOutputFiles, keyed by tag.
+	outputFiles map[string][]string
+
+	// lateDeps holds the dependencies added by BottomUpMutatorContext.AddLateVariationDependencies
+	// until they are resolved after all mutators have run.
+	lateDeps []lateDependency
+
+	// orderOnlyDepNames holds the names passed to BottomUpMutatorContext.AddOrderOnlyDependency until
+	// they are resolved into orderOnlyDeps after all mutators have run.
+	orderOnlyDepNames []string
+	orderOnlyDeps     []*moduleGroup
+
+	// orderOnlyOutputs and orderOnlyOutputStrings hold the outputs of the build statements of the
+	// module that are added to the phony target for the order-only dependencies on its group.
+	orderOnlyOutputs       []*ninjaString
+	orderOnlyOutputStrings []string
+
+	// transitionErrs counts the errors reported by OutgoingTransition and IncomingTransition for
+	// the dependencies of the module while the current mutator runs on it.
+	transitionErrs int
 
 	// set during PrepareBuildActions
 	actionDefs localBuildActions
+	phonys     map[string][]string
+
+	// outputPaths holds the outputs of the build statements of the module for
+	// checkDuplicateOutputs, as actionDefs is cleared when the module is streamed to the
+	// module actions writer.
+	outputPaths []string
 
 	providers                  []interface{}
 	providerInitialValueHashes []uint64
@@ -396,6 +606,10 @@ type variant struct {
 type depInfo struct {
 	module *moduleInfo
 	tag    DependencyTag
+
+	// transitions records how each transition mutator that has run chose the variation of module,
+	// reported by WalkDepsWithTransitionInfo.
+	transitions []EdgeTransition
 }
 
 func (module *moduleInfo) Name() string {
@@ -507,6 +721,16 @@ func (vm variationMap) delete(mutator string) {
 	delete(vm.variations, mutator)
 }
 
+// skipped returns true if an incoming transition dropped the dependency on this variant.
+func (vm variationMap) skipped() bool {
+	for _, variation := range vm.variations {
+		if variation == skippedVariation {
+			return true
+		}
+	}
+	return false
+}
+
 func (vm variationMap) empty() bool {
 	return len(vm.variations) == 0
 }
@@ -529,6 +753,7 @@ type singletonInfo struct {
 	singleton Singleton
 	name      string
 	parallel  bool
+	after     []string
 
 	// set during PrepareBuildActions
 	actionDefs localBuildActions
@@ -542,6 +767,12 @@ type mutatorInfo struct {
 	index             int
 	transitionMutator *transitionMutatorImpl
 
+	// set on the top down mutator that propagates the variations of a transition mutator
+	propagatesTransitionMutator *transitionMutatorImpl
+
+	// set on the bottom up mutator that calls Mutate for a transition mutator
+	mutatesTransitionMutator *transitionMutatorImpl
+
 	usesRename              bool
 	usesReverseDependencies bool
 	usesReplaceDependencies bool
@@ -549,6 +780,14 @@ type mutatorInfo struct {
 	mutatesDependencies     bool
 	mutatesGlobalState      bool
 	neverFar                bool
+
+	// moduleTypes restricts the mutator to modules whose logic module has one of these types or
+	// implements one of these interface types.  If it is empty the mutator visits every module.
+	moduleTypes []reflect.Type
+
+	// neverFarFor restricts neverFar to far variation dependencies that only request variations
+	// for these mutators.  If it is nil neverFar applies to all far variation dependencies.
+	neverFarFor []string
 }
 
 func newContext() *Context {
@@ -619,6 +858,16 @@ type ModuleFactory func() (m Module, propertyStructs []interface{})
 // field in the returned module properties struct result in an error during the
 // Context's parse phase.
 //
+// The values of string and []string fields tagged `blueprint:"path"` are paths
+// relative to the directory of the Blueprints file.  They are checked during the
+// parse phase to be inside the source tree and, unless they contain a glob
+// pattern, to exist.  A path that doesn't exist is reported as a warning instead
+// of an error if SetAllowMissingDependencies was called.  In a []string field,
+// entries with a glob pattern are replaced by the sorted list of files they
+// match, and entries starting with "!" exclude the paths they match from the
+// rest of the list.  A glob that matches no files is an error unless
+// SetAllowEmptyPathGlobs was called.
+//
 // As an example, the follow code:
 //
 //	type myModule struct {
@@ -650,13 +899,111 @@ type ModuleFactory func() (m Module, propertyStructs []interface{})
 //
 // The factory function may be called from multiple goroutines.  Any accesses
 // to global variables must be synchronized.
+//
+// RegisterModuleType calls the factory once to check for property names that
+// are declared by two fields of the same property struct, for example when a
+// field of an embedded struct has the same name as a field of the embedding
+// struct, or by fields of different property structs with incompatible types,
+// and panics if it finds one.  A property may otherwise be declared in multiple
+// property structs, in which case its value is set in all of them.
 func (c *Context) RegisterModuleType(name string, factory ModuleFactory) {
 	if _, present := c.moduleFactories[name]; present {
 		panic(fmt.Errorf("module type %q is already registered", name))
 	}
+	if err := checkDuplicateProperties(factory); err != nil {
+		panic(fmt.Errorf("module type %q: %s", name, err))
+	}
 	c.moduleFactories[name] = factory
 }
 
+// checkDuplicateProperties returns an error if a property is declared twice in one of the property
+// structs returned by factory, or in two of them with incompatible types.  Nested structs with the
+// same name are allowed, as their properties are merged, but their fields are checked.
+func checkDuplicateProperties(factory ModuleFactory) error {
+	type declaration struct {
+		field        string
+		propertyType reflect.Type
+		structIndex  int
+	}
+	declared := make(map[string]declaration)
+
+	// propertyType returns the type that the unpacker sets for a field of the given type, or nil
+	// if values of any type may be compatible with it.
+	propertyType := func(t reflect.Type) reflect.Type {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if proptools.IsConfigurable(t) || t.Kind() == reflect.Interface {
+			return nil
+		}
+		return t
+	}
+	isStruct := func(t reflect.Type) bool {
+		return t != nil && t.Kind() == reflect.Struct
+	}
+
+	var walk func(structType reflect.Type, structIndex int, owner, fieldPrefix, prefix string) error
+	walk = func(structType reflect.Type, structIndex int, owner, fieldPrefix, prefix string) error {
+		for i := 0; i < structType.NumField(); i++ {
+			field := structType.Field(i)
+			if proptools.ShouldSkipProperty(field) {
+				continue
+			}
+
+			fieldName := fieldPrefix + field.Name
+			fieldType := propertyType(field.Type)
+			name := prefix
+			if !proptools.IsEmbedded(field) {
+				name += proptools.PropertyNameForField(field.Name)
+
+				prev, ok := declared[name]
+				switch {
+				case !ok:
+					declared[name] = declaration{owner + "." + fieldName, fieldType, structIndex}
+				case isStruct(prev.propertyType) && isStruct(fieldType):
+					// Nested property structs are merged.
+				case prev.structIndex == structIndex:
+					return fmt.Errorf("property %q is declared by both %s and %s.%s",
+						name, prev.field, owner, fieldName)
+				case prev.propertyType != nil && fieldType != nil && prev.propertyType != fieldType:
+					return fmt.Errorf("property %q is declared by both %s (%s) and %s.%s (%s) with incompatible types",
+						name, prev.field, prev.propertyType, owner, fieldName, fieldType)
+				}
+			}
+
+			if isStruct(fieldType) {
+				nestedPrefix := name
+				if !proptools.IsEmbedded(field) {
+					nestedPrefix += "."
+				}
+				if err := walk(fieldType, structIndex, owner, fieldName+".", nestedPrefix); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	_, properties := factory()
+	for i, p := range properties {
+		structType := reflect.TypeOf(p)
+		if structType != nil && structType.Kind() == reflect.Ptr {
+			structType = structType.Elem()
+		}
+		if structType == nil || structType.Kind() != reflect.Struct {
+			continue
+		}
+		owner := structType.String()
+		if structType.Name() == "" {
+			owner = fmt.Sprintf("property struct %d", i)
+		}
+		if err := walk(structType, i, owner, "", ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // A SingletonFactory function creates a new Singleton object.  See the
 // Context.RegisterSingletonType method for details about how a registered
 // SingletonFactory is used by a Context.
@@ -672,25 +1019,126 @@ type SingletonFactory func() Singleton
 // The singleton type names given here must be unique for the context.  The
 // factory function should be a named function so that its package and name can
 // be included in the generated Ninja file for debugging purposes.
-func (c *Context) RegisterSingletonType(name string, factory SingletonFactory, parallel bool) {
+//
+// The optional order arguments list mutators that must have finished before the
+// singleton runs.  They are validated by PrepareBuildActions, which returns an
+// error if a listed mutator is not registered.
+func (c *Context) RegisterSingletonType(name string, factory SingletonFactory, parallel bool,
+	order ...SingletonOrder) {
 	for _, s := range c.singletonInfo {
 		if s.name == name {
 			panic(fmt.Errorf("singleton %q is already registered", name))
 		}
 	}
 
+	var after []string
+	for _, o := range order {
+		after = append(after, o.After...)
+	}
+
 	c.singletonInfo = append(c.singletonInfo, &singletonInfo{
 		factory:   factory,
 		singleton: factory(),
 		name:      name,
 		parallel:  parallel,
+		after:     after,
 	})
 }
 
+// RegisterDependencyTag associates a name with a dependency tag, so that dependencies added with
+// the tag can be identified by name in the JSON module graph and selected with
+// VisitDirectDepsWithTagName.  It takes precedence over the name returned by a tag that
+// implements NamedDependencyTag.  The tag must be comparable, and each name and tag can only be
+// registered once.
+func (c *Context) RegisterDependencyTag(name string, tag DependencyTag) {
+	if !reflect.TypeOf(tag).Comparable() {
+		panic(fmt.Errorf("dependency tag %q of type %T is not comparable", name, tag))
+	}
+	if _, exists := c.dependencyTagsByName[name]; exists {
+		panic(fmt.Errorf("dependency tag %q is already registered", name))
+	}
+	if existing, exists := c.dependencyTagNames[tag]; exists {
+		panic(fmt.Errorf("dependency tag %#v is already registered as %q", tag, existing))
+	}
+	if c.dependencyTagNames == nil {
+		c.dependencyTagNames = make(map[DependencyTag]string)
+		c.dependencyTagsByName = make(map[string]DependencyTag)
+	}
+	c.dependencyTagNames[tag] = name
+	c.dependencyTagsByName[name] = tag
+}
+
+// DependencyTagName returns the name of a dependency tag, either registered with
+// RegisterDependencyTag or returned by its Name method if it implements NamedDependencyTag.  It
+// returns an empty string if the tag has no name.
+func (c *Context) DependencyTagName(tag DependencyTag) string {
+	if tag == nil {
+		return ""
+	}
+	if reflect.TypeOf(tag).Comparable() {
+		if name, ok := c.dependencyTagNames[tag]; ok {
+			return name
+		}
+	}
+	if named, ok := tag.(NamedDependencyTag); ok {
+		return named.Name()
+	}
+	return ""
+}
+
+// DependencyTagByName returns the dependency tag registered with RegisterDependencyTag under
+// name, or false if there is none.
+func (c *Context) DependencyTagByName(name string) (DependencyTag, bool) {
+	tag, ok := c.dependencyTagsByName[name]
+	return tag, ok
+}
+
+// SingletonOrder describes ordering requirements for a singleton type passed to
+// RegisterSingletonType.
+type SingletonOrder struct {
+	// After lists the names of mutators that must have finished running on all
+	// modules before the singleton runs.  For a TransitionMutator this is the
+	// name it was registered with, and guarantees that the variants it created
+	// are visible to the singleton.
+	After []string
+}
+
+// validateSingletonOrder returns an error for each mutator listed in a singleton's
+// SingletonOrder that is not registered.  Singletons only run after ResolveDependencies has
+// succeeded, at which point every registered mutator has finished.
+func (c *Context) validateSingletonOrder() []error {
+	var errs []error
+	for _, info := range c.singletonInfo {
+		for _, name := range info.after {
+			if !slices.ContainsFunc(c.mutatorInfo, func(m *mutatorInfo) bool { return m.name == name }) {
+				errs = append(errs, fmt.Errorf("singleton %q must run after mutator %q, which is not registered",
+					info.name, name))
+			}
+		}
+	}
+	return errs
+}
+
 func (c *Context) SetNameInterface(i NameInterface) {
 	c.nameInterface = i
 }
 
+// RegisterNamespace makes the modules defined in Blueprints files in dir, a path relative to the
+// source directory, and its subdirectories part of a namespace whose modules are visible to the
+// modules in the namespaces listed in imports.  See SimpleNameInterface.RegisterNamespace for how
+// dependencies are resolved.  It must be called before the Blueprints files are parsed, and is not
+// supported with a custom NameInterface.
+func (c *Context) RegisterNamespace(dir string, imports ...string) error {
+	s, ok := c.nameInterface.(*SimpleNameInterface)
+	if !ok {
+		return fmt.Errorf("RegisterNamespace is not supported with a custom NameInterface")
+	}
+	if len(c.moduleGroups) > 0 {
+		return fmt.Errorf("RegisterNamespace called after parsing Blueprints files")
+	}
+	return s.RegisterNamespace(dir, imports)
+}
+
 func (c *Context) SetIncrementalAnalysis(incremental bool) {
 	c.incrementalAnalysis = incremental
 }
@@ -793,6 +1241,14 @@ func singletonTypeName(singleton Singleton) string {
 // The mutator type names given here must be unique to all top down mutators in
 // the Context.
 //
+// A TopDownMutator registered after a TransitionMutator runs on the variants created by it, and
+// VisitDirectDeps visits the variants of the dependencies selected by the transitions, including
+// dependencies added by BottomUpMutators after the TransitionMutator.  Since the invocation on a
+// module returns before any of its dependencies are visited, it can push configuration down into
+// the variants of its dependencies before GenerateBuildActions.  A dependency may be visited by
+// multiple modules in parallel, so any modifications to it must be synchronized, and the mutator
+// should be marked with MutatesDependencies.
+//
 // Returns a MutatorHandle, on which Parallel can be called to set the mutator to visit modules in
 // parallel while maintaining ordering.
 func (c *Context) RegisterTopDownMutator(name string, mutator TopDownMutator) MutatorHandle {
@@ -842,6 +1298,135 @@ func (c *Context) RegisterBottomUpMutator(name string, mutator BottomUpMutator)
 	return info
 }
 
+// DisableMutators prevents the named mutators from running, so they don't split modules into
+// variants or add dependencies, which can be used to bisect a regression to a single mutator.
+// Disabling a transition mutator by its name disables all of the mutators it registers.  A
+// disabled mutator is considered finished by HasMutatorFinished.  ResolveDependencies returns an
+// error for a name that isn't a registered mutator, and reports a warning for each transition
+// mutator that is ordered relative to a disabled one with After or Before.
+//
+// This is a debugging tool, the resulting module graph may be incomplete and fail in later phases.
+func (c *Context) DisableMutators(names ...string) {
+	if c.disabledMutators == nil {
+		c.disabledMutators = make(map[string]bool)
+	}
+	for _, name := range names {
+		c.disabledMutators[name] = true
+	}
+}
+
+// registeredName returns the name that DisableMutators and SnapshotAfterMutator use to refer to
+// the mutator, which is the name of the transition mutator for each of the mutators registered by
+// a transition mutator.
+func (mutator *mutatorInfo) registeredName() string {
+	for _, impl := range []*transitionMutatorImpl{mutator.propagatesTransitionMutator,
+		mutator.transitionMutator, mutator.mutatesTransitionMutator} {
+		if impl != nil {
+			return impl.name
+		}
+	}
+	return mutator.name
+}
+
+// enabledMutators returns the registered mutators that were not disabled with DisableMutators,
+// along with errors for the disabled names that aren't registered mutators.  It reports a
+// warning for each transition mutator that is ordered relative to a disabled mutator.
+func (c *Context) enabledMutators() ([]*mutatorInfo, []error) {
+	if len(c.disabledMutators) == 0 {
+		return c.mutatorInfo, nil
+	}
+
+	var enabled []*mutatorInfo
+	found := make(map[string]bool)
+	var warnings []error
+	for _, mutator := range c.mutatorInfo {
+		name := mutator.registeredName()
+		if c.disabledMutators[name] {
+			found[name] = true
+			continue
+		}
+		enabled = append(enabled, mutator)
+		if impl := mutator.propagatesTransitionMutator; impl != nil {
+			for _, other := range slices.Concat(impl.after, impl.before) {
+				if c.disabledMutators[other] {
+					warnings = append(warnings, fmt.Errorf("transition mutator %q is ordered relative to "+
+						"disabled mutator %q", impl.name, other))
+				}
+			}
+		}
+	}
+	c.addWarnings(warnings)
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(c.disabledMutators)) {
+		if !found[name] {
+			errs = append(errs, fmt.Errorf("cannot disable unknown mutator %q", name))
+		}
+	}
+	return enabled, errs
+}
+
+// MutatorOrderDOT writes a Graphviz DOT graph of the registered mutators to w.  Each bottom-up,
+// top-down and transition mutator is a node, and solid edges connect them in the order they will
+// run.  The constraints added with TransitionMutatorHandle.After and TransitionMutatorHandle.Before
+// are dashed edges from the mutator that must run first, and mutators disabled with
+// DisableMutators are drawn dashed.  It only uses the registered mutators, and can be called
+// before ResolveDependencies.  It returns an error if the ordering constraints can't be satisfied.
+func (c *Context) MutatorOrderDOT(w io.Writer) error {
+	mutators, errs := c.orderedMutators()
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	buf := &strings.Builder{}
+	buf.WriteString("digraph mutators {\n")
+	buf.WriteString("\tnode [shape=box];\n")
+
+	var names []string
+	for _, mutator := range mutators {
+		name := mutator.registeredName()
+		if len(names) > 0 && names[len(names)-1] == name {
+			// The mutators registered by a transition mutator are a single node.
+			continue
+		}
+
+		var kind string
+		switch {
+		case mutator.propagatesTransitionMutator != nil:
+			kind = "transition"
+		case mutator.topDownMutator != nil:
+			kind = "top-down"
+		default:
+			kind = "bottom-up"
+		}
+		style := ""
+		if c.disabledMutators[name] {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(buf, "\t%q [label=%q%s];\n", name, name+"\n"+kind, style)
+		names = append(names, name)
+	}
+
+	for i := 1; i < len(names); i++ {
+		fmt.Fprintf(buf, "\t%q -> %q;\n", names[i-1], names[i])
+	}
+
+	for _, mutator := range mutators {
+		if impl := mutator.propagatesTransitionMutator; impl != nil {
+			for _, after := range impl.after {
+				fmt.Fprintf(buf, "\t%q -> %q [style=dashed, label=\"after\"];\n", after, impl.name)
+			}
+			for _, before := range impl.before {
+				fmt.Fprintf(buf, "\t%q -> %q [style=dashed, label=\"before\"];\n", impl.name, before)
+			}
+		}
+	}
+
+	buf.WriteString("}\n")
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
 // HasMutatorFinished returns true if the given mutator has finished running.
 // It will panic if given an invalid mutator name.
 func (c *Context) HasMutatorFinished(mutatorName string) bool {
@@ -878,8 +1463,17 @@ type MutatorHandle interface {
 	// adjacent mutators into a single mutator pass.
 	MutatesGlobalState() MutatorHandle
 
+	// FilterByType restricts the mutator to modules whose Module is of the given type, or implements
+	// the given interface type.  The mutator is not called at all on other modules, which avoids the
+	// cost of calling it only to have it check the type of the module and return.  Calling it more
+	// than once allows modules of any of the given types.
+	FilterByType(typ reflect.Type) MutatorHandle
+
 	setTransitionMutator(impl *transitionMutatorImpl) MutatorHandle
+	setPropagatesTransitionMutator(impl *transitionMutatorImpl) MutatorHandle
+	setMutatesTransitionMutator(impl *transitionMutatorImpl) MutatorHandle
 	setNeverFar() MutatorHandle
+	setNeverFarFor(mutatorNames []string) MutatorHandle
 }
 
 func (mutator *mutatorInfo) UsesRename() MutatorHandle {
@@ -912,16 +1506,86 @@ func (mutator *mutatorInfo) MutatesGlobalState() MutatorHandle {
 	return mutator
 }
 
+func (mutator *mutatorInfo) FilterByType(typ reflect.Type) MutatorHandle {
+	mutator.moduleTypes = append(mutator.moduleTypes, typ)
+	return mutator
+}
+
+// visits returns true if the mutator should be called on module.
+func (mutator *mutatorInfo) visits(module *moduleInfo) bool {
+	if module.skippedMutators[mutator.skipName()] {
+		return false
+	}
+	if len(mutator.moduleTypes) == 0 {
+		return true
+	}
+	moduleType := reflect.TypeOf(module.logicModule)
+	for _, typ := range mutator.moduleTypes {
+		if moduleType == typ || (typ.Kind() == reflect.Interface && moduleType.Implements(typ)) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipName returns the name that BottomUpMutatorContext.SkipMutator uses to refer to the mutator,
+// which is the name of the transition mutator for each of the mutators that implement it.
+func (mutator *mutatorInfo) skipName() string {
+	switch {
+	case mutator.transitionMutator != nil:
+		return mutator.transitionMutator.name
+	case mutator.propagatesTransitionMutator != nil:
+		return mutator.propagatesTransitionMutator.name
+	case mutator.mutatesTransitionMutator != nil:
+		return mutator.mutatesTransitionMutator.name
+	}
+	return mutator.name
+}
+
+func (mutator *mutatorInfo) setMutatesTransitionMutator(impl *transitionMutatorImpl) MutatorHandle {
+	mutator.mutatesTransitionMutator = impl
+	return mutator
+}
+
 func (mutator *mutatorInfo) setTransitionMutator(impl *transitionMutatorImpl) MutatorHandle {
 	mutator.transitionMutator = impl
 	return mutator
 }
 
+func (mutator *mutatorInfo) setPropagatesTransitionMutator(impl *transitionMutatorImpl) MutatorHandle {
+	mutator.propagatesTransitionMutator = impl
+	return mutator
+}
+
 func (mutator *mutatorInfo) setNeverFar() MutatorHandle {
 	mutator.neverFar = true
+	mutator.neverFarFor = nil
+	return mutator
+}
+
+func (mutator *mutatorInfo) setNeverFarFor(mutatorNames []string) MutatorHandle {
+	mutator.neverFar = true
+	mutator.neverFarFor = slices.Clone(mutatorNames)
 	return mutator
 }
 
+// isNeverFarFor returns true if the variations of this mutator should be kept when adding a far
+// variation dependency that requests the given variations.
+func (mutator *mutatorInfo) isNeverFarFor(requestedVariations []Variation) bool {
+	if !mutator.neverFar {
+		return false
+	}
+	if mutator.neverFarFor == nil {
+		return true
+	}
+	for _, v := range requestedVariations {
+		if !slices.Contains(mutator.neverFarFor, v.Mutator) {
+			return false
+		}
+	}
+	return true
+}
+
 // SetIgnoreUnknownModuleTypes sets the behavior of the context in the case
 // where it encounters an unknown module type while parsing Blueprints files. By
 // default, the context will report unknown module types as an error.  If this
@@ -942,43 +1606,278 @@ func (c *Context) SetAllowMissingDependencies(allowMissingDependencies bool) {
 	c.allowMissingDependencies = allowMissingDependencies
 }
 
-func (c *Context) SetModuleListFile(listFile string) {
-	c.moduleListFile = listFile
+// SetAllowEmptyPathGlobs changes the behavior of Blueprint to allow a glob in
+// a []string property tagged `blueprint:"path"` to match no files instead of
+// reporting an error.
+func (c *Context) SetAllowEmptyPathGlobs(allowEmptyPathGlobs bool) {
+	c.allowEmptyPathGlobs = allowEmptyPathGlobs
 }
 
-func (c *Context) ListModulePaths(baseDir string) (paths []string, err error) {
-	reader, err := c.fs.Open(c.moduleListFile)
-	if err != nil {
-		return nil, err
-	}
-	defer reader.Close()
-	bytes, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return nil, err
-	}
-	text := string(bytes)
+// SetStringInterpolation changes the behavior of Blueprint to replace "${name}" in string literals in
+// Blueprints files with the value of the string variable name, and "$${" with a literal "${".  It is
+// off by default, so strings that contain "${" to reference ninja variables are unmodified.  It must be
+// called before parsing.
+func (c *Context) SetStringInterpolation(stringInterpolation bool) {
+	c.stringInterpolation = stringInterpolation
+}
 
-	text = strings.Trim(text, "\n")
-	lines := strings.Split(text, "\n")
-	for i := range lines {
-		lines[i] = filepath.Join(baseDir, lines[i])
+// newRootScope returns the scope for Blueprints files that have no parent.
+func (c *Context) newRootScope() *parser.Scope {
+	scope := parser.NewScope(nil)
+	if c.stringInterpolation {
+		scope.EnableStringInterpolation()
 	}
+	return scope
+}
 
-	return lines, nil
+// SetErrorOnAmbiguousFarDependencies changes the behavior of Blueprint to report
+// an error when a far variation dependency that requests some variations matches
+// several variants equally well, instead of using the first of them.
+func (c *Context) SetErrorOnAmbiguousFarDependencies(errorOnAmbiguousFarDependencies bool) {
+	c.errorOnAmbiguousFarDependencies = errorOnAmbiguousFarDependencies
 }
 
-// a fileParseContext tells the status of parsing a particular file
-type fileParseContext struct {
-	// name of file
-	fileName string
+// SetCheckRuleArgs changes the behavior of Blueprint to report an error from
+// PrepareBuildActions when a build statement doesn't set an argument of its rule
+// that is referenced by the rule's command.  Ninja expands unset arguments to
+// an empty string, so rules may have optional arguments, but an argument that
+// is always expected to be set can be forgotten silently.
+func (c *Context) SetCheckRuleArgs(checkRuleArgs bool) {
+	c.checkRuleArgs = checkRuleArgs
+}
 
-	// scope to use when resolving variables
-	Scope *parser.Scope
+// SetInternRules changes the behavior of Blueprint to share the rules created by
+// ModuleContext.Rule in different modules that have the same name and definition,
+// so that they are written to the ninja file once.  Without it every module
+// writes its own copy of each of its rules.
+func (c *Context) SetInternRules(internRules bool) {
+	c.internRules = internRules
+}
 
-	// pointer to the one in the parent directory
-	parent *fileParseContext
+// SetErrorOnDuplicateOutputs changes the behavior of Blueprint to report an
+// error from PrepareBuildActions when two build statements in modules or
+// singletons declare the same output path, either as an output or an implicit
+// output.  Paths that reference ninja variables are compared before the
+// variables are expanded.
+func (c *Context) SetErrorOnDuplicateOutputs(errorOnDuplicateOutputs bool) {
+	c.errorOnDuplicateOutputs = errorOnDuplicateOutputs
+}
 
-	// is closed once FileHandler has completed for this file
+// SetVariantSeparator sets the string used to join the variation names of
+// multiple mutators into the name of a variant, as returned by ModuleSubDir.
+// The default is "_".  The name of a variant created by a single mutator is
+// always just its variation name.  It panics if sep is empty or contains a
+// character that is not allowed in a ninja path or path component, and must be
+// called before ResolveDependencies.
+func (c *Context) SetVariantSeparator(sep string) {
+	if c.dependenciesReady {
+		panic(fmt.Errorf("SetVariantSeparator called after ResolveDependencies"))
+	}
+	if sep == "" {
+		panic(fmt.Errorf("variant separator must not be empty"))
+	}
+	if i := strings.IndexAny(sep, invalidVariantSeparatorChars); i >= 0 {
+		panic(fmt.Errorf("variant separator %q contains invalid character %q", sep, sep[i]))
+	}
+	c.variantSeparator = sep
+}
+
+// SetDependencyPathRoot sets the name of the module that errors reported with
+// BaseModuleContext.ModuleErrorfWithPath describe the dependency path from, for
+// example the module at the top of the dependency graph of a product.  If no
+// root is set or the module with the error can't be reached from any variant of
+// the root, ModuleErrorfWithPath behaves like ModuleErrorf.
+func (c *Context) SetDependencyPathRoot(name string) {
+	c.dependencyPathRoot = name
+}
+
+// invalidVariantSeparatorChars are the characters that have a special meaning in ninja paths or
+// would split a variant name into multiple path components.
+const invalidVariantSeparatorChars = "$: \t\n/\\|"
+
+// SetDeterministicDependencyOrder changes the behavior of Blueprint to sort the
+// direct dependencies of each module by the name and variant of the dependency
+// whenever dependencies are resolved, so that the order seen by VisitDirectDeps
+// and the generated ninja file do not depend on the order in which mutators
+// added the dependencies.  Dependencies on the same module variant keep the
+// order in which they were added.
+func (c *Context) SetDeterministicDependencyOrder(deterministicDependencyOrder bool) {
+	c.deterministicDependencyOrder = deterministicDependencyOrder
+}
+
+// ModuleVisitOrder selects the order in which modules are visited to generate build actions.
+type ModuleVisitOrder int
+
+const (
+	// ModuleVisitOrderLegacy visits modules in parallel, starting each module once all of its
+	// dependencies have generated their build actions.  The order in which modules finish is not
+	// deterministic.
+	ModuleVisitOrderLegacy ModuleVisitOrder = iota
+
+	// ModuleVisitOrderTopological visits modules one at a time in a deterministic order that
+	// guarantees that all of a module's dependencies generate their build actions before it does.
+	// Modules that are not ordered by dependencies are visited by name and then variant name.
+	ModuleVisitOrderTopological
+
+	// ModuleVisitOrderAlphabetical visits modules one at a time sorted by name and then variant
+	// name.  Dependencies are not guaranteed to generate their build actions first, so it should
+	// not be used when GenerateBuildActions reads providers set by its dependencies.
+	ModuleVisitOrderAlphabetical
+)
+
+func (o ModuleVisitOrder) String() string {
+	switch o {
+	case ModuleVisitOrderLegacy:
+		return "legacy"
+	case ModuleVisitOrderTopological:
+		return "topological"
+	case ModuleVisitOrderAlphabetical:
+		return "alphabetical"
+	default:
+		return fmt.Sprintf("ModuleVisitOrder(%d)", int(o))
+	}
+}
+
+// SetModuleVisitOrder sets the order in which PrepareBuildActions calls GenerateBuildActions on
+// modules.  The default is ModuleVisitOrderLegacy.
+func (c *Context) SetModuleVisitOrder(order ModuleVisitOrder) {
+	c.moduleVisitOrder = order
+}
+
+// SetModuleActionsWriter makes PrepareBuildActions write the build actions of each module to w as soon as
+// its GenerateBuildActions has finished, and then release them, instead of holding the build actions of
+// every module in memory until WriteBuildFile.  WriteBuildFile writes a subninja statement for
+// ninjaFileName, which must be the path of the file that w writes to, after the variables, pools and rules
+// that the modules' build actions use, so that it can only be called after all of them are known.
+//
+// The modules are written in the order they finish GenerateBuildActions, which is only deterministic
+// when the module visit order is ModuleVisitOrderTopological or ModuleVisitOrderAlphabetical.  Package
+// names are chosen from all registered packages instead of only the ones that are used, order-only
+// dependencies are not deduplicated, and the build actions are not included in PrintJSONGraphAndActions.
+// It cannot be used with incremental analysis, and it must be called before PrepareBuildActions.
+func (c *Context) SetModuleActionsWriter(w StringWriterWriter, ninjaFileName string) {
+	c.moduleActionsWriter = w
+	c.moduleActionsNinjaFile = ninjaFileName
+}
+
+// SetParallelGenerateBuildActions makes PrepareBuildActions call GenerateBuildActions on modules
+// concurrently without waiting for their dependencies.  A module that reads a provider of one of its direct
+// or transitive dependencies that was set in its GenerateBuildActions pauses until that module's
+// GenerateBuildActions has finished, so data passed between modules through providers is still ordered.
+// Reading such a provider of a module that is not a dependency is an error, as the two modules could
+// otherwise wait for each other.  Modules that read or modify any other
+// state shared between modules in GenerateBuildActions, for example fields of their dependencies, are not
+// safe to use in this mode.  It has no effect when the module visit order is not ModuleVisitOrderLegacy or
+// when incremental analysis is enabled, in which case modules always wait for their dependencies.
+func (c *Context) SetParallelGenerateBuildActions(parallel bool) {
+	c.parallelGenerateBuildActions = parallel
+}
+
+// SetIncludeDisabledModules makes the Context treat every module as enabled, ignoring the Enabled method
+// of modules that implement EnableableModule.  It is useful for tools that need to see the modules and
+// dependencies that a normal build would skip.
+func (c *Context) SetIncludeDisabledModules(include bool) {
+	c.includeDisabledModules = include
+}
+
+// SetDependencyNameResolver sets a function that is called with the depending module and the name of
+// every dependency added by AddDependency, AddVariationDependencies, AddOptionalVariationDependencies or
+// AddFarVariationDependencies, and returns the name of the module to depend on instead.  It is called after
+// any ":variant" suffix has been removed from the name and before the module is looked up, so it can
+// be used to redirect dependencies while modules are migrated between names or namespaces.  It may be
+// called concurrently from multiple mutators.
+func (c *Context) SetDependencyNameResolver(resolver func(from Module, name string) string) {
+	c.dependencyNameResolver = resolver
+}
+
+// moduleEnabled returns false if module implements EnableableModule and is not enabled, unless
+// SetIncludeDisabledModules was called.
+func (c *Context) moduleEnabled(module *moduleInfo) bool {
+	if c.includeDisabledModules {
+		return true
+	}
+	if m, ok := module.logicModule.(EnableableModule); ok {
+		return m.Enabled()
+	}
+	return true
+}
+
+// skipTransitionOnDisabledModules makes transition mutator t skip the modules that are disabled when
+// it starts, so that they are not split.
+func (c *Context) skipTransitionOnDisabledModules(t *transitionMutatorImpl) {
+	for module := range c.iterateAllVariants() {
+		if !c.moduleEnabled(module) {
+			if module.skippedMutators == nil {
+				module.skippedMutators = make(map[string]bool)
+			}
+			module.skippedMutators[t.name] = true
+		}
+	}
+}
+
+// SetNinjaVariable defines a ninja variable that is written once at the top level of the generated
+// ninja file.  It can be referenced as ${name} in the rules, variables and build statements created by
+// modules and singletons, where it takes precedence over a variable with the same name in the
+// calling package.  The value is a ninja string, so a literal '$' must be written as "$$", and it may
+// reference variables previously set with SetNinjaVariable.  It must be called before
+// PrepareBuildActions.
+func (c *Context) SetNinjaVariable(name, value string) error {
+	if err := validateNinjaName(name); err != nil {
+		return err
+	}
+	if strings.ContainsRune(name, '.') {
+		return fmt.Errorf("ninja variable name %q contains '.'", name)
+	}
+
+	if c.ninjaVariables == nil {
+		c.ninjaVariables = newScope(nil)
+	}
+
+	ninjaValue, err := parseNinjaString(c.ninjaVariables, value)
+	if err != nil {
+		return fmt.Errorf("error parsing value of ninja variable %q: %s", name, err)
+	}
+
+	return c.ninjaVariables.AddVariable(&globalVariable{name_: name, value_: ninjaValue})
+}
+
+func (c *Context) SetModuleListFile(listFile string) {
+	c.moduleListFile = listFile
+}
+
+func (c *Context) ListModulePaths(baseDir string) (paths []string, err error) {
+	reader, err := c.fs.Open(c.moduleListFile)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	bytes, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	text := string(bytes)
+
+	text = strings.Trim(text, "\n")
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		lines[i] = filepath.Join(baseDir, lines[i])
+	}
+
+	return lines, nil
+}
+
+// a fileParseContext tells the status of parsing a particular file
+type fileParseContext struct {
+	// name of file
+	fileName string
+
+	// scope to use when resolving variables
+	Scope *parser.Scope
+
+	// pointer to the one in the parent directory
+	parent *fileParseContext
+
+	// is closed once FileHandler has completed for this file
 	doneVisiting chan struct{}
 }
 
@@ -1041,6 +1940,213 @@ func shouldVisitFile(c *Context, file *parser.File) shouldVisitFileInfo {
 func (c *Context) ParseFileList(rootDir string, filePaths []string,
 	config interface{}) (deps []string, errs []error) {
 
+	return c.parseFileList(c.fs, rootDir, filePaths, config)
+}
+
+// ParseBlueprintContents parses the given map of file names to Blueprints file contents as if they
+// were files in the source tree, without reading the filesystem configured on the Context.  As with
+// MockFileSystem every file named Android.bp is parsed, other files are only parsed if they are
+// referenced through "build" from one of them.  It is intended for tools that need to parse files
+// that have not been saved, for example editor buffers.
+func (c *Context) ParseBlueprintContents(contents map[string]string,
+	config interface{}) (deps []string, errs []error) {
+
+	files := make(map[string][]byte, len(contents))
+	var pathsToParse []string
+	for name, content := range contents {
+		files[name] = []byte(content)
+		if filepath.Base(name) == "Android.bp" {
+			pathsToParse = append(pathsToParse, name)
+		}
+	}
+	sort.Strings(pathsToParse)
+	return c.parseFileList(pathtools.MockFs(files), ".", pathsToParse, config)
+}
+
+// parsedFile is the result of parsing a single Blueprints file that is retained for Reparse.
+type parsedFile struct {
+	file          *parser.File
+	scope         *parser.Scope
+	subBlueprints []string
+}
+
+type parseArgs struct {
+	fs        pathtools.FileSystem
+	contextFs bool
+	rootDir   string
+	filePaths []string
+	config    interface{}
+}
+
+// SetRetainParsedFiles causes the Context to keep the result of parsing each Blueprints file so
+// that Reparse can be used to update the modules after some of the files change.  It must be
+// called before parsing.
+func (c *Context) SetRetainParsedFiles(retain bool) {
+	if retain {
+		c.parsedFiles = make(map[string]*parsedFile)
+	} else {
+		c.parsedFiles = nil
+	}
+}
+
+// Reparse updates the modules defined by the most recent call to ParseBlueprintsFiles,
+// ParseFileList or ParseBlueprintContents after the given Blueprints files changed, reading the
+// files from the same filesystem that they were originally parsed from.  Only the changed files and
+// the files that inherit variables from them, which are the files in their subdirectories and the
+// files they list in "build", are parsed again, the modules in all other files are recreated from
+// their retained syntax trees.  The set of Blueprints files must not change.
+//
+// Reparse returns the Context to its state before ResolveDependencies, so ResolveDependencies
+// and PrepareBuildActions must be called again.  Only parsing is incremental: Reparse does not
+// re-resolve just the subgraph affected by the change, and the following ResolveDependencies runs
+// every mutator on every module, so it costs as much as it did after the original parse.
+// Mutators can modify modules in place, read the properties of dependencies and other global
+// state and create variants of dependencies, so the result of running them on a module can depend
+// on any other module and there is no subgraph that can safely be resolved on its own.  The
+// variants of modules that are not affected by the change are recreated identically.
+//
+// SetRetainParsedFiles must have been called before the files were originally parsed.  Reparse is
+// not supported with a custom NameInterface.
+func (c *Context) Reparse(changedFiles []string) []error {
+	if c.parsedFiles == nil || c.lastParse == nil {
+		return []error{fmt.Errorf("Reparse called without SetRetainParsedFiles before parsing")}
+	}
+	if _, ok := c.nameInterface.(*SimpleNameInterface); !ok {
+		return []error{fmt.Errorf("Reparse is not supported with a custom NameInterface")}
+	}
+
+	descendants, err := findBlueprintDescendants(c.lastParse.filePaths)
+	if err != nil {
+		return []error{err}
+	}
+
+	// Drop the retained results for the changed files and every file that inherits their scope.
+	invalid := slices.Clone(changedFiles)
+	for len(invalid) > 0 {
+		file := invalid[len(invalid)-1]
+		invalid = invalid[:len(invalid)-1]
+		invalid = append(invalid, descendants[file]...)
+		if parsed, ok := c.parsedFiles[file]; ok {
+			invalid = append(invalid, parsed.subBlueprints...)
+			delete(c.parsedFiles, file)
+		}
+	}
+
+	// The variables of the retained files may have been referenced by the files that are parsed
+	// again, so recompute their Referenced flags from the references made by the retained files.
+	// Files that are parsed again mark the variables they still reference.
+	for _, parsed := range c.parsedFiles {
+		for _, assignment := range parsed.scope.LocalAssignments() {
+			assignment.Referenced = false
+		}
+	}
+	for _, parsed := range c.parsedFiles {
+		for _, assignment := range parsed.scope.ReferencedAssignments() {
+			assignment.Referenced = true
+		}
+	}
+
+	c.moduleGroups = nil
+	c.moduleInfo = make(map[Module]*moduleInfo)
+	c.warnings = nil
+	c.variables = nil
+	c.nameInterface = c.nameInterface.(*SimpleNameInterface).newWithSameNamespaces()
+	c.renamedModules = nil
+	c.cachedSortedModuleGroups = nil
+	c.transitionMutators = nil
+	c.variantCreatingMutatorOrder = nil
+	c.finishedMutators = nil
+	c.buildActionsReady = false
+	c.internedRules = nil
+	for _, mutator := range c.mutatorInfo {
+		if t := mutator.transitionMutator; t != nil {
+			t.reset()
+		}
+	}
+
+	fs := c.lastParse.fs
+	if c.lastParse.contextFs {
+		fs = c.fs
+	}
+	_, errs := c.parseFileList(fs, c.lastParse.rootDir, c.lastParse.filePaths, c.lastParse.config)
+	return errs
+}
+
+// Warnings returns the problems found while parsing the Blueprints files that don't prevent the build,
+// like setting a property tagged `blueprint:"deprecated:<message>"`, sorted by position.
+func (c *Context) Warnings() []error {
+	c.warningsLock.Lock()
+	defer c.warningsLock.Unlock()
+
+	warnings := slices.Clone(c.warnings)
+	slices.SortStableFunc(warnings, func(a, b error) int {
+		var aErr, bErr *BlueprintError
+		if !errors.As(a, &aErr) || !errors.As(b, &bErr) {
+			return 0
+		}
+		return cmp.Or(
+			cmp.Compare(aErr.Pos.Filename, bErr.Pos.Filename),
+			cmp.Compare(aErr.Pos.Line, bErr.Pos.Line),
+			cmp.Compare(aErr.Pos.Column, bErr.Pos.Column))
+	})
+	return warnings
+}
+
+func (c *Context) addWarnings(warnings []error) {
+	c.warningsLock.Lock()
+	defer c.warningsLock.Unlock()
+	c.warnings = append(c.warnings, warnings...)
+}
+
+// A VariableRef identifies a variable assigned in a Blueprints file.
+type VariableRef struct {
+	Name string
+	Pos  scanner.Position
+}
+
+// UnusedVariables returns the top level variables assigned in the parsed Blueprints files that are never
+// referenced by a property or by another variable, including in the Blueprints files in subdirectories that
+// inherit them, sorted by position.  Appending to a variable with += is not a reference to it, and the variable
+// is reported at the position of its original assignment.  It must be called after parsing has finished.
+func (c *Context) UnusedVariables() []VariableRef {
+	c.variablesLock.Lock()
+	defer c.variablesLock.Unlock()
+
+	var unused []VariableRef
+	for _, assignment := range c.variables {
+		if !assignment.Referenced {
+			unused = append(unused, VariableRef{Name: assignment.Name, Pos: assignment.NamePos})
+		}
+	}
+	slices.SortFunc(unused, func(a, b VariableRef) int {
+		return cmp.Or(
+			cmp.Compare(a.Pos.Filename, b.Pos.Filename),
+			cmp.Compare(a.Pos.Line, b.Pos.Line),
+			cmp.Compare(a.Pos.Column, b.Pos.Column))
+	})
+	return unused
+}
+
+// addVariables records the variables assigned in the scope of a Blueprints file for UnusedVariables,
+// except for the ones that are read by Blueprint itself.
+func (c *Context) addVariables(scope *parser.Scope) {
+	c.variablesLock.Lock()
+	defer c.variablesLock.Unlock()
+	for _, assignment := range scope.LocalAssignments() {
+		switch assignment.Name {
+		case "build", "subdirs", "optional_subdirs":
+		default:
+			c.variables = append(c.variables, assignment)
+		}
+	}
+}
+
+func (c *Context) parseFileList(fs pathtools.FileSystem, rootDir string, filePaths []string,
+	config interface{}) (deps []string, errs []error) {
+
+	c.lastParse = &parseArgs{fs: fs, contextFs: fs == c.fs, rootDir: rootDir, filePaths: filePaths,
+		config: config}
+
 	if len(filePaths) < 1 {
 		return nil, []error{fmt.Errorf("no paths provided to parse")}
 	}
@@ -1114,11 +2220,22 @@ func (c *Context) ParseFileList(rootDir string, filePaths []string,
 		for _, def := range file.Defs {
 			switch def := def.(type) {
 			case *parser.Module:
-				module, errs := processModuleDef(def, file.Name, c.moduleFactories, scopedModuleFactories, c.ignoreUnknownModuleTypes)
+				module, warnings, errs := processModuleDef(def, file.Name, c.moduleFactories, scopedModuleFactories, c.ignoreUnknownModuleTypes)
+				// Paths can only be checked against the Context's filesystem, ParseBlueprintContents
+				// parses from a filesystem that only contains the Blueprints file.
+				if len(errs) == 0 && module != nil && fs == c.fs {
+					var pathWarnings []error
+					errs, pathWarnings = c.processPathProperties(rootDir, module, def)
+					warnings = append(warnings, pathWarnings...)
+				}
 				if len(errs) == 0 && module != nil {
 					errs = addModule(module)
 				}
 
+				if len(warnings) > 0 {
+					c.addWarnings(warnings)
+				}
+
 				if len(errs) > 0 {
 					atomic.AddUint32(&numErrs, uint32(len(errs)))
 					errsCh <- errs
@@ -1136,7 +2253,7 @@ func (c *Context) ParseFileList(rootDir string, filePaths []string,
 	atomic.AddInt32(&numGoroutines, 1)
 	go func() {
 		var errs []error
-		deps, errs = c.WalkBlueprintsFiles(rootDir, filePaths, handleOneFile)
+		deps, errs = c.walkBlueprintsFiles(fs, rootDir, filePaths, handleOneFile)
 		if len(errs) > 0 {
 			errsCh <- errs
 		}
@@ -1151,6 +2268,9 @@ loop:
 			errs = append(errs, newErrs...)
 		case module := <-moduleCh:
 			newErrs := c.addModule(module.moduleInfo)
+			if len(newErrs) == 0 {
+				c.traceModule(module.moduleInfo, "parse", "defined at %s", module.pos)
+			}
 			hookDeps = append(hookDeps, module.deps...)
 			if module.added != nil {
 				module.added <- struct{}{}
@@ -1175,6 +2295,7 @@ loop:
 	}
 
 	deps = append(deps, hookDeps...)
+	c.parseDeps = deps
 	return deps, errs
 }
 
@@ -1200,9 +2321,19 @@ type FileHandler func(*parser.File)
 // ancestor directory has completed.
 //
 // WalkBlueprintsFiles will not return until all calls to visitor have returned.
+//
+// FindBlueprintsFiles walks the tree rooted at a single Blueprints file without parsing its
+// modules, and returns the files in the order they are found, reporting include cycles and files
+// that are included more than once as errors.
 func (c *Context) WalkBlueprintsFiles(rootDir string, filePaths []string,
 	visitor FileHandler) (deps []string, errs []error) {
 
+	return c.walkBlueprintsFiles(c.fs, rootDir, filePaths, visitor)
+}
+
+func (c *Context) walkBlueprintsFiles(fs pathtools.FileSystem, rootDir string, filePaths []string,
+	visitor FileHandler) (deps []string, errs []error) {
+
 	// make a mapping from ancestors to their descendants to facilitate parsing ancestors first
 	descendantsMap, err := findBlueprintDescendants(filePaths)
 	if err != nil {
@@ -1239,7 +2370,7 @@ func (c *Context) WalkBlueprintsFiles(rootDir string, filePaths []string,
 		deps = append(deps, blueprint.fileName)
 		visitorWaitGroup.Add(1)
 		go func() {
-			file, blueprints, deps, errs := c.openAndParse(blueprint.fileName, blueprint.Scope, rootDir,
+			file, blueprints, deps, errs := c.openAndParse(fs, blueprint.fileName, blueprint.Scope, rootDir,
 				&blueprint)
 			if len(errs) > 0 {
 				errsCh <- errs
@@ -1286,7 +2417,7 @@ func (c *Context) WalkBlueprintsFiles(rootDir string, filePaths []string,
 	}
 
 	// begin parsing any files that have no ancestors
-	startParseDescendants(fileParseContext{"", parser.NewScope(nil), nil, nil})
+	startParseDescendants(fileParseContext{"", c.newRootScope(), nil, nil})
 
 loop:
 	for {
@@ -1359,21 +2490,37 @@ func (c *Context) SetFs(fs pathtools.FileSystem) {
 }
 
 // openAndParse opens and parses a single Blueprints file, and returns the results
-func (c *Context) openAndParse(filename string, scope *parser.Scope, rootDir string,
+func (c *Context) openAndParse(fs pathtools.FileSystem, filename string, scope *parser.Scope, rootDir string,
 	parent *fileParseContext) (file *parser.File,
 	subBlueprints []fileParseContext, deps []string, errs []error) {
 
-	f, err := c.fs.Open(filename)
+	if c.parsedFiles != nil {
+		c.parsedFilesLock.Lock()
+		parsed, ok := c.parsedFiles[filename]
+		c.parsedFilesLock.Unlock()
+		if ok {
+			// Reuse the scope that the file was evaluated in, which is inherited by its descendants.
+			parent.Scope = parsed.scope
+			c.addVariables(parsed.scope)
+			for _, b := range parsed.subBlueprints {
+				subBlueprints = append(subBlueprints,
+					fileParseContext{b, parser.NewScope(parsed.scope), parent, make(chan struct{})})
+			}
+			return parsed.file, subBlueprints, parsed.subBlueprints, nil
+		}
+	}
+
+	f, err := fs.Open(filename)
 	if err != nil {
 		// couldn't open the file; see if we can provide a clearer error than "could not open file"
-		stats, statErr := c.fs.Lstat(filename)
+		stats, statErr := fs.Lstat(filename)
 		if statErr == nil {
 			isSymlink := stats.Mode()&os.ModeSymlink != 0
 			if isSymlink {
 				err = fmt.Errorf("could not open symlink %v : %v", filename, err)
 				target, readlinkErr := os.Readlink(filename)
 				if readlinkErr == nil {
-					_, targetStatsErr := c.fs.Lstat(target)
+					_, targetStatsErr := fs.Lstat(target)
 					if targetStatsErr != nil {
 						err = fmt.Errorf("could not open symlink %v; its target (%v) cannot be opened", filename, target)
 					}
@@ -1392,7 +2539,7 @@ func (c *Context) openAndParse(filename string, scope *parser.Scope, rootDir str
 				errs = append(errs, err)
 			}
 		}()
-		file, subBlueprints, errs = c.parseOne(rootDir, filename, f, scope, parent)
+		file, subBlueprints, errs = c.parseOneInFs(fs, rootDir, filename, f, scope, parent)
 	}()
 
 	if len(errs) > 0 {
@@ -1403,9 +2550,110 @@ func (c *Context) openAndParse(filename string, scope *parser.Scope, rootDir str
 		deps = append(deps, b.fileName)
 	}
 
+	c.addVariables(scope)
+
+	if c.parsedFiles != nil {
+		c.parsedFilesLock.Lock()
+		c.parsedFiles[filename] = &parsedFile{file: file, scope: scope, subBlueprints: deps}
+		c.parsedFilesLock.Unlock()
+	}
+
 	return file, subBlueprints, deps, nil
 }
 
+// FindBlueprintsFiles walks the tree of Blueprints files rooted at rootFile like WalkBlueprintsFiles, but without
+// creating modules or calling a visitor, and returns the Blueprints files that make up the tree: rootFile, the
+// files it lists in "build", and recursively the files with the same name as rootFile in the directories it
+// lists in "subdirs" and "optional_subdirs", in the order they are found.  It is an error for a file to be reached more
+// than once, either through a cycle or through two different directives.  Only the "build", "subdirs" and
+// "optional_subdirs" assignments of the files are evaluated, no modules are created.
+//
+// The returned list can be recorded by the caller to decide when the tree needs to be parsed again.  The files
+// found through "subdirs" can be passed to ParseFileList, which finds the files listed in "build" itself.
+func (c *Context) FindBlueprintsFiles(rootFile string) (files []string, errs []error) {
+	subBlueprintsName := filepath.Base(rootFile)
+	includedFrom := make(map[string]scanner.Position)
+	var visiting []string
+
+	var visit func(filename string, scope *parser.Scope, pos scanner.Position)
+	visit = func(filename string, scope *parser.Scope, pos scanner.Position) {
+		if i := slices.Index(visiting, filename); i >= 0 {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("include cycle: %s", strings.Join(append(slices.Clone(visiting[i:]), filename), " -> ")),
+				Pos: pos,
+			})
+			return
+		}
+		if prev, ok := includedFrom[filename]; ok {
+			errs = append(errs, &BlueprintError{
+				// seven characters at the start of the second line to align with the string "error: "
+				Err: fmt.Errorf("%q included more than once\n"+
+					"       %s <-- previously included here", filename, prev),
+				Pos: pos,
+			})
+			return
+		}
+		includedFrom[filename] = pos
+		files = append(files, filename)
+		visiting = append(visiting, filename)
+		defer func() { visiting = visiting[:len(visiting)-1] }()
+
+		f, err := c.fs.Open(filename)
+		if err != nil {
+			errs = append(errs, &BlueprintError{Err: err, Pos: pos})
+			return
+		}
+		defer f.Close()
+
+		scope.DontInherit("subdirs")
+		scope.DontInherit("optional_subdirs")
+		scope.DontInherit("build")
+		if _, parseErrs := parser.ParseAndEval(filename, f, scope); len(parseErrs) > 0 {
+			for _, err := range parseErrs {
+				if parseErr, ok := err.(*parser.ParseError); ok {
+					err = &BlueprintError{
+						Err: parseErr.Err,
+						Pos: parseErr.Pos,
+					}
+				}
+				errs = append(errs, err)
+			}
+			return
+		}
+
+		dir := filepath.Dir(filename)
+		visitAll := func(variable string, find func(list []string, pos scanner.Position) ([]string, []error)) {
+			list, listPos, err := getLocalStringListFromScope(scope, variable)
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			found, findErrs := find(list, listPos)
+			errs = append(errs, findErrs...)
+			for _, child := range found {
+				visit(child, parser.NewScope(scope), listPos)
+			}
+		}
+		visitAll("build", func(build []string, pos scanner.Position) ([]string, []error) {
+			if errs := checkBuildEntries(build, pos); len(errs) > 0 {
+				return nil, errs
+			}
+			found, errs := c.findBuildBlueprints(c.fs, dir, build, pos)
+			// A glob in "build" may match the file itself, which is not a cycle.
+			return slices.DeleteFunc(found, func(f string) bool { return f == filename }), errs
+		})
+		visitAll("subdirs", func(subdirs []string, pos scanner.Position) ([]string, []error) {
+			return c.findSubdirBlueprints(dir, subdirs, pos, subBlueprintsName, false)
+		})
+		visitAll("optional_subdirs", func(subdirs []string, pos scanner.Position) ([]string, []error) {
+			return c.findSubdirBlueprints(dir, subdirs, pos, subBlueprintsName, true)
+		})
+	}
+
+	visit(filepath.Clean(rootFile), c.newRootScope(), scanner.Position{})
+	return files, errs
+}
+
 // parseOne parses a single Blueprints file from the given reader, creating Module
 // objects for each of the module definitions encountered.  If the Blueprints
 // file contains an assignment to the "subdirs" variable, then the
@@ -1420,6 +2668,30 @@ func (c *Context) openAndParse(filename string, scope *parser.Scope, rootDir str
 func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 	scope *parser.Scope, parent *fileParseContext) (file *parser.File, subBlueprints []fileParseContext, errs []error) {
 
+	return c.parseOneInFs(c.fs, rootDir, filename, reader, scope, parent)
+}
+
+// ParseAndReturnAST parses a single Blueprints file from the given reader and returns its syntax
+// tree, including assignments, comments and the positions of every module, property and value.  The
+// file is not evaluated, so the module types in it don't need to be registered and no modules are
+// added to the Context.  It is intended for tools like formatters, linters and language servers.
+func (c *Context) ParseAndReturnAST(filename string, r io.Reader) (*parser.File, []error) {
+	file, errs := parser.Parse(filename, r)
+	for i, err := range errs {
+		if parseErr, ok := err.(*parser.ParseError); ok {
+			errs[i] = &BlueprintError{
+				Err: parseErr.Err,
+				Pos: parseErr.Pos,
+			}
+		}
+	}
+	return file, errs
+}
+
+// parseOneInFs is like parseOne, but searches for the Blueprints files listed in "build" in fs.
+func (c *Context) parseOneInFs(fs pathtools.FileSystem, rootDir, filename string, reader io.Reader,
+	scope *parser.Scope, parent *fileParseContext) (file *parser.File, subBlueprints []fileParseContext, errs []error) {
+
 	relBlueprintsFile, err := filepath.Rel(rootDir, filename)
 	if err != nil {
 		return nil, nil, []error{err}
@@ -1450,14 +2722,7 @@ func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 	if err != nil {
 		errs = append(errs, err)
 	}
-	for _, buildEntry := range build {
-		if strings.Contains(buildEntry, "/") {
-			errs = append(errs, &BlueprintError{
-				Err: fmt.Errorf("illegal value %v. The '/' character is not permitted", buildEntry),
-				Pos: buildPos,
-			})
-		}
-	}
+	errs = append(errs, checkBuildEntries(build, buildPos)...)
 
 	if err != nil {
 		errs = append(errs, err)
@@ -1465,7 +2730,7 @@ func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 
 	var blueprints []string
 
-	newBlueprints, newErrs := c.findBuildBlueprints(filepath.Dir(filename), build, buildPos)
+	newBlueprints, newErrs := c.findBuildBlueprints(fs, filepath.Dir(filename), build, buildPos)
 	blueprints = append(blueprints, newBlueprints...)
 	errs = append(errs, newErrs...)
 
@@ -1476,7 +2741,22 @@ func (c *Context) parseOne(rootDir, filename string, reader io.Reader,
 	return file, subBlueprintsAndScope, errs
 }
 
-func (c *Context) findBuildBlueprints(dir string, build []string,
+// checkBuildEntries returns an error for each entry of a "build" assignment that is not in the same directory
+// as the Blueprints file.
+func checkBuildEntries(build []string, buildPos scanner.Position) []error {
+	var errs []error
+	for _, buildEntry := range build {
+		if strings.Contains(buildEntry, "/") {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("illegal value %v. The '/' character is not permitted", buildEntry),
+				Pos: buildPos,
+			})
+		}
+	}
+	return errs
+}
+
+func (c *Context) findBuildBlueprints(fs pathtools.FileSystem, dir string, build []string,
 	buildPos scanner.Position) ([]string, []error) {
 
 	var blueprints []string
@@ -1487,7 +2767,13 @@ func (c *Context) findBuildBlueprints(dir string, build []string,
 		var matches []string
 		var err error
 
-		matches, err = c.glob(pattern, nil)
+		if fs == c.fs {
+			matches, err = c.glob(pattern, nil)
+		} else {
+			var result pathtools.GlobResult
+			result, err = fs.Glob(pattern, nil, pathtools.FollowSymlinks)
+			matches = result.Matches
+		}
 
 		if err != nil {
 			errs = append(errs, &BlueprintError{
@@ -1610,14 +2896,14 @@ func (c *Context) cloneLogicModule(origModule *moduleInfo) (Module, []interface{
 	return newLogicModule, newProperties
 }
 
-func newVariant(module *moduleInfo, mutatorName string, variationName string) variant {
+func (c *Context) newVariant(module *moduleInfo, mutatorName string, variationName string) variant {
 
 	newVariantName := module.variant.name
 	if variationName != "" {
 		if newVariantName == "" {
 			newVariantName = variationName
 		} else {
-			newVariantName += "_" + variationName
+			newVariantName += cmp.Or(c.variantSeparator, "_") + variationName
 		}
 	}
 
@@ -1656,16 +2942,8 @@ func (c *Context) createVariations(origModule *moduleInfo, mutator *mutatorInfo,
 			newLogicModule, newProperties = c.cloneLogicModule(origModule)
 		}
 
-		m := *origModule
-		newModule := &m
-		newModule.directDeps = slices.Clone(origModule.directDeps)
-		newModule.reverseDeps = nil
-		newModule.forwardDeps = nil
-		newModule.logicModule = newLogicModule
-		newModule.variant = newVariant(origModule, mutator.name, variationName)
-		newModule.properties = newProperties
-		newModule.providers = slices.Clone(origModule.providers)
-		newModule.providerInitialValueHashes = slices.Clone(origModule.providerInitialValueHashes)
+		newModule := newVariantModule(origModule, newLogicModule, newProperties,
+			c.newVariant(origModule, mutator.name, variationName))
 
 		newModules = append(newModules, newModule)
 
@@ -1673,8 +2951,11 @@ func (c *Context) createVariations(origModule *moduleInfo, mutator *mutatorInfo,
 		if len(newErrs) > 0 {
 			errs = append(errs, newErrs...)
 		}
+		c.traceDirectDeps(newModule, mutator.name)
 	}
 
+	c.traceModule(origModule, mutator.name, "split into variations %q", variationNames)
+
 	// Mark original variant as invalid.  Modules that depend on this module will still
 	// depend on origModule, but we'll fix it when the mutator is called on them.
 	origModule.obsoletedByNewVariants = true
@@ -1685,6 +2966,27 @@ func (c *Context) createVariations(origModule *moduleInfo, mutator *mutatorInfo,
 	return newModules, errs
 }
 
+// newVariantModule returns a copy of origModule for a new variant that uses the given logic module
+// and properties.
+func newVariantModule(origModule *moduleInfo, logicModule Module, properties []interface{}, v variant) *moduleInfo {
+	m := *origModule
+	newModule := &m
+	newModule.directDeps = slices.Clone(origModule.directDeps)
+	newModule.reverseDeps = nil
+	newModule.inDegree = 0
+	newModule.forwardDeps = nil
+	newModule.logicModule = logicModule
+	newModule.variant = v
+	newModule.properties = properties
+	newModule.providers = slices.Clone(origModule.providers)
+	newModule.providerInitialValueHashes = slices.Clone(origModule.providerInitialValueHashes)
+	newModule.skippedMutators = maps.Clone(origModule.skippedMutators)
+	newModule.metadata = maps.Clone(origModule.metadata)
+	newModule.lateDeps = slices.Clone(origModule.lateDeps)
+	newModule.orderOnlyDepNames = slices.Clone(origModule.orderOnlyDepNames)
+	return newModule
+}
+
 type depChooser func(source *moduleInfo, variationIndex, depIndex int, dep depInfo) (*moduleInfo, string)
 
 func chooseDep(candidates moduleList, mutatorName, variationName string, defaultVariationName *string) (*moduleInfo, string) {
@@ -1709,6 +3011,10 @@ func chooseDep(candidates moduleList, mutatorName, variationName string, default
 func chooseDepByIndexes(mutatorName string, variations [][]string) depChooser {
 	return func(source *moduleInfo, variationIndex, depIndex int, dep depInfo) (*moduleInfo, string) {
 		desiredVariation := variations[variationIndex][depIndex]
+		if desiredVariation == skippedVariation {
+			// The edge was dropped by the incoming transition and will be removed by the caller.
+			return dep.module, ""
+		}
 		return chooseDep(dep.module.splitModules, mutatorName, desiredVariation, nil)
 	}
 }
@@ -1746,20 +3052,34 @@ func (c *Context) convertDepsToVariation(module *moduleInfo, variationIndex int,
 	return errs
 }
 
-func (c *Context) prettyPrintVariant(variations variationMap) string {
-	var names []string
+// variationList returns the variations in a variationMap as a list of Variations sorted in mutator
+// registration order.
+func (c *Context) variationList(variations variationMap) []Variation {
+	var list []Variation
 	for _, m := range c.variantMutatorNames {
 		if v := variations.get(m); v != "" {
-			names = append(names, m+":"+v)
+			list = append(list, Variation{Mutator: m, Variation: v})
 		}
 	}
-	if len(names) == 0 {
+	return list
+}
+
+func prettyPrintVariations(variations []Variation) string {
+	if len(variations) == 0 {
 		return "<empty variant>"
 	}
 
+	names := make([]string, 0, len(variations))
+	for _, v := range variations {
+		names = append(names, v.Mutator+":"+v.Variation)
+	}
 	return strings.Join(names, ",")
 }
 
+func (c *Context) prettyPrintVariant(variations variationMap) string {
+	return prettyPrintVariations(c.variationList(variations))
+}
+
 func (c *Context) prettyPrintGroupVariants(group *moduleGroup) string {
 	var variants []string
 	for _, module := range group.modules {
@@ -1778,8 +3098,11 @@ func newModule(factory ModuleFactory) *moduleInfo {
 	}
 }
 
+// processModuleDef creates a module from its definition in a Blueprints file, and returns it along with
+// any warnings and errors from unpacking its properties.
 func processModuleDef(moduleDef *parser.Module,
-	relBlueprintsFile string, moduleFactories, scopedModuleFactories map[string]ModuleFactory, ignoreUnknownModuleTypes bool) (*moduleInfo, []error) {
+	relBlueprintsFile string, moduleFactories, scopedModuleFactories map[string]ModuleFactory,
+	ignoreUnknownModuleTypes bool) (*moduleInfo, []error, []error) {
 
 	factory, ok := moduleFactories[moduleDef.Type]
 	if !ok && scopedModuleFactories != nil {
@@ -1787,10 +3110,10 @@ func processModuleDef(moduleDef *parser.Module,
 	}
 	if !ok {
 		if ignoreUnknownModuleTypes {
-			return nil, nil
+			return nil, nil, nil
 		}
 
-		return nil, []error{
+		return nil, nil, []error{
 			&BlueprintError{
 				Err: fmt.Errorf("unrecognized module type %q", moduleDef.Type),
 				Pos: moduleDef.TypePos,
@@ -1803,7 +3126,7 @@ func processModuleDef(moduleDef *parser.Module,
 
 	module.relBlueprintsFile = relBlueprintsFile
 
-	propertyMap, errs := proptools.UnpackProperties(moduleDef.Properties, module.properties...)
+	propertyMap, warnings, errs := proptools.UnpackPropertiesWithWarnings(moduleDef.Properties, module.properties...)
 	if len(errs) > 0 {
 		for i, err := range errs {
 			if unpackErr, ok := err.(*proptools.UnpackError); ok {
@@ -1814,7 +3137,16 @@ func processModuleDef(moduleDef *parser.Module,
 				errs[i] = err
 			}
 		}
-		return nil, errs
+		return nil, nil, errs
+	}
+
+	for i, warning := range warnings {
+		if unpackErr, ok := warning.(*proptools.UnpackError); ok {
+			warnings[i] = &BlueprintError{
+				Err: unpackErr.Err,
+				Pos: unpackErr.Pos,
+			}
+		}
 	}
 
 	module.pos = moduleDef.TypePos
@@ -1823,7 +3155,15 @@ func processModuleDef(moduleDef *parser.Module,
 		module.propertyPos[name] = propertyDef.ColonPos
 	}
 
-	return module, nil
+	if validator, ok := module.logicModule.(PropertyValidator); ok {
+		vctx := &validationContext{module: module}
+		validator.ValidateProperties(vctx)
+		if len(vctx.errs) > 0 {
+			return nil, nil, vctx.errs
+		}
+	}
+
+	return module, warnings, nil
 }
 
 func (c *Context) addModule(module *moduleInfo) []error {
@@ -1870,14 +3210,396 @@ func (c *Context) ResolveDependencies(config interface{}) (deps []string, errs [
 	return c.resolveDependencies(c.Context, config)
 }
 
-// coalesceMutators takes the list of mutators and returns a list of lists of mutators,
-// where sublist is a compatible group of mutators that can be run with relaxed
-// intra-mutator ordering.
-func coalesceMutators(mutators []*mutatorInfo) [][]*mutatorInfo {
-	var coalescedMutators [][]*mutatorInfo
-	var last *mutatorInfo
+// A ResolvedEdge describes a dependency between two module variants found by
+// ResolveDependenciesDryRun.
+type ResolvedEdge struct {
+	From        string
+	FromVariant string
+	To          string
+	ToVariant   string
+	Tag         DependencyTag
+}
 
-	// Returns true if the mutator can be coalesced with other mutators that
+// ResolveDependenciesDryRun runs the registered mutators like ResolveDependencies, including
+// the variant selection done by transition mutators, and returns every dependency edge in the
+// resulting graph.  The mutators are run on copies of the modules in a separate Context, so
+// this Context is left in its pre-resolution state and ResolveDependencies can be called on it
+// afterwards.  The copies are created with the module factories and the properties of the
+// parsed modules, so any other state stored in the modules is not copied.  Persistent caches set
+// with TransitionMutatorHandle.WithPersistentCache are not used, and the traces returned by
+// TraceModule and the hook set by SetMutatorTraceHook are shared with the copies.  It is not
+// supported with a custom NameInterface.
+func (c *Context) ResolveDependenciesDryRun(config interface{}) ([]ResolvedEdge, []error) {
+	if c.dependenciesReady {
+		return nil, []error{fmt.Errorf("ResolveDependenciesDryRun called after ResolveDependencies")}
+	}
+	if _, ok := c.nameInterface.(*SimpleNameInterface); !ok {
+		return nil, []error{fmt.Errorf("ResolveDependenciesDryRun is not supported with a custom NameInterface")}
+	}
+
+	dry, errs := c.cloneUnresolved(nil)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	dry.SkipCloneModulesAfterMutators = true
+
+	_, errs = dry.ResolveDependencies(config)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	var edges []ResolvedEdge
+	for _, group := range dry.moduleGroups {
+		for _, module := range group.modules {
+			for _, dep := range module.directDeps {
+				edges = append(edges, ResolvedEdge{
+					From:        module.Name(),
+					FromVariant: module.variant.name,
+					To:          dep.module.Name(),
+					ToVariant:   dep.module.variant.name,
+					Tag:         dep.tag,
+				})
+			}
+		}
+	}
+	return edges, nil
+}
+
+// CloneForConfig returns a new Context containing copies of the modules in this Context, with the
+// dependencies resolved for config.  The registered module types, mutators, singletons and settings
+// are shared with the clone, but the clone has its own module instances and mutator state, so
+// multiple clones can be created and resolved concurrently for different configs.
+// PrepareBuildActions should then be called on the returned Context with the same config.  The
+// copies are created with the module factories and the properties of the parsed modules, so any
+// other state stored in the modules is not copied.  It must be called before ResolveDependencies,
+// this Context must not be modified while it is running, and it is not supported with a custom
+// NameInterface.
+//
+// The traces returned by TraceModule and the hook set by SetMutatorTraceHook are shared with the
+// clone, but persistent caches set with TransitionMutatorHandle.WithPersistentCache are not used.  The snapshots requested with SnapshotAfterMutator are taken
+// separately by each clone and must be retrieved by calling SnapshotAfterMutator on the clone.
+// The writer set by SetModuleActionsWriter and the files retained for Reparse are not copied.
+func (c *Context) CloneForConfig(config interface{}) (*Context, error) {
+	if c.dependenciesReady {
+		return nil, fmt.Errorf("CloneForConfig called after ResolveDependencies")
+	}
+	if _, ok := c.nameInterface.(*SimpleNameInterface); !ok {
+		return nil, fmt.Errorf("CloneForConfig is not supported with a custom NameInterface")
+	}
+
+	clone, errs := c.cloneUnresolved(nil)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if _, errs := clone.ResolveDependencies(config); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return clone, nil
+}
+
+// ResolveDependenciesForRoots is like ResolveDependencies, but only the modules named by roots and
+// the modules they transitively depend on are kept in this Context; all other parsed modules are
+// dropped before any mutators run.  The set of kept modules is found from the names of modules that
+// appear in string values of the properties of each kept module, which includes the dependencies
+// added from properties.  If any mutator uses reverse dependencies, modules whose properties name a
+// kept module are kept too, as they may add a reverse dependency on it.  The kept modules are then
+// resolved on copies of the modules, like ResolveDependenciesDryRun, to find dependencies whose names
+// don't appear in properties, repeating with the modules they reference until none are found.
+//
+// The result is only equivalent to resolving the whole graph if the mutators don't depend on
+// modules outside the kept set.  Reverse dependencies added by modules that don't name their
+// target in their properties and modules created by modules that are not kept are missing, and
+// mutators that collect global state across all modules (for example in a singleton-like map or a
+// persistent cache) will see only the kept modules.  This Context is only modified once the set of
+// kept modules has been found.  It must be called before ResolveDependencies and is not supported
+// with a custom NameInterface.
+func (c *Context) ResolveDependenciesForRoots(roots []string, config interface{}) []error {
+	if c.dependenciesReady {
+		return []error{fmt.Errorf("ResolveDependenciesForRoots called after ResolveDependencies")}
+	}
+	if _, ok := c.nameInterface.(*SimpleNameInterface); !ok {
+		return []error{fmt.Errorf("ResolveDependenciesForRoots is not supported with a custom NameInterface")}
+	}
+
+	keep := make(map[*moduleGroup]bool)
+	var queue []*moduleGroup
+	add := func(group *moduleGroup) {
+		if !keep[group] {
+			keep[group] = true
+			queue = append(queue, group)
+		}
+	}
+
+	var errs []error
+	for _, root := range roots {
+		group := c.moduleGroupFromName(root, nil)
+		if group == nil {
+			errs = append(errs, fmt.Errorf("root module %q does not exist", root))
+			continue
+		}
+		add(group)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	var referencedBy map[*moduleGroup][]*moduleGroup
+	if slices.ContainsFunc(c.mutatorInfo, func(m *mutatorInfo) bool { return m.usesReverseDependencies }) {
+		referencedBy = make(map[*moduleGroup][]*moduleGroup)
+		for _, group := range c.moduleGroups {
+			for _, ref := range c.referencedGroups(group) {
+				referencedBy[ref] = append(referencedBy[ref], group)
+			}
+		}
+	}
+
+	for {
+		for len(queue) > 0 {
+			group := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			for _, ref := range c.referencedGroups(group) {
+				add(ref)
+			}
+			for _, ref := range referencedBy[group] {
+				add(ref)
+			}
+		}
+
+		partial, errs := c.cloneUnresolved(func(group *moduleGroup) bool { return keep[group] })
+		if len(errs) > 0 {
+			return errs
+		}
+		partial.allowMissingDependencies = true
+		partial.SkipCloneModulesAfterMutators = true
+		if _, errs := partial.ResolveDependencies(config); len(errs) > 0 {
+			return errs
+		}
+
+		for module := range partial.iterateAllVariants() {
+			namespace := c.nameInterface.GetNamespace(newNamespaceContext(module))
+			for _, name := range module.missingDeps {
+				// Missing dependencies on a specific variant are recorded as name{variations}.
+				name, _, _ = strings.Cut(name, "{")
+				if group := c.moduleGroupFromName(name, namespace); group != nil {
+					add(group)
+				}
+			}
+		}
+		if len(queue) == 0 {
+			break
+		}
+	}
+
+	nameInterface := c.nameInterface.(*SimpleNameInterface).newWithSameNamespaces()
+	moduleInfo := make(map[Module]*moduleInfo)
+	var groups []*moduleGroup
+	for _, group := range c.moduleGroups {
+		if !keep[group] {
+			continue
+		}
+		for _, module := range group.modules {
+			_, errs := nameInterface.NewModule(newNamespaceContext(module), ModuleGroup{moduleGroup: group},
+				module.logicModule)
+			if len(errs) > 0 {
+				for i := range errs {
+					errs[i] = &BlueprintError{Err: errs[i], Pos: module.pos}
+				}
+				return errs
+			}
+			moduleInfo[module.logicModule] = module
+		}
+		groups = append(groups, group)
+	}
+	c.nameInterface = nameInterface
+	c.moduleInfo = moduleInfo
+	c.moduleGroups = groups
+
+	_, errs = c.ResolveDependencies(config)
+	return errs
+}
+
+// referencedGroups returns the module groups, other than group, whose names appear in a string value
+// of the properties of the modules in group.
+func (c *Context) referencedGroups(group *moduleGroup) []*moduleGroup {
+	var groups []*moduleGroup
+	for _, module := range group.modules {
+		namespace := c.nameInterface.GetNamespace(newNamespaceContext(module))
+		visited := make(map[uintptr]bool)
+		for _, p := range module.properties {
+			visitPropertyStrings(reflect.ValueOf(p), visited, func(s string) {
+				ref := c.moduleGroupFromName(s, namespace)
+				if ref != nil && ref != group && !slices.Contains(groups, ref) {
+					groups = append(groups, ref)
+				}
+			})
+		}
+	}
+	return groups
+}
+
+// visitPropertyStrings calls visit with every string value found in v, including the values of
+// unexported fields such as the cases of a proptools.Configurable.  visited holds the pointers that
+// have already been followed, to stop at pointer cycles.
+func visitPropertyStrings(v reflect.Value, visited map[uintptr]bool, visit func(string)) {
+	switch v.Kind() {
+	case reflect.String:
+		visit(v.String())
+	case reflect.Pointer:
+		if !v.IsNil() && !visited[v.Pointer()] {
+			visited[v.Pointer()] = true
+			visitPropertyStrings(v.Elem(), visited, visit)
+		}
+	case reflect.Interface:
+		if !v.IsNil() {
+			visitPropertyStrings(v.Elem(), visited, visit)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			visitPropertyStrings(v.Field(i), visited, visit)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			visitPropertyStrings(v.Index(i), visited, visit)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			visitPropertyStrings(iter.Value(), visited, visit)
+		}
+	}
+}
+
+// cloneUnresolved returns a new Context with the same registrations and settings as this Context
+// and a copy of each of its modules, before any mutators have run.  Each transition mutator and
+// singleton is given new state, and the modules are recreated with cloneLogicModule.  It requires
+// a SimpleNameInterface.  If include is not nil only the module groups it returns true for are
+// copied.
+func (c *Context) cloneUnresolved(include func(*moduleGroup) bool) (*Context, []error) {
+	clone := newContext()
+	clone.Context = c.Context
+	clone.nameInterface = c.nameInterface.(*SimpleNameInterface).newWithSameNamespaces()
+	clone.moduleFactories = c.moduleFactories
+	clone.variantMutatorNames = c.variantMutatorNames
+	clone.ignoreUnknownModuleTypes = c.ignoreUnknownModuleTypes
+	clone.allowMissingDependencies = c.allowMissingDependencies
+	clone.allowEmptyPathGlobs = c.allowEmptyPathGlobs
+	clone.errorOnDuplicateOutputs = c.errorOnDuplicateOutputs
+	clone.checkRuleArgs = c.checkRuleArgs
+	clone.internRules = c.internRules
+	clone.errorOnAmbiguousFarDependencies = c.errorOnAmbiguousFarDependencies
+	clone.stringInterpolation = c.stringInterpolation
+	clone.variantSeparator = c.variantSeparator
+	clone.dependencyPathRoot = c.dependencyPathRoot
+	clone.disabledMutators = c.disabledMutators
+	clone.deterministicDependencyOrder = c.deterministicDependencyOrder
+	clone.moduleVisitOrder = c.moduleVisitOrder
+	clone.parallelGenerateBuildActions = c.parallelGenerateBuildActions
+	clone.includeDisabledModules = c.includeDisabledModules
+	clone.dependencyNameResolver = c.dependencyNameResolver
+	clone.dependencyTagNames = c.dependencyTagNames
+	clone.dependencyTagsByName = c.dependencyTagsByName
+	clone.ninjaVariables = c.ninjaVariables
+	clone.srcDir = c.srcDir
+	clone.fs = c.fs
+	clone.includeTags = c.includeTags
+	clone.sourceRootDirs = c.sourceRootDirs
+	clone.SkipCloneModulesAfterMutators = c.SkipCloneModulesAfterMutators
+	clone.BeforePrepareBuildActionsHook = c.BeforePrepareBuildActionsHook
+	clone.moduleListFile = c.moduleListFile
+	clone.parseDeps = c.parseDeps
+	clone.manifestRegeneration = c.manifestRegeneration
+	clone.incrementalAnalysis = c.incrementalAnalysis
+	clone.incrementalEnabled = c.incrementalEnabled
+	clone.buildActionsFromCache = c.buildActionsFromCache
+	clone.orderOnlyStringsFromCache = c.orderOnlyStringsFromCache
+	clone.mutatorTraceHook = c.mutatorTraceHook
+
+	// ModuleTrace is safe for concurrent use, so the events of the clone are recorded into the
+	// traces returned by TraceModule on this Context.
+	clone.moduleTraces = c.moduleTraces
+
+	// Copy the mutators, giving each transition mutator new state.  Each transition mutator is
+	// registered as three consecutive mutators by RegisterTransitionMutator.
+	for i := 0; i < len(c.mutatorInfo); i++ {
+		info := *c.mutatorInfo[i]
+		if impl := info.propagatesTransitionMutator; impl != nil {
+			newImpl := &transitionMutatorImpl{name: impl.name, mutator: impl.mutator,
+				after: impl.after, before: impl.before,
+				usesAliasVariations: impl.usesAliasVariations}
+			bottomUp, mutate := *c.mutatorInfo[i+1], *c.mutatorInfo[i+2]
+			info.propagatesTransitionMutator, info.topDownMutator = newImpl, newImpl.topDownMutator
+			bottomUp.transitionMutator, bottomUp.bottomUpMutator = newImpl, newImpl.bottomUpMutator
+			mutate.mutatesTransitionMutator, mutate.bottomUpMutator = newImpl, newImpl.mutateMutator
+			clone.mutatorInfo = append(clone.mutatorInfo, &info, &bottomUp, &mutate)
+			i += 2
+		} else {
+			clone.mutatorInfo = append(clone.mutatorInfo, &info)
+		}
+	}
+
+	// A GraphSnapshot is filled in by the Context that takes it, so the clone takes its own
+	// snapshots, which are returned by SnapshotAfterMutator on the clone.
+	for name := range c.graphSnapshots {
+		clone.SnapshotAfterMutator(name)
+	}
+
+	for _, info := range c.singletonInfo {
+		clone.singletonInfo = append(clone.singletonInfo, &singletonInfo{
+			factory:   info.factory,
+			singleton: info.factory(),
+			name:      info.name,
+			parallel:  info.parallel,
+			after:     info.after,
+		})
+	}
+
+	var groups []*moduleGroup
+	for _, group := range c.moduleGroups {
+		if include == nil || include(group) {
+			groups = append(groups, group)
+		}
+	}
+
+	newModules := make(map[*moduleInfo]*moduleInfo)
+	for _, group := range groups {
+		for _, module := range group.modules {
+			logicModule, properties := c.cloneLogicModule(module)
+			newModules[module] = &moduleInfo{
+				typeName:          module.typeName,
+				factory:           module.factory,
+				relBlueprintsFile: module.relBlueprintsFile,
+				pos:               module.pos,
+				propertyPos:       module.propertyPos,
+				createdBy:         module.createdBy,
+				variant:           module.variant,
+				logicModule:       logicModule,
+				properties:        properties,
+			}
+		}
+	}
+	for _, group := range groups {
+		for _, module := range group.modules {
+			newModule := newModules[module]
+			if newModule.createdBy != nil {
+				newModule.createdBy = newModules[newModule.createdBy]
+			}
+			if errs := clone.addModule(newModule); len(errs) > 0 {
+				return nil, errs
+			}
+		}
+	}
+
+	return clone, nil
+}
+
+// coalesceMutators takes the list of mutators and returns a list of lists of mutators,
+// where sublist is a compatible group of mutators that can be run with relaxed
+// intra-mutator ordering.
+func coalesceMutators(mutators []*mutatorInfo) [][]*mutatorInfo {
+	var coalescedMutators [][]*mutatorInfo
+	var last *mutatorInfo
+
+	// Returns true if the mutator can be coalesced with other mutators that
 	// also return true.
 	coalescable := func(m *mutatorInfo) bool {
 		return m.bottomUpMutator != nil &&
@@ -1887,7 +3609,8 @@ func coalesceMutators(mutators []*mutatorInfo) [][]*mutatorInfo {
 			!m.usesReverseDependencies &&
 			!m.usesRename &&
 			!m.mutatesGlobalState &&
-			!m.mutatesDependencies
+			!m.mutatesDependencies &&
+			(m.mutatesTransitionMutator == nil || !m.mutatesTransitionMutator.usesAliasVariations)
 	}
 
 	for _, mutator := range mutators {
@@ -1906,13 +3629,24 @@ func coalesceMutators(mutators []*mutatorInfo) [][]*mutatorInfo {
 func (c *Context) resolveDependencies(ctx context.Context, config interface{}) (deps []string, errs []error) {
 	pprof.Do(ctx, pprof.Labels("blueprint", "ResolveDependencies"), func(ctx context.Context) {
 		c.initProviders()
+		c.resolvedConfig = config
+
+		errs = c.orderTransitionMutators()
+		if len(errs) > 0 {
+			return
+		}
 
 		errs = c.updateDependencies()
 		if len(errs) > 0 {
 			return
 		}
 
-		mutatorGroups := coalesceMutators(c.mutatorInfo)
+		var mutators []*mutatorInfo
+		mutators, errs = c.enabledMutators()
+		if len(errs) > 0 {
+			return
+		}
+		mutatorGroups := c.splitMutatorGroupsAtSnapshots(coalesceMutators(mutators))
 
 		deps, errs = c.runMutators(ctx, config, mutatorGroups)
 		if len(errs) > 0 {
@@ -1977,7 +3711,12 @@ func (c *Context) findReverseDependency(module *moduleInfo, config any, requeste
 		}}
 	}
 
-	if m, _, errs := c.findVariant(module, config, possibleDeps, requestedVariations, false, true); errs != nil {
+	if err := c.checkReverseDependencyVariations(module, destName, requestedVariations); err != nil {
+		return nil, []error{err}
+	}
+
+	m, newVariant, _, errs := c.findVariant(module, config, possibleDeps, requestedVariations, nil, false, true)
+	if errs != nil {
 		return nil, errs
 	} else if m != nil {
 		return m, nil
@@ -1985,44 +3724,89 @@ func (c *Context) findReverseDependency(module *moduleInfo, config any, requeste
 
 	if c.allowMissingDependencies {
 		// Allow missing variants.
-		return nil, c.discoveredMissingDependencies(module, destName, module.variant.variations)
+		return nil, c.discoveredMissingDependencies(module, destName, newVariant)
 	}
 
-	return nil, []error{&BlueprintError{
-		Err: fmt.Errorf("reverse dependency %q of %q missing variant:\n  %s\navailable variants:\n  %s",
-			destName, module.Name(),
-			c.prettyPrintVariant(module.variant.variations),
-			c.prettyPrintGroupVariants(possibleDeps)),
-		Pos: module.pos,
-	}}
+	err := c.missingVariantError(module, destName, possibleDeps, newVariant,
+		"reverse dependency %q of %q missing variant:\n  %s\navailable variants:\n  %s")
+	// seven characters at the start of the line to align with the string "error: "
+	err.Err = fmt.Errorf("%w\n       %s <-- %q defined here", err.Err, possibleDeps.modules.firstModule().pos, destName)
+	return nil, []error{err}
+}
+
+// checkReverseDependencyVariations returns an error if the variations requested for a reverse dependency
+// from destName on module select a variation of a mutator that is not a TransitionMutator that has
+// already run, as no variant of destName could match them.
+func (c *Context) checkReverseDependencyVariations(module *moduleInfo, destName string,
+	variations []Variation) error {
+
+	for _, v := range variations {
+		finished := slices.ContainsFunc(c.transitionMutators, func(t *transitionMutatorImpl) bool {
+			return t.name == v.Mutator
+		})
+		if !finished {
+			return &BlueprintError{
+				Err: fmt.Errorf("reverse dependency %q of %q requests variation %q of %q, which is not a "+
+					"transition mutator that has already run", destName, module.Name(), v.Variation, v.Mutator),
+				Pos: module.pos,
+			}
+		}
+	}
+	return nil
+}
+
+// findOrCreateReverseDependency is like findReverseDependency, but if the requested variant of the
+// destination doesn't exist it runs the destination through the IncomingTransition of the most
+// recent transition mutator and creates the resulting variant.  It is called serially at the end of
+// a mutator pass.  It returns nil and no errors if the incoming transition dropped the dependency.
+func (c *Context) findOrCreateReverseDependency(config any, mutator *mutatorInfo,
+	r reverseDepCreatingVariant) (*moduleInfo, []error) {
+
+	module := r.dep.module
+	possibleDeps := c.moduleGroupFromName(r.destName, module.namespace())
+	if possibleDeps == nil || len(c.transitionMutators) == 0 {
+		return c.findReverseDependency(module, config, r.variations, r.destName)
+	}
+
+	if m, _, _, errs := c.findVariant(module, config, possibleDeps, r.variations, r.dep.tag, false, true); errs != nil {
+		return nil, errs
+	} else if m != nil {
+		return m, nil
+	}
+
+	t := c.transitionMutators[len(c.transitionMutators)-1]
+	if t.variantCreatingMutatorIndex < len(c.variantCreatingMutatorOrder)-1 {
+		// Variants can only be created on demand for the most recent variant creating mutator.
+		return c.findReverseDependency(module, config, r.variations, r.destName)
+	}
+
+	requested := module.variant.variations.clone()
+	for _, v := range r.variations {
+		requested.set(v.Mutator, v.Variation)
+	}
+
+	dep, errs := t.createVariantOnDemand(c, config, mutator, module, possibleDeps, requested)
+	if errs != nil {
+		return nil, errs
+	} else if dep == nil && !requested.skipped() {
+		return c.findReverseDependency(module, config, r.variations, r.destName)
+	}
+	return dep, nil
 }
 
 // applyTransitions takes a variationMap being used to add a dependency on a module in a moduleGroup
 // and applies the OutgoingTransition and IncomingTransition methods of each completed TransitionMutator to
 // modify the requested variation.  It finds a variant that existed before the TransitionMutator ran that is
-// a subset of the requested variant to use as the module context for IncomingTransition.
+// a subset of the requested variant to use as the module context for IncomingTransition.  It returns the
+// modified variant and the transition applied by each TransitionMutator.
 func (c *Context) applyTransitions(config any, module *moduleInfo, group *moduleGroup, variant variationMap,
-	requestedVariations []Variation) (variationMap, []error) {
+	requestedVariations []Variation, tag DependencyTag) (variationMap, []EdgeTransition, []error) {
+	var transitions []EdgeTransition
 	for _, transitionMutator := range c.transitionMutators {
 		explicitlyRequested := slices.ContainsFunc(requestedVariations, func(variation Variation) bool {
 			return variation.Mutator == transitionMutator.name
 		})
 
-		sourceVariation := variant.get(transitionMutator.name)
-		outgoingVariation := sourceVariation
-
-		// Apply the outgoing transition if it was not explicitly requested.
-		if !explicitlyRequested {
-			ctx := &outgoingTransitionContextImpl{
-				transitionContextImpl{context: c, source: module, dep: nil,
-					depTag: nil, postMutator: true, config: config},
-			}
-			outgoingVariation = transitionMutator.mutator.OutgoingTransition(ctx, sourceVariation)
-			if len(ctx.errs) > 0 {
-				return variationMap{}, ctx.errs
-			}
-		}
-
 		earlierVariantCreatingMutators := c.variantCreatingMutatorOrder[:transitionMutator.variantCreatingMutatorIndex]
 		filteredVariant := variant.cloneMatching(earlierVariantCreatingMutators)
 
@@ -2031,8 +3815,8 @@ func (c *Context) applyTransitions(config any, module *moduleInfo, group *module
 			return filteredInputVariant.equal(filteredVariant)
 		}
 
-		// Find an appropriate module to use as the context for the IncomingTransition.  First check if any of the
-		// saved inputVariants for the transition mutator match the filtered variant.
+		// Find an appropriate module to use as the DepModule for the OutgoingTransition and the context for the
+		// IncomingTransition.  First check if any of the saved inputVariants for the transition mutator match the filtered variant.
 		var matchingInputVariant *moduleInfo
 		for _, inputVariant := range transitionMutator.inputVariants[group] {
 			if check(inputVariant.variant.variations) {
@@ -2054,16 +3838,45 @@ func (c *Context) applyTransitions(config any, module *moduleInfo, group *module
 			}
 		}
 
-		if matchingInputVariant != nil {
+		sourceVariation := variant.get(transitionMutator.name)
+		outgoingVariation := sourceVariation
+
+		// Apply the outgoing transition if it was not explicitly requested.
+		if !explicitlyRequested {
+			ctx := &outgoingTransitionContextImpl{
+				transitionContextImpl{context: c, mutator: transitionMutator, source: module,
+					dep: matchingInputVariant, depName: group.name, depTag: tag, variation: sourceVariation, postMutator: true, config: config},
+			}
+			outgoingVariation = transitionMutator.mutator.OutgoingTransition(ctx, sourceVariation)
+			if len(ctx.errs) > 0 {
+				return variationMap{}, nil, ctx.errs
+			}
+		}
+
+		if matchingInputVariant != nil && matchingInputVariant.skippedMutators[transitionMutator.name] {
+			// The transition mutator was skipped for the target, so it only has a single variant.
+			variant.delete(transitionMutator.name)
+		} else if matchingInputVariant != nil {
 			// Apply the incoming transition.
 			ctx := &incomingTransitionContextImpl{
-				transitionContextImpl{context: c, source: nil, dep: matchingInputVariant,
-					depTag: nil, postMutator: true, config: config},
+				transitionContextImpl: transitionContextImpl{context: c, mutator: transitionMutator, source: module,
+					dep: matchingInputVariant, depTag: tag, variation: outgoingVariation, postMutator: true,
+					config: config},
 			}
 
 			finalVariation := transitionMutator.mutator.IncomingTransition(ctx, outgoingVariation)
 			if len(ctx.errs) > 0 {
-				return variationMap{}, ctx.errs
+				return variationMap{}, nil, ctx.errs
+			}
+			if ctx.skip {
+				finalVariation = skippedVariation
+			} else {
+				var errs []error
+				finalVariation, errs = transitionMutator.applyDefaultVariation(c, config, matchingInputVariant,
+					finalVariation)
+				if len(errs) > 0 {
+					return variationMap{}, nil, errs
+				}
 			}
 			variant.set(transitionMutator.name, finalVariation)
 		}
@@ -2073,13 +3886,52 @@ func (c *Context) applyTransitions(config any, module *moduleInfo, group *module
 			// was explicitly requested when adding the dependency.
 			variant.delete(transitionMutator.name)
 		}
+
+		transitions = append(transitions, EdgeTransition{
+			Mutator:           transitionMutator.name,
+			SourceVariation:   module.variant.variations.get(transitionMutator.name),
+			OutgoingVariation: outgoingVariation,
+			FinalVariation:    variant.get(transitionMutator.name),
+		})
 	}
 
-	return variant, nil
+	return variant, transitions, nil
+}
+
+// matchVariant returns a bool for whether the requested variant matches the given variant, and a
+// divergence score.  A score of 0 is best match, and a positive integer is a worse match.  For a
+// non-far search, the score is always 0 as the match must always be exact.  For a far search, the
+// score is the number of variations that are present in the given variant but not the requested
+// one.
+func matchVariant(requested, variant variationMap, far bool) (bool, int) {
+	if far {
+		if requested.subsetOf(variant) {
+			return true, variant.differenceKeysCount(requested)
+		}
+	} else {
+		if variant.equal(requested) {
+			return true, 0
+		}
+	}
+	return false, math.MaxInt
 }
 
-func (c *Context) findVariant(module *moduleInfo, config any,
-	possibleDeps *moduleGroup, requestedVariations []Variation, far bool, reverse bool) (*moduleInfo, variationMap, []error) {
+// findVariant returns the variant of possibleDeps that a dependency from module requesting
+// requestedVariations resolves to, the variant that was requested after applying the
+// transition mutators and the transitions that were applied.  Reverse dependencies don't apply
+// the transition mutators and return no transitions.  A non-far dependency must match the requested variant exactly.  A far
+// dependency starts from only the requested variations and the variations of the mutators that
+// are never far, and selects, in order of precedence:
+//   - the variant that matches the requested variant exactly,
+//   - otherwise the matching variant with the fewest variations beyond the requested ones, so a
+//     variant with the default empty variation of a mutator is preferred over one with a named
+//     variation,
+//   - if several matching variants have the fewest extra variations, the first of them, or an
+//     error if variations were requested and SetErrorOnAmbiguousFarDependencies was called.
+//
+// See Context.ExplainFarMatch.
+func (c *Context) findVariant(module *moduleInfo, config any, possibleDeps *moduleGroup,
+	requestedVariations []Variation, tag DependencyTag, far bool, reverse bool) (*moduleInfo, variationMap, []EdgeTransition, []error) {
 
 	// We can't just append variant.Variant to module.dependencyVariant.variantName and
 	// compare the strings because the result won't be in mutator registration order.
@@ -2089,7 +3941,7 @@ func (c *Context) findVariant(module *moduleInfo, config any,
 		newVariant = module.variant.variations.clone()
 	} else {
 		for _, mutator := range c.mutatorInfo {
-			if mutator.neverFar {
+			if mutator.isNeverFarFor(requestedVariations) {
 				newVariant.set(mutator.name, module.variant.variations.get(mutator.name))
 			}
 		}
@@ -2098,75 +3950,159 @@ func (c *Context) findVariant(module *moduleInfo, config any,
 		newVariant.set(v.Mutator, v.Variation)
 	}
 
+	var transitions []EdgeTransition
 	if !reverse {
 		var errs []error
-		newVariant, errs = c.applyTransitions(config, module, possibleDeps, newVariant, requestedVariations)
+		newVariant, transitions, errs = c.applyTransitions(config, module, possibleDeps, newVariant, requestedVariations, tag)
 		if len(errs) > 0 {
-			return nil, variationMap{}, errs
+			module.transitionErrs += len(errs)
+			return nil, variationMap{}, nil, errs
 		}
 	}
 
-	// check returns a bool for whether the requested newVariant matches the given variant from possibleDeps, and a
-	// divergence score.  A score of 0 is best match, and a positive integer is a worse match.
-	// For a non-far search, the score is always 0 as the match must always be exact.  For a far search,
-	// the score is the number of variants that are present in the given variant but not newVariant.
-	check := func(variant variationMap) (bool, int) {
-		if far {
-			if newVariant.subsetOf(variant) {
-				return true, variant.differenceKeysCount(newVariant)
-			}
-		} else {
-			if variant.equal(newVariant) {
-				return true, 0
-			}
-		}
-		return false, math.MaxInt
-	}
+	newVariant = possibleDeps.applyVariationAliases(newVariant)
 
 	var foundDep *moduleInfo
+	var tiedDeps []*moduleInfo
 	bestDivergence := math.MaxInt
 	for _, m := range possibleDeps.modules {
-		if match, divergence := check(m.variant.variations); match && divergence < bestDivergence {
+		match, divergence := matchVariant(newVariant, m.variant.variations, far)
+		if match && divergence < bestDivergence {
 			foundDep = m
+			tiedDeps = nil
 			bestDivergence = divergence
 			if !far {
 				// non-far dependencies use equality, so only the first match needs to be checked.
 				break
 			}
+		} else if match && divergence == bestDivergence {
+			tiedDeps = append(tiedDeps, m)
+		}
+	}
+
+	// A far dependency with no requested variations matches any variant and uses the first one.  One
+	// that requests a subset of the variations also uses the first of the closest matches, unless
+	// ambiguous far dependencies are errors.
+	if len(tiedDeps) > 0 && len(requestedVariations) > 0 && c.errorOnAmbiguousFarDependencies {
+		candidates := []string{c.prettyPrintVariant(foundDep.variant.variations)}
+		for _, m := range tiedDeps {
+			candidates = append(candidates, c.prettyPrintVariant(m.variant.variations))
 		}
+		return nil, variationMap{}, nil, []error{&BlueprintError{
+			Err: fmt.Errorf("far dependency %q of %q matches multiple variants equally well:\n  %s\ncandidates:\n  %s",
+				possibleDeps.name, module.Name(), c.prettyPrintVariant(newVariant), strings.Join(candidates, "\n  ")),
+			Pos: module.pos,
+		}}
 	}
 
-	return foundDep, newVariant, nil
+	return foundDep, newVariant, transitions, nil
 }
 
+// ExplainFarMatch returns a description of how a far variation dependency from the module on the
+// module with the given name that requests the given variations selects a variant, listing the
+// requested variant, how well each variant of the named module matches it and the selected
+// variant.  The precedence rules are applied as they would be by the
+// BottomUpMutatorContext.AddFarVariationDependencies call after all mutators have run, with a nil
+// dependency tag.  It must be called after ResolveDependencies.  If from is not a module known to
+// the Context the returned description says so.
+func (c *Context) ExplainFarMatch(from Module, name string, variations ...Variation) string {
+	module := c.moduleInfo[from]
+	if module == nil {
+		return fmt.Sprintf("far dependency %q of %q: module %q is not known to the Context",
+			name, from.Name(), from.Name())
+	}
+	possibleDeps := c.moduleGroupFromName(name, module.namespace())
+	if possibleDeps == nil {
+		return fmt.Sprintf("far dependency %q of %q: no module named %q", name, module.Name(), name)
+	}
+
+	foundDep, newVariant, _, errs := c.findVariant(module, c.resolvedConfig, possibleDeps, variations, nil, true, false)
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "far dependency %q of %q requests:\n  %s\n", name, module.Name(), c.prettyPrintVariant(newVariant))
+	sb.WriteString("candidates:\n")
+	for _, m := range possibleDeps.modules {
+		match, divergence := matchVariant(newVariant, m.variant.variations, true)
+		var explanation string
+		switch {
+		case !match:
+			explanation = "does not match"
+		case divergence == 0:
+			explanation = "exact match"
+		default:
+			explanation = fmt.Sprintf("%d extra variations", divergence)
+		}
+		fmt.Fprintf(sb, "  %s: %s\n", c.prettyPrintVariant(m.variant.variations), explanation)
+	}
+
+	switch {
+	case len(errs) > 0:
+		for _, err := range errs {
+			if bpErr, ok := err.(*BlueprintError); ok {
+				err = bpErr.Err
+			}
+			fmt.Fprintf(sb, "error: %s\n", err)
+		}
+	case newVariant.skipped():
+		sb.WriteString("selected: none, dropped by an incoming transition\n")
+	case foundDep == nil:
+		sb.WriteString("selected: none\n")
+	default:
+		fmt.Fprintf(sb, "selected: %s\n", c.prettyPrintVariant(foundDep.variant.variations))
+	}
+	return sb.String()
+}
+
+// addVariationDependency adds a dependency from module on the variant of depName selected by
+// variations.  If optional is true a missing module or variant is silently ignored and nil is
+// returned, regardless of allowMissingDependencies.  depName may select a variation of the most
+// recent transition mutator with the "name:variant" shorthand, see splitDependencyVariant.
 func (c *Context) addVariationDependency(module *moduleInfo, mutator *mutatorInfo, config any, variations []Variation,
-	tag DependencyTag, depName string, far bool) (*moduleInfo, []error) {
+	tag DependencyTag, depName string, far bool, optional bool) (*moduleInfo, []error) {
 	if _, ok := tag.(BaseDependencyTag); ok {
 		panic("BaseDependencyTag is not allowed to be used directly!")
 	}
 
+	depName, variations, err := c.splitDependencyVariant(depName, variations)
+	if err != nil {
+		return nil, []error{&BlueprintError{
+			Err: err,
+			Pos: module.pos,
+		}}
+	}
+	if c.dependencyNameResolver != nil {
+		depName = c.dependencyNameResolver(module.logicModule, depName)
+	}
+
 	possibleDeps := c.moduleGroupFromName(depName, module.namespace())
 	if possibleDeps == nil {
+		if optional {
+			return nil, nil
+		}
 		return nil, c.discoveredMissingDependencies(module, depName, variationMap{})
 	}
 
-	foundDep, newVariant, errs := c.findVariant(module, config, possibleDeps, variations, far, false)
+	foundDep, newVariant, transitions, errs := c.findVariant(module, config, possibleDeps, variations, tag, far, false)
 	if errs != nil {
 		return nil, errs
 	}
 
+	if newVariant.skipped() {
+		// An incoming transition dropped the dependency.
+		c.traceModule(module, mutator.name, "dependency on %q dropped by an incoming transition", depName)
+		return nil, nil
+	}
+
 	if foundDep == nil {
+		if optional {
+			return nil, nil
+		}
 		if c.allowMissingDependencies {
 			// Allow missing variants.
 			return nil, c.discoveredMissingDependencies(module, depName, newVariant)
 		}
-		return nil, []error{&BlueprintError{
-			Err: fmt.Errorf("dependency %q of %q missing variant:\n  %s\navailable variants:\n  %s",
-				depName, module.Name(),
-				c.prettyPrintVariant(newVariant),
-				c.prettyPrintGroupVariants(possibleDeps)),
-			Pos: module.pos,
-		}}
+		return nil, []error{c.missingVariantError(module, depName, possibleDeps, newVariant,
+			"dependency %q of %q missing variant:\n  %s\navailable variants:\n  %s")}
 	}
 
 	if module == foundDep {
@@ -2184,17 +4120,40 @@ func (c *Context) addVariationDependency(module *moduleInfo, mutator *mutatorInf
 			Pos: module.pos,
 		}}
 	}
+	if err := c.checkVisibility(module, foundDep); err != nil {
+		return nil, []error{err}
+	}
 
 	// The mutator will pause until the newly added dependency has finished running the current mutator,
 	// so it is safe to add the new dependency directly to directDeps and forwardDeps where it will be visible
 	// to future calls to VisitDirectDeps.  Set newDirectDeps so that at the end of the mutator the reverseDeps
 	// of the dependencies can be updated to point to this module without running a full c.updateDependencies()
-	module.directDeps = append(module.directDeps, depInfo{foundDep, tag})
+	module.directDeps = append(module.directDeps, depInfo{foundDep, tag, transitions})
 	module.forwardDeps = append(module.forwardDeps, foundDep)
 	module.newDirectDeps = append(module.newDirectDeps, foundDep)
+	c.traceDependency(module, foundDep, mutator.name, "dependency", tag)
 	return foundDep, nil
 }
 
+// splitDependencyVariant splits a dependency name of the form "name:variant" into the module name
+// and variations with the variant added as the variation of the most recent transition mutator,
+// overriding any variation for that mutator already in variations.  A name without a variant is
+// returned unchanged.  Names starting with "//" are fully qualified names that contain a ':' between
+// the path and the module name, so they only have a variant if they contain a second ':'.
+func (c *Context) splitDependencyVariant(depName string, variations []Variation) (string, []Variation, error) {
+	i := strings.LastIndexByte(depName, ':')
+	if i < 0 || (strings.HasPrefix(depName, "//") && strings.IndexByte(depName, ':') == i) {
+		return depName, variations, nil
+	}
+	name, variant := depName[:i], depName[i+1:]
+	if len(c.transitionMutators) == 0 {
+		return "", nil, fmt.Errorf("dependency %q selects variant %q before any transition mutator has run",
+			name, variant)
+	}
+	t := c.transitionMutators[len(c.transitionMutators)-1]
+	return name, append(slices.Clip(variations), Variation{Mutator: t.name, Variation: variant}), nil
+}
+
 // findBlueprintDescendants returns a map linking parent Blueprint files to child Blueprints files
 // For example, if paths = []string{"a/b/c/Android.bp", "a/Android.bp"},
 // then descendants = {"":[]string{"a/Android.bp"}, "a/Android.bp":[]string{"a/b/c/Android.bp"}}
@@ -2235,6 +4194,85 @@ func findBlueprintDescendants(paths []string) (descendants map[string][]string,
 	return descendants, nil
 }
 
+// alphabeticallySortedModules returns all module variants sorted by name and then variant name.
+func (c *Context) alphabeticallySortedModules() []*moduleInfo {
+	modules := slices.Collect(c.iterateAllVariants())
+	slices.SortStableFunc(modules, func(a, b *moduleInfo) int {
+		return cmp.Or(cmp.Compare(a.Name(), b.Name()), cmp.Compare(a.variant.name, b.variant.name))
+	})
+	return modules
+}
+
+// topologicallySortedModules returns all module variants ordered so that each module comes after
+// all of its dependencies, breaking ties by name and then variant name.
+func (c *Context) topologicallySortedModules() []*moduleInfo {
+	sorted, _ := c.topologicalSort()
+	return sorted
+}
+
+// topologicalSort returns all module variants ordered like topologicallySortedModules.  If the
+// dependencies contain a cycle it returns the modules in the cycle instead, in the reverse order
+// expected by cycleError.
+func (c *Context) topologicalSort() (sorted, cycle []*moduleInfo) {
+	roots := c.alphabeticallySortedModules()
+	sorted = make([]*moduleInfo, 0, len(roots))
+	visited := make(map[*moduleInfo]bool, len(roots))
+	var stack []*moduleInfo
+
+	var visit func(module *moduleInfo) []*moduleInfo
+	visit = func(module *moduleInfo) []*moduleInfo {
+		if done, seen := visited[module]; seen {
+			if done {
+				return nil
+			}
+			// The module is still on the stack, so the path from it to the top of the stack
+			// is a cycle.
+			cycle := slices.Clone(stack[slices.Index(stack, module):])
+			slices.Reverse(cycle)
+			return cycle
+		}
+		visited[module] = false
+		stack = append(stack, module)
+		for _, dep := range module.forwardDeps {
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		stack = stack[:len(stack)-1]
+		visited[module] = true
+		sorted = append(sorted, module)
+		return nil
+	}
+
+	for _, module := range roots {
+		if cycle := visit(module); cycle != nil {
+			return nil, cycle
+		}
+	}
+	return sorted, nil
+}
+
+// TopologicalModuleOrder returns every module variant ordered so that each module comes after all
+// of its dependencies, breaking ties by name and then variant name.  This is the order used by
+// ModuleVisitOrderTopological, and is stable for the same set of modules and dependencies, so it
+// can be split into contiguous ranges to divide work between multiple processes.  It returns an
+// error if it is called before ResolveDependencies, or if the dependencies contain a cycle, in
+// which case the error lists the modules in the cycle.
+func (c *Context) TopologicalModuleOrder() ([]Module, error) {
+	if !c.dependenciesReady {
+		return nil, fmt.Errorf("TopologicalModuleOrder called before ResolveDependencies")
+	}
+	sorted, cycle := c.topologicalSort()
+	if cycle != nil {
+		return nil, errors.Join(cycleError(cycle)...)
+	}
+	modules := make([]Module, len(sorted))
+	for i, module := range sorted {
+		modules[i] = module.logicModule
+	}
+	return modules, nil
+}
+
 type visitOrderer interface {
 	// returns the number of modules that this module needs to wait for
 	waitCount(module *moduleInfo) int
@@ -2553,6 +4591,14 @@ func (c *Context) updateDependencies() (errs []error) {
 		// Reset the forward and reverse deps without reducing their capacity to avoid reallocation.
 		module.reverseDeps = module.reverseDeps[:0]
 		module.forwardDeps = module.forwardDeps[:0]
+		module.inDegree = 0
+
+		if c.deterministicDependencyOrder {
+			slices.SortStableFunc(module.directDeps, func(a, b depInfo) int {
+				return cmp.Or(cmp.Compare(a.module.Name(), b.module.Name()),
+					cmp.Compare(a.module.variant.name, b.module.variant.name))
+			})
+		}
 
 		// Add an implicit dependency ordering on all earlier modules in the same module group
 		selfIndex := slices.Index(module.group.modules, module)
@@ -2563,7 +4609,7 @@ func (c *Context) updateDependencies() (errs []error) {
 			module.forwardDeps = append(module.forwardDeps, dep.module)
 		}
 
-		for _, dep := range module.forwardDeps {
+		for i, dep := range module.forwardDeps {
 			if checking[dep] {
 				// This is a cycle.
 				return []*moduleInfo{dep, module}
@@ -2590,6 +4636,9 @@ func (c *Context) updateDependencies() (errs []error) {
 			}
 
 			dep.reverseDeps = append(dep.reverseDeps, module)
+			if i >= selfIndex {
+				dep.inDegree++
+			}
 		}
 
 		return nil
@@ -2619,7 +4668,8 @@ type jsonModuleName struct {
 
 type jsonDep struct {
 	jsonModuleName
-	Tag string
+	Tag     string
+	TagName string `json:",omitempty"`
 }
 
 type JsonModule struct {
@@ -2629,6 +4679,7 @@ type JsonModule struct {
 	Blueprint string
 	CreatedBy *string
 	Module    map[string]interface{}
+	Metadata  map[string]string `json:",omitempty"`
 }
 
 func jsonModuleNameFromModuleInfo(m *moduleInfo) *jsonModuleName {
@@ -2662,6 +4713,7 @@ func jsonModuleFromModuleInfo(m *moduleInfo) *JsonModule {
 		Type:           m.typeName,
 		Blueprint:      m.relBlueprintsFile,
 		Module:         make(map[string]interface{}),
+		Metadata:       maps.Clone(m.metadata),
 	}
 	if m.createdBy != nil {
 		n := m.createdBy.Name()
@@ -2752,6 +4804,24 @@ func (c *Context) GetWeightedOutputsFromPredicate(predicate func(*JsonModule) (b
 	return outputToWeight
 }
 
+// ModulePropertiesJSON returns the current values of the property structs of a module as JSON, after
+// they have been unpacked from the Blueprints file and modified by load hooks and mutators.  See
+// proptools.PropertiesToJSON for the format.
+func (c *Context) ModulePropertiesJSON(logicModule Module) ([]byte, error) {
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		return nil, fmt.Errorf("module %q is not in this context", logicModule.Name())
+	}
+	return proptools.PropertiesToJSON(module.properties)
+}
+
+// ModuleMetadata returns a copy of the metadata set on a module with BaseModuleContext.SetModuleMetadata, or nil
+// if none was set.
+func (c *Context) ModuleMetadata(logicModule Module) map[string]string {
+	module := c.moduleInfo[logicModule]
+	return maps.Clone(module.metadata)
+}
+
 // PrintJSONGraph prints info of modules in a JSON file.
 func (c *Context) PrintJSONGraphAndActions(wGraph io.Writer, wActions io.Writer) {
 	modulesToGraph := make([]*JsonModule, 0)
@@ -2763,6 +4833,7 @@ func (c *Context) PrintJSONGraphAndActions(wGraph io.Writer, wActions io.Writer)
 			jm.Deps = append(jm.Deps, jsonDep{
 				jsonModuleName: *jsonModuleNameFromModuleInfo(d.module),
 				Tag:            fmt.Sprintf("%T %+v", d.tag, d.tag),
+				TagName:        c.DependencyTagName(d.tag),
 			})
 			jmWithActions.Deps = append(jmWithActions.Deps, jsonDep{
 				jsonModuleName: jsonModuleName{
@@ -2810,6 +4881,7 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 		c.buildActionsReady = false
 
 		c.liveGlobals = newLiveTracker(c, config)
+		c.internedRules = nil
 		// Add all the global rules/variable/pools here because when we restore from
 		// cache we don't have the build defs available to build the globals.
 		// TODO(b/356414070): Revisit this logic once we have a clearer picture about
@@ -2849,6 +4921,22 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 			deps = append(deps, extraDeps...)
 		}
 
+		errs = c.validateSingletonOrder()
+		if len(errs) > 0 {
+			return
+		}
+
+		if c.moduleActionsWriter != nil {
+			if c.GetIncrementalEnabled() {
+				errs = []error{fmt.Errorf("SetModuleActionsWriter cannot be used with incremental analysis")}
+				return
+			}
+			// The module build actions are written before the live packages are known, so the
+			// package names have to be chosen from all of them.
+			pkgNames, _ := c.makeUniquePackageNames(allPackageContexts())
+			c.nameTracker = &nameTracker{pkgNames: pkgNames}
+		}
+
 		var depsModules []string
 		depsModules, errs = c.generateModuleBuildActions(config, c.liveGlobals)
 		if len(errs) > 0 {
@@ -2861,6 +4949,13 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 			return
 		}
 
+		if c.errorOnDuplicateOutputs {
+			errs = c.checkDuplicateOutputs()
+			if len(errs) > 0 {
+				return
+			}
+		}
+
 		deps = append(deps, depsModules...)
 		deps = append(deps, depsSingletons...)
 
@@ -2872,7 +4967,10 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 			}
 		}
 
-		pkgNames, depsPackages := c.makeUniquePackageNames(c.liveGlobals)
+		pkgNames, depsPackages := c.makeUniquePackageNames(c.liveGlobals.packageContexts())
+		if c.moduleActionsWriter != nil {
+			pkgNames = c.nameTracker.pkgNames
+		}
 
 		deps = append(deps, depsPackages...)
 
@@ -2898,6 +4996,11 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 
 func (c *Context) runMutators(ctx context.Context, config interface{}, mutatorGroups [][]*mutatorInfo) (deps []string, errs []error) {
 	c.finishedMutators = make([]bool, len(c.mutatorInfo))
+	for _, mutator := range c.mutatorInfo {
+		if c.disabledMutators[mutator.registeredName()] {
+			c.finishedMutators[mutator.index] = true
+		}
+	}
 
 	pprof.Do(ctx, pprof.Labels("blueprint", "runMutators"), func(ctx context.Context) {
 		for _, mutatorGroup := range mutatorGroups {
@@ -2910,7 +5013,18 @@ func (c *Context) runMutators(ctx context.Context, config interface{}, mutatorGr
 				defer c.EndEvent(name)
 				var newDeps []string
 				if mutatorGroup[0].topDownMutator != nil {
+					if t := mutatorGroup[0].propagatesTransitionMutator; t != nil {
+						c.skipTransitionOnDisabledModules(t)
+						defer t.clearPropagateState()
+					}
 					newDeps, errs = c.runMutator(config, mutatorGroup, topDownMutator)
+					if t := mutatorGroup[0].propagatesTransitionMutator; t != nil {
+						for len(errs) == 0 && t.needsRepropagate() {
+							var moreDeps []string
+							moreDeps, errs = c.runMutator(config, mutatorGroup, topDownMutator)
+							newDeps = append(newDeps, moreDeps...)
+						}
+					}
 				} else if mutatorGroup[0].bottomUpMutator != nil {
 					newDeps, errs = c.runMutator(config, mutatorGroup, bottomUpMutator)
 				} else {
@@ -2924,7 +5038,22 @@ func (c *Context) runMutators(ctx context.Context, config interface{}, mutatorGr
 			if len(errs) > 0 {
 				return
 			}
+			c.takeGraphSnapshots(mutatorGroup)
+		}
+
+		c.BeginEvent("late_dependencies")
+		errs = c.resolveLateDependencies(config)
+		c.EndEvent("late_dependencies")
+		if len(errs) > 0 {
+			return
+		}
+
+		errs = c.resolveOrderOnlyDependencies()
+		if len(errs) > 0 {
+			return
 		}
+
+		errs = c.checkOrderOnlyDependencyCycles()
 	})
 
 	if len(errs) > 0 {
@@ -2934,6 +5063,189 @@ func (c *Context) runMutators(ctx context.Context, config interface{}, mutatorGr
 	return deps, nil
 }
 
+// lateDependency is a dependency added by BottomUpMutatorContext.AddLateVariationDependencies.
+type lateDependency struct {
+	variations []Variation
+	tag        DependencyTag
+	name       string
+}
+
+// resolveLateDependencies adds the dependencies added by BottomUpMutatorContext.AddLateVariationDependencies
+// to each variant after all mutators have run.
+func (c *Context) resolveLateDependencies(config any) (errs []error) {
+	mutator := &mutatorInfo{name: "late_dependencies"}
+	added := false
+	// The variants of a module usually add the same late dependencies, only report each error once.
+	seenErrs := make(map[string]bool)
+	for module := range c.iterateAllVariants() {
+		for _, dep := range module.lateDeps {
+			foundDep, newErrs := c.addVariationDependency(module, mutator, config, dep.variations, dep.tag,
+				dep.name, false, false)
+			for _, err := range newErrs {
+				if !seenErrs[err.Error()] {
+					seenErrs[err.Error()] = true
+					errs = append(errs, err)
+				}
+			}
+			added = added || foundDep != nil
+		}
+		module.lateDeps = nil
+		module.newDirectDeps = nil
+	}
+	if len(errs) > 0 || !added {
+		return errs
+	}
+
+	// Update the reverse dependencies and the sorted module list for the new dependencies, which also
+	// reports any dependency cycles they introduced.
+	return c.updateDependencies()
+}
+
+// resolveOrderOnlyDependencies finds the modules named by BottomUpMutatorContext.AddOrderOnlyDependency
+// after all mutators have run.  The dependencies don't select a variant, they apply to every variant of
+// the named module.
+func (c *Context) resolveOrderOnlyDependencies() (errs []error) {
+	for module := range c.iterateAllVariants() {
+		for _, name := range module.orderOnlyDepNames {
+			group := c.moduleGroupFromName(name, module.namespace())
+			if group == nil {
+				if c.allowMissingDependencies {
+					module.missingDeps = append(module.missingDeps, name)
+				} else {
+					errs = append(errs, c.missingDependencyError(module, name))
+				}
+				continue
+			}
+			if err := c.checkVisibility(module, group.modules.firstModule()); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if !slices.Contains(module.orderOnlyDeps, group) {
+				module.orderOnlyDeps = append(module.orderOnlyDeps, group)
+				group.hasOrderOnlyDependents = true
+			}
+		}
+		module.orderOnlyDepNames = nil
+	}
+	return errs
+}
+
+// checkOrderOnlyDependencyCycles returns an error if the order-only dependencies, together with the
+// other dependencies, form a cycle.  An order-only dependency makes every variant of the current
+// module wait for every variant of the dependency, so the cycle would otherwise only be found by
+// ninja.
+func (c *Context) checkOrderOnlyDependencyCycles() []error {
+	visited := make(map[*moduleInfo]bool)
+	var stack []*moduleInfo
+
+	var visit func(module *moduleInfo) []*moduleInfo
+	visit = func(module *moduleInfo) []*moduleInfo {
+		if done, seen := visited[module]; seen {
+			if done {
+				return nil
+			}
+			cycle := slices.Clone(stack[slices.Index(stack, module):])
+			slices.Reverse(cycle)
+			return cycle
+		}
+		visited[module] = false
+		stack = append(stack, module)
+		for _, dep := range module.forwardDeps {
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		for _, group := range module.orderOnlyDeps {
+			for _, dep := range group.modules {
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		visited[module] = true
+		return nil
+	}
+
+	// Any cycle must contain an order-only dependency, as cycles of other dependencies have already
+	// been rejected, so it is enough to start from the modules that have one.
+	for module := range c.iterateAllVariants() {
+		if len(module.orderOnlyDeps) > 0 {
+			if cycle := visit(module); cycle != nil {
+				return cycleError(cycle)
+			}
+		}
+	}
+	return nil
+}
+
+// addOrderOnlyDependencies adds the ninja order-only dependencies for the order-only dependencies
+// of a module to its build statements.  If the module is the target of an order-only dependency it
+// also records the outputs of its build statements, which are added to the phony target shared by
+// all of its variants by orderOnlyPhonys.  Outputs can reference global ninja variables, but not
+// variables local to the module, as the phony target is not written with the module.
+func (c *Context) addOrderOnlyDependencies(mctx *moduleContext) []error {
+	var errs []error
+	module := mctx.module
+	if module.group.hasOrderOnlyDependents {
+		module.orderOnlyOutputs, module.orderOnlyOutputStrings = nil, nil
+		for _, def := range mctx.actionDefs.buildDefs {
+			module.orderOnlyOutputStrings = append(module.orderOnlyOutputStrings, def.OutputStrings...)
+			module.orderOnlyOutputStrings = append(module.orderOnlyOutputStrings, def.ImplicitOutputStrings...)
+			for _, output := range slices.Concat(def.Outputs, def.ImplicitOutputs) {
+				for _, v := range output.Variables() {
+					if _, ok := v.(*localVariable); ok {
+						errs = append(errs, fmt.Errorf("output %q of a module with order-only dependents "+
+							"references local variable %s", output.str, v.name()))
+					}
+				}
+				module.orderOnlyOutputs = append(module.orderOnlyOutputs, output)
+			}
+		}
+	}
+
+	if len(module.orderOnlyDeps) > 0 {
+		var phonys []string
+		for _, group := range module.orderOnlyDeps {
+			phonys = append(phonys, c.orderOnlyPhonyName(group))
+		}
+		for _, def := range mctx.actionDefs.buildDefs {
+			def.OrderOnlyStrings = append(slices.Clip(def.OrderOnlyStrings), phonys...)
+		}
+	}
+	return errs
+}
+
+// orderOnlyPhonys returns the phony build statements for the order-only dependencies on each module,
+// which depend on the outputs of all of its variants.  They are written for every module that is the
+// target of an order-only dependency, even if all of its variants are disabled.
+func (c *Context) orderOnlyPhonys() []*buildDef {
+	var buildDefs []*buildDef
+	for _, group := range c.moduleGroups {
+		if !group.hasOrderOnlyDependents {
+			continue
+		}
+		def := &buildDef{
+			Rule:          Phony,
+			OutputStrings: []string{c.orderOnlyPhonyName(group)},
+			Optional:      true,
+		}
+		for _, module := range group.modules {
+			def.Inputs = append(def.Inputs, module.orderOnlyOutputs...)
+			def.InputStrings = append(def.InputStrings, module.orderOnlyOutputStrings...)
+		}
+		buildDefs = append(buildDefs, def)
+	}
+	return buildDefs
+}
+
+// orderOnlyPhonyName returns the name of the phony target for the order-only dependencies on the
+// variants of a module.
+func (c *Context) orderOnlyPhonyName(group *moduleGroup) string {
+	uniqueName := c.nameInterface.UniqueName(newNamespaceContext(group.modules.firstModule()), group.name)
+	return moduleOrderOnlyPhonyName(toNinjaName(uniqueName))
+}
+
 type mutatorDirection interface {
 	run(mutator []*mutatorInfo, ctx *mutatorContext)
 	orderer() visitOrderer
@@ -2946,8 +5258,22 @@ func (bottomUpMutatorImpl) run(mutatorGroup []*mutatorInfo, ctx *mutatorContext)
 	for _, mutator := range mutatorGroup {
 		ctx.mutator = mutator
 		ctx.module.startedMutator = mutator.index
-		mutator.bottomUpMutator(ctx)
+		if mutator.visits(ctx.module) {
+			ctx.context.traceModule(ctx.module, mutator.name, "visited by %s", bottomUpMutator)
+			if hook := ctx.context.mutatorTraceHook; hook != nil {
+				hook(mutator.name, ctx.module.logicModule, PhaseStart)
+				mutator.bottomUpMutator(ctx)
+				hook(mutator.name, ctx.module.logicModule, PhaseEnd)
+			} else {
+				mutator.bottomUpMutator(ctx)
+			}
+		}
 		ctx.module.finishedMutator = mutator.index
+		if len(ctx.errs) > 0 {
+			// Don't run later mutators in the group on a module with errors, as they would not have
+			// been run if the mutators had not been coalesced.
+			break
+		}
 	}
 }
 
@@ -2965,7 +5291,17 @@ func (topDownMutatorImpl) run(mutatorGroup []*mutatorInfo, ctx *mutatorContext)
 	if len(mutatorGroup) > 1 {
 		panic(fmt.Errorf("top down mutator group %s must only have 1 mutator, found %d", mutatorGroup[0].name, len(mutatorGroup)))
 	}
-	mutatorGroup[0].topDownMutator(ctx)
+	if !mutatorGroup[0].visits(ctx.module) {
+		return
+	}
+	ctx.context.traceModule(ctx.module, mutatorGroup[0].name, "visited by %s", topDownMutator)
+	if hook := ctx.context.mutatorTraceHook; hook != nil {
+		hook(mutatorGroup[0].name, ctx.module.logicModule, PhaseStart)
+		mutatorGroup[0].topDownMutator(ctx)
+		hook(mutatorGroup[0].name, ctx.module.logicModule, PhaseEnd)
+	} else {
+		mutatorGroup[0].topDownMutator(ctx)
+	}
 }
 
 func (topDownMutatorImpl) orderer() visitOrderer {
@@ -2986,17 +5322,26 @@ type reverseDep struct {
 	dep    depInfo
 }
 
+// reverseDepCreatingVariant is a reverse dependency added by
+// AddReverseVariationDependencyCreatingVariant, it is resolved at the end of the mutator pass.
+type reverseDepCreatingVariant struct {
+	destName   string
+	variations []Variation
+	dep        depInfo
+}
+
 func (c *Context) runMutator(config interface{}, mutatorGroup []*mutatorInfo,
 	direction mutatorDirection) (deps []string, errs []error) {
 
 	newModuleInfo := maps.Clone(c.moduleInfo)
 
 	type globalStateChange struct {
-		reverse    []reverseDep
-		rename     []rename
-		replace    []replace
-		newModules []*moduleInfo
-		deps       []string
+		reverse                []reverseDep
+		reverseCreatingVariant []reverseDepCreatingVariant
+		rename                 []rename
+		replace                []replace
+		newModules             []*moduleInfo
+		deps                   []string
 	}
 
 	type newVariationPair struct {
@@ -3005,17 +5350,25 @@ func (c *Context) runMutator(config interface{}, mutatorGroup []*mutatorInfo,
 	}
 
 	reverseDeps := make(map[*moduleInfo][]depInfo)
+	var reverseDepsCreatingVariant []reverseDepCreatingVariant
 	var rename []rename
 	var replace []replace
 	var newModules []*moduleInfo
 
-	errsCh := make(chan []error)
+	type mutatorErrs struct {
+		errs       []error
+		transition bool
+	}
+
+	errsCh := make(chan mutatorErrs)
 	globalStateCh := make(chan globalStateChange)
 	newVariationsCh := make(chan newVariationPair)
 	done := make(chan bool)
 
 	c.needsUpdateDependencies = 0
 
+	seenErrs := make(map[string]bool)
+
 	visit := func(module *moduleInfo, pause chan<- pauseSpec) bool {
 		if module.splitModules != nil {
 			panic("split module found in sorted module list")
@@ -3034,6 +5387,7 @@ func (c *Context) runMutator(config interface{}, mutatorGroup []*mutatorInfo,
 		origLogicModule := module.logicModule
 
 		module.startedMutator = mutatorGroup[0].index
+		module.transitionErrs = 0
 
 		func() {
 			defer func() {
@@ -3053,21 +5407,26 @@ func (c *Context) runMutator(config interface{}, mutatorGroup []*mutatorInfo,
 		module.finishedMutator = mutatorGroup[len(mutatorGroup)-1].index
 
 		if len(mctx.errs) > 0 {
-			errsCh <- mctx.errs
-			return true
+			// Errors reported by the transitions of a module's dependencies are collected from
+			// every module so that they can be reported together, any other error stops the pass.
+			transitionErrs := module.transitionErrs == len(mctx.errs)
+			errsCh <- mutatorErrs{mctx.errs, transitionErrs}
+			return !transitionErrs
 		}
 
 		if len(mctx.newVariations) > 0 {
 			newVariationsCh <- newVariationPair{mctx.newVariations, origLogicModule}
 		}
 
-		if len(mctx.reverseDeps) > 0 || len(mctx.replace) > 0 || len(mctx.rename) > 0 || len(mctx.newModules) > 0 || len(mctx.ninjaFileDeps) > 0 {
+		if len(mctx.reverseDeps) > 0 || len(mctx.reverseDepsCreatingVariant) > 0 || len(mctx.replace) > 0 ||
+			len(mctx.rename) > 0 || len(mctx.newModules) > 0 || len(mctx.ninjaFileDeps) > 0 {
 			globalStateCh <- globalStateChange{
-				reverse:    mctx.reverseDeps,
-				replace:    mctx.replace,
-				rename:     mctx.rename,
-				newModules: mctx.newModules,
-				deps:       mctx.ninjaFileDeps,
+				reverse:                mctx.reverseDeps,
+				reverseCreatingVariant: mctx.reverseDepsCreatingVariant,
+				replace:                mctx.replace,
+				rename:                 mctx.rename,
+				newModules:             mctx.newModules,
+				deps:                   mctx.ninjaFileDeps,
 			}
 		}
 
@@ -3082,11 +5441,23 @@ func (c *Context) runMutator(config interface{}, mutatorGroup []*mutatorInfo,
 		for {
 			select {
 			case newErrs := <-errsCh:
-				errs = append(errs, newErrs...)
+				if !newErrs.transition {
+					errs = append(errs, newErrs.errs...)
+					break
+				}
+				// The same transition error may be reported for each variation of a module, or
+				// for each module that depends on a module whose incoming transition failed.
+				for _, err := range newErrs.errs {
+					if !seenErrs[err.Error()] {
+						seenErrs[err.Error()] = true
+						errs = append(errs, err)
+					}
+				}
 			case globalStateChange := <-globalStateCh:
 				for _, r := range globalStateChange.reverse {
 					reverseDeps[r.module] = append(reverseDeps[r.module], r.dep)
 				}
+				reverseDepsCreatingVariant = append(reverseDepsCreatingVariant, globalStateChange.reverseCreatingVariant...)
 				replace = append(replace, globalStateChange.replace...)
 				rename = append(rename, globalStateChange.rename...)
 				newModules = append(newModules, globalStateChange.newModules...)
@@ -3163,6 +5534,12 @@ func (c *Context) runMutator(config interface{}, mutatorGroup []*mutatorInfo,
 			// having to call a full c.updateDependencies().
 			for _, m := range module.newDirectDeps {
 				m.reverseDeps = append(m.reverseDeps, module)
+				m.inDegree++
+			}
+			if c.deterministicDependencyOrder && len(module.newDirectDeps) > 0 {
+				// The new dependencies need to be sorted into the existing ones, which is done
+				// by updateDependencies.
+				c.needsUpdateDependencies++
 			}
 			module.newDirectDeps = nil
 		}
@@ -3178,11 +5555,37 @@ func (c *Context) runMutator(config interface{}, mutatorGroup []*mutatorInfo,
 		c.variantCreatingMutatorOrder = append(c.variantCreatingMutatorOrder, mutatorGroup[0].name)
 	}
 
+	// Resolve the reverse dependencies that may create variants in a deterministic order, so that
+	// the created variants don't depend on the order in which modules were visited.
+	slices.SortFunc(reverseDepsCreatingVariant, func(a, b reverseDepCreatingVariant) int {
+		return cmp.Or(cmp.Compare(a.destName, b.destName),
+			cmp.Compare(a.dep.module.Name(), b.dep.module.Name()),
+			cmp.Compare(a.dep.module.variant.name, b.dep.module.variant.name))
+	})
+	for _, r := range reverseDepsCreatingVariant {
+		destModule, errs := c.findOrCreateReverseDependency(config, mutatorGroup[len(mutatorGroup)-1], r)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		if destModule != nil {
+			if err := c.checkVisibility(destModule, r.dep.module); err != nil {
+				return nil, []error{err}
+			}
+			reverseDeps[destModule] = append(reverseDeps[destModule], r.dep)
+		}
+	}
+
 	// Add in any new reverse dependencies that were added by the mutator
 	for module, deps := range reverseDeps {
 		sort.Sort(depSorter(deps))
+		for i := range deps {
+			deps[i].transitions = c.explicitEdgeTransitions(module, deps[i].module)
+		}
 		module.directDeps = append(module.directDeps, deps...)
 		c.needsUpdateDependencies++
+		for _, dep := range deps {
+			c.traceDependency(module, dep.module, mutatorGroup[0].name, "reverse dependency", dep.tag)
+		}
 	}
 
 	for _, module := range newModules {
@@ -3191,6 +5594,7 @@ func (c *Context) runMutator(config interface{}, mutatorGroup []*mutatorInfo,
 			return nil, errs
 		}
 		c.needsUpdateDependencies++
+		c.traceModule(module, mutatorGroup[0].name, "created by %s", module.createdBy)
 	}
 
 	errs = c.handleRenames(rename)
@@ -3307,78 +5711,157 @@ func (c *Context) generateModuleBuildActions(config interface{},
 		}
 	}()
 
-	visitErrs := parallelVisit(c.iterateAllVariants(), bottomUpVisitor, parallelVisitLimit,
-		func(module *moduleInfo, pause chan<- pauseSpec) bool {
-			uniqueName := c.nameInterface.UniqueName(newNamespaceContext(module), module.group.name)
-			sanitizedName := toNinjaName(uniqueName)
-			sanitizedVariant := toNinjaName(module.variant.name)
+	headerTemplate := template.Must(template.New("moduleHeader").Parse(moduleHeaderTemplate))
+
+	unordered := c.moduleVisitOrder == ModuleVisitOrderLegacy && c.parallelGenerateBuildActions &&
+		!c.GetIncrementalEnabled()
 
-			prefix := moduleNamespacePrefix(sanitizedName + "_" + sanitizedVariant)
+	for module := range c.iterateAllVariants() {
+		module.disabled = !c.moduleEnabled(module)
+	}
 
-			// The parent scope of the moduleContext's local scope gets overridden to be that of the
-			// calling Go package on a per-call basis.  Since the initial parent scope doesn't matter we
-			// just set it to nil.
-			scope := newLocalScope(nil, prefix)
+	visit := func(module *moduleInfo, pause chan<- pauseSpec) bool {
+		if module.disabled {
+			c.traceModule(module, "generate", "skipping disabled module")
+			module.startedGenerateBuildActions = true
+			module.finishedGenerateBuildActions = true
+			return false
+		}
 
-			mctx := &moduleContext{
-				baseModuleContext: baseModuleContext{
-					context: c,
-					config:  config,
-					module:  module,
-				},
-				scope:              scope,
-				handledMissingDeps: module.missingDeps == nil,
+		var disabledDepErrs []error
+		for _, dep := range module.directDeps {
+			if tag, ok := dep.tag.(RequiredDependencyTag); ok && dep.module.disabled && tag.RequiresEnabledDependency() {
+				disabledDepErrs = append(disabledDepErrs,
+					c.moduleErrorf(module, "depends on disabled module %q", dep.module.Name()))
 			}
+		}
+		if len(disabledDepErrs) > 0 {
+			errsCh <- disabledDepErrs
+			return true
+		}
 
-			mctx.module.startedGenerateBuildActions = true
+		uniqueName := c.nameInterface.UniqueName(newNamespaceContext(module), module.group.name)
+		sanitizedName := toNinjaName(uniqueName)
+		sanitizedVariant := toNinjaName(module.variant.name)
 
-			func() {
-				defer func() {
-					if r := recover(); r != nil {
-						in := fmt.Sprintf("GenerateBuildActions for %s", module)
-						if err, ok := r.(panicError); ok {
-							err.addIn(in)
-							mctx.error(err)
-						} else {
-							mctx.error(newPanicErrorf(r, in))
-						}
+		prefix := moduleNamespacePrefix(sanitizedName + "_" + sanitizedVariant)
+
+		// The parent scope of the moduleContext's local scope gets overridden to be that of the
+		// calling Go package on a per-call basis.  Since the initial parent scope doesn't matter we
+		// just set it to nil.
+		scope := newLocalScope(nil, prefix)
+		scope.globals = c.ninjaVariables
+
+		mctx := &moduleContext{
+			baseModuleContext: baseModuleContext{
+				context: c,
+				config:  config,
+				module:  module,
+			},
+			scope:              scope,
+			handledMissingDeps: module.missingDeps == nil,
+		}
+		if unordered {
+			mctx.pauseCh = pause
+		}
+
+		mctx.module.startedGenerateBuildActions = true
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					in := fmt.Sprintf("GenerateBuildActions for %s", module)
+					if err, ok := r.(panicError); ok {
+						err.addIn(in)
+						mctx.error(err)
+					} else {
+						mctx.error(newPanicErrorf(r, in))
 					}
-				}()
-				restored, cacheKey := mctx.restoreModuleBuildActions()
-				if !restored {
-					mctx.module.logicModule.GenerateBuildActions(mctx)
-				}
-				if cacheKey != nil {
-					mctx.cacheModuleBuildActions(cacheKey)
 				}
 			}()
+			restored, cacheKey := mctx.restoreModuleBuildActions()
+			if !restored {
+				c.traceModule(module, "generate", "generating build actions")
+				mctx.module.logicModule.GenerateBuildActions(mctx)
+			} else {
+				c.traceModule(module, "generate", "restored build actions from cache")
+			}
+			if cacheKey != nil {
+				mctx.cacheModuleBuildActions(cacheKey)
+			}
+		}()
 
-			mctx.module.finishedGenerateBuildActions = true
+		mctx.module.finishedGenerateBuildActions = true
 
-			if len(mctx.errs) > 0 {
-				errsCh <- mctx.errs
-				return true
+		if len(mctx.errs) > 0 {
+			errsCh <- mctx.errs
+			return true
+		}
+
+		if module.missingDeps != nil && !mctx.handledMissingDeps {
+			var errs []error
+			for _, depName := range module.missingDeps {
+				errs = append(errs, c.missingDependencyError(module, depName))
 			}
+			errsCh <- errs
+			return true
+		}
 
-			if module.missingDeps != nil && !mctx.handledMissingDeps {
-				var errs []error
-				for _, depName := range module.missingDeps {
-					errs = append(errs, c.missingDependencyError(module, depName))
-				}
-				errsCh <- errs
-				return true
+		depsCh <- mctx.ninjaFileDeps
+
+		if newErrs := c.addOrderOnlyDependencies(mctx); len(newErrs) > 0 {
+			for i, err := range newErrs {
+				newErrs[i] = c.moduleErrorf(module, "%s", err)
+			}
+			errsCh <- newErrs
+			return true
+		}
+		module.phonys = mctx.phonys
+
+		newErrs := c.processLocalBuildActions(&module.actionDefs,
+			&mctx.actionDefs, liveGlobals)
+		if len(newErrs) > 0 {
+			for i, err := range newErrs {
+				newErrs[i] = c.moduleErrorf(module, "%s", err)
 			}
+			errsCh <- newErrs
+			return true
+		}
 
-			depsCh <- mctx.ninjaFileDeps
+		if c.errorOnDuplicateOutputs {
+			module.outputPaths = buildDefOutputPaths(module.actionDefs.buildDefs)
+		}
 
-			newErrs := c.processLocalBuildActions(&module.actionDefs,
-				&mctx.actionDefs, liveGlobals)
-			if len(newErrs) > 0 {
-				errsCh <- newErrs
+		if c.moduleActionsWriter != nil {
+			if err := c.streamModuleActions(module, headerTemplate); err != nil {
+				errsCh <- []error{err}
 				return true
 			}
-			return false
-		})
+		}
+		return false
+	}
+
+	var visitErrs []error
+	switch c.moduleVisitOrder {
+	case ModuleVisitOrderTopological:
+		for _, module := range c.topologicallySortedModules() {
+			if visit(module, nil) {
+				break
+			}
+		}
+	case ModuleVisitOrderAlphabetical:
+		for _, module := range c.alphabeticallySortedModules() {
+			if visit(module, nil) {
+				break
+			}
+		}
+	default:
+		if unordered {
+			visitErrs = parallelVisit(c.iterateAllVariants(), unorderedVisitorImpl{}, parallelVisitLimit, visit)
+		} else {
+			visitErrs = parallelVisit(c.iterateAllVariants(), bottomUpVisitor, parallelVisitLimit, visit)
+		}
+	}
 
 	cancelCh <- struct{}{}
 	<-cancelCh
@@ -3398,6 +5881,7 @@ func (c *Context) generateOneSingletonBuildActions(config interface{},
 	// calling Go package on a per-call basis.  Since the initial parent scope doesn't matter we
 	// just set it to nil.
 	scope := newLocalScope(nil, singletonNamespacePrefix(info.name))
+	scope.globals = c.ninjaVariables
 
 	sctx := &singletonContext{
 		name:    info.name,
@@ -3486,6 +5970,51 @@ func (c *Context) generateParallelSingletonBuildActions(config interface{},
 	return deps, errs
 }
 
+// buildDefOutputPaths returns the outputs and implicit outputs of buildDefs.  Paths that reference
+// ninja variables are returned before the variables are expanded.
+func buildDefOutputPaths(buildDefs []*buildDef) []string {
+	var outputs []string
+	for _, def := range buildDefs {
+		outputs = append(outputs, def.OutputStrings...)
+		outputs = append(outputs, def.ImplicitOutputStrings...)
+		for _, output := range def.Outputs {
+			outputs = append(outputs, output.str)
+		}
+		for _, output := range def.ImplicitOutputs {
+			outputs = append(outputs, output.str)
+		}
+	}
+	return outputs
+}
+
+// checkDuplicateOutputs returns an error for each output path of a module's or singleton's build
+// statements that is also an output path of an earlier build statement, naming the module or
+// singleton that declared it first.  Modules are checked before singletons.
+func (c *Context) checkDuplicateOutputs() []error {
+	var errs []error
+	declaredBy := make(map[string]string)
+	for module := range c.iterateAllVariants() {
+		for _, output := range module.outputPaths {
+			if first, ok := declaredBy[output]; ok {
+				errs = append(errs, c.moduleErrorf(module, "output %q is also declared by %s", output, first))
+				continue
+			}
+			declaredBy[output] = fmt.Sprintf("%s at %s", module, module.pos)
+		}
+	}
+	for _, info := range c.singletonInfo {
+		for _, output := range buildDefOutputPaths(info.actionDefs.buildDefs) {
+			if first, ok := declaredBy[output]; ok {
+				errs = append(errs, fmt.Errorf("singleton %q: output %q is also declared by %s",
+					info.name, output, first))
+				continue
+			}
+			declaredBy[output] = fmt.Sprintf("singleton %q", info.name)
+		}
+	}
+	return errs
+}
+
 func (c *Context) generateSingletonBuildActions(config interface{},
 	singletons []*singletonInfo, liveGlobals *liveTracker) ([]string, []error) {
 
@@ -3523,6 +6052,72 @@ func (c *Context) generateSingletonBuildActions(config interface{},
 	return deps, errs
 }
 
+// sharedPool returns the pool with the given name and depth created by ModuleContext.Pool, creating
+// it the first time it is requested.
+func (c *Context) sharedPool(name string, depth int) (*sharedPool, error) {
+	if err := validateNinjaName(name); err != nil {
+		return nil, err
+	}
+	if strings.ContainsRune(name, '.') {
+		return nil, fmt.Errorf("pool name %q contains '.'", name)
+	}
+	if name == "console" {
+		return nil, fmt.Errorf("pool name %q is reserved for ninja's builtin pool, use blueprint.Console instead", name)
+	}
+	if depth < 1 {
+		return nil, fmt.Errorf("pool %q has depth %d, must be at least 1", name, depth)
+	}
+
+	c.sharedPoolsLock.Lock()
+	defer c.sharedPoolsLock.Unlock()
+
+	if pool, ok := c.sharedPools[name]; ok {
+		if pool.def_.Depth != depth {
+			return nil, fmt.Errorf("pool %q has depth %d, but was already created with depth %d",
+				name, depth, pool.def_.Depth)
+		}
+		return pool, nil
+	}
+
+	pool := &sharedPool{
+		name_: name,
+		def_:  &poolDef{Depth: depth},
+	}
+	if c.sharedPools == nil {
+		c.sharedPools = make(map[string]*sharedPool)
+	}
+	c.sharedPools[name] = pool
+	return pool, nil
+}
+
+// internRule returns the rule shared by all modules that define a rule with the same name and
+// definition as r, which is r itself the first time it is seen.  A shared rule is not local to any
+// module, so it is written to the ninja file once along with the global rules.  It returns nil if
+// r references variables that are local to its module.
+func (c *Context) internRule(r *localRule) *localRule {
+	key, ok := r.internKey()
+	if !ok {
+		return nil
+	}
+
+	c.internedRulesLock.Lock()
+	defer c.internedRulesLock.Unlock()
+
+	if interned, ok := c.internedRules[key]; ok {
+		return interned
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	r.fullName_ = fmt.Sprintf("r.%s.%x", r.name_, hash.Sum64())
+
+	if c.internedRules == nil {
+		c.internedRules = make(map[string]*localRule)
+	}
+	c.internedRules[key] = r
+	return r
+}
+
 func (c *Context) processLocalBuildActions(out, in *localBuildActions,
 	liveGlobals *liveTracker) []error {
 
@@ -3608,6 +6203,9 @@ type replace struct {
 type rename struct {
 	group *moduleGroup
 	name  string
+
+	// keepOldName is true if dependencies can still be added using the old name after the rename.
+	keepOldName bool
 }
 
 // moduleVariantsThatDependOn takes the name of a module and a dependency and returns the all the variants of the
@@ -3639,7 +6237,15 @@ func (c *Context) handleRenames(renames []rename) []error {
 			continue
 		}
 
-		errs = append(errs, c.nameInterface.Rename(group.name, rename.name, group.namespace)...)
+		oldName := group.name
+		renameErrs := c.nameInterface.Rename(oldName, rename.name, group.namespace)
+		if len(renameErrs) == 0 && rename.keepOldName {
+			if c.renamedModules == nil {
+				c.renamedModules = make(map[string][]*moduleGroup)
+			}
+			c.renamedModules[oldName] = append(c.renamedModules[oldName], group)
+		}
+		errs = append(errs, renameErrs...)
 	}
 
 	return errs
@@ -3654,6 +6260,10 @@ func (c *Context) handleReplacements(replacements []replace) []error {
 				if d.module == replace.from {
 					// If the replacement has a predicate then check it.
 					if replace.predicate == nil || replace.predicate(m.logicModule, d.tag, d.module.logicModule) {
+						if err := c.checkVisibility(m, replace.to); err != nil {
+							errs = append(errs, err)
+							continue
+						}
 						m.directDeps[i].module = replace.to
 						changedDeps = true
 					}
@@ -3680,6 +6290,27 @@ func (c *Context) discoveredMissingDependencies(module *moduleInfo, depName stri
 	return []error{c.missingDependencyError(module, depName)}
 }
 
+func (c *Context) missingVariantError(module *moduleInfo, depName string, possibleDeps *moduleGroup,
+	requested variationMap, format string) *MissingVariantError {
+
+	available := make([][]Variation, 0, len(possibleDeps.modules))
+	for _, m := range possibleDeps.modules {
+		available = append(available, c.variationList(m.variant.variations))
+	}
+
+	return &MissingVariantError{
+		BlueprintError: BlueprintError{
+			Err: fmt.Errorf(format, depName, module.Name(),
+				c.prettyPrintVariant(requested),
+				c.prettyPrintGroupVariants(possibleDeps)),
+			Pos: module.pos,
+		},
+		DepName:   depName,
+		Requested: c.variationList(requested),
+		Available: available,
+	}
+}
+
 func (c *Context) missingDependencyError(module *moduleInfo, depName string) (errs error) {
 	guess := namesLike(depName, module.Name(), c.moduleGroups)
 	err := c.nameInterface.MissingDependencyError(module.Name(), module.namespace(), depName, guess)
@@ -3694,6 +6325,15 @@ func (c *Context) moduleGroupFromName(name string, namespace Namespace) *moduleG
 	if exists {
 		return group.moduleGroup
 	}
+
+	// Fall back to modules that were renamed by RenameModule from the requested name, as long as the
+	// renamed module is visible under its new name from the namespace.
+	for _, renamed := range c.renamedModules[name] {
+		if group, exists := c.nameInterface.ModuleFromName(renamed.name, namespace); exists &&
+			group.moduleGroup == renamed {
+			return renamed
+		}
+	}
 	return nil
 }
 
@@ -3806,7 +6446,7 @@ func (c *Context) setOutDir(value *ninjaString) {
 }
 
 func (c *Context) makeUniquePackageNames(
-	liveGlobals *liveTracker) (map[*packageContext]string, []string) {
+	pctxs []*packageContext) (map[*packageContext]string, []string) {
 
 	pkgs := make(map[string]*packageContext)
 	pkgNames := make(map[*packageContext]string)
@@ -3839,14 +6479,8 @@ func (c *Context) makeUniquePackageNames(
 
 	// We try to give all packages their short name, but when we get collisions
 	// we need to use the full unique package name.
-	for v, _ := range liveGlobals.variables {
-		processPackage(v.packageContext())
-	}
-	for p, _ := range liveGlobals.pools {
-		processPackage(p.packageContext())
-	}
-	for r, _ := range liveGlobals.rules {
-		processPackage(r.packageContext())
+	for _, pctx := range pctxs {
+		processPackage(pctx)
 	}
 
 	// Add the packages that had collisions using their full unique names.  This
@@ -3987,6 +6621,53 @@ func (c *Context) ModuleSubDir(logicModule Module) string {
 	return module.variant.name
 }
 
+// ModuleVariantsForMutator returns the variations that the transition mutator with the given name
+// created for the module, in the order of the variants of the module.  A module that was not split
+// by the mutator has the single variation "".  It returns nil if the module is not known to the
+// Context, or if the named mutator is not a transition mutator, is disabled or has not finished
+// creating variants yet.
+func (c *Context) ModuleVariantsForMutator(logicModule Module, mutatorName string) []string {
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		return nil
+	}
+
+	index := slices.IndexFunc(c.mutatorInfo, func(m *mutatorInfo) bool {
+		return m.name == mutatorName && m.transitionMutator != nil
+	})
+	if index < 0 {
+		return nil
+	}
+	mutator := c.mutatorInfo[index]
+	if c.disabledMutators[mutator.registeredName()] || len(c.finishedMutators) <= mutator.index ||
+		!c.finishedMutators[mutator.index] {
+		return nil
+	}
+
+	var variations []string
+	for _, variant := range module.group.modules {
+		variations = addToStringListIfNotPresent(variations, variant.variant.variations.get(mutatorName))
+	}
+	return variations
+}
+
+// ModuleVariations returns the variation of the module for each mutator that created variants of
+// it, keyed by mutator name.  Mutators for which the module has the variation "" are omitted.  The
+// returned map is a copy and may be modified by the caller.
+func (c *Context) ModuleVariations(logicModule Module) map[string]string {
+	module := c.moduleInfo[logicModule]
+	return maps.Clone(module.variant.variations.variations)
+}
+
+// ModulePropertyStructs returns the pointers to the property structs of the module, in the order
+// they were returned by the module's factory.  For a variant they point to the property structs of
+// the variant's logic module, which are copied when the variant is created.  The returned slice is a
+// copy, but the property structs are not, and must not be modified outside of mutators.
+func (c *Context) ModulePropertyStructs(logicModule Module) []interface{} {
+	module := c.moduleInfo[logicModule]
+	return slices.Clone(module.properties)
+}
+
 func (c *Context) ModuleType(logicModule Module) string {
 	module := c.moduleInfo[logicModule]
 	return module.typeName
@@ -4001,6 +6682,71 @@ func (c *Context) ModuleProvider(logicModule Module, provider AnyProviderKey) (a
 	return c.provider(module, provider.provider())
 }
 
+// ModuleOutputFiles returns the output files declared by a module with ModuleContext.AddOutputFiles for the
+// given tag.  It returns an error if the module did not declare any output files with the tag, or if
+// GenerateBuildActions has not been called on it yet.
+func (c *Context) ModuleOutputFiles(logicModule Module, tag string) ([]string, error) {
+	module := c.moduleInfo[logicModule]
+	if !module.finishedGenerateBuildActions {
+		return nil, fmt.Errorf("output files of module %q requested before its build actions were generated",
+			module.Name())
+	}
+	return c.outputFiles(module, tag)
+}
+
+func (c *Context) outputFiles(module *moduleInfo, tag string) ([]string, error) {
+	files, ok := module.outputFiles[tag]
+	if !ok {
+		return nil, fmt.Errorf("module %q has no output files with tag %q", module.Name(), tag)
+	}
+	return slices.Clone(files), nil
+}
+
+// EdgeCount returns the total number of direct dependency edges between all variants of all
+// modules.  Multiple dependencies between the same pair of variants are counted separately.
+func (c *Context) EdgeCount() int {
+	count := 0
+	for module := range c.iterateAllVariants() {
+		count += len(module.directDeps)
+	}
+	return count
+}
+
+// OutDegree returns the number of direct dependencies of the given module variant.
+func (c *Context) OutDegree(logicModule Module) int {
+	module := c.moduleInfo[logicModule]
+	return len(module.directDeps)
+}
+
+// InDegree returns the number of direct dependencies onto the given module variant from other
+// module variants.
+func (c *Context) InDegree(logicModule Module) int {
+	module := c.moduleInfo[logicModule]
+	return module.inDegree
+}
+
+// dependsOn returns true if dep is a direct or transitive dependency of module, including the
+// implicit dependencies on the earlier variants of the same module.
+func (c *Context) dependsOn(module, dep *moduleInfo) bool {
+	visited := make(map[*moduleInfo]bool)
+	var visit func(m *moduleInfo) bool
+	visit = func(m *moduleInfo) bool {
+		for _, d := range m.forwardDeps {
+			if d == dep {
+				return true
+			}
+			if !visited[d] {
+				visited[d] = true
+				if visit(d) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return visit(module)
+}
+
 func (c *Context) BlueprintFile(logicModule Module) string {
 	module := c.moduleInfo[logicModule]
 	return module.relBlueprintsFile
@@ -4024,11 +6770,72 @@ func (c *Context) moduleErrorf(module *moduleInfo, format string,
 	}
 }
 
+// moduleErrorfWithPath returns an error like moduleErrorf with the shortest dependency path from
+// the root set by SetDependencyPathRoot to module appended to the message.
+func (c *Context) moduleErrorfWithPath(module *moduleInfo, format string,
+	args ...interface{}) error {
+
+	if path := c.dependencyPath(module); len(path) > 1 {
+		names := make([]string, len(path))
+		for i, m := range path {
+			names[i] = m.Name()
+		}
+		format += " (via %s)"
+		args = append(slices.Clip(args), strings.Join(names, " -> "))
+	}
+	return c.moduleErrorf(module, format, args...)
+}
+
+// dependencyPath returns the shortest path of direct dependencies from a variant of the module
+// set by SetDependencyPathRoot to target, including both ends, or nil if there is none.  Only the
+// dependencies that have been added so far are followed, which excludes those added by mutators
+// coalesced with the current mutator on modules that have not been visited yet.
+func (c *Context) dependencyPath(target *moduleInfo) []*moduleInfo {
+	if c.dependencyPathRoot == "" || target == nil {
+		return nil
+	}
+	root := c.moduleGroupFromName(c.dependencyPathRoot, nil)
+	if root == nil {
+		return nil
+	}
+
+	parents := make(map[*moduleInfo]*moduleInfo)
+	var queue []*moduleInfo
+	for _, m := range root.modules {
+		parents[m] = nil
+		queue = append(queue, m)
+	}
+
+	for len(queue) > 0 {
+		module := queue[0]
+		queue = queue[1:]
+		if module == target {
+			var path []*moduleInfo
+			for m := module; m != nil; m = parents[m] {
+				path = append(path, m)
+			}
+			slices.Reverse(path)
+			return path
+		}
+		for _, dep := range module.directDeps {
+			if _, seen := parents[dep.module]; !seen {
+				parents[dep.module] = module
+				queue = append(queue, dep.module)
+			}
+		}
+	}
+	return nil
+}
+
 func (c *Context) ModuleErrorf(logicModule Module, format string,
 	args ...interface{}) error {
 	return c.moduleErrorf(c.moduleInfo[logicModule], format, args...)
 }
 
+// PropertyErrorf returns an error at the position of the given property of the module in its
+// Blueprints file.  Nested properties are named with dots, for example "arch.arm.srcs".  If the
+// property was not set in the Blueprints file the error is reported at its closest enclosing
+// property that was set, or at the module if there is none.
 func (c *Context) PropertyErrorf(logicModule Module, property string, format string,
 	args ...interface{}) error {
 
@@ -4040,9 +6847,19 @@ func (c *Context) PropertyErrorf(logicModule Module, property string, format str
 		}
 	}
 
+	return propertyErrorf(module, property, format, args...)
+}
+
+func propertyErrorf(module *moduleInfo, property string, format string, args ...interface{}) error {
 	pos := module.propertyPos[property]
-	if !pos.IsValid() {
-		pos = module.pos
+	for prefix := property; !pos.IsValid(); {
+		i := strings.LastIndexByte(prefix, '.')
+		if i < 0 {
+			pos = module.pos
+			break
+		}
+		prefix = prefix[:i]
+		pos = module.propertyPos[prefix]
 	}
 
 	return &PropertyError{
@@ -4067,6 +6884,57 @@ func (c *Context) VisitAllModulesIf(pred func(Module) bool,
 	c.visitAllModulesIf(pred, visit)
 }
 
+// FindModules returns every variant of every module whose name matches re, in the order the modules are
+// visited by VisitAllModules.  It is intended for interactive queries, for example from a debugging tool.
+func (c *Context) FindModules(re *regexp.Regexp) []Module {
+	var result []Module
+	for _, group := range c.sortedModuleGroups() {
+		if !re.MatchString(group.name) {
+			continue
+		}
+		for _, module := range group.modules {
+			result = append(result, module.logicModule)
+		}
+	}
+	return result
+}
+
+// FindModuleVariants returns every variant of the module with exactly the given name, as seen from the root
+// namespace, or nil if there is no such module.
+func (c *Context) FindModuleVariants(name string) []Module {
+	group := c.moduleGroupFromName(name, nil)
+	if group == nil {
+		return nil
+	}
+	result := make([]Module, 0, len(group.modules))
+	for _, module := range group.modules {
+		result = append(result, module.logicModule)
+	}
+	return result
+}
+
+// BaseModuleContext returns a BaseModuleContext for the module that tools can use to query the
+// module and its dependencies after ResolveDependencies, for example with VisitDirectDeps or
+// OtherModuleProvider.  The returned context does not allow modifying the module: SetProvider and
+// SetModuleMetadata panic, and errors reported through it are ignored.  It panics if called before
+// ResolveDependencies or with a module that is not known to the Context.
+func (c *Context) BaseModuleContext(logicModule Module) BaseModuleContext {
+	if !c.dependenciesReady {
+		panic(fmt.Errorf("BaseModuleContext called before ResolveDependencies"))
+	}
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		panic(fmt.Errorf("BaseModuleContext called with unknown module %q", logicModule.Name()))
+	}
+	return &readOnlyModuleContext{
+		baseModuleContext: baseModuleContext{
+			context: c,
+			config:  c.resolvedConfig,
+			module:  module,
+		},
+	}
+}
+
 func (c *Context) VisitDirectDeps(module Module, visit func(Module)) {
 	c.VisitDirectDepsWithTags(module, func(m Module, _ DependencyTag) {
 		visit(m)
@@ -4111,6 +6979,134 @@ func (c *Context) VisitDirectDepsIf(module Module, pred func(Module) bool, visit
 	}
 }
 
+// VisitDirectDepsWithTag calls visit for each direct dependency of module that was added with a
+// dependency tag equal to tag.
+func (c *Context) VisitDirectDepsWithTag(module Module, tag DependencyTag, visit func(Module)) {
+	topModule := c.moduleInfo[module]
+
+	var visiting *moduleInfo
+
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "VisitDirectDepsWithTag(%s, %v, %s) for dependency %s",
+				topModule, tag, funcName(visit), visiting))
+		}
+	}()
+
+	for _, dep := range topModule.directDeps {
+		if dependencyTagsEqual(dep.tag, tag) {
+			visiting = dep.module
+			visit(dep.module.logicModule)
+		}
+	}
+}
+
+// dependencyTagsEqual returns true if a and b are the same dependency tag.  Tags that cannot be
+// compared with ==, for example because they contain a slice, are compared with reflect.DeepEqual
+// instead of panicking.
+func dependencyTagsEqual(a, b DependencyTag) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	if !reflect.ValueOf(a).Comparable() {
+		return reflect.DeepEqual(a, b)
+	}
+	return a == b
+}
+
+// VisitDirectDepsWithTagName calls visit for each direct dependency of module that was added
+// with a dependency tag whose name, as returned by DependencyTagName, is name.
+func (c *Context) VisitDirectDepsWithTagName(module Module, name string, visit func(Module)) {
+	topModule := c.moduleInfo[module]
+
+	var visiting *moduleInfo
+
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "VisitDirectDepsWithTagName(%s, %q, %s) for dependency %s",
+				topModule, name, funcName(visit), visiting))
+		}
+	}()
+
+	for _, dep := range topModule.directDeps {
+		if c.DependencyTagName(dep.tag) == name {
+			visiting = dep.module
+			visit(dep.module.logicModule)
+		}
+	}
+}
+
+// VisitOrderOnlyDeps calls visit for each variant of each module that module has an order-only
+// dependency on, added with BottomUpMutatorContext.AddOrderOnlyDependency.  It is only valid
+// after ResolveDependencies.
+func (c *Context) VisitOrderOnlyDeps(module Module, visit func(Module)) {
+	topModule := c.moduleInfo[module]
+
+	var visiting *moduleInfo
+
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "VisitOrderOnlyDeps(%s, %s) for dependency %s",
+				topModule, funcName(visit), visiting))
+		}
+	}()
+
+	for _, group := range topModule.orderOnlyDeps {
+		for _, dep := range group.modules {
+			visiting = dep
+			visit(dep.logicModule)
+		}
+	}
+}
+
+// VisitDirectReverseDeps calls visit for each module variant that has a direct dependency on
+// module.  If a module variant has multiple direct dependencies on module visit will be called
+// multiple times with it.  The implicit ordering dependencies between variants of the same module
+// are not visited.  Module variants are visited in order of unique name and then variant name.
+func (c *Context) VisitDirectReverseDeps(module Module, visit func(Module)) {
+	topModule := c.moduleInfo[module]
+
+	var visiting *moduleInfo
+
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "VisitDirectReverseDeps(%s, %s) for dependency %s",
+				topModule, funcName(visit), visiting))
+		}
+	}()
+
+	// reverseDeps contains an entry for each forward dependency, which may include duplicates and
+	// the implicit dependencies from later variants, so only use it to find candidate modules and
+	// then check their direct dependencies.  Modules in different namespaces may have the same
+	// name, so remove the duplicates by identity and sort by unique name.
+	seen := make(map[*moduleInfo]bool, len(topModule.reverseDeps))
+	var reverseDeps []*moduleInfo
+	for _, reverseDep := range topModule.reverseDeps {
+		if !seen[reverseDep] {
+			seen[reverseDep] = true
+			reverseDeps = append(reverseDeps, reverseDep)
+		}
+	}
+	uniqueName := func(m *moduleInfo) string {
+		return c.nameInterface.UniqueName(newNamespaceContext(m), m.group.name)
+	}
+	slices.SortFunc(reverseDeps, func(a, b *moduleInfo) int {
+		return cmp.Or(cmp.Compare(uniqueName(a), uniqueName(b)), cmp.Compare(a.variant.name, b.variant.name))
+	})
+
+	for _, reverseDep := range reverseDeps {
+		for _, dep := range reverseDep.directDeps {
+			if dep.module == topModule {
+				visiting = reverseDep
+				visit(reverseDep.logicModule)
+			}
+		}
+	}
+}
+
 func (c *Context) VisitDepsDepthFirst(module Module, visit func(Module)) {
 	topModule := c.moduleInfo[module]
 
@@ -4149,6 +7145,69 @@ func (c *Context) VisitDepsDepthFirstIf(module Module, pred func(Module) bool, v
 	})
 }
 
+// WalkDepsAllowCycles walks the dependencies of module depth first, calling visit for each
+// dependency edge with the dependency and its parent.  If visit returns false the dependencies of
+// child are not walked.  The dependencies of each module are only walked once.  Unlike the walks used by mutators and
+// singletons it does not assume the dependency graph is acyclic: a dependency on a module that is
+// currently being walked is not visited, and the first such cycle is returned as the list of
+// modules in the cycle, starting and ending with the repeated module, along with an error
+// describing it.
+func (c *Context) WalkDepsAllowCycles(module Module, visit func(child, parent Module) bool) ([]Module, error) {
+	topModule := c.moduleInfo[module]
+
+	var visiting *moduleInfo
+
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "WalkDepsAllowCycles(%s, %s) for dependency %s",
+				topModule, funcName(visit), visiting))
+		}
+	}()
+
+	var cycle []*moduleInfo
+	visited := make(map[*moduleInfo]bool)
+	var stack []*moduleInfo
+
+	var walk func(module *moduleInfo)
+	walk = func(module *moduleInfo) {
+		stack = append(stack, module)
+		visited[module] = true
+		for _, dep := range module.directDeps {
+			if i := slices.Index(stack, dep.module); i >= 0 {
+				if cycle == nil {
+					cycle = append(slices.Clone(stack[i:]), dep.module)
+				}
+				continue
+			}
+			if visited[dep.module] {
+				continue
+			}
+			visiting = dep.module
+			if visit(dep.module.logicModule, module.logicModule) {
+				walk(dep.module)
+			}
+		}
+		stack = stack[:len(stack)-1]
+	}
+
+	walk(topModule)
+
+	if cycle == nil {
+		return nil, nil
+	}
+
+	modules := make([]Module, len(cycle))
+	names := make([]string, len(cycle))
+	for i, m := range cycle {
+		modules[i] = m.logicModule
+		names[i] = m.String()
+	}
+	return modules, &BlueprintError{
+		Err: fmt.Errorf("encountered dependency cycle: %s", strings.Join(names, " -> ")),
+		Pos: cycle[0].pos,
+	}
+}
+
 func (c *Context) PrimaryModule(module Module) Module {
 	return c.moduleInfo[module].group.modules.firstModule().logicModule
 }
@@ -4280,6 +7339,12 @@ func (c *Context) WriteBuildFile(w StringWriterWriter, shardNinja bool, ninjaFil
 			return
 		}
 
+		if c.moduleActionsWriter != nil {
+			if err = nw.Subninja(c.moduleActionsNinjaFile); err != nil {
+				return
+			}
+		}
+
 		if err = c.writeAllModuleActions(nw, shardNinja, ninjaFileName); err != nil {
 			return
 		}
@@ -4287,6 +7352,10 @@ func (c *Context) WriteBuildFile(w StringWriterWriter, shardNinja bool, ninjaFil
 		if err = c.writeAllSingletonActions(nw); err != nil {
 			return
 		}
+
+		if err = c.writeManifestRegeneration(nw, ninjaFileName); err != nil {
+			return
+		}
 	})
 
 	return err
@@ -4376,6 +7445,71 @@ func (c *Context) writeSubninjas(nw *ninjaWriter) error {
 	return nw.BlankLine()
 }
 
+// manifestRegeneration holds the arguments to AddManifestRegenerationRule.
+type manifestRegeneration struct {
+	command string
+	inputs  []string
+}
+
+// manifestRegenerationRule is the name of the rule written by AddManifestRegenerationRule.
+const manifestRegenerationRule = "blueprint_regenerate_manifest"
+
+// AddManifestRegenerationRule makes WriteBuildFile write a rule with generator = 1 and a build
+// statement that runs command to regenerate the Ninja file whenever one of its inputs changes.
+// command is written to the Ninja file as is, so it may refer to $in and $out, and "$" must be
+// written as "$$".  The explicit inputs of the build statement are inputs, and its implicit inputs
+// are every Blueprints file and directory read by the most recent parse and the dependencies of
+// every glob, so that adding or removing a file that matches a glob also regenerates the Ninja
+// file.  The output is the ninjaFileName passed to WriteBuildFile, which must not be empty.
+func (c *Context) AddManifestRegenerationRule(command string, inputs []string) {
+	c.manifestRegeneration = &manifestRegeneration{
+		command: command,
+		inputs:  slices.Clone(inputs),
+	}
+}
+
+// writeManifestRegeneration writes the rule and build statement added by
+// AddManifestRegenerationRule, if any.
+func (c *Context) writeManifestRegeneration(nw *ninjaWriter, ninjaFileName string) error {
+	regen := c.manifestRegeneration
+	if regen == nil {
+		return nil
+	}
+	if ninjaFileName == "" {
+		return fmt.Errorf("AddManifestRegenerationRule requires the Ninja file name to be passed to WriteBuildFile")
+	}
+
+	var implicits []string
+	implicits = append(implicits, c.parseDeps...)
+	for _, glob := range c.Globs() {
+		implicits = append(implicits, glob.Deps...)
+	}
+	implicits = slices.DeleteFunc(slices.Compact(slices.Sorted(slices.Values(implicits))), func(dep string) bool {
+		return slices.Contains(regen.inputs, dep)
+	})
+
+	if err := nw.Rule(manifestRegenerationRule); err != nil {
+		return err
+	}
+	if err := nw.ScopedAssign("command", regen.command); err != nil {
+		return err
+	}
+	if err := nw.ScopedAssign("description", "Regenerating "+ninjaFileName); err != nil {
+		return err
+	}
+	if err := nw.ScopedAssign("generator", "1"); err != nil {
+		return err
+	}
+	if err := nw.BlankLine(); err != nil {
+		return err
+	}
+	if err := nw.Build("", manifestRegenerationRule, nil, nil, nil, nil, nil, nil,
+		[]string{ninjaFileName}, nil, regen.inputs, implicits, nil, nil, c.nameTracker); err != nil {
+		return err
+	}
+	return nw.BlankLine()
+}
+
 func (c *Context) writeBuildDir(nw *ninjaWriter) error {
 	if c.outDir != nil {
 		err := nw.Assign("builddir", c.outDir.Value(c.nameTracker))
@@ -4586,6 +7720,9 @@ func (c *Context) writeAllModuleActions(nw *ninjaWriter, shardNinja bool, ninjaF
 		return err
 	}
 
+	phonys.buildDefs = append(phonys.buildDefs, mergeModulePhonys(modules, incrementalModules)...)
+	phonys.buildDefs = append(phonys.buildDefs, c.orderOnlyPhonys()...)
+
 	c.EventHandler.Do("sort_phony_builddefs", func() {
 		// sorting for determinism, the phony output names are stable
 		sort.Slice(phonys.buildDefs, func(i int, j int) bool {
@@ -4673,6 +7810,30 @@ func (c *Context) writeAllModuleActions(nw *ninjaWriter, shardNinja bool, ninjaF
 	}
 }
 
+// mergeModulePhonys returns a phony build statement for each name passed to ModuleContext.Phony,
+// with the union of the dependencies contributed by all modules.
+func mergeModulePhonys(moduleLists ...[]*moduleInfo) []*buildDef {
+	deps := make(map[string][]string)
+	for _, modules := range moduleLists {
+		for _, module := range modules {
+			for name, phonyDeps := range module.phonys {
+				deps[name] = append(deps[name], phonyDeps...)
+			}
+		}
+	}
+
+	buildDefs := make([]*buildDef, 0, len(deps))
+	for _, name := range slices.Sorted(maps.Keys(deps)) {
+		buildDefs = append(buildDefs, &buildDef{
+			Rule:          Phony,
+			OutputStrings: []string{name},
+			InputStrings:  slices.Compact(slices.Sorted(slices.Values(deps[name]))),
+			Optional:      true,
+		})
+	}
+	return buildDefs
+}
+
 func orderOnlyForIncremental(c *Context, modules []*moduleInfo, phonys *localBuildActions) error {
 	for _, mod := range modules {
 		// find the order only strings of the incremental module, it can come from
@@ -4774,6 +7935,21 @@ func writeIncrementalModules(c *Context, baseFile string, modules []*moduleInfo,
 	return nil
 }
 
+// streamModuleActions writes the build actions of a module that has finished GenerateBuildActions to
+// the writer set by SetModuleActionsWriter, and then releases them.
+func (c *Context) streamModuleActions(module *moduleInfo, headerTemplate *template.Template) error {
+	buf := &strings.Builder{}
+	if err := c.writeModuleAction([]*moduleInfo{module}, newNinjaWriter(buf), headerTemplate); err != nil {
+		return err
+	}
+	module.actionDefs = localBuildActions{}
+
+	c.moduleActionsLock.Lock()
+	defer c.moduleActionsLock.Unlock()
+	_, err := c.moduleActionsWriter.WriteString(buf.String())
+	return err
+}
+
 func (c *Context) writeModuleAction(modules []*moduleInfo, nw *ninjaWriter, headerTemplate *template.Template) error {
 	buf := bytes.NewBuffer(nil)
 
@@ -19,8 +19,11 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"maps"
 	"os"
 	"reflect"
+	"regexp"
+	"runtime"
 	"slices"
 	"strconv"
 	"strings"
@@ -193,6 +196,228 @@ func TestContextParse(t *testing.T) {
 	}
 }
 
+func TestParseAndReturnAST(t *testing.T) {
+	ctx := NewContext()
+
+	r := bytes.NewBufferString(`// Comment on the module
+unregistered_module {
+    name: "MyModule",
+    srcs: ["a.c"],
+}
+`)
+
+	file, errs := ctx.ParseAndReturnAST("Android.bp", r)
+	assertNoErrors(t, errs)
+
+	if len(file.Comments) != 1 || file.Comments[0].Comments[0].Comment[0] != "// Comment on the module" {
+		t.Errorf("expected the comment to be kept, got %v", file.Comments)
+	}
+
+	if len(file.Defs) != 1 {
+		t.Fatalf("expected 1 definition, got %d", len(file.Defs))
+	}
+	module, ok := file.Defs[0].(*parser.Module)
+	if !ok {
+		t.Fatalf("expected a module, got %T", file.Defs[0])
+	}
+	if module.Type != "unregistered_module" {
+		t.Errorf("expected module type unregistered_module, got %q", module.Type)
+	}
+	if prop, found := module.GetProperty("srcs"); !found {
+		t.Errorf("expected srcs property")
+	} else if pos := prop.Pos(); pos.Filename != "Android.bp" || pos.Line != 4 {
+		t.Errorf("expected srcs property at Android.bp:4, got %s", pos)
+	}
+
+	_, errs = ctx.ParseAndReturnAST("Android.bp", bytes.NewBufferString("unregistered_module {"))
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %q", errs)
+	}
+	if _, ok := errs[0].(*BlueprintError); !ok {
+		t.Errorf("expected a BlueprintError, got %T", errs[0])
+	}
+}
+
+type nestedPropertiesModule struct {
+	SimpleName
+	properties struct {
+		Nested struct {
+			Srcs   []string
+			Cflags []string
+		}
+		Other *string
+	}
+}
+
+func newNestedPropertiesModule() (Module, []interface{}) {
+	m := &nestedPropertiesModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *nestedPropertiesModule) GenerateBuildActions(ModuleContext) {}
+
+func TestPropertyErrorf(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("nested_module", newNestedPropertiesModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+nested_module {
+    name: "A",
+    nested: {
+        srcs: ["a.c"],
+    },
+}
+`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+
+	module := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule
+
+	testCases := []struct {
+		property string
+		expected string
+	}{
+		{"nested.srcs", "Android.bp:5:13"},
+		{"nested", "Android.bp:4:11"},
+		// Unset properties are reported at the closest enclosing property that was set.
+		{"nested.cflags", "Android.bp:4:11"},
+		{"other", "Android.bp:2:1"},
+	}
+	for _, tc := range testCases {
+		err := ctx.PropertyErrorf(module, tc.property, "bad value")
+		if got := err.(*PropertyError).Pos.String(); got != tc.expected {
+			t.Errorf("expected error for %q at %s, got %s", tc.property, tc.expected, got)
+		}
+	}
+}
+
+func TestReparse(t *testing.T) {
+	ctx := newContext()
+	ctx.SetRetainParsedFiles(true)
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTransitionMutator("transition", transitionTestMutator{})
+	ctx.MockFileSystem(map[string][]byte{
+		"a/Android.bp": []byte(`
+			transition_module {
+				name: "A",
+				split: ["a1", "a2"],
+				deps: ["B"],
+			}
+		`),
+		"b/Android.bp": []byte(`
+			transition_module {
+				name: "B",
+			}
+		`),
+		"c/Android.bp": []byte(`
+			transition_module {
+				name: "C",
+				split: ["c1", "c2"],
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	checkTransitionVariants(t, ctx, "B", []string{"", "a1", "a2"})
+	checkTransitionVariants(t, ctx, "C", []string{"c1", "c2"})
+
+	// Only b/Android.bp is read again, the other files are recreated from the retained results.
+	ctx.MockFileSystem(map[string][]byte{
+		"b/Android.bp": []byte(`
+			transition_module {
+				name: "B",
+				split: ["b"],
+			}
+		`),
+	})
+
+	errs = ctx.Reparse([]string{"b/Android.bp"})
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	checkTransitionVariants(t, ctx, "A", []string{"a1", "a2"})
+	checkTransitionVariants(t, ctx, "B", []string{"b", "a1", "a2"})
+	checkTransitionVariants(t, ctx, "C", []string{"c1", "c2"})
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a1"), "B(a1)")
+	checkTransitionMutate(t, getTransitionModule(ctx, "C", "c2"), "c2")
+}
+
+func TestReparseWithoutRetainedFiles(t *testing.T) {
+	ctx := newContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+				name: "A",
+			}
+		`),
+	})
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+
+	errs = ctx.Reparse([]string{"Android.bp"})
+	if len(errs) != 1 || errs[0].Error() != "Reparse called without SetRetainParsedFiles before parsing" {
+		t.Errorf("unexpected errors %q", errs)
+	}
+}
+
+func TestParseBlueprintContents(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+				name: "OnDisk",
+			}
+		`),
+	})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+
+	deps, errs := ctx.ParseBlueprintContents(map[string]string{
+		"Android.bp": `
+			build = ["other.bp"]
+
+			foo_module {
+				name: "MyFooModule",
+				deps: ["MyBarModule", "MyBazModule"],
+			}
+		`,
+		"other.bp": `
+			bar_module {
+				name: "MyBazModule",
+			}
+		`,
+		"sub/Android.bp": `
+			bar_module {
+				name: "MyBarModule",
+			}
+		`,
+	}, nil)
+	assertNoErrors(t, errs)
+
+	for _, file := range []string{"Android.bp", "other.bp", "sub/Android.bp"} {
+		if !slices.Contains(deps, file) {
+			t.Errorf("expected deps to contain %q, got %q", file, deps)
+		}
+	}
+
+	if ctx.moduleGroupFromName("OnDisk", nil) != nil {
+		t.Errorf("expected the mock filesystem not to be parsed")
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+}
+
 // > |===B---D       - represents a non-walkable edge
 // > A               = represents a walkable edge
 // > |===C===E---G
@@ -268,6 +493,81 @@ func TestWalkDeps(t *testing.T) {
 	}
 }
 
+func TestWalkDepsAllowCycles(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B", "D"],
+			}
+
+			foo_module {
+			    name: "B",
+			    deps: ["C"],
+			}
+
+			foo_module {
+			    name: "C",
+			    deps: ["D"],
+			}
+
+			foo_module {
+			    name: "D",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	moduleInfo := func(name string) *moduleInfo {
+		return ctx.moduleGroupFromName(name, nil).modules.firstModule()
+	}
+	a := moduleInfo("A").logicModule
+
+	walk := func() (string, []Module, error) {
+		var visited []string
+		cycle, err := ctx.WalkDepsAllowCycles(a, func(child, parent Module) bool {
+			visited = append(visited, ctx.ModuleName(parent)+"->"+ctx.ModuleName(child))
+			return true
+		})
+		return strings.Join(visited, " "), cycle, err
+	}
+
+	visited, cycle, err := walk()
+	if cycle != nil || err != nil {
+		t.Errorf("unexpected cycle %v: %s", cycle, err)
+	}
+	if g, w := visited, "A->B B->C C->D"; g != w {
+		t.Errorf("expected visits %q, got %q", w, g)
+	}
+
+	// Dependency cycles are rejected during ResolveDependencies, add one directly.
+	c := moduleInfo("C")
+	c.directDeps = append(c.directDeps, depInfo{moduleInfo("A"), walkerDepsTag{follow: true}, nil})
+
+	visited, cycle, err = walk()
+	if g, w := visited, "A->B B->C C->D"; g != w {
+		t.Errorf("expected visits %q, got %q", w, g)
+	}
+	var cycleNames []string
+	for _, m := range cycle {
+		cycleNames = append(cycleNames, ctx.ModuleName(m))
+	}
+	if g, w := cycleNames, []string{"A", "B", "C", "A"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected cycle %q, got %q", w, g)
+	}
+	expectedErr := `Android.bp:2:4: encountered dependency cycle: module "A" -> module "B" -> module "C" -> module "A"`
+	if err == nil || err.Error() != expectedErr {
+		t.Errorf("expected error %q, got %v", expectedErr, err)
+	}
+}
+
 // > |===B---D           - represents a non-walkable edge
 // > A                   = represents a walkable edge
 // > |===C===E===\       A should not be visited because it's the root node.
@@ -418,61 +718,1785 @@ func TestWalkDepsDuplicates_IgnoreFirstPath(t *testing.T) {
 	}
 }
 
-func TestCreateModule(t *testing.T) {
-	ctx := newContext()
-	ctx.MockFileSystem(map[string][]byte{
-		"Android.bp": []byte(`
-			foo_module {
-			    name: "A",
-			    deps: ["B", "C"],
-			}
-		`),
+func TestDeterministicDependencyOrder(t *testing.T) {
+	run := func(t *testing.T, deterministic bool) string {
+		ctx := NewContext()
+		ctx.SetDeterministicDependencyOrder(deterministic)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				foo_module {
+					name: "A",
+					deps: ["C", "B", "C"],
+					ignored_deps: ["B"],
+				}
+
+				foo_module {
+					name: "B",
+				}
+
+				foo_module {
+					name: "C",
+				}
+			`),
+		})
+
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterBottomUpMutator("deps", depsMutator)
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies(nil)
+		assertNoErrors(t, errs)
+
+		var deps []string
+		for _, dep := range ctx.moduleGroupFromName("A", nil).modules.firstModule().directDeps {
+			deps = append(deps, fmt.Sprintf("%s:%v", dep.module.Name(), dep.tag.(walkerDepsTag).follow))
+		}
+		return strings.Join(deps, " ")
+	}
+
+	t.Run("insertion order", func(t *testing.T) {
+		if g, w := run(t, false), "B:false C:true B:true C:true"; g != w {
+			t.Errorf("expected deps %q, got %q", w, g)
+		}
 	})
 
-	ctx.RegisterBottomUpMutator("create", createTestMutator).UsesCreateModule()
-	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	t.Run("deterministic", func(t *testing.T) {
+		if g, w := run(t, true), "B:false B:true C:true C:true"; g != w {
+			t.Errorf("expected deps %q, got %q", w, g)
+		}
+	})
+}
 
-	ctx.RegisterModuleType("foo_module", newFooModule)
-	ctx.RegisterModuleType("bar_module", newBarModule)
-	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
-	if len(errs) > 0 {
-		t.Errorf("unexpected parse errors:")
+type visitOrderModule struct {
+	baseTestModule
+	generated func(name string)
+}
+
+func (m *visitOrderModule) GenerateBuildActions(ctx ModuleContext) {
+	m.generated(ctx.ModuleName())
+}
+
+func TestModuleVisitOrder(t *testing.T) {
+	run := func(t *testing.T, order ModuleVisitOrder) []string {
+		var lock sync.Mutex
+		var generated []string
+		record := func(name string) {
+			lock.Lock()
+			defer lock.Unlock()
+			generated = append(generated, name)
+		}
+
+		ctx := NewContext()
+		ctx.SetModuleVisitOrder(order)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				visit_order_module {
+					name: "D",
+				}
+
+				visit_order_module {
+					name: "A",
+					deps: ["C", "B"],
+				}
+
+				visit_order_module {
+					name: "C",
+					deps: ["D"],
+				}
+
+				visit_order_module {
+					name: "B",
+				}
+			`),
+		})
+
+		ctx.RegisterModuleType("visit_order_module", func() (Module, []interface{}) {
+			m := &visitOrderModule{generated: record}
+			return m, []interface{}{&m.baseTestModule.properties, &m.SimpleName.Properties}
+		})
+		ctx.RegisterBottomUpMutator("deps", depsMutator)
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies(nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.PrepareBuildActions(nil)
+		assertNoErrors(t, errs)
+		return generated
+	}
+
+	t.Run("legacy", func(t *testing.T) {
+		generated := run(t, ModuleVisitOrderLegacy)
+		if g, w := len(generated), 4; g != w {
+			t.Fatalf("expected %d modules, got %q", w, generated)
+		}
+		if slices.Index(generated, "D") > slices.Index(generated, "C") ||
+			slices.Index(generated, "C") > slices.Index(generated, "A") ||
+			slices.Index(generated, "B") > slices.Index(generated, "A") {
+			t.Errorf("expected dependencies to be generated first, got %q", generated)
+		}
+	})
+
+	t.Run("topological", func(t *testing.T) {
+		if g, w := run(t, ModuleVisitOrderTopological), []string{"D", "C", "B", "A"}; !slices.Equal(g, w) {
+			t.Errorf("expected order %q, got %q", w, g)
+		}
+	})
+
+	t.Run("alphabetical", func(t *testing.T) {
+		if g, w := run(t, ModuleVisitOrderAlphabetical), []string{"A", "B", "C", "D"}; !slices.Equal(g, w) {
+			t.Errorf("expected order %q, got %q", w, g)
+		}
+	})
+}
+
+func TestModuleErrorfWithPath(t *testing.T) {
+	run := func(t *testing.T, root string) []string {
+		t.Helper()
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterBottomUpMutator("deps", depsMutator).MutatesDependencies()
+		ctx.RegisterBottomUpMutator("error", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "D" || ctx.ModuleName() == "E" {
+				ctx.ModuleErrorfWithPath("bad %s", "module")
+			}
+		})
+		ctx.SetDependencyPathRoot(root)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				foo_module {
+					name: "A",
+					deps: ["B", "C"],
+				}
+
+				foo_module {
+					name: "B",
+					deps: ["C"],
+				}
+
+				foo_module {
+					name: "C",
+					deps: ["D"],
+				}
+
+				foo_module {
+					name: "D",
+				}
+
+				foo_module {
+					name: "E",
+				}
+			`),
+		})
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies(nil)
+		var ret []string
 		for _, err := range errs {
-			t.Errorf("  %s", err)
+			ret = append(ret, err.Error())
 		}
-		t.FailNow()
+		slices.Sort(ret)
+		return ret
 	}
 
-	_, errs = ctx.ResolveDependencies(nil)
-	if len(errs) > 0 {
-		t.Errorf("unexpected dep errors:")
-		for _, err := range errs {
-			t.Errorf("  %s", err)
+	if g, w := run(t, "A"), []string{
+		`Android.bp:17:5: module "D": bad module (via A -> C -> D)`,
+		`Android.bp:21:5: module "E": bad module`,
+	}; !slices.Equal(g, w) {
+		t.Errorf("expected errors %q, got %q", w, g)
+	}
+	if g, w := run(t, ""), []string{
+		`Android.bp:17:5: module "D": bad module`,
+		`Android.bp:21:5: module "E": bad module`,
+	}; !slices.Equal(g, w) {
+		t.Errorf("expected errors %q, got %q", w, g)
+	}
+}
+
+func TestTopologicalModuleOrder(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+				name: "E",
+			}
+
+			foo_module {
+				name: "A",
+				deps: ["B", "C"],
+			}
+
+			foo_module {
+				name: "C",
+			}
+
+			foo_module {
+				name: "B",
+				deps: ["D"],
+			}
+
+			foo_module {
+				name: "D",
+			}
+		`),
+	})
+
+	_, err := ctx.TopologicalModuleOrder()
+	if g, w := fmt.Sprint(err), "TopologicalModuleOrder called before ResolveDependencies"; g != w {
+		t.Errorf("expected error %q, got %q", w, g)
+	}
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	modules, err := ctx.TopologicalModuleOrder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range modules {
+		got = append(got, ctx.ModuleName(m))
+	}
+	if w := []string{"D", "B", "C", "A", "E"}; !slices.Equal(got, w) {
+		t.Errorf("expected order %q, got %q", w, got)
+	}
+
+	// Add a dependency from D back to A to create a cycle.
+	a := ctx.moduleGroupFromName("A", nil).modules.firstModule()
+	d := ctx.moduleGroupFromName("D", nil).modules.firstModule()
+	d.forwardDeps = append(d.forwardDeps, a)
+	_, err = ctx.TopologicalModuleOrder()
+	want := `Android.bp:6:4: encountered dependency cycle:
+Android.bp:20:4:     module "D" depends on module "A"
+Android.bp:6:4:     module "A" depends on module "B"
+Android.bp:15:4:     module "B" depends on module "D"`
+	if g := fmt.Sprint(err); g != want {
+		t.Errorf("expected error:\n%s\ngot:\n%s", want, g)
+	}
+}
+
+type modulesByTypeSingleton struct {
+	modules []string
+}
+
+func (s *modulesByTypeSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.VisitAllModulesByType(&fooModule{}, func(m Module) {
+		s.modules = append(s.modules, ctx.ModuleName(m)+"("+ctx.ModuleSubDir(m)+")")
+	})
+}
+
+func TestVisitAllModulesByType(t *testing.T) {
+	singleton := &modulesByTypeSingleton{}
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+				name: "A",
+			}
+
+			bar_module {
+				name: "B",
+			}
+
+			foo_module {
+				name: "C",
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterTransitionMutator("arch", fixedSplitTransitionMutator{variations: []string{"x86", "arm"}})
+	ctx.RegisterSingletonType("by_type", func() Singleton { return singleton }, false)
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.PrepareBuildActions(nil)
+	assertNoErrors(t, errs)
+
+	if g, w := singleton.modules, []string{"A(x86)", "A(arm)", "C(x86)", "C(arm)"}; !slices.Equal(g, w) {
+		t.Errorf("expected modules %q, got %q", w, g)
+	}
+}
+
+type phonyModule struct {
+	SimpleName
+	properties struct {
+		Phony_deps []string
+	}
+}
+
+func newPhonyModule() (Module, []interface{}) {
+	m := &phonyModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *phonyModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Phony("droidcore", m.properties.Phony_deps...)
+	ctx.Phony(ctx.ModuleName()+"_phony", ctx.ModuleName()+"_out")
+}
+
+func TestModulePhony(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			phony_module {
+				name: "A",
+				phony_deps: ["a", "shared"],
+			}
+
+			phony_module {
+				name: "B",
+				phony_deps: ["shared", "b"],
+			}
+		`),
+	})
+
+	ctx.RegisterModuleType("phony_module", newPhonyModule)
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.PrepareBuildActions(nil)
+	assertNoErrors(t, errs)
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range []string{
+		"build droidcore: phony a b shared\n",
+		"build A_phony: phony A_out\n",
+		"build B_phony: phony B_out\n",
+	} {
+		if g := strings.Count(buf.String(), w); g != 1 {
+			t.Errorf("expected %q once in build file, found %d times:\n%s", w, g, buf.String())
+		}
+	}
+}
+
+func TestOrderOnlyDependency(t *testing.T) {
+	run := func(t *testing.T, dep string) (*Context, []error) {
+		ctx := NewContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				foo_module {
+					name: "A",
+				}
+
+				foo_module {
+					name: "B",
+				}
+			`),
+		})
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterBottomUpMutator("order_only", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "B" {
+				ctx.AddOrderOnlyDependency(dep)
+			}
+		})
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.PrepareBuildActions(nil)
+		return ctx, errs
+	}
+
+	ctx, errs := run(t, "A")
+	assertNoErrors(t, errs)
+
+	b := ctx.moduleGroupFromName("B", nil).modules.firstModule().logicModule
+	var got []string
+	ctx.VisitOrderOnlyDeps(b, func(m Module) { got = append(got, m.Name()) })
+	if w := []string{"A"}; !slices.Equal(got, w) {
+		t.Errorf("expected order-only dependencies %q, got %q", w, got)
+	}
+	ctx.VisitDirectDeps(b, func(m Module) {
+		t.Errorf("expected no direct dependencies, got %q", m.Name())
+	})
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range []string{
+		"build m.A.order_only: phony A_phony_output\n",
+		"build B_phony_output: phony || m.A.order_only\n",
+		"build A_phony_output: phony\n",
+	} {
+		if g := strings.Count(buf.String(), w); g != 1 {
+			t.Errorf("expected %q once in build file, found %d times:\n%s", w, g, buf.String())
+		}
+	}
+
+	_, errs = run(t, "missing")
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), `depends on undefined module "missing"`) {
+		t.Errorf("expected missing dependency error, got %q", errs)
+	}
+}
+
+func TestOrderOnlyDependencyTargets(t *testing.T) {
+	run := func(t *testing.T, bp string, orderOnlyDeps map[string][]string) (string, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(bp),
+		})
+		ctx.RegisterModuleType("rule_module", newRuleModule)
+		ctx.RegisterBottomUpMutator("order_only", func(ctx BottomUpMutatorContext) {
+			ctx.AddOrderOnlyDependency(orderOnlyDeps[ctx.ModuleName()]...)
+		})
+		if err := ctx.SetNinjaVariable("outDir", "out"); err != nil {
+			t.Fatal(err)
+		}
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.PrepareBuildActions(nil)
+		if len(errs) > 0 {
+			return "", errs
+		}
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String(), nil
+	}
+
+	t.Run("disabled and variable outputs", func(t *testing.T) {
+		out, errs := run(t, `
+			rule_module {
+				name: "A",
+				enabled: false,
+			}
+
+			rule_module {
+				name: "B",
+				output_prefix: "${outDir}/",
+			}
+
+			rule_module {
+				name: "C",
+			}
+		`, map[string][]string{"C": {"A", "B"}})
+		assertNoErrors(t, errs)
+		for _, w := range []string{
+			"build m.A.order_only: phony\n",
+			"build m.B.order_only: phony ${outDir}/B.out\n",
+			" C.in || m.A.order_only m.B.order_only\n",
+		} {
+			if g := strings.Count(out, w); g != 1 {
+				t.Errorf("expected %q once in build file, found %d times:\n%s", w, g, out)
+			}
+		}
+	})
+
+	t.Run("local variable output", func(t *testing.T) {
+		_, errs := run(t, `
+			rule_module {
+				name: "A",
+				local_variable: true,
+				output_prefix: "${flags}/",
+			}
+
+			rule_module {
+				name: "B",
+			}
+		`, map[string][]string{"B": {"A"}})
+		assertOneErrorMatches(t, errs, `module "A": output "\$\{flags\}/A.out" of a module with order-only dependents references local variable flags`)
+	})
+
+	for _, tt := range []struct {
+		name          string
+		orderOnlyDeps map[string][]string
+	}{
+		{"self", map[string][]string{"A": {"A"}}},
+		{"cycle", map[string][]string{"A": {"B"}, "B": {"A"}}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := run(t, `
+				rule_module {
+					name: "A",
+				}
+
+				rule_module {
+					name: "B",
+				}
+			`, tt.orderOnlyDeps)
+			if len(errs) == 0 || !strings.Contains(errs[0].Error(), "encountered dependency cycle") {
+				t.Errorf("expected a dependency cycle error, got %q", errs)
+			}
+		})
+	}
+}
+
+type globModule struct {
+	SimpleName
+}
+
+func (m *globModule) GenerateBuildActions(ctx ModuleContext) {
+	if _, err := ctx.GlobWithDeps("sub/*.c", nil); err != nil {
+		ctx.ModuleErrorf("%s", err)
+	}
+}
+
+func TestManifestRegenerationRule(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			glob_module {
+				name: "A",
+			}
+		`),
+		"sub/Android.bp": []byte(`
+			glob_module {
+				name: "B",
+			}
+		`),
+		"sub/a.c": nil,
+	})
+	ctx.RegisterModuleType("glob_module", func() (Module, []interface{}) {
+		m := &globModule{}
+		return m, []interface{}{&m.SimpleName.Properties}
+	})
+	ctx.AddManifestRegenerationRule("bp_main -o $out $in", []string{"bp_main"})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.PrepareBuildActions(nil)
+	assertNoErrors(t, errs)
+
+	if err := ctx.WriteBuildFile(&strings.Builder{}, false, ""); err == nil {
+		t.Errorf("expected an error writing the regeneration rule without a Ninja file name")
+	}
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, "out/build.ninja"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, w := range []string{
+		"rule blueprint_regenerate_manifest\n" +
+			"    command = bp_main -o $out $in\n" +
+			"    description = Regenerating out/build.ninja\n" +
+			"    generator = 1\n",
+		"build out/build.ninja: blueprint_regenerate_manifest bp_main | Android.bp sub $\n" +
+			"        sub/Android.bp\n",
+	} {
+		if !strings.Contains(buf.String(), w) {
+			t.Errorf("expected %q in build file:\n%s", w, buf.String())
+		}
+	}
+}
+
+type ruleModule struct {
+	SimpleName
+	SimpleEnabled
+	properties struct {
+		Output_prefix  string
+		Command        string
+		Unique         bool
+		Local_variable bool
+		Pool           string
+		Pool_depth     *int64
+		Arg_names      []string
+		Set_args       []string
+	}
+}
+
+func newRuleModule() (Module, []interface{}) {
+	m := &ruleModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleEnabled.Properties}
+}
+
+func (m *ruleModule) GenerateBuildActions(ctx ModuleContext) {
+	params := RuleParams{
+		Command:     "cp $in $out",
+		Description: "copy $out",
+	}
+	if m.properties.Command != "" {
+		params.Command = m.properties.Command
+	}
+	if m.properties.Local_variable {
+		ctx.Variable(pctx, "flags", "-f")
+		params.Command = "cp $flags $in $out"
+	}
+
+	var rule Rule
+	if m.properties.Unique {
+		rule = ctx.UniqueRule(pctx, "cp", params, m.properties.Arg_names...)
+	} else {
+		rule = ctx.Rule(pctx, "cp", params, m.properties.Arg_names...)
+	}
+
+	var pool Pool
+	if m.properties.Pool != "" {
+		pool = ctx.Pool(m.properties.Pool, int(proptools.Int(m.properties.Pool_depth)))
+	}
+
+	var args map[string]string
+	for _, arg := range m.properties.Set_args {
+		if args == nil {
+			args = make(map[string]string)
+		}
+		args[arg] = "-" + arg
+	}
+
+	ctx.Build(pctx, BuildParams{
+		Rule:    rule,
+		Inputs:  []string{ctx.ModuleName() + ".in"},
+		Outputs: []string{m.properties.Output_prefix + ctx.ModuleName() + ".out"},
+		Pool:    pool,
+		Args:    args,
+	})
+}
+
+func TestRuleInterning(t *testing.T) {
+	runWithIntern := func(t *testing.T, bp string, intern bool) string {
+		t.Helper()
+		ctx := NewContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(bp),
+		})
+		ctx.RegisterModuleType("rule_module", newRuleModule)
+		ctx.SetInternRules(intern)
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.PrepareBuildActions(nil)
+		assertNoErrors(t, errs)
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	run := func(t *testing.T, bp string) string {
+		t.Helper()
+		return runWithIntern(t, bp, true)
+	}
+
+	t.Run("not interned", func(t *testing.T) {
+		out := runWithIntern(t, `
+			rule_module {
+				name: "A",
+			}
+
+			rule_module {
+				name: "B",
+			}
+		`, false)
+		if g, w := strings.Count(out, "\nrule "), 2; g != w {
+			t.Errorf("expected %d rule blocks, got %d:\n%s", w, g, out)
+		}
+	})
+
+	t.Run("shared", func(t *testing.T) {
+		out := run(t, `
+			rule_module {
+				name: "A",
+			}
+
+			rule_module {
+				name: "B",
+			}
+		`)
+		if g, w := strings.Count(out, "\nrule "), 1; g != w {
+			t.Errorf("expected %d rule blocks, got %d:\n%s", w, g, out)
+		}
+		if g, w := regexp.MustCompile(`build [AB].out: r\.cp\.[0-9a-f]+ [AB].in`).FindAllString(out, -1), 2; len(g) != w {
+			t.Errorf("expected %d build statements using the shared rule, got %q:\n%s", w, g, out)
+		}
+	})
+
+	t.Run("unique", func(t *testing.T) {
+		out := run(t, `
+			rule_module {
+				name: "A",
+				unique: true,
+			}
+
+			rule_module {
+				name: "B",
+			}
+		`)
+		if g, w := strings.Count(out, "\nrule "), 2; g != w {
+			t.Errorf("expected %d rule blocks, got %d:\n%s", w, g, out)
+		}
+	})
+
+	t.Run("local variable", func(t *testing.T) {
+		out := run(t, `
+			rule_module {
+				name: "A",
+				local_variable: true,
+			}
+
+			rule_module {
+				name: "B",
+				local_variable: true,
+			}
+		`)
+		if g, w := strings.Count(out, "\nrule "), 2; g != w {
+			t.Errorf("expected %d rule blocks, got %d:\n%s", w, g, out)
+		}
+	})
+}
+
+func TestRuleInterningReparse(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetRetainParsedFiles(true)
+	ctx.SetInternRules(true)
+	ctx.RegisterModuleType("rule_module", newRuleModule)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			rule_module {
+				name: "A",
+				command: "cp $in $out",
+			}
+		`),
+	})
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.PrepareBuildActions(nil)
+	assertNoErrors(t, errs)
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			rule_module {
+				name: "A",
+				command: "cp -f $in $out",
+			}
+		`),
+	})
+	assertNoErrors(t, ctx.Reparse([]string{"Android.bp"}))
+	_, errs = ctx.PrepareBuildActions(nil)
+	assertNoErrors(t, errs)
+
+	// The rule interned by the first PrepareBuildActions is not kept.
+	if g, w := len(ctx.internedRules), 1; g != w {
+		t.Errorf("expected %d interned rules, got %d", w, g)
+	}
+}
+
+func TestModulePool(t *testing.T) {
+	run := func(t *testing.T, bp string) (string, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(bp),
+		})
+		ctx.RegisterModuleType("rule_module", newRuleModule)
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.PrepareBuildActions(nil)
+		if len(errs) > 0 {
+			return "", errs
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String(), nil
+	}
+
+	t.Run("shared", func(t *testing.T) {
+		out, errs := run(t, `
+			rule_module {
+				name: "A",
+				pool: "link",
+				pool_depth: 2,
+			}
+
+			rule_module {
+				name: "B",
+				pool: "link",
+				pool_depth: 2,
+			}
+
+			rule_module {
+				name: "C",
+			}
+		`)
+		assertNoErrors(t, errs)
+		if g, w := strings.Count(out, "pool link\n    depth = 2\n"), 1; g != w {
+			t.Errorf("expected %d pool blocks, got %d:\n%s", w, g, out)
+		}
+		if g, w := strings.Count(out, "\n    pool = link\n"), 2; g != w {
+			t.Errorf("expected %d build statements in the pool, got %d:\n%s", w, g, out)
+		}
+	})
+
+	t.Run("depth", func(t *testing.T) {
+		_, errs := run(t, `
+			rule_module {
+				name: "A",
+				pool: "link",
+			}
+		`)
+		assertOneErrorMatches(t, errs, `pool "link" has depth 0, must be at least 1`)
+	})
+
+	t.Run("console", func(t *testing.T) {
+		_, errs := run(t, `
+			rule_module {
+				name: "A",
+				pool: "console",
+				pool_depth: 1,
+			}
+		`)
+		assertOneErrorMatches(t, errs, `pool name "console" is reserved for ninja's builtin pool`)
+	})
+
+	t.Run("mismatched depth", func(t *testing.T) {
+		_, errs := run(t, `
+			rule_module {
+				name: "A",
+				pool: "link",
+				pool_depth: 2,
+			}
+
+			rule_module {
+				name: "B",
+				pool: "link",
+				pool_depth: 3,
+			}
+		`)
+		if len(errs) == 0 {
+			t.Errorf("expected an error for a pool created with two depths")
+		}
+	})
+}
+
+func TestRuleArgs(t *testing.T) {
+	runWithCheck := func(t *testing.T, checkRuleArgs bool, bp string) (string, []error) {
+		t.Helper()
+		ctx := NewContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(bp),
+		})
+		ctx.SetCheckRuleArgs(checkRuleArgs)
+		ctx.RegisterModuleType("rule_module", newRuleModule)
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.PrepareBuildActions(nil)
+		if len(errs) > 0 {
+			return "", errs
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String(), nil
+	}
+	run := func(t *testing.T, bp string) (string, []error) {
+		t.Helper()
+		return runWithCheck(t, true, bp)
+	}
+
+	t.Run("set", func(t *testing.T) {
+		out, errs := run(t, `
+			rule_module {
+				name: "A",
+				command: "cp $flags $in $out",
+				arg_names: ["flags"],
+				set_args: ["flags"],
+			}
+		`)
+		assertNoErrors(t, errs)
+		if w := "\n    flags = -flags\n"; !strings.Contains(out, w) {
+			t.Errorf("expected %q in build file:\n%s", w, out)
+		}
+	})
+
+	t.Run("unused", func(t *testing.T) {
+		_, errs := run(t, `
+			rule_module {
+				name: "A",
+				arg_names: ["flags"],
+			}
+		`)
+		assertNoErrors(t, errs)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, errs := run(t, `
+			rule_module {
+				name: "A",
+				command: "cp $flags $in $out",
+				arg_names: ["flags"],
+			}
+		`)
+		assertOneErrorMatches(t, errs,
+			`^Android.bp:2:4: module "A": build statement for \["A.out"\] using rule .* doesn't set argument "flags" used in its command$`)
+	})
+
+	t.Run("missing without check", func(t *testing.T) {
+		_, errs := runWithCheck(t, false, `
+			rule_module {
+				name: "A",
+				command: "cp $flags $in $out",
+				arg_names: ["flags"],
+			}
+		`)
+		assertNoErrors(t, errs)
+	})
+}
+
+// duplicateOutputSingleton builds the output of module A.
+type duplicateOutputSingleton struct{}
+
+func (duplicateOutputSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.Build(pctx, BuildParams{
+		Rule:    Phony,
+		Outputs: []string{"A_phony_output"},
+	})
+}
+
+func TestErrorOnDuplicateOutputs(t *testing.T) {
+	run := func(t *testing.T, errorOnDuplicateOutputs bool, ctxHook func(*Context)) []error {
+		t.Helper()
+		ctx := NewContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				foo_module {
+					name: "A",
+				}
+
+				foo_module {
+					name: "B",
+				}
+			`),
+		})
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterTransitionMutator("os", configTransitionMutator{})
+		ctx.SetErrorOnDuplicateOutputs(errorOnDuplicateOutputs)
+		if ctxHook != nil {
+			ctxHook(ctx)
+		}
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.PrepareBuildActions([]string{"x", "y"})
+		return errs
+	}
+
+	errorStrings := func(errs []error) []string {
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		return got
+	}
+
+	want := []string{
+		`Android.bp:2:5: module "A" variant "y": output "A_phony_output" is also declared by module "A" variant "x" at Android.bp:2:5`,
+		`Android.bp:6:5: module "B" variant "y": output "B_phony_output" is also declared by module "B" variant "x" at Android.bp:6:5`,
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		assertNoErrors(t, run(t, false, nil))
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		if got := errorStrings(run(t, true, nil)); !slices.Equal(got, want) {
+			t.Errorf("expected errors %q, got %q", want, got)
+		}
+	})
+
+	t.Run("module actions writer", func(t *testing.T) {
+		errs := run(t, true, func(ctx *Context) {
+			ctx.SetModuleActionsWriter(&discardStringWriter{}, "modules.ninja")
+		})
+		if got := errorStrings(errs); !slices.Equal(got, want) {
+			t.Errorf("expected errors %q, got %q", want, got)
+		}
+	})
+
+	t.Run("singleton", func(t *testing.T) {
+		errs := run(t, true, func(ctx *Context) {
+			ctx.RegisterSingletonType("duplicate", func() Singleton { return duplicateOutputSingleton{} }, false)
+		})
+		want := append(slices.Clone(want),
+			`singleton "duplicate": output "A_phony_output" is also declared by module "A" variant "x" at Android.bp:2:5`)
+		if got := errorStrings(errs); !slices.Equal(got, want) {
+			t.Errorf("expected errors %q, got %q", want, got)
+		}
+	})
+}
+
+func TestBaseModuleContext(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+				name: "A",
+				deps: ["B"],
+			}
+
+			foo_module {
+				name: "B",
+			}
+		`),
+	})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+
+	a := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule
+	expectPanic := func(t *testing.T, want string, f func()) {
+		t.Helper()
+		defer func() {
+			t.Helper()
+			if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), want) {
+				t.Errorf("expected panic containing %q, got %v", want, r)
+			}
+		}()
+		f()
+	}
+
+	expectPanic(t, "BaseModuleContext called before ResolveDependencies", func() {
+		ctx.BaseModuleContext(a)
+	})
+
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	a = ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule
+	mctx := ctx.BaseModuleContext(a)
+	if g, w := mctx.ModuleName(), "A"; g != w {
+		t.Errorf("expected module name %q, got %q", w, g)
+	}
+	if mctx.Module() != a {
+		t.Errorf("expected Module to return the module")
+	}
+	var deps []string
+	mctx.VisitDirectDeps(func(m Module) { deps = append(deps, mctx.OtherModuleName(m)) })
+	if w := []string{"B"}; !slices.Equal(deps, w) {
+		t.Errorf("expected dependencies %q, got %q", w, deps)
+	}
+
+	expectPanic(t, "SetModuleMetadata called on the read-only context for module \"A\"", func() {
+		mctx.SetModuleMetadata("key", "value")
+	})
+}
+
+type DuplicatePropertiesEmbedded struct {
+	Srcs []string
+}
+
+type duplicatePropertiesModule struct {
+	SimpleName
+}
+
+func (m *duplicatePropertiesModule) GenerateBuildActions(ModuleContext) {}
+
+func TestRegisterModuleTypeDuplicateProperties(t *testing.T) {
+	testCases := []struct {
+		name       string
+		properties func() []interface{}
+		err        string
+	}{
+		{
+			name: "shared property",
+			properties: func() []interface{} {
+				return []interface{}{
+					&struct{ Srcs []string }{},
+					&struct {
+						Srcs   []string
+						Target struct{ Host struct{ Enabled *bool } }
+					}{},
+					&struct {
+						Target struct{ Android struct{ Enabled *bool } }
+					}{},
+				}
+			},
+		},
+		{
+			name: "embedded",
+			properties: func() []interface{} {
+				return []interface{}{&struct {
+					DuplicatePropertiesEmbedded
+					Srcs []string
+				}{}}
+			},
+			err: `module type "test_module": property "srcs" is declared by both ` +
+				`property struct 0.DuplicatePropertiesEmbedded.Srcs and property struct 0.Srcs`,
+		},
+		{
+			name: "nested",
+			properties: func() []interface{} {
+				return []interface{}{&struct {
+					Target struct {
+						Host struct{ Enabled *bool }
+					}
+				}{}, &struct {
+					Target struct {
+						Host struct{ Enabled string }
+					}
+				}{}}
+			},
+			err: `module type "test_module": property "target.host.enabled" is declared by both ` +
+				`property struct 0.Target.Host.Enabled (bool) and property struct 1.Target.Host.Enabled (string) ` +
+				`with incompatible types`,
+		},
+		{
+			name: "struct and value",
+			properties: func() []interface{} {
+				return []interface{}{
+					&struct{ Target struct{ Host *bool } }{},
+					&struct{ Target []string }{},
+				}
+			},
+			err: `module type "test_module": property "target" is declared by both ` +
+				`property struct 0.Target (struct { Host *bool }) and property struct 1.Target ([]string) ` +
+				`with incompatible types`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var err string
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						err = fmt.Sprint(r)
+					}
+				}()
+				NewContext().RegisterModuleType("test_module", func() (Module, []interface{}) {
+					return &duplicatePropertiesModule{}, tc.properties()
+				})
+			}()
+			if err != tc.err {
+				t.Errorf("expected error %q, got %q", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestModulePropertyStructs(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+				name: "A",
+				deps: ["B"],
+			}
+
+			foo_module {
+				name: "B",
+			}
+		`),
+	})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterTransitionMutator("os", configTransitionMutator{})
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies([]string{"x", "y"})
+	assertNoErrors(t, errs)
+
+	variants := ctx.moduleGroupFromName("A", nil).modules
+	if len(variants) != 2 {
+		t.Fatalf("expected 2 variants, got %d", len(variants))
+	}
+	for _, module := range variants {
+		m := module.logicModule.(*fooModule)
+		got := ctx.ModulePropertyStructs(m)
+		want := []interface{}{&m.baseTestModule.properties, &m.SimpleName.Properties}
+		if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("expected property structs of %s to be %p, got %p", module, want, got)
+		}
+	}
+	if ctx.ModulePropertyStructs(variants[0].logicModule)[0] == ctx.ModulePropertyStructs(variants[1].logicModule)[0] {
+		t.Errorf("expected variants to have separate property structs")
+	}
+}
+
+func TestModuleActionsWriter(t *testing.T) {
+	bp := `
+		rule_module {
+			name: "A",
+		}
+
+		rule_module {
+			name: "B",
+			unique: true,
+			local_variable: true,
+		}
+
+		rule_module {
+			name: "C",
+			pool: "link",
+			pool_depth: 1,
+		}
+	`
+	run := func(t *testing.T, moduleActions StringWriterWriter) string {
+		t.Helper()
+		ctx := NewContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(bp),
+		})
+		ctx.RegisterModuleType("rule_module", newRuleModule)
+		ctx.SetModuleVisitOrder(ModuleVisitOrderAlphabetical)
+		if moduleActions != nil {
+			ctx.SetModuleActionsWriter(moduleActions, "modules.ninja")
+		}
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.PrepareBuildActions(nil)
+		assertNoErrors(t, errs)
+
+		if moduleActions != nil {
+			for _, module := range ctx.moduleInfo {
+				if len(module.actionDefs.buildDefs) > 0 {
+					t.Errorf("expected build actions of %s to be released", module)
+				}
+			}
+		}
+
+		buf := &strings.Builder{}
+		if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	buffered := run(t, nil)
+	moduleActions := &strings.Builder{}
+	streamed := run(t, moduleActions)
+
+	if strings.Contains(streamed, "\nbuild ") {
+		t.Errorf("expected no build statements in the ninja file, got:\n%s", streamed)
+	}
+	subninja := strings.Index(streamed, "subninja modules.ninja\n")
+	if subninja == -1 {
+		t.Fatalf("expected a subninja statement for the module actions, got:\n%s", streamed)
+	}
+	if i := strings.LastIndex(streamed, "\nrule "); i > subninja {
+		t.Errorf("expected rules before the subninja statement, got:\n%s", streamed)
+	}
+	if i := strings.LastIndex(streamed, "\npool "); i > subninja {
+		t.Errorf("expected pools before the subninja statement, got:\n%s", streamed)
+	}
+	if !strings.Contains(buffered, moduleActions.String()) {
+		t.Errorf("expected streamed module actions:\n%s\nto match the buffered ninja file:\n%s",
+			moduleActions.String(), buffered)
+	}
+}
+
+func BenchmarkModuleActionsWriter(b *testing.B) {
+	bp := &strings.Builder{}
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(bp, "rule_module { name: \"m%d\", local_variable: true }\n", i)
+	}
+
+	run := func(b *testing.B, stream bool) {
+		b.ReportAllocs()
+		var retained uint64
+		for i := 0; i < b.N; i++ {
+			ctx := NewContext()
+			ctx.MockFileSystem(map[string][]byte{
+				"Android.bp": []byte(bp.String()),
+			})
+			ctx.RegisterModuleType("rule_module", newRuleModule)
+			if stream {
+				ctx.SetModuleActionsWriter(&discardStringWriter{}, "modules.ninja")
+			}
+			_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+			if len(errs) > 0 {
+				b.Fatal(errs)
+			}
+			_, errs = ctx.PrepareBuildActions(nil)
+			if len(errs) > 0 {
+				b.Fatal(errs)
+			}
+
+			// Measure the memory held between PrepareBuildActions and WriteBuildFile.
+			var memStats runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&memStats)
+			retained += memStats.HeapAlloc
+
+			if err := ctx.WriteBuildFile(&discardStringWriter{}, false, ""); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+	}
+
+	b.Run("buffered", func(b *testing.B) { run(b, false) })
+	b.Run("streamed", func(b *testing.B) { run(b, true) })
+}
+
+type discardStringWriter struct{}
+
+func (discardStringWriter) Write(p []byte) (int, error)       { return len(p), nil }
+func (discardStringWriter) WriteString(s string) (int, error) { return len(s), nil }
+
+func TestSetNinjaVariable(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			rule_module {
+				name: "A",
+				command: "${toolchain}/clang $in -o $out",
+			}
+
+			rule_module {
+				name: "B",
+				command: "${toolchain}/clang -O2 $in -o $out",
+			}
+		`),
+	})
+	ctx.RegisterModuleType("rule_module", newRuleModule)
+
+	if err := ctx.SetNinjaVariable("toolchain_root", "/very/long/path/to/$$toolchain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.SetNinjaVariable("toolchain", "${toolchain_root}/bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.SetNinjaVariable("toolchain", "/other"); err == nil {
+		t.Error("expected error when setting a ninja variable twice")
+	}
+	if err := ctx.SetNinjaVariable("pkg.toolchain", "/other"); err == nil {
+		t.Error("expected error for a ninja variable name containing '.'")
+	}
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.PrepareBuildActions(nil)
+	assertNoErrors(t, errs)
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, w := range []string{
+		"\ntoolchain_root = /very/long/path/to/$$toolchain\n\ntoolchain = ${toolchain_root}/bin\n",
+		"command = ${toolchain}/clang ${in} -o ${out}\n",
+		"command = ${toolchain}/clang -O2 ${in} -o ${out}\n",
+	} {
+		if g := strings.Count(out, w); g != 1 {
+			t.Errorf("expected %q once in build file, found %d times:\n%s", w, g, out)
+		}
+	}
+}
+
+func TestSetStringInterpolation(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			compiler = "clang"
+
+			rule_module {
+				name: "A",
+				command: "$${toolchain}/${compiler} $in -o $out",
+			}
+		`),
+	})
+	ctx.RegisterModuleType("rule_module", newRuleModule)
+	ctx.SetStringInterpolation(true)
+
+	if err := ctx.SetNinjaVariable("toolchain", "/bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.PrepareBuildActions(nil)
+	assertNoErrors(t, errs)
+
+	buf := &strings.Builder{}
+	if err := ctx.WriteBuildFile(buf, false, ""); err != nil {
+		t.Fatal(err)
+	}
+	if w := "command = ${toolchain}/clang ${in} -o ${out}\n"; !strings.Contains(buf.String(), w) {
+		t.Errorf("expected %q in build file:\n%s", w, buf.String())
+	}
+}
+
+func TestUnrecognizedProperty(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+				name: "A",
+				dep: ["B"],
+			}
+
+			bar_module {
+				name: "B",
+			}
+		`),
+	})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertOneErrorMatches(t, errs, `^Android.bp:4:8: unrecognized property "dep"$`)
+}
+
+type deprecatedPropertyModule struct {
+	SimpleName
+	properties struct {
+		Dep  []string `blueprint:"deprecated:use deps instead"`
+		Deps []string
+	}
+}
+
+func newDeprecatedPropertyModule() (Module, []interface{}) {
+	m := &deprecatedPropertyModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *deprecatedPropertyModule) GenerateBuildActions(ModuleContext) {}
+
+func TestDeprecatedPropertyWarnings(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			deprecated_module {
+				name: "B",
+				dep: ["C"],
+			}
+
+			deprecated_module {
+				name: "A",
+				dep: ["B"],
+			}
+
+			deprecated_module {
+				name: "C",
+				deps: ["A"],
+			}
+		`),
+	})
+	ctx.RegisterModuleType("deprecated_module", newDeprecatedPropertyModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+
+	var got []string
+	for _, warning := range ctx.Warnings() {
+		got = append(got, warning.Error())
+	}
+	want := []string{
+		`Android.bp:4:8: property "dep" is deprecated: use deps instead`,
+		`Android.bp:9:8: property "dep" is deprecated: use deps instead`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected warnings %q, got %q", want, got)
+	}
+
+	b := ctx.moduleGroupFromName("B", nil).modules.firstModule().logicModule.(*deprecatedPropertyModule)
+	if g, w := b.properties.Dep, []string{"C"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected deprecated property to be unpacked as %q, got %q", w, g)
+	}
+}
+
+func TestUnusedVariables(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			used = ["B"]
+			augmented = ["C"]
+			augmented += ["D"]
+			unused = ["E"]
+			unused += ["F"]
+			inherited = ["G"]
+			indirect = "H"
+			via_variable = [indirect]
+
+			deprecated_module {
+				name: "A",
+				deps: used + augmented + via_variable,
+			}
+		`),
+		"sub/Android.bp": []byte(`
+			unused_in_sub = "I"
+
+			deprecated_module {
+				name: "B",
+				deps: inherited,
+			}
+		`),
+	})
+	ctx.RegisterModuleType("deprecated_module", newDeprecatedPropertyModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+
+	var got []string
+	for _, v := range ctx.UnusedVariables() {
+		got = append(got, v.Pos.String()+": "+v.Name)
+	}
+	want := []string{
+		"Android.bp:5:4: unused",
+		"sub/Android.bp:2:4: unused_in_sub",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected unused variables %q, got %q", want, got)
+	}
+}
+
+func TestReparseUnusedVariables(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetRetainParsedFiles(true)
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			local = ["B"]
+			in_a = ["C"]
+			in_b = ["D"]
+
+			deprecated_module {
+				name: "A",
+				deps: local,
+			}
+		`),
+		"a/Android.bp": []byte(`
+			deprecated_module {
+				name: "B",
+				deps: in_a,
+			}
+		`),
+		"b/Android.bp": []byte(`
+			deprecated_module {
+				name: "C",
+				deps: in_b,
+			}
+		`),
+	})
+	ctx.RegisterModuleType("deprecated_module", newDeprecatedPropertyModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	if unused := ctx.UnusedVariables(); len(unused) > 0 {
+		t.Errorf("expected no unused variables, got %v", unused)
+	}
+
+	// in_b is no longer referenced by b/Android.bp, the references from the retained files remain.
+	ctx.MockFileSystem(map[string][]byte{
+		"b/Android.bp": []byte(`
+			deprecated_module {
+				name: "C",
+			}
+		`),
+	})
+	errs = ctx.Reparse([]string{"b/Android.bp"})
+	assertNoErrors(t, errs)
+
+	var got []string
+	for _, v := range ctx.UnusedVariables() {
+		got = append(got, v.Pos.String()+": "+v.Name)
+	}
+	want := []string{"Android.bp:4:4: in_b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected unused variables %q, got %q", want, got)
+	}
+}
+
+func TestCreateModule(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B", "C"],
+			}
+		`),
+	})
+
+	ctx.RegisterBottomUpMutator("create", createTestMutator).UsesCreateModule()
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected dep errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	a := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule.(*fooModule)
+	b := ctx.moduleGroupFromName("B", nil).modules.firstModule().logicModule.(*barModule)
+	c := ctx.moduleGroupFromName("C", nil).modules.firstModule().logicModule.(*barModule)
+	d := ctx.moduleGroupFromName("D", nil).modules.firstModule().logicModule.(*fooModule)
+
+	checkDeps := func(m Module, expected string) {
+		var deps []string
+		ctx.VisitDirectDeps(m, func(m Module) {
+			deps = append(deps, ctx.ModuleName(m))
+		})
+		got := strings.Join(deps, ",")
+		if got != expected {
+			t.Errorf("unexpected %q dependencies, got %q expected %q",
+				ctx.ModuleName(m), got, expected)
+		}
+	}
+
+	checkDeps(a, "B,C")
+	checkDeps(b, "D")
+	checkDeps(c, "D")
+	checkDeps(d, "")
+}
+
+func TestRenameModule(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["libfoo"],
+			}
+
+			foo_module {
+			    name: "B",
+			    deps: ["libfoo.v2"],
+			}
+
+			foo_module {
+			    name: "C",
+			}
+
+			bar_module {
+			    name: "libfoo",
+			}
+		`),
+	})
+
+	ctx.RegisterBottomUpMutator("early_deps", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "C" {
+			mctx.AddDependency(mctx.Module(), walkerDepsTag{follow: true}, "libfoo")
+		}
+	})
+	ctx.RegisterBottomUpMutator("rename", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "C" {
+			mctx.VisitDirectDeps(func(dep Module) {
+				mctx.RenameModule(dep, "libfoo.v2")
+			})
+		}
+	}).UsesRename()
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	for _, name := range []string{"A", "B", "C"} {
+		m := ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule
+		var deps []string
+		ctx.VisitDirectDeps(m, func(dep Module) {
+			deps = append(deps, ctx.ModuleName(dep))
+		})
+		if !slices.Equal(deps, []string{"libfoo.v2"}) {
+			t.Errorf("expected %q to depend on [libfoo.v2], got %q", name, deps)
+		}
+	}
+}
+
+func TestRenameUnknownModule(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+			    name: "A",
+			}
+		`),
+	})
+
+	ctx.RegisterBottomUpMutator("rename", func(mctx BottomUpMutatorContext) {
+		unknown, _ := newFooModule()
+		mctx.RenameModule(unknown, "B")
+	}).UsesRename()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertOneErrorMatches(t, errs, `Android.bp:2:4: cannot rename unknown module to "B"`)
+}
+
+func TestFilterByType(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+			    name: "A",
+			    deps: ["B"],
+			}
+
+			bar_module {
+			    name: "B",
+			    deps: ["C"],
+			}
+
+			foo_module {
+			    name: "C",
+			}
+		`),
+	})
+
+	var lock sync.Mutex
+	visited := make(map[string][]string)
+	record := func(mutator string) func(string) {
+		return func(name string) {
+			lock.Lock()
+			defer lock.Unlock()
+			visited[mutator] = append(visited[mutator], name)
 		}
-		t.FailNow()
 	}
+	recordFoo, recordBar, recordWalker := record("foo"), record("bar"), record("walker")
 
-	a := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule.(*fooModule)
-	b := ctx.moduleGroupFromName("B", nil).modules.firstModule().logicModule.(*barModule)
-	c := ctx.moduleGroupFromName("C", nil).modules.firstModule().logicModule.(*barModule)
-	d := ctx.moduleGroupFromName("D", nil).modules.firstModule().logicModule.(*fooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterBottomUpMutator("foo", func(mctx BottomUpMutatorContext) {
+		recordFoo(mctx.ModuleName())
+	}).FilterByType(reflect.TypeOf(&fooModule{}))
+	ctx.RegisterTopDownMutator("bar", func(mctx TopDownMutatorContext) {
+		recordBar(mctx.ModuleName())
+	}).FilterByType(reflect.TypeOf(&barModule{}))
+	ctx.RegisterBottomUpMutator("walker", func(mctx BottomUpMutatorContext) {
+		recordWalker(mctx.ModuleName())
+	}).FilterByType(reflect.TypeOf((*Walker)(nil)).Elem())
 
-	checkDeps := func(m Module, expected string) {
-		var deps []string
-		ctx.VisitDirectDeps(m, func(m Module) {
-			deps = append(deps, ctx.ModuleName(m))
-		})
-		got := strings.Join(deps, ",")
-		if got != expected {
-			t.Errorf("unexpected %q dependencies, got %q expected %q",
-				ctx.ModuleName(m), got, expected)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	expected := map[string][]string{
+		"foo":    {"C", "A"},
+		"bar":    {"B"},
+		"walker": {"C", "B", "A"},
+	}
+	for mutator, want := range expected {
+		got := visited[mutator]
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("expected mutator %q to visit %q, got %q", mutator, want, got)
 		}
 	}
-
-	checkDeps(a, "B,C")
-	checkDeps(b, "D")
-	checkDeps(c, "D")
-	checkDeps(d, "")
 }
 
 func createTestMutator(ctx BottomUpMutatorContext) {
@@ -601,6 +2625,164 @@ func TestWalkingWithSyntaxError(t *testing.T) {
 
 }
 
+func TestFindBlueprintsFiles(t *testing.T) {
+	find := func(files map[string]string) ([]string, []error) {
+		ctx := NewContext()
+		fs := make(map[string][]byte)
+		for name, contents := range files {
+			fs[name] = []byte(contents)
+		}
+		ctx.MockFileSystem(fs)
+		return ctx.FindBlueprintsFiles("Android.bp")
+	}
+
+	t.Run("tree", func(t *testing.T) {
+		files, errs := find(map[string]string{
+			"Android.bp": `
+				dirs = ["b", "a"]
+				subdirs = dirs
+				optional_subdirs = ["missing"]
+				build = ["extra.bp"]
+			`,
+			"extra.bp":          ``,
+			"a/Android.bp":      `subdirs = ["*"]`,
+			"a/x/Android.bp":    ``,
+			"a/y/Android.bp":    `optional_subdirs = dirs`,
+			"b/Android.bp":      `build = ["*.bp"]`,
+			"b/other.bp":        ``,
+			"unused/Android.bp": ``,
+		})
+		assertNoErrors(t, errs)
+		want := []string{"Android.bp", "extra.bp", "b/Android.bp", "b/other.bp", "a/Android.bp", "a/x/Android.bp",
+			"a/y/Android.bp"}
+		if !slices.Equal(files, want) {
+			t.Errorf("unexpected files, expected %q got %q", want, files)
+		}
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		_, errs := find(map[string]string{
+			"Android.bp":   `subdirs = ["a"]`,
+			"a/Android.bp": `subdirs = [".."]`,
+		})
+		assertOneErrorMatches(t, errs, `^a/Android.bp:1:9: include cycle: Android.bp -> a/Android.bp -> Android.bp$`)
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		_, errs := find(map[string]string{
+			"Android.bp":   `subdirs = ["a", "b"]`,
+			"a/Android.bp": ``,
+			"b/Android.bp": `subdirs = ["../a"]`,
+		})
+		assertOneErrorMatches(t, errs, `^b/Android.bp:1:9: "a/Android.bp" included more than once\n`+
+			`       Android.bp:1:9 <-- previously included here$`)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, errs := find(map[string]string{
+			"Android.bp": `subdirs = ["a"]`,
+		})
+		assertOneErrorMatches(t, errs, `^Android.bp:1:9: "a/Android.bp": not found$`)
+	})
+}
+
+type defaultsTestModule struct {
+	SimpleName
+	properties struct {
+		Defaults []string
+	}
+}
+
+func newDefaultsTestModule() (Module, []interface{}) {
+	m := &defaultsTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *defaultsTestModule) GenerateBuildActions(ModuleContext) {}
+
+type defaultsDepTag struct {
+	BaseDependencyTag
+}
+
+// defaultsDepsMutator adds dependencies on the defaults modules the way a primary builder would before
+// applying their properties, so that cycles between defaults are reported as dependency cycles.
+func defaultsDepsMutator(mctx BottomUpMutatorContext) {
+	if m, ok := mctx.Module().(*defaultsTestModule); ok {
+		mctx.AddDependency(m, defaultsDepTag{}, m.properties.Defaults...)
+	}
+}
+
+func TestDefaultsCycle(t *testing.T) {
+	testCases := []struct {
+		name string
+		bp   string
+		errs []string
+	}{
+		{
+			name: "self",
+			bp: `
+				defaults_module {
+					name: "a",
+					defaults: ["a"],
+				}
+			`,
+			errs: []string{`Android.bp:2:5: "a" depends on itself`},
+		},
+		{
+			name: "chain",
+			bp: `
+				defaults_module {
+					name: "a",
+					defaults: ["b"],
+				}
+
+				defaults_module {
+					name: "b",
+					defaults: ["c"],
+				}
+
+				defaults_module {
+					name: "c",
+					defaults: ["a"],
+				}
+			`,
+			errs: []string{
+				`Android.bp:2:5: encountered dependency cycle:`,
+				`Android.bp:12:5:     module "c" depends on module "a"`,
+				`Android.bp:2:5:     module "a" depends on module "b"`,
+				`Android.bp:7:5:     module "b" depends on module "c"`,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := NewContext()
+			ctx.MockFileSystem(map[string][]byte{
+				"Android.bp": []byte(tc.bp),
+			})
+			ctx.RegisterModuleType("defaults_module", newDefaultsTestModule)
+			ctx.RegisterBottomUpMutator("defaults_deps", defaultsDepsMutator)
+			ctx.RegisterBottomUpMutator("defaults", func(mctx BottomUpMutatorContext) {
+				t.Errorf("defaults applied to %q despite dependency cycle", mctx.ModuleName())
+			})
+
+			_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+			assertNoErrors(t, errs)
+			_, errs = ctx.ResolveDependencies(nil)
+
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, tc.errs) {
+				t.Errorf("expected errors:\n  %s\ngot:\n  %s",
+					strings.Join(tc.errs, "\n  "), strings.Join(got, "\n  "))
+			}
+		})
+	}
+}
+
 func TestParseFailsForModuleWithoutName(t *testing.T) {
 	ctx := NewContext()
 	ctx.MockFileSystem(map[string][]byte{
@@ -734,7 +2916,7 @@ func Test_findVariant(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ctx := NewContext()
-			got, _, errs := ctx.findVariant(module, nil, tt.possibleDeps, tt.variations, tt.far, tt.reverse)
+			got, _, _, errs := ctx.findVariant(module, nil, tt.possibleDeps, tt.variations, nil, tt.far, tt.reverse)
 			if errs != nil {
 				t.Fatal(errs)
 			}
@@ -752,7 +2934,7 @@ func Test_findVariant(t *testing.T) {
 
 func Test_parallelVisit(t *testing.T) {
 	addDep := func(from, to *moduleInfo) {
-		from.directDeps = append(from.directDeps, depInfo{to, nil})
+		from.directDeps = append(from.directDeps, depInfo{to, nil, nil})
 		from.forwardDeps = append(from.forwardDeps, to)
 		to.reverseDeps = append(to.reverseDeps, from)
 	}
@@ -1565,6 +3747,43 @@ func TestRestoreBuildActions(t *testing.T) {
 	}
 }
 
+func TestCacheBuildActionsMetadata(t *testing.T) {
+	ctx := incrementalSetup(t)
+	ctx.SetIncrementalEnabled(true)
+	incInfo := ctx.moduleGroupFromName("MyIncrementalModule", nil).modules.firstModule()
+	barInfo := ctx.moduleGroupFromName("MyBarModule", nil).modules.firstModule()
+	incInfo.metadata = map[string]string{"owner": "build"}
+
+	_, errs := ctx.PrepareBuildActions(nil)
+	assertNoErrors(t, errs)
+
+	cacheKey := calculateHashKey(incInfo, [][]uint64{barInfo.providerInitialValueHashes})
+	cache := ctx.buildActionsToCache[cacheKey]
+	if cache == nil {
+		t.Fatalf("failed to find cached build actions for the incremental module")
+	}
+	if g, w := cache.Metadata, map[string]string{"owner": "build"}; !maps.Equal(g, w) {
+		t.Errorf("expected cached metadata %q, got %q", w, g)
+	}
+}
+
+func TestRestoreBuildActionsMetadata(t *testing.T) {
+	ctx, _ := incrementalSetupForRestore(t, nil)
+	for _, data := range ctx.buildActionsFromCache {
+		data.Metadata = map[string]string{"owner": "build"}
+	}
+	_, errs := ctx.PrepareBuildActions(nil)
+	assertNoErrors(t, errs)
+
+	incInfo := ctx.moduleGroupFromName("MyIncrementalModule", nil).modules.firstModule()
+	if incInfo.logicModule.(*incrementalModule).GenerateBuildActionsCalled {
+		t.Fatalf("expected GenerateBuildActions to be skipped for the incremental module")
+	}
+	if g, w := ctx.ModuleMetadata(incInfo.logicModule), map[string]string{"owner": "build"}; !maps.Equal(g, w) {
+		t.Errorf("expected restored metadata %q, got %q", w, g)
+	}
+}
+
 func TestSkipNinjaForCacheHit(t *testing.T) {
 	ctx, _ := incrementalSetupForRestore(t, nil)
 	_, errs := ctx.PrepareBuildActions(nil)
@@ -1745,6 +3964,12 @@ func TestDisallowedMutatorMethods(t *testing.T) {
 			mutatorFunc:       func(ctx BottomUpMutatorContext) { ctx.Rename("qux") },
 			expectedPanic:     "method Rename called from mutator that was not marked UsesRename",
 		},
+		{
+			name:              "rename_module",
+			mutatorHandleFunc: func(handle MutatorHandle) { handle.UsesRename() },
+			mutatorFunc:       func(ctx BottomUpMutatorContext) { ctx.RenameModule(ctx.Module(), "qux") },
+			expectedPanic:     "method RenameModule called from mutator that was not marked UsesRename",
+		},
 		{
 			name:              "replace_dependencies",
 			mutatorHandleFunc: func(handle MutatorHandle) { handle.UsesReplaceDependencies() },
@@ -1846,3 +4071,339 @@ func TestDisallowedMutatorMethods(t *testing.T) {
 	}
 
 }
+
+type enableableTestModule struct {
+	SimpleName
+	SimpleEnabled
+	properties struct {
+		Deps          []string
+		Required_deps []string
+	}
+
+	generated bool
+	visited   []string
+}
+
+func newEnableableTestModule() (Module, []interface{}) {
+	m := &enableableTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleEnabled.Properties}
+}
+
+func (m *enableableTestModule) GenerateBuildActions(ctx ModuleContext) {
+	m.generated = true
+	ctx.VisitDirectDeps(func(dep Module) {
+		m.visited = append(m.visited, "direct:"+ctx.OtherModuleName(dep))
+	})
+	ctx.WalkDeps(func(child, parent Module) bool {
+		m.visited = append(m.visited, "walk:"+ctx.OtherModuleName(child))
+		return true
+	})
+}
+
+type requiredDepTag struct {
+	BaseDependencyTag
+}
+
+func (requiredDepTag) RequiresEnabledDependency() bool { return true }
+
+func enableableDepsMutator(ctx BottomUpMutatorContext) {
+	m := ctx.Module().(*enableableTestModule)
+	ctx.AddDependency(m, nil, m.properties.Deps...)
+	ctx.AddDependency(m, requiredDepTag{}, m.properties.Required_deps...)
+}
+
+func TestDisabledModules(t *testing.T) {
+	run := func(t *testing.T, bp string, ctxHook func(*Context)) (*Context, []error) {
+		ctx := NewContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(bp),
+		})
+		ctx.RegisterModuleType("enableable_module", newEnableableTestModule)
+		ctx.RegisterBottomUpMutator("deps", enableableDepsMutator)
+		if ctxHook != nil {
+			ctxHook(ctx)
+		}
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies(nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.PrepareBuildActions(nil)
+		return ctx, errs
+	}
+
+	module := func(ctx *Context, name string) *enableableTestModule {
+		return ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule.(*enableableTestModule)
+	}
+
+	bp := `
+		enableable_module {
+			name: "A",
+			deps: ["B", "C"],
+		}
+
+		enableable_module {
+			name: "B",
+			deps: ["D"],
+			enabled: false,
+		}
+
+		enableable_module {
+			name: "C",
+			deps: ["D"],
+		}
+
+		enableable_module {
+			name: "D",
+		}
+	`
+
+	t.Run("disabled", func(t *testing.T) {
+		ctx, errs := run(t, bp, nil)
+		assertNoErrors(t, errs)
+
+		if module(ctx, "B").generated {
+			t.Errorf("expected GenerateBuildActions not to be called on disabled module B")
+		}
+		if !module(ctx, "A").generated {
+			t.Errorf("expected GenerateBuildActions to be called on enabled module A")
+		}
+		want := []string{"direct:C", "walk:C", "walk:D"}
+		if got := module(ctx, "A").visited; !slices.Equal(got, want) {
+			t.Errorf("unexpected dependencies visited by A, want %q got %q", want, got)
+		}
+	})
+
+	t.Run("include disabled", func(t *testing.T) {
+		ctx, errs := run(t, bp, func(ctx *Context) {
+			ctx.SetIncludeDisabledModules(true)
+		})
+		assertNoErrors(t, errs)
+
+		if !module(ctx, "B").generated {
+			t.Errorf("expected GenerateBuildActions to be called on disabled module B")
+		}
+		want := []string{"direct:B", "direct:C", "walk:B", "walk:D", "walk:C", "walk:D"}
+		if got := module(ctx, "A").visited; !slices.Equal(got, want) {
+			t.Errorf("unexpected dependencies visited by A, want %q got %q", want, got)
+		}
+	})
+
+	t.Run("required", func(t *testing.T) {
+		ctx, errs := run(t, `
+			enableable_module {
+				name: "A",
+				required_deps: ["B"],
+			}
+
+			enableable_module {
+				name: "B",
+				enabled: false,
+			}
+		`, nil)
+		assertOneErrorMatches(t, errs, `^Android.bp:2:4: module "A": depends on disabled module "B"$`)
+		if module(ctx, "A").generated {
+			t.Errorf("expected GenerateBuildActions not to be called on A")
+		}
+	})
+}
+
+func TestDependencyNameResolver(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+				name: "A",
+				deps: ["B", "C"],
+			}
+
+			foo_module {
+				name: "B",
+			}
+
+			foo_module {
+				name: "D",
+			}
+		`),
+	})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterBottomUpMutator("late_deps", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "B" {
+			mctx.AddVariationDependencies(nil, walkerDepsTag{follow: true}, "C")
+		}
+	})
+
+	var resolvedLock sync.Mutex
+	var resolved []string
+	ctx.SetDependencyNameResolver(func(from Module, name string) string {
+		resolvedLock.Lock()
+		defer resolvedLock.Unlock()
+		resolved = append(resolved, from.Name()+"->"+name)
+		if name == "C" {
+			return "D"
+		}
+		return name
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	checkDeps := func(name string, expected ...string) {
+		t.Helper()
+		var got []string
+		ctx.VisitDirectDeps(ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule, func(m Module) {
+			got = append(got, ctx.ModuleName(m))
+		})
+		if !slices.Equal(got, expected) {
+			t.Errorf("unexpected dependencies of %q, expected %q got %q", name, expected, got)
+		}
+	}
+	checkDeps("A", "B", "D")
+	checkDeps("B", "D")
+
+	slices.Sort(resolved)
+	if want := []string{"A->B", "A->C", "B->C"}; !slices.Equal(resolved, want) {
+		t.Errorf("unexpected calls to the resolver, expected %q got %q", want, resolved)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	run := func(t *testing.T, fs map[string][]byte) (*Context, []error) {
+		var fileList []string
+		for f := range fs {
+			fileList = append(fileList, f)
+		}
+		slices.Sort(fileList)
+
+		ctx := NewContext()
+		ctx.MockFileSystem(fs)
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterBottomUpMutator("deps", depsMutator)
+		if err := ctx.RegisterNamespace("vendor/b"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ctx.RegisterNamespace("vendor/a", "vendor/b"); err != nil {
+			t.Fatal(err)
+		}
+		_, errs := ctx.ParseFileList(".", fileList, nil)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+
+	t.Run("resolve", func(t *testing.T) {
+		ctx, errs := run(t, map[string][]byte{
+			"Android.bp": []byte(`
+				foo_module {
+					name: "libfoo",
+				}
+
+				foo_module {
+					name: "user",
+					deps: ["libfoo", "//vendor/a:libfoo", "//vendor/b:libbar"],
+				}
+			`),
+			"vendor/a/Android.bp": []byte(`
+				foo_module {
+					name: "libfoo",
+				}
+
+				foo_module {
+					name: "user",
+					deps: ["libfoo", "libbar", "//:libfoo"],
+				}
+			`),
+			"vendor/a/sub/Android.bp": []byte(`
+				foo_module {
+					name: "sub",
+					deps: ["libfoo", "user"],
+				}
+			`),
+			"vendor/b/Android.bp": []byte(`
+				foo_module {
+					name: "libbar",
+					deps: ["libfoo"],
+				}
+			`),
+		})
+		assertNoErrors(t, errs)
+
+		checkDeps := func(dir, name string, expected ...string) {
+			t.Helper()
+			var module Module
+			ctx.VisitAllModules(func(m Module) {
+				if ctx.ModuleName(m) == name && ctx.ModuleDir(m) == dir {
+					module = m
+				}
+			})
+			if module == nil {
+				t.Fatalf("module //%s:%s not found", dir, name)
+			}
+			var got []string
+			ctx.VisitDirectDeps(module, func(dep Module) {
+				got = append(got, "//"+strings.TrimPrefix(ctx.ModuleDir(dep), ".")+":"+ctx.ModuleName(dep))
+			})
+			if !slices.Equal(got, expected) {
+				t.Errorf("unexpected dependencies of //%s:%s, expected %q got %q", dir, name, expected, got)
+			}
+		}
+		checkDeps(".", "user", "//:libfoo", "//vendor/a:libfoo", "//vendor/b:libbar")
+		checkDeps("vendor/a", "user", "//vendor/a:libfoo", "//vendor/b:libbar", "//:libfoo")
+		checkDeps("vendor/a/sub", "sub", "//vendor/a:libfoo", "//vendor/a:user")
+		checkDeps("vendor/b", "libbar", "//:libfoo")
+	})
+
+	t.Run("not visible", func(t *testing.T) {
+		_, errs := run(t, map[string][]byte{
+			"Android.bp": []byte(`
+				foo_module {
+					name: "user",
+					deps: ["libbar"],
+				}
+			`),
+			"vendor/b/Android.bp": []byte(`
+				foo_module {
+					name: "libbar",
+				}
+			`),
+		})
+		assertOneErrorMatches(t, errs, `"user" depends on undefined module "libbar"`)
+	})
+
+	t.Run("duplicate in namespace", func(t *testing.T) {
+		_, errs := run(t, map[string][]byte{
+			"vendor/a/Android.bp": []byte(`
+				foo_module {
+					name: "libfoo",
+				}
+			`),
+			"vendor/a/sub/Android.bp": []byte(`
+				foo_module {
+					name: "libfoo",
+				}
+			`),
+		})
+		assertOneErrorMatches(t, errs, `module "libfoo" already defined`)
+	})
+
+	t.Run("register errors", func(t *testing.T) {
+		ctx := NewContext()
+		if err := ctx.RegisterNamespace("a", "b"); err == nil || err.Error() != `namespace "a" imports unknown namespace "b"` {
+			t.Errorf("unexpected error for unknown import: %v", err)
+		}
+		if err := ctx.RegisterNamespace("."); err == nil || err.Error() != `invalid namespace directory "."` {
+			t.Errorf("unexpected error for root directory: %v", err)
+		}
+		if err := ctx.RegisterNamespace("a"); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if err := ctx.RegisterNamespace("a/"); err == nil || err.Error() != `namespace "a" already registered` {
+			t.Errorf("unexpected error for duplicate namespace: %v", err)
+		}
+	})
+}
@@ -0,0 +1,292 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// A SnapshotModule identifies a module variant in a GraphSnapshot.
+type SnapshotModule struct {
+	Name    string
+	Variant string
+}
+
+// A GraphSnapshot records the module variants, the dependency edges between them and the values
+// of the properties tagged `blueprint:"mutated"` at the point it was taken, see
+// Context.SnapshotAfterMutator.
+type GraphSnapshot struct {
+	mutator string
+	taken   bool
+
+	// modules maps each module variant to the formatted values of its mutated properties.
+	modules map[SnapshotModule]map[string]string
+	edges   map[snapshotEdgeKey]ResolvedEdge
+}
+
+// snapshotEdgeKey identifies a ResolvedEdge, whose dependency tag may not be comparable, by the
+// formatted dependency tag.
+type snapshotEdgeKey struct {
+	from, fromVariant, to, toVariant string
+	tag                              string
+}
+
+func newSnapshotEdgeKey(edge ResolvedEdge) snapshotEdgeKey {
+	return snapshotEdgeKey{
+		from:        edge.From,
+		fromVariant: edge.FromVariant,
+		to:          edge.To,
+		toVariant:   edge.ToVariant,
+		tag:         fmt.Sprintf("%T %+v", edge.Tag, edge.Tag),
+	}
+}
+
+// Mutator returns the name of the mutator the snapshot is taken after.
+func (s *GraphSnapshot) Mutator() string {
+	return s.mutator
+}
+
+// Taken returns true if the mutator has run and the snapshot contains the module graph.
+func (s *GraphSnapshot) Taken() bool {
+	return s.taken
+}
+
+// Modules returns the module variants in the snapshot, sorted by name and variant.
+func (s *GraphSnapshot) Modules() []SnapshotModule {
+	return slices.SortedFunc(maps.Keys(s.modules), compareSnapshotModules)
+}
+
+// Edges returns the dependency edges in the snapshot, sorted by the modules they connect.
+func (s *GraphSnapshot) Edges() []ResolvedEdge {
+	return sortedSnapshotEdges(maps.Keys(s.edges), s.edges)
+}
+
+// A GraphDiff describes the differences between two GraphSnapshots, see GraphSnapshot.Diff.
+type GraphDiff struct {
+	AddedModules   []SnapshotModule
+	RemovedModules []SnapshotModule
+	AddedEdges     []ResolvedEdge
+	RemovedEdges   []ResolvedEdge
+
+	// ChangedProperties contains an entry for each mutated property whose value differs in a
+	// module variant that is in both snapshots.
+	ChangedProperties []PropertyChange
+}
+
+// Empty returns true if the snapshots were identical.
+func (d GraphDiff) Empty() bool {
+	return len(d.AddedModules) == 0 && len(d.RemovedModules) == 0 && len(d.AddedEdges) == 0 &&
+		len(d.RemovedEdges) == 0 && len(d.ChangedProperties) == 0
+}
+
+// A PropertyChange describes a property tagged `blueprint:"mutated"` whose value differs between
+// two GraphSnapshots.  Nested properties are named with dots, and the values are formatted with
+// fmt.Sprint.
+type PropertyChange struct {
+	Module   SnapshotModule
+	Property string
+	Old      string
+	New      string
+}
+
+// Diff returns the changes from s to other, which is usually a snapshot taken after a later
+// mutator.  The lists in the returned GraphDiff are sorted.
+func (s *GraphSnapshot) Diff(other *GraphSnapshot) GraphDiff {
+	var diff GraphDiff
+
+	for module, props := range s.modules {
+		otherProps, ok := other.modules[module]
+		if !ok {
+			diff.RemovedModules = append(diff.RemovedModules, module)
+			continue
+		}
+		for _, property := range slices.Sorted(maps.Keys(props)) {
+			if props[property] != otherProps[property] {
+				diff.ChangedProperties = append(diff.ChangedProperties, PropertyChange{
+					Module:   module,
+					Property: property,
+					Old:      props[property],
+					New:      otherProps[property],
+				})
+			}
+		}
+	}
+	for module := range other.modules {
+		if _, ok := s.modules[module]; !ok {
+			diff.AddedModules = append(diff.AddedModules, module)
+		}
+	}
+	slices.SortFunc(diff.AddedModules, compareSnapshotModules)
+	slices.SortFunc(diff.RemovedModules, compareSnapshotModules)
+	slices.SortStableFunc(diff.ChangedProperties, func(a, b PropertyChange) int {
+		return compareSnapshotModules(a.Module, b.Module)
+	})
+
+	var added, removed []snapshotEdgeKey
+	for key := range s.edges {
+		if _, ok := other.edges[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	for key := range other.edges {
+		if _, ok := s.edges[key]; !ok {
+			added = append(added, key)
+		}
+	}
+	diff.RemovedEdges = sortedSnapshotEdges(slices.Values(removed), s.edges)
+	diff.AddedEdges = sortedSnapshotEdges(slices.Values(added), other.edges)
+
+	return diff
+}
+
+func compareSnapshotModules(a, b SnapshotModule) int {
+	return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.Variant, b.Variant))
+}
+
+func sortedSnapshotEdges(keys iter.Seq[snapshotEdgeKey], edges map[snapshotEdgeKey]ResolvedEdge) []ResolvedEdge {
+	sortedKeys := slices.SortedFunc(keys, func(a, b snapshotEdgeKey) int {
+		return cmp.Or(cmp.Compare(a.from, b.from), cmp.Compare(a.fromVariant, b.fromVariant),
+			cmp.Compare(a.to, b.to), cmp.Compare(a.toVariant, b.toVariant), cmp.Compare(a.tag, b.tag))
+	})
+	var ret []ResolvedEdge
+	for _, key := range sortedKeys {
+		ret = append(ret, edges[key])
+	}
+	return ret
+}
+
+// SnapshotAfterMutator returns a GraphSnapshot that ResolveDependencies fills in with the module
+// graph after the named mutator has visited every module, which can be compared with a snapshot
+// taken after a later mutator to find which mutator introduced a variant, a dependency or a
+// property change.  The snapshot of a transition mutator is taken after all of the mutators it
+// registers have run.  Calling it again with the same name returns the same GraphSnapshot.
+//
+// It must be called after the mutator is registered and before ResolveDependencies, and panics if
+// no mutator with the name is registered.  The snapshot is not taken if the mutator is disabled
+// with DisableMutators or an earlier mutator fails.
+func (c *Context) SnapshotAfterMutator(name string) *GraphSnapshot {
+	if !slices.ContainsFunc(c.mutatorInfo, func(m *mutatorInfo) bool { return m.registeredName() == name }) {
+		panic(fmt.Errorf("unknown mutator %q", name))
+	}
+	if c.graphSnapshots == nil {
+		c.graphSnapshots = make(map[string]*GraphSnapshot)
+	}
+	if snapshot, ok := c.graphSnapshots[name]; ok {
+		return snapshot
+	}
+	snapshot := &GraphSnapshot{mutator: name}
+	c.graphSnapshots[name] = snapshot
+	return snapshot
+}
+
+// splitMutatorGroupsAtSnapshots splits the coalesced mutator groups after each mutator that a
+// snapshot is taken after, so that the snapshot doesn't include the changes made by the mutators
+// registered after it.
+func (c *Context) splitMutatorGroupsAtSnapshots(groups [][]*mutatorInfo) [][]*mutatorInfo {
+	if len(c.graphSnapshots) == 0 {
+		return groups
+	}
+	var ret [][]*mutatorInfo
+	for _, group := range groups {
+		start := 0
+		for i, mutator := range group {
+			if c.graphSnapshots[mutator.registeredName()] != nil {
+				ret = append(ret, group[start:i+1])
+				start = i + 1
+			}
+		}
+		if start < len(group) {
+			ret = append(ret, group[start:])
+		}
+	}
+	return ret
+}
+
+// takeGraphSnapshots takes the snapshots requested with SnapshotAfterMutator for the mutators in
+// a mutator group that has finished running.
+func (c *Context) takeGraphSnapshots(group []*mutatorInfo) {
+	if len(c.graphSnapshots) == 0 {
+		return
+	}
+	snapshot := c.graphSnapshots[group[len(group)-1].registeredName()]
+	if snapshot == nil {
+		return
+	}
+
+	snapshot.taken = true
+	snapshot.modules = make(map[SnapshotModule]map[string]string)
+	snapshot.edges = make(map[snapshotEdgeKey]ResolvedEdge)
+	for module := range c.iterateAllVariants() {
+		props := make(map[string]string)
+		for _, p := range module.properties {
+			appendMutatedProperties(props, reflect.ValueOf(p).Elem(), "")
+		}
+		snapshot.modules[SnapshotModule{module.Name(), module.variant.name}] = props
+
+		for _, dep := range module.directDeps {
+			edge := ResolvedEdge{
+				From:        module.Name(),
+				FromVariant: module.variant.name,
+				To:          dep.module.Name(),
+				ToVariant:   dep.module.variant.name,
+				Tag:         dep.tag,
+			}
+			snapshot.edges[newSnapshotEdgeKey(edge)] = edge
+		}
+	}
+}
+
+// appendMutatedProperties adds the formatted values of the fields tagged `blueprint:"mutated"` in
+// structValue, or in the structs nested in it, to props.
+func appendMutatedProperties(props map[string]string, structValue reflect.Value, prefix string) {
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := prefix
+		if !proptools.IsEmbedded(field) {
+			name += proptools.PropertyNameForField(field.Name)
+		}
+
+		fieldValue := structValue.Field(i)
+		if proptools.HasTag(field, "blueprint", "mutated") {
+			if fieldValue.Kind() == reflect.Ptr && !fieldValue.IsNil() {
+				fieldValue = fieldValue.Elem()
+			}
+			props[name] = fmt.Sprint(fieldValue.Interface())
+			continue
+		}
+
+		for (fieldValue.Kind() == reflect.Ptr || fieldValue.Kind() == reflect.Interface) && !fieldValue.IsNil() {
+			fieldValue = fieldValue.Elem()
+		}
+		if fieldValue.Kind() == reflect.Struct && !proptools.IsConfigurable(fieldValue.Type()) {
+			if !proptools.IsEmbedded(field) {
+				name += "."
+			}
+			appendMutatedProperties(props, fieldValue, name)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGraphSnapshot(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+				deps: ["B"],
+			}
+
+			transition_module {
+				name: "B",
+			}
+
+			transition_module {
+				name: "C",
+			}
+		`),
+	})
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTransitionMutator("os", configTransitionMutator{})
+	ctx.RegisterBottomUpMutator("late", func(ctx BottomUpMutatorContext) {
+		if ctx.ModuleName() == "A" {
+			ctx.Module().(*transitionModule).properties.Mutated = "late"
+			ctx.AddDependency(ctx.Module(), walkerDepsTag{follow: true}, "C")
+		}
+	})
+	ctx.RegisterBottomUpMutator("unchanged", func(ctx BottomUpMutatorContext) {})
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	deps := ctx.SnapshotAfterMutator("deps")
+	os := ctx.SnapshotAfterMutator("os")
+	late := ctx.SnapshotAfterMutator("late")
+	unchanged := ctx.SnapshotAfterMutator("unchanged")
+	if ctx.SnapshotAfterMutator("os") != os {
+		t.Errorf("expected SnapshotAfterMutator to return the same snapshot for the same mutator")
+	}
+	if deps.Taken() {
+		t.Errorf("expected snapshot to not be taken before ResolveDependencies")
+	}
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies([]string{"x", "y"})
+	assertNoErrors(t, errs)
+
+	type edge struct{ from, fromVariant, to, toVariant string }
+	edges := func(resolved []ResolvedEdge) []edge {
+		var ret []edge
+		for _, e := range resolved {
+			ret = append(ret, edge{e.From, e.FromVariant, e.To, e.ToVariant})
+		}
+		return ret
+	}
+
+	if !deps.Taken() || !os.Taken() || !late.Taken() || !unchanged.Taken() {
+		t.Fatalf("expected all snapshots to be taken")
+	}
+	if g, w := deps.Modules(), []SnapshotModule{{"A", ""}, {"B", ""}, {"C", ""}}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected modules %v, got %v", w, g)
+	}
+
+	diff := deps.Diff(os)
+	if g, w := diff.AddedModules, []SnapshotModule{{"A", "x"}, {"A", "y"}, {"B", "x"}, {"B", "y"},
+		{"C", "x"}, {"C", "y"}}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected added modules %v, got %v", w, g)
+	}
+	if g, w := diff.RemovedModules, []SnapshotModule{{"A", ""}, {"B", ""}, {"C", ""}}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected removed modules %v, got %v", w, g)
+	}
+	if g, w := edges(diff.AddedEdges), []edge{{"A", "x", "B", "x"}, {"A", "y", "B", "y"}}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected added edges %v, got %v", w, g)
+	}
+	if g, w := edges(diff.RemovedEdges), []edge{{"A", "", "B", ""}}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected removed edges %v, got %v", w, g)
+	}
+
+	diff = os.Diff(late)
+	if len(diff.AddedModules) > 0 || len(diff.RemovedModules) > 0 || len(diff.RemovedEdges) > 0 {
+		t.Errorf("expected only added edges and changed properties, got %+v", diff)
+	}
+	if g, w := edges(diff.AddedEdges), []edge{{"A", "x", "C", "x"}, {"A", "y", "C", "y"}}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected added edges %v, got %v", w, g)
+	}
+	if g, w := diff.ChangedProperties, []PropertyChange{
+		{SnapshotModule{"A", "x"}, "mutated", "", "late"},
+		{SnapshotModule{"A", "y"}, "mutated", "", "late"},
+	}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected changed properties %v, got %v", w, g)
+	}
+
+	if diff := late.Diff(unchanged); !diff.Empty() {
+		t.Errorf("expected no differences, got %+v", diff)
+	}
+}
@@ -32,6 +32,10 @@ type BuildActionCachedData struct {
 	Providers        []CachedProvider
 	Pos              *scanner.Position
 	OrderOnlyStrings []string
+	Phonys           map[string][]string
+	Pools            map[string]int
+	OutputFiles      map[string][]string
+	Metadata         map[string]string
 }
 
 type BuildActionCache = map[BuildActionCacheKey]*BuildActionCachedData
@@ -115,3 +115,28 @@ func namesLike(name string, unlike string, moduleGroups []*moduleGroup) []string
 	sort.Strings(best)
 	return best
 }
+
+// variantsLike returns the entries in available with the smallest edit distance to requested.
+func variantsLike(requested string, available []string) []string {
+	const kAllowedDifferences = 10
+
+	var best []string
+	bestVal := kAllowedDifferences + 1
+
+	for _, other := range available {
+		buf := make([][]int, len(other)+1)
+		for i := range buf {
+			buf[i] = make([]int, len(requested)+1)
+		}
+
+		l := levenshtein(requested, other, 0, 0, kAllowedDifferences, buf)
+		if l < bestVal {
+			bestVal = l
+			best = []string{other}
+		} else if l == bestVal && !stringIn(best, other) {
+			best = append(best, other)
+		}
+	}
+
+	return best
+}
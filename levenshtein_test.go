@@ -52,3 +52,10 @@ func TestLevenshteinDelete(t *testing.T) {
 func TestLevenshteinReplace(t *testing.T) {
 	assertEqual(t, namesLike("aa", "test", mods([]string{"ab", "ac", "not_this"})), []string{"ab", "ac"})
 }
+
+func TestVariantsLike(t *testing.T) {
+	assertEqual(t, variantsLike("arch:arm", []string{"arch:arm64", "arch:x86", "<empty variant>"}),
+		[]string{"arch:arm64"})
+	assertEqual(t, variantsLike("arch:x86", []string{"arch:x86_64", "arch:x87", "<empty variant>"}),
+		[]string{"arch:x87"})
+}
@@ -50,6 +50,25 @@ func (l *liveTracker) AddBuildDefDeps(def *buildDef) error {
 	}
 	def.RuleDef = ruleDef
 
+	if def.Pool != nil {
+		err = l.innerAddPool(def.Pool)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = def.checkDepfile(ruleDef)
+	if err != nil {
+		return err
+	}
+
+	if l.ctx.checkRuleArgs {
+		err = def.checkRuleArgs(ruleDef)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = l.innerAddNinjaStringListDeps(def.Outputs)
 	if err != nil {
 		return err
@@ -143,7 +162,7 @@ func (l *liveTracker) innerAddRule(r Rule) (def *ruleDef, err error) {
 func (l *liveTracker) addPool(p Pool) error {
 	l.Lock()
 	defer l.Unlock()
-	return l.addPool(p)
+	return l.innerAddPool(p)
 }
 
 func (l *liveTracker) innerAddPool(p Pool) error {
@@ -256,3 +275,18 @@ func (l *liveTracker) RemoveRuleIfLive(r Rule) bool {
 	}
 	return isLive
 }
+
+// packageContexts returns the package of each live variable, pool and rule.
+func (l *liveTracker) packageContexts() []*packageContext {
+	var pctxs []*packageContext
+	for v := range l.variables {
+		pctxs = append(pctxs, v.packageContext())
+	}
+	for p := range l.pools {
+		pctxs = append(pctxs, p.packageContext())
+	}
+	for r := range l.rules {
+		pctxs = append(pctxs, r.packageContext())
+	}
+	return pctxs
+}
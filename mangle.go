@@ -22,6 +22,10 @@ func moduleNamespacePrefix(moduleName string) string {
 	return "m." + moduleName + "."
 }
 
+func moduleOrderOnlyPhonyName(moduleName string) string {
+	return moduleNamespacePrefix(moduleName) + "order_only"
+}
+
 func singletonNamespacePrefix(singletonName string) string {
 	return "s." + singletonName + "."
 }
@@ -17,7 +17,9 @@ package blueprint
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -126,6 +128,20 @@ func (m ModuleProxy) GenerateBuildActions(context ModuleContext) {
 	m.module.GenerateBuildActions(context)
 }
 
+// An EnableableModule is a Module that can be disabled, usually by an "enabled: false" property.  A module that
+// is disabled when a TransitionMutator starts is not split by it, as if it had called
+// BottomUpMutatorContext.SkipMutator.  A module that is disabled after all the mutators have run does not have its
+// GenerateBuildActions method called, and the dependency visiting methods of the ModuleContext of the modules that
+// depend on it skip it.  A dependency on a disabled module with a tag that implements RequiredDependencyTag is an
+// error instead.  Enabled is called between mutators, so it should only depend on the properties of the module.
+//
+// Context.SetIncludeDisabledModules makes all modules behave as if they were enabled.
+type EnableableModule interface {
+	Module
+
+	Enabled() bool
+}
+
 // A DynamicDependerModule is a Module that may add dependencies that do not
 // appear in its "deps" property.  Any Module that implements this interface
 // will have its DynamicDependencies method called by the Context that created
@@ -183,6 +199,10 @@ type EarlyModuleContext interface {
 	// ModuleErrorf reports an error at the line number of the module type in the module definition.
 	ModuleErrorf(fmt string, args ...interface{})
 
+	// ModuleErrorfWithPath is like ModuleErrorf, but appends the dependency path from the module set by
+	// Context.SetDependencyPathRoot to this module to the message, for example "(via A -> B -> C)".
+	ModuleErrorfWithPath(fmt string, args ...interface{})
+
 	// PropertyErrorf reports an error at the line number of a property in the module definition.
 	PropertyErrorf(property, fmt string, args ...interface{})
 
@@ -240,7 +260,7 @@ type BaseModuleContext interface {
 
 	// VisitDirectDeps calls visit for each direct dependency.  If there are multiple direct dependencies on the same
 	// module visit will be called multiple times on that module and OtherModuleDependencyTag will return a different
-	// tag for each.
+	// tag for each.  Dependencies on disabled modules are not visited from GenerateBuildActions, see EnableableModule.
 	//
 	// The Module passed to the visit function should not be retained outside of the visit function, it may be
 	// invalidated by future mutators.
@@ -256,6 +276,22 @@ type BaseModuleContext interface {
 	// invalidated by future mutators.
 	VisitDirectDepsIf(pred func(Module) bool, visit func(Module))
 
+	// VisitDirectDepsWithTag calls visit for each direct dependency that was added with a dependency tag equal to
+	// tag.  OtherModuleDependencyTag called from visit returns the tag of the dependency being visited.  Tags that
+	// cannot be compared with ==, such as structs containing slices, are compared with reflect.DeepEqual.
+	//
+	// The Module passed to the visit function should not be retained outside of the visit function, it may be
+	// invalidated by future mutators.
+	VisitDirectDepsWithTag(tag DependencyTag, visit func(Module))
+
+	// VisitDirectDepsWithTagName calls visit for each direct dependency that was added with a dependency tag whose
+	// name, as registered with Context.RegisterDependencyTag or returned by NamedDependencyTag.Name, is name.
+	// OtherModuleDependencyTag called from visit returns the tag of the dependency being visited.
+	//
+	// The Module passed to the visit function should not be retained outside of the visit function, it may be
+	// invalidated by future mutators.
+	VisitDirectDepsWithTagName(name string, visit func(Module))
+
 	// VisitDepsDepthFirst calls visit for each transitive dependency, traversing the dependency tree in depth first
 	// order. visit will only be called once for any given module, even if there are multiple paths through the
 	// dependency tree to the module or multiple direct dependencies with different tags.  OtherModuleDependencyTag will
@@ -341,6 +377,16 @@ type BaseModuleContext interface {
 	// passed to Context.SetNameInterface, or SimpleNameInterface if it was not called.
 	OtherModuleExists(name string) bool
 
+	// ModuleExists returns true if a module with the specified name exists, like OtherModuleExists.  It does not add
+	// a dependency, so it can be used to choose between optional dependencies before adding one.
+	ModuleExists(name string) bool
+
+	// ModuleHasVariant returns true if a module with the specified name has a variant with the given variation for
+	// mutator.  A variation of "" matches the variants that were not split by the mutator.  It only sees the
+	// variants that exist when it is called, so variants created by a mutator that has not finished running on the
+	// other module are not visible.  It does not add a dependency.
+	ModuleHasVariant(name, mutator, variation string) bool
+
 	// ModuleFromName returns (module, true) if a module exists by the given name and same context namespace,
 	// or (nil, false) if it does not exist. It panics if there is either more than one
 	// module of the given name, or if the given name refers to an alias instead of a module.
@@ -399,10 +445,22 @@ type BaseModuleContext interface {
 	// This method shouldn't be used directly, prefer the type-safe android.SetProvider instead.
 	SetProvider(provider AnyProviderKey, value any)
 
+	// SetModuleMetadata sets a string value for key in the metadata of the current module, replacing any
+	// previous value.  Metadata is not used by Blueprint, it is exposed to external tools through
+	// Context.ModuleMetadata and the JSON module graph.  It is copied to new variants of the module.
+	SetModuleMetadata(key, value string)
+
 	EarlyGetMissingDependencies() []string
 
 	EqualModules(m1, m2 Module) bool
 
+	// RequiredVariations returns the sorted variations that mutators registered before the current
+	// TransitionMutator marked as required for the module with BottomUpMutatorContext.RequireVariation.
+	// It allows TransitionMutator.Split to only return a variation when something needs it.  It
+	// returns nil when not called from the methods of a TransitionMutator that are passed a
+	// BaseModuleContext.
+	RequiredVariations() []string
+
 	base() *baseModuleContext
 }
 
@@ -417,16 +475,62 @@ type ModuleContext interface {
 
 	ModuleCacheKey() string
 
+	// ModuleVariantsForMutator returns the variations that the transition mutator with the given name created for
+	// the given module.  See Context.ModuleVariantsForMutator for more information.
+	ModuleVariantsForMutator(module Module, mutatorName string) []string
+
 	// Variable creates a new ninja variable scoped to the module.  It can be referenced by calls to Rule and Build
 	// in the same module.
 	Variable(pctx PackageContext, name, value string)
 
 	// Rule creates a new ninja rule scoped to the module.  It can be referenced by calls to Build in the same module.
+	// If Context.SetInternRules was called, rules with the same name and definition created by different modules
+	// are shared and written to the ninja file once, unless they reference variables created by Variable.
 	Rule(pctx PackageContext, name string, params RuleParams, argNames ...string) Rule
 
+	// UniqueRule is like Rule, but always creates a rule that is distinct from the rules of other modules.
+	UniqueRule(pctx PackageContext, name string, params RuleParams, argNames ...string) Rule
+
+	// Pool returns a ninja pool with the given name that limits the number of rules or build statements assigned
+	// to it that run concurrently to depth.  Every module that calls Pool with the same name gets the same pool, which
+	// is written to the ninja file once, and it panics if the depth is not the same.  The depth must be at least 1.
+	// The name "console" is reserved for ninja's builtin pool, which is available as Console.
+	Pool(name string, depth int) Pool
+
 	// Build creates a new ninja build statement.
 	Build(pctx PackageContext, params BuildParams)
 
+	// VisitOrderOnlyDeps calls visit for each variant of each module that the current module has an
+	// order-only dependency on, see BottomUpMutatorContext.AddOrderOnlyDependency.
+	VisitOrderOnlyDeps(visit func(Module))
+
+	// VisitDirectReverseDeps calls visit for each enabled module that has a direct dependency on the
+	// current module.  If a module has multiple direct dependencies on the current module visit will
+	// be called multiple times on that module.  The modules are visited in order of name and then
+	// variant name.
+	//
+	// The reverse dependencies may be generating their build actions concurrently, so visit should
+	// only use them with methods that are safe to call on other modules, like OtherModuleName or
+	// OtherModuleProvider.
+	VisitDirectReverseDeps(visit func(Module))
+
+	// Phony adds deps to the ninja phony target with the given name.  Any number of modules may
+	// contribute to the same phony target, a single phony build statement that depends on the union
+	// of all of their deps is written to the ninja file.
+	Phony(name string, deps ...string)
+
+	// AddOutputFiles declares paths as output files of the module with the given tag, so that dependents and
+	// singletons can retrieve them with OutputFiles.  Calling it several times with the same tag appends to
+	// the paths.  The empty tag is conventionally used for the default outputs of a module.
+	AddOutputFiles(tag string, paths ...string)
+
+	// OutputFiles returns the output files declared by the given module with AddOutputFiles for the given
+	// tag, or the output files of the current module so far.  When modules are visited without ordering it
+	// waits for GenerateBuildActions to finish on the given module, otherwise it returns an error if it has
+	// not finished yet, which can only happen for modules that are not dependencies of the current module.
+	// It also returns an error if the module did not declare any output files with the tag.
+	OutputFiles(module Module, tag string) ([]string, error)
+
 	// GetMissingDependencies returns the list of dependencies that were passed to AddDependencies or related methods,
 	// but do not exist.  It can be used with Context.SetAllowMissingDependencies to allow the primary builder to
 	// handle missing dependencies on its own instead of having Blueprint treat them as an error.
@@ -443,6 +547,9 @@ type baseModuleContext struct {
 	visitingParent *moduleInfo
 	visitingDep    depInfo
 	ninjaFileDeps  []string
+
+	// splitMutator is the transition mutator whose Split is being called, for RequiredVariations.
+	splitMutator *transitionMutatorImpl
 }
 
 func (d *baseModuleContext) moduleInfo() *moduleInfo {
@@ -506,6 +613,12 @@ func (d *baseModuleContext) ModuleErrorf(format string,
 	d.error(d.context.moduleErrorf(d.module, format, args...))
 }
 
+func (d *baseModuleContext) ModuleErrorfWithPath(format string,
+	args ...interface{}) {
+
+	d.error(d.context.moduleErrorfWithPath(d.module, format, args...))
+}
+
 func (d *baseModuleContext) PropertyErrorf(property, format string,
 	args ...interface{}) {
 
@@ -545,7 +658,13 @@ type moduleContext struct {
 	baseModuleContext
 	scope              *localScope
 	actionDefs         localBuildActions
+	phonys             map[string][]string
+	pools              map[string]int
 	handledMissingDeps bool
+
+	// set when GenerateBuildActions is called without waiting for the module's dependencies,
+	// see SetParallelGenerateBuildActions
+	pauseCh chan<- pauseSpec
 }
 
 func (m *baseModuleContext) EqualModules(m1, m2 Module) bool {
@@ -628,12 +747,29 @@ func (m *baseModuleContext) OtherModuleExists(name string) bool {
 	return exists
 }
 
+func (m *baseModuleContext) ModuleExists(name string) bool {
+	return m.OtherModuleExists(name)
+}
+
+func (m *baseModuleContext) ModuleHasVariant(name, mutator, variation string) bool {
+	group := m.context.moduleGroupFromName(name, m.module.namespace())
+	if group == nil {
+		return false
+	}
+	for _, module := range group.modules {
+		if module.variant.variations.get(mutator) == variation {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *baseModuleContext) OtherModuleDependencyVariantExists(variations []Variation, name string) bool {
 	possibleDeps := m.context.moduleGroupFromName(name, m.module.namespace())
 	if possibleDeps == nil {
 		return false
 	}
-	found, _, errs := m.context.findVariant(m.module, m.config, possibleDeps, variations, false, false)
+	found, _, _, errs := m.context.findVariant(m.module, m.config, possibleDeps, variations, nil, false, false)
 	if errs != nil {
 		panic(errors.Join(errs...))
 	}
@@ -645,7 +781,7 @@ func (m *baseModuleContext) OtherModuleFarDependencyVariantExists(variations []V
 	if possibleDeps == nil {
 		return false
 	}
-	found, _, errs := m.context.findVariant(m.module, m.config, possibleDeps, variations, true, false)
+	found, _, _, errs := m.context.findVariant(m.module, m.config, possibleDeps, variations, nil, true, false)
 	if errs != nil {
 		panic(errors.Join(errs...))
 	}
@@ -657,7 +793,7 @@ func (m *baseModuleContext) OtherModuleReverseDependencyVariantExists(name strin
 	if possibleDeps == nil {
 		return false
 	}
-	found, _, errs := m.context.findVariant(m.module, m.config, possibleDeps, nil, false, true)
+	found, _, _, errs := m.context.findVariant(m.module, m.config, possibleDeps, nil, nil, false, true)
 	if errs != nil {
 		panic(errors.Join(errs...))
 	}
@@ -669,6 +805,29 @@ func (m *baseModuleContext) OtherModuleProvider(logicModule Module, provider Any
 	return m.context.provider(module, provider.provider())
 }
 
+func (m *moduleContext) OtherModuleProvider(logicModule Module, provider AnyProviderKey) (any, bool) {
+	module := m.context.moduleInfo[getWrappedModule(logicModule)]
+	if m.pauseCh != nil && module != m.module && provider.provider().mutator == "" {
+		// Only wait for dependencies, two modules that read each other's providers would otherwise
+		// wait for each other forever.
+		if !m.context.dependsOn(m.module, module) {
+			m.ModuleErrorf("OtherModuleProvider called on %s, which is not a direct or transitive dependency",
+				module)
+			return nil, false
+		}
+		// The other module's GenerateBuildActions may not have finished yet, wait for it to set
+		// its providers.
+		unpause := make(unpause)
+		m.pauseCh <- pauseSpec{
+			paused:  m.module,
+			until:   module,
+			unpause: unpause,
+		}
+		<-unpause
+	}
+	return m.context.provider(module, provider.provider())
+}
+
 func (m *baseModuleContext) Provider(provider AnyProviderKey) (any, bool) {
 	return m.context.provider(m.module, provider.provider())
 }
@@ -677,6 +836,27 @@ func (m *baseModuleContext) SetProvider(provider AnyProviderKey, value interface
 	m.context.setProvider(m.module, provider.provider(), value)
 }
 
+func (m *baseModuleContext) SetModuleMetadata(key, value string) {
+	if m.module.metadata == nil {
+		m.module.metadata = make(map[string]string)
+	}
+	m.module.metadata[key] = value
+}
+
+// readOnlyModuleContext is the BaseModuleContext returned by Context.BaseModuleContext, which
+// disallows the methods that modify the module.
+type readOnlyModuleContext struct {
+	baseModuleContext
+}
+
+func (m *readOnlyModuleContext) SetProvider(provider AnyProviderKey, value interface{}) {
+	panic(fmt.Errorf("SetProvider called on the read-only context for %s", m.module))
+}
+
+func (m *readOnlyModuleContext) SetModuleMetadata(key, value string) {
+	panic(fmt.Errorf("SetModuleMetadata called on the read-only context for %s", m.module))
+}
+
 func (m *moduleContext) cacheModuleBuildActions(key *BuildActionCacheKey) {
 	var providers []CachedProvider
 	for i, p := range m.module.providers {
@@ -694,8 +874,12 @@ func (m *moduleContext) cacheModuleBuildActions(key *BuildActionCacheKey) {
 	relPos := m.module.pos
 	relPos.Filename = m.module.relBlueprintsFile
 	data := BuildActionCachedData{
-		Providers: providers,
-		Pos:       &relPos,
+		Providers:   providers,
+		Pos:         &relPos,
+		Phonys:      m.phonys,
+		Pools:       m.pools,
+		OutputFiles: m.module.outputFiles,
+		Metadata:    maps.Clone(m.module.metadata),
 	}
 
 	m.context.updateBuildActionsCache(key, &data)
@@ -750,6 +934,21 @@ func (m *moduleContext) restoreModuleBuildActions() (bool, *BuildActionCacheKey)
 			}
 			m.module.incrementalRestored = true
 			m.module.orderOnlyStrings = data.OrderOnlyStrings
+			m.phonys = data.Phonys
+			m.module.outputFiles = data.OutputFiles
+			m.module.metadata = maps.Clone(data.Metadata)
+			// The pools are declared in the global section of the ninja file rather than the module's
+			// cached actions, so they have to be recreated.
+			for name, depth := range data.Pools {
+				p, err := m.context.sharedPool(name, depth)
+				if err != nil {
+					panic(err)
+				}
+				if err := m.context.liveGlobals.addPool(p); err != nil {
+					panic(err)
+				}
+			}
+			m.pools = data.Pools
 			restored = true
 		}
 	}
@@ -759,7 +958,7 @@ func (m *moduleContext) restoreModuleBuildActions() (bool, *BuildActionCacheKey)
 
 func (m *baseModuleContext) GetDirectDep(name string) (Module, DependencyTag) {
 	for _, dep := range m.module.directDeps {
-		if dep.module.Name() == name {
+		if dep.module.Name() == name && !dep.module.disabled {
 			return dep.module.logicModule, dep.tag
 		}
 	}
@@ -770,7 +969,7 @@ func (m *baseModuleContext) GetDirectDep(name string) (Module, DependencyTag) {
 func (m *baseModuleContext) GetDirectDepWithTag(name string, tag DependencyTag) Module {
 	var deps []depInfo
 	for _, dep := range m.module.directDeps {
-		if dep.module.Name() == name {
+		if dep.module.Name() == name && !dep.module.disabled {
 			if dep.tag == tag {
 				return dep.module.logicModule
 			}
@@ -796,6 +995,9 @@ func (m *baseModuleContext) VisitDirectDeps(visit func(Module)) {
 	m.visitingParent = m.module
 
 	for _, dep := range m.module.directDeps {
+		if dep.module.disabled {
+			continue
+		}
 		m.visitingDep = dep
 		visit(dep.module.logicModule)
 	}
@@ -815,6 +1017,9 @@ func (m *baseModuleContext) VisitDirectDepsProxy(visit func(proxy ModuleProxy))
 	m.visitingParent = m.module
 
 	for _, dep := range m.module.directDeps {
+		if dep.module.disabled {
+			continue
+		}
 		m.visitingDep = dep
 		visit(ModuleProxy{dep.module.logicModule})
 	}
@@ -823,6 +1028,48 @@ func (m *baseModuleContext) VisitDirectDepsProxy(visit func(proxy ModuleProxy))
 	m.visitingDep = depInfo{}
 }
 
+func (m *baseModuleContext) VisitDirectDepsWithTag(tag DependencyTag, visit func(Module)) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "VisitDirectDepsWithTag(%s, %v, %s) for dependency %s",
+				m.module, tag, funcName(visit), m.visitingDep.module))
+		}
+	}()
+
+	m.visitingParent = m.module
+
+	for _, dep := range m.module.directDeps {
+		if dependencyTagsEqual(dep.tag, tag) && !dep.module.disabled {
+			m.visitingDep = dep
+			visit(dep.module.logicModule)
+		}
+	}
+
+	m.visitingParent = nil
+	m.visitingDep = depInfo{}
+}
+
+func (m *baseModuleContext) VisitDirectDepsWithTagName(name string, visit func(Module)) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(newPanicErrorf(r, "VisitDirectDepsWithTagName(%s, %q, %s) for dependency %s",
+				m.module, name, funcName(visit), m.visitingDep.module))
+		}
+	}()
+
+	m.visitingParent = m.module
+
+	for _, dep := range m.module.directDeps {
+		if m.context.DependencyTagName(dep.tag) == name && !dep.module.disabled {
+			m.visitingDep = dep
+			visit(dep.module.logicModule)
+		}
+	}
+
+	m.visitingParent = nil
+	m.visitingDep = depInfo{}
+}
+
 func (m *baseModuleContext) VisitDirectDepsIf(pred func(Module) bool, visit func(Module)) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -834,6 +1081,9 @@ func (m *baseModuleContext) VisitDirectDepsIf(pred func(Module) bool, visit func
 	m.visitingParent = m.module
 
 	for _, dep := range m.module.directDeps {
+		if dep.module.disabled {
+			continue
+		}
 		m.visitingDep = dep
 		if pred(dep.module.logicModule) {
 			visit(dep.module.logicModule)
@@ -852,10 +1102,12 @@ func (m *baseModuleContext) VisitDepsDepthFirst(visit func(Module)) {
 		}
 	}()
 
-	m.context.walkDeps(m.module, false, nil, func(dep depInfo, parent *moduleInfo) {
-		m.visitingParent = parent
-		m.visitingDep = dep
-		visit(dep.module.logicModule)
+	m.context.walkDeps(m.module, false, skipDisabledDeps, func(dep depInfo, parent *moduleInfo) {
+		if !dep.module.disabled {
+			m.visitingParent = parent
+			m.visitingDep = dep
+			visit(dep.module.logicModule)
+		}
 	})
 
 	m.visitingParent = nil
@@ -872,8 +1124,8 @@ func (m *baseModuleContext) VisitDepsDepthFirstIf(pred func(Module) bool,
 		}
 	}()
 
-	m.context.walkDeps(m.module, false, nil, func(dep depInfo, parent *moduleInfo) {
-		if pred(dep.module.logicModule) {
+	m.context.walkDeps(m.module, false, skipDisabledDeps, func(dep depInfo, parent *moduleInfo) {
+		if !dep.module.disabled && pred(dep.module.logicModule) {
 			m.visitingParent = parent
 			m.visitingDep = dep
 			visit(dep.module.logicModule)
@@ -886,6 +1138,9 @@ func (m *baseModuleContext) VisitDepsDepthFirstIf(pred func(Module) bool,
 
 func (m *baseModuleContext) WalkDeps(visit func(child, parent Module) bool) {
 	m.context.walkDeps(m.module, true, func(dep depInfo, parent *moduleInfo) bool {
+		if dep.module.disabled {
+			return false
+		}
 		m.visitingParent = parent
 		m.visitingDep = dep
 		return visit(dep.module.logicModule, parent.logicModule)
@@ -897,6 +1152,9 @@ func (m *baseModuleContext) WalkDeps(visit func(child, parent Module) bool) {
 
 func (m *baseModuleContext) WalkDepsProxy(visit func(child, parent ModuleProxy) bool) {
 	m.context.walkDeps(m.module, true, func(dep depInfo, parent *moduleInfo) bool {
+		if dep.module.disabled {
+			return false
+		}
 		m.visitingParent = parent
 		m.visitingDep = dep
 		return visit(ModuleProxy{dep.module.logicModule}, ModuleProxy{parent.logicModule})
@@ -906,6 +1164,11 @@ func (m *baseModuleContext) WalkDepsProxy(visit func(child, parent ModuleProxy)
 	m.visitingDep = depInfo{}
 }
 
+// skipDisabledDeps is a visitDown function for Context.walkDeps that doesn't recurse into disabled modules.
+func skipDisabledDeps(dep depInfo, _ *moduleInfo) bool {
+	return !dep.module.disabled
+}
+
 func (m *baseModuleContext) PrimaryModule() Module {
 	return m.module.group.modules.firstModule().logicModule
 }
@@ -954,6 +1217,10 @@ func (m *moduleContext) ModuleCacheKey() string {
 	return m.module.ModuleCacheKey()
 }
 
+func (m *moduleContext) ModuleVariantsForMutator(logicModule Module, mutatorName string) []string {
+	return m.context.ModuleVariantsForMutator(getWrappedModule(logicModule), mutatorName)
+}
+
 func (m *moduleContext) Variable(pctx PackageContext, name, value string) {
 	m.scope.ReparentTo(pctx)
 
@@ -968,6 +1235,54 @@ func (m *moduleContext) Variable(pctx PackageContext, name, value string) {
 func (m *moduleContext) Rule(pctx PackageContext, name string,
 	params RuleParams, argNames ...string) Rule {
 
+	r := m.addLocalRule(pctx, name, params, argNames)
+
+	// Modules that support incremental builds may have their build actions restored from the cache
+	// without the module that first defined a shared rule, so they always use their own rules.
+	if m.context.internRules && m.module.buildActionCacheKey == nil {
+		if interned := m.context.internRule(r); interned != nil {
+			m.scope.replaceLocalRule(interned)
+			return interned
+		}
+	}
+
+	m.actionDefs.rules = append(m.actionDefs.rules, r)
+
+	return r
+}
+
+func (m *moduleContext) UniqueRule(pctx PackageContext, name string,
+	params RuleParams, argNames ...string) Rule {
+
+	r := m.addLocalRule(pctx, name, params, argNames)
+
+	m.actionDefs.rules = append(m.actionDefs.rules, r)
+
+	return r
+}
+
+func (m *moduleContext) Pool(name string, depth int) Pool {
+	p, err := m.context.sharedPool(name, depth)
+	if err != nil {
+		panic(err)
+	}
+
+	err = m.scope.addSharedPool(p)
+	if err != nil {
+		panic(err)
+	}
+
+	if m.pools == nil {
+		m.pools = make(map[string]int)
+	}
+	m.pools[name] = depth
+
+	return p
+}
+
+func (m *moduleContext) addLocalRule(pctx PackageContext, name string,
+	params RuleParams, argNames []string) *localRule {
+
 	m.scope.ReparentTo(pctx)
 
 	r, err := m.scope.AddLocalRule(name, &params, argNames...)
@@ -975,8 +1290,6 @@ func (m *moduleContext) Rule(pctx PackageContext, name string,
 		panic(err)
 	}
 
-	m.actionDefs.rules = append(m.actionDefs.rules, r)
-
 	return r
 }
 
@@ -991,6 +1304,56 @@ func (m *moduleContext) Build(pctx PackageContext, params BuildParams) {
 	m.actionDefs.buildDefs = append(m.actionDefs.buildDefs, def)
 }
 
+func (m *moduleContext) VisitOrderOnlyDeps(visit func(Module)) {
+	m.context.VisitOrderOnlyDeps(m.module.logicModule, visit)
+}
+
+func (m *moduleContext) VisitDirectReverseDeps(visit func(Module)) {
+	m.context.VisitDirectReverseDeps(m.module.logicModule, func(module Module) {
+		if !m.context.moduleInfo[module].disabled {
+			visit(module)
+		}
+	})
+}
+
+func (m *moduleContext) Phony(name string, deps ...string) {
+	if m.phonys == nil {
+		m.phonys = make(map[string][]string)
+	}
+	m.phonys[name] = append(m.phonys[name], deps...)
+}
+
+func (m *moduleContext) AddOutputFiles(tag string, paths ...string) {
+	if m.module.outputFiles == nil {
+		m.module.outputFiles = make(map[string][]string)
+	}
+	m.module.outputFiles[tag] = append(m.module.outputFiles[tag], paths...)
+}
+
+func (m *moduleContext) OutputFiles(logicModule Module, tag string) ([]string, error) {
+	module := m.context.moduleInfo[getWrappedModule(logicModule)]
+	if module == m.module {
+		return m.context.outputFiles(module, tag)
+	}
+	if m.pauseCh != nil {
+		// The other module's GenerateBuildActions may not have finished yet, wait for it to add its
+		// output files.
+		unpause := make(unpause)
+		m.pauseCh <- pauseSpec{
+			paused:  m.module,
+			until:   module,
+			unpause: unpause,
+		}
+		<-unpause
+	} else if !module.finishedGenerateBuildActions {
+		// Only the dependencies of the module are guaranteed to have finished when the modules are
+		// visited in order.
+		return nil, fmt.Errorf("output files of module %q requested before its build actions were generated",
+			module.Name())
+	}
+	return m.context.outputFiles(module, tag)
+}
+
 func (m *moduleContext) GetMissingDependencies() []string {
 	m.handledMissingDeps = true
 	return m.module.missingDeps
@@ -1006,14 +1369,17 @@ func (m *baseModuleContext) EarlyGetMissingDependencies() []string {
 
 type mutatorContext struct {
 	baseModuleContext
-	mutator          *mutatorInfo
-	reverseDeps      []reverseDep
-	rename           []rename
-	replace          []replace
-	newVariations    moduleList    // new variants of existing modules
-	newModules       []*moduleInfo // brand new modules
-	defaultVariation *string
-	pauseCh          chan<- pauseSpec
+	mutator     *mutatorInfo
+	reverseDeps []reverseDep
+	rename      []rename
+
+	reverseDepsCreatingVariant []reverseDepCreatingVariant
+	replace                    []replace
+	newVariations              moduleList    // new variants of existing modules
+	newModules                 []*moduleInfo // brand new modules
+	defaultVariation           *string
+	pauseCh                    chan<- pauseSpec
+	splitData                  any // set while calling TransitionMutator.Mutate
 }
 
 type TopDownMutatorContext interface {
@@ -1027,6 +1393,10 @@ type BottomUpMutatorContext interface {
 	// dependency (some entries may be nil).  Does not affect the ordering of the current mutator
 	// pass, but will be ordered correctly for all future mutator passes.
 	//
+	// A name of the form "name:variant" selects the variation "variant" of the most recent
+	// TransitionMutator that has finished, as if it had been passed to AddVariationDependencies.
+	// It is an error to use this form before any TransitionMutator has finished.
+	//
 	// This method will pause until the new dependencies have had the current mutator called on them.
 	AddDependency(module Module, tag DependencyTag, name ...string) []Module
 
@@ -1043,10 +1413,31 @@ type BottomUpMutatorContext interface {
 	// each dependency (some entries may be nil).  A variant of the dependency must exist that matches
 	// the all of the non-local variations of the current module, plus the variations argument.
 	//
+	// The returned slice has one entry for each name in deps, in the same order.  An entry is nil if
+	// the dependency could not be added, for example because the module or variant is missing and
+	// SetAllowMissingDependencies(true) was called, or because an IncomingTransition dropped it.
+	//
+	// Each name in deps may use the "name:variant" form described in AddDependency, which overrides
+	// any variation of the same TransitionMutator in the variations argument.
 	//
 	// This method will pause until the new dependencies have had the current mutator called on them.
 	AddVariationDependencies([]Variation, DependencyTag, ...string) []Module
 
+	// AddOptionalVariationDependencies is like AddVariationDependencies, but a missing module or a
+	// missing variant of a module is never an error, regardless of SetAllowMissingDependencies.  The
+	// returned slice has a nil entry for each missing dependency, and the missing dependency is not
+	// reported by GetMissingDependencies.
+	//
+	// This method will pause until the new dependencies have had the current mutator called on them.
+	AddOptionalVariationDependencies([]Variation, DependencyTag, ...string) []Module
+
+	// AddVariationDependencyIfExists is like AddOptionalVariationDependencies for a single
+	// dependency.  The dependency is only added if the named module already has the requested
+	// variant, and the dependency is returned, otherwise it is silently skipped and nil is returned.
+	//
+	// This method will pause until the new dependency has had the current mutator called on it.
+	AddVariationDependencyIfExists([]Variation, DependencyTag, string) Module
+
 	// AddReverseVariationDependency adds a dependency from the named module to the current
 	// module. The given variations will be added to the current module's varations, and then the
 	// result will be used to find the correct variation of the depending module, which must exist.
@@ -1058,11 +1449,26 @@ type BottomUpMutatorContext interface {
 	// UsesReverseDependencies during registration.
 	AddReverseVariationDependency([]Variation, DependencyTag, string)
 
+	// AddReverseVariationDependencyCreatingVariant is like AddReverseVariationDependency, but if
+	// the named module doesn't have the requested variant it is run through the IncomingTransition
+	// of the most recent TransitionMutator and the resulting variant is created, and has Mutate
+	// called on it, instead of reporting an error.  The created variant is cloned from the module as
+	// it was before the TransitionMutator split it, so it only has the dependencies that existed at
+	// that point, and mutators that have run since the TransitionMutator are not run on it.  It
+	// participates in all later mutators.  Variants can only be created when no other mutator has
+	// created variants since the most recent TransitionMutator.
+	//
+	// The dependency is resolved at the end of the mutator pass.
+	AddReverseVariationDependencyCreatingVariant([]Variation, DependencyTag, string)
+
 	// AddFarVariationDependencies adds deps as dependencies of the current module, but uses the
 	// variations argument to select which variant of the dependency to use.  It returns a slice of
 	// modules for each dependency (some entries may be nil).  A variant of the dependency must
 	// exist that matches the variations argument, but may also have other variations.
-	// For any unspecified variation the first variant will be used.
+	// The variant with the fewest variations beyond the requested ones is used, and if more than
+	// one variant is equally close the first of them is used, or an error is reported if
+	// Context.SetErrorOnAmbiguousFarDependencies was called.  If variations is empty any variant
+	// matches and the first variant with the fewest variations will be used.
 	//
 	// Unlike AddVariationDependencies, the variations of the current module are ignored - the
 	// dependency only needs to match the supplied variations.
@@ -1071,6 +1477,25 @@ type BottomUpMutatorContext interface {
 	// This method will pause until the new dependencies have had the current mutator called on them.
 	AddFarVariationDependencies([]Variation, DependencyTag, ...string) []Module
 
+	// AddLateVariationDependencies is like AddVariationDependencies, but the dependencies are not
+	// resolved until after all mutators have run, so that they can select variants created by mutators
+	// that run after the current one.  The dependencies are copied to any variants later created from the
+	// current module, and each variant uses its final variations plus the variations argument to select
+	// the variant of the dependency.  They are resolved in a final pass after the reverse dependencies
+	// added by the last mutator, and errors for missing modules or variants are reported the same way as
+	// for AddVariationDependencies.  A "name:variant" form selects a variation of the last
+	// TransitionMutator.
+	AddLateVariationDependencies([]Variation, DependencyTag, ...string)
+
+	// AddOrderOnlyDependency adds order-only dependencies on the modules with the given names.  An
+	// order-only dependency doesn't select or create a variant of the dependency and is not visited
+	// by VisitDirectDeps, it only ensures that every build statement of each variant of the current
+	// module runs after the build statements of all variants of the dependency, as a ninja order-only
+	// ("||") input.  The dependencies are copied to any variants later created from the current module,
+	// and are resolved after all mutators have run, when a cycle formed by order-only and other
+	// dependencies is reported as an error.  They can be visited with ModuleContext.VisitOrderOnlyDeps.
+	AddOrderOnlyDependency(names ...string)
+
 	// ReplaceDependencies finds all the variants of the module with the specified name, then
 	// replaces all dependencies onto those variants with the current variant of this module.
 	// Replacements don't take effect until after the mutator pass is finished.  May only
@@ -1089,10 +1514,50 @@ type BottomUpMutatorContext interface {
 	// by mutators that were marked with UsesRename during registration.
 	Rename(name string)
 
+	// RenameModule renames all variants of the given module, which may be the current module or a
+	// dependency.  Like Rename the new name is not visible until after this mutator pass is
+	// complete, but unlike Rename the old name continues to refer to the module, so dependencies
+	// that are added using the old name by later mutators resolve to the renamed module.
+	// Dependencies that were already added are not affected, they refer to the module and not to its
+	// name.  Dependencies added during the same mutator pass can only use the old name, so the
+	// mutator should run before the mutators that add dependencies on the renamed module using its
+	// new name.  An error is reported if module is not a module in the Context.  May only be
+	// called by mutators that were marked with UsesRename during registration.
+	RenameModule(module Module, name string)
+
 	// CreateModule creates a new module by calling the factory method for the specified moduleType, and applies
 	// the specified property structs to it as if the properties were set in a blueprint file.  May only
 	// be called by mutators that were marked with UsesCreateModule during registration.
 	CreateModule(ModuleFactory, string, ...interface{}) Module
+
+	// SplitData returns the data that TransitionMutatorWithData.SplitWithData attached to the
+	// variation of the module when called from TransitionMutator.Mutate, or nil otherwise.
+	SplitData() any
+
+	// SkipMutator prevents the named mutator, which must run after the current mutator, from being
+	// called on the current module or on any variants later created from it.  If the named mutator
+	// is a TransitionMutator the module is not split by it: none of its methods are called on the
+	// module, and dependencies on the module from any variant of another module use its single
+	// variant.  Other modules can still add dependencies on the module while the named mutator runs,
+	// including reverse dependencies that make the skipped module depend on them, since those are
+	// added by the mutator running on the other module.
+	SkipMutator(name string)
+
+	// RequireVariation marks the given module, which may be the current module or another module
+	// such as a dependency, as requiring the variation of the named TransitionMutator, which must
+	// run after the current mutator.  The marks are returned by BaseModuleContext.RequiredVariations
+	// when the TransitionMutator's Split is called on the module, allowing it to only split modules
+	// into a variation when something needs it.  Marks are copied to new variants of the module.
+	RequireVariation(module Module, mutator, variation string)
+
+	// CreateAliasVariation makes the variation from of the current TransitionMutator an alias for
+	// its variation to on all variants of the current module, so that dependencies added later that
+	// request from, for example with AddVariationDependencies, resolve to the variant with to instead
+	// without a separate variant being created.  VisitDirectDeps reports the aliased variant.  It
+	// must be called from TransitionMutator.Mutate of a mutator that was marked with
+	// TransitionMutatorHandle.UsesAliasVariations, from must not be a variation of the module and
+	// to must be one.
+	CreateAliasVariation(from, to string)
 }
 
 // A Mutator function is called for each Module, and can modify properties on the modules.
@@ -1120,6 +1585,24 @@ func (BaseDependencyTag) dependencyTag(DependencyTag) {
 
 var _ DependencyTag = BaseDependencyTag{}
 
+// NamedDependencyTag is a DependencyTag with a stable name that identifies it to tools outside of the build, for
+// example in the JSON module graph or when selecting dependencies with VisitDirectDepsWithTagName.  Tags that
+// cannot implement it can be given a name with Context.RegisterDependencyTag instead.
+type NamedDependencyTag interface {
+	DependencyTag
+
+	Name() string
+}
+
+// RequiredDependencyTag is a DependencyTag for a dependency that must be on an enabled module.  If
+// RequiresEnabledDependency returns true and the dependency is on a disabled module then PrepareBuildActions
+// reports an error for the depending module instead of calling its GenerateBuildActions.
+type RequiredDependencyTag interface {
+	DependencyTag
+
+	RequiresEnabledDependency() bool
+}
+
 func (mctx *mutatorContext) createVariationsWithTransition(variationNames []string, outgoingTransitions [][]string) []Module {
 	return mctx.createVariations(variationNames, chooseDepByIndexes(mctx.mutator.name, outgoingTransitions))
 }
@@ -1147,6 +1630,103 @@ func (mctx *mutatorContext) createVariations(variationNames []string, depChooser
 	return ret
 }
 
+func (mctx *mutatorContext) SkipMutator(name string) {
+	index := slices.IndexFunc(mctx.context.mutatorInfo, func(m *mutatorInfo) bool {
+		return m.skipName() == name
+	})
+	if index < 0 {
+		panic(fmt.Errorf("SkipMutator called with unknown mutator %q", name))
+	}
+	if index <= mctx.mutator.index {
+		panic(fmt.Errorf("SkipMutator called from mutator %q with mutator %q that has already run",
+			mctx.mutator.name, name))
+	}
+	if mctx.module.skippedMutators == nil {
+		mctx.module.skippedMutators = make(map[string]bool)
+	}
+	mctx.module.skippedMutators[name] = true
+}
+
+func (mctx *mutatorContext) RequireVariation(module Module, mutator, variation string) {
+	index := slices.IndexFunc(mctx.context.mutatorInfo, func(m *mutatorInfo) bool {
+		return m.propagatesTransitionMutator != nil && m.propagatesTransitionMutator.name == mutator
+	})
+	if index < 0 {
+		panic(fmt.Errorf("RequireVariation called with unknown transition mutator %q", mutator))
+	}
+	if index <= mctx.mutator.index {
+		panic(fmt.Errorf("RequireVariation called from mutator %q with transition mutator %q that has already run",
+			mctx.mutator.name, mutator))
+	}
+
+	m := mctx.context.moduleInfo[module]
+	if m == nil {
+		panic(fmt.Errorf("RequireVariation called with module %q that is not known to the Context", module.Name()))
+	}
+	m.requiredVariationsLock.Lock()
+	defer m.requiredVariationsLock.Unlock()
+
+	// The map may be shared with other variants of the module, copy it before modifying it.
+	marked := maps.Clone(m.markedVariations)
+	if marked == nil {
+		marked = make(map[string][]string)
+	}
+	marked[mutator] = addToStringListIfNotPresent(slices.Clone(marked[mutator]), variation)
+	m.markedVariations = marked
+}
+
+func (mctx *mutatorContext) CreateAliasVariation(from, to string) {
+	t := mctx.mutator.mutatesTransitionMutator
+	if t == nil {
+		panic(fmt.Errorf("CreateAliasVariation called from mutator %q that is not TransitionMutator.Mutate",
+			mctx.mutator.name))
+	}
+	if !t.usesAliasVariations {
+		panic(fmt.Errorf("method CreateAliasVariation called from transition mutator %q that was not marked UsesAliasVariations",
+			t.name))
+	}
+
+	group := mctx.module.group
+	hasVariation := func(variation string) bool {
+		return slices.ContainsFunc(group.modules, func(m *moduleInfo) bool {
+			return m.variant.variations.get(t.name) == variation
+		})
+	}
+	if from == "" || hasVariation(from) {
+		mctx.ModuleErrorf("cannot create alias %q, it is a variation of mutator %q", from, t.name)
+		return
+	}
+	if !hasVariation(to) {
+		mctx.ModuleErrorf("cannot create alias %q for missing variation %q of mutator %q", from, to, t.name)
+		return
+	}
+
+	group.variationAliasesLock.Lock()
+	defer group.variationAliasesLock.Unlock()
+
+	if existing, ok := group.variationAliases[t.name][from]; ok && existing != to {
+		mctx.ModuleErrorf("cannot create alias %q for variation %q of mutator %q, it is already an alias for %q",
+			from, to, t.name, existing)
+		return
+	}
+	if group.variationAliases == nil {
+		group.variationAliases = make(map[string]map[string]string)
+	}
+	if group.variationAliases[t.name] == nil {
+		group.variationAliases[t.name] = make(map[string]string)
+	}
+	group.variationAliases[t.name][from] = to
+}
+
+func (d *baseModuleContext) RequiredVariations() []string {
+	if d.splitMutator == nil {
+		return nil
+	}
+	d.module.requiredVariationsLock.Lock()
+	defer d.module.requiredVariationsLock.Unlock()
+	return slices.Sorted(slices.Values(d.module.markedVariations[d.splitMutator.name]))
+}
+
 func (mctx *mutatorContext) Module() Module {
 	return mctx.module.logicModule
 }
@@ -1155,7 +1735,7 @@ func (mctx *mutatorContext) AddDependency(module Module, tag DependencyTag, deps
 	depInfos := make([]Module, 0, len(deps))
 	for _, dep := range deps {
 		modInfo := mctx.context.moduleInfo[module]
-		depInfo, errs := mctx.context.addVariationDependency(modInfo, mctx.mutator, mctx.config, nil, tag, dep, false)
+		depInfo, errs := mctx.context.addVariationDependency(modInfo, mctx.mutator, mctx.config, nil, tag, dep, false, false)
 		if len(errs) > 0 {
 			mctx.errs = append(mctx.errs, errs...)
 		}
@@ -1188,9 +1768,14 @@ func (mctx *mutatorContext) AddReverseDependency(module Module, tag DependencyTa
 		return
 	}
 
+	if err := mctx.context.checkVisibility(destModule, mctx.context.moduleInfo[module]); err != nil {
+		mctx.errs = append(mctx.errs, err)
+		return
+	}
+
 	mctx.reverseDeps = append(mctx.reverseDeps, reverseDep{
 		destModule,
-		depInfo{mctx.context.moduleInfo[module], tag},
+		depInfo{mctx.context.moduleInfo[module], tag, nil},
 	})
 }
 
@@ -1214,18 +1799,70 @@ func (mctx *mutatorContext) AddReverseVariationDependency(variations []Variation
 		return
 	}
 
+	if err := mctx.context.checkVisibility(destModule, mctx.module); err != nil {
+		mctx.errs = append(mctx.errs, err)
+		return
+	}
+
 	mctx.reverseDeps = append(mctx.reverseDeps, reverseDep{
 		destModule,
-		depInfo{mctx.module, tag},
+		depInfo{mctx.module, tag, nil},
+	})
+}
+
+func (mctx *mutatorContext) AddReverseVariationDependencyCreatingVariant(variations []Variation, tag DependencyTag, destName string) {
+	if !mctx.mutator.usesReverseDependencies {
+		panic(fmt.Errorf("method AddReverseVariationDependencyCreatingVariant called from mutator that was not marked UsesReverseDependencies"))
+	}
+
+	if _, ok := tag.(BaseDependencyTag); ok {
+		panic("BaseDependencyTag is not allowed to be used directly!")
+	}
+
+	if destName == mctx.module.Name() {
+		mctx.errs = append(mctx.errs, &BlueprintError{
+			Err: fmt.Errorf("%q depends on itself", destName),
+			Pos: mctx.module.pos,
+		})
+		return
+	}
+
+	if err := mctx.context.checkReverseDependencyVariations(mctx.module, destName, variations); err != nil {
+		mctx.errs = append(mctx.errs, err)
+		return
+	}
+
+	mctx.reverseDepsCreatingVariant = append(mctx.reverseDepsCreatingVariant, reverseDepCreatingVariant{
+		destName:   destName,
+		variations: variations,
+		dep:        depInfo{mctx.module, tag, nil},
 	})
 }
 
 func (mctx *mutatorContext) AddVariationDependencies(variations []Variation, tag DependencyTag,
 	deps ...string) []Module {
 
+	return mctx.addVariationDependencies(variations, tag, false, deps)
+}
+
+func (mctx *mutatorContext) AddOptionalVariationDependencies(variations []Variation, tag DependencyTag,
+	deps ...string) []Module {
+
+	return mctx.addVariationDependencies(variations, tag, true, deps)
+}
+
+func (mctx *mutatorContext) AddVariationDependencyIfExists(variations []Variation, tag DependencyTag,
+	dep string) Module {
+
+	return mctx.addVariationDependencies(variations, tag, true, []string{dep})[0]
+}
+
+func (mctx *mutatorContext) addVariationDependencies(variations []Variation, tag DependencyTag, optional bool,
+	deps []string) []Module {
+
 	depInfos := make([]Module, 0, len(deps))
 	for _, dep := range deps {
-		depInfo, errs := mctx.context.addVariationDependency(mctx.module, mctx.mutator, mctx.config, variations, tag, dep, false)
+		depInfo, errs := mctx.context.addVariationDependency(mctx.module, mctx.mutator, mctx.config, variations, tag, dep, false, optional)
 		if len(errs) > 0 {
 			mctx.errs = append(mctx.errs, errs...)
 		}
@@ -1243,7 +1880,7 @@ func (mctx *mutatorContext) AddFarVariationDependencies(variations []Variation,
 
 	depInfos := make([]Module, 0, len(deps))
 	for _, dep := range deps {
-		depInfo, errs := mctx.context.addVariationDependency(mctx.module, mctx.mutator, mctx.config, variations, tag, dep, true)
+		depInfo, errs := mctx.context.addVariationDependency(mctx.module, mctx.mutator, mctx.config, variations, tag, dep, true, false)
 		if len(errs) > 0 {
 			mctx.errs = append(mctx.errs, errs...)
 		}
@@ -1256,6 +1893,25 @@ func (mctx *mutatorContext) AddFarVariationDependencies(variations []Variation,
 	return depInfos
 }
 
+func (mctx *mutatorContext) AddLateVariationDependencies(variations []Variation, tag DependencyTag,
+	deps ...string) {
+
+	if _, ok := tag.(BaseDependencyTag); ok {
+		panic("BaseDependencyTag is not allowed to be used directly!")
+	}
+	for _, dep := range deps {
+		mctx.module.lateDeps = append(mctx.module.lateDeps, lateDependency{
+			variations: slices.Clone(variations),
+			tag:        tag,
+			name:       dep,
+		})
+	}
+}
+
+func (mctx *mutatorContext) AddOrderOnlyDependency(names ...string) {
+	mctx.module.orderOnlyDepNames = append(mctx.module.orderOnlyDepNames, names...)
+}
+
 func (mctx *mutatorContext) ReplaceDependencies(name string) {
 	mctx.ReplaceDependenciesIf(name, nil)
 }
@@ -1282,11 +1938,30 @@ func (mctx *mutatorContext) ReplaceDependenciesIf(name string, predicate Replace
 	}
 }
 
+func (mctx *mutatorContext) SplitData() any {
+	return mctx.splitData
+}
+
 func (mctx *mutatorContext) Rename(name string) {
 	if !mctx.mutator.usesRename {
 		panic(fmt.Errorf("method Rename called from mutator that was not marked UsesRename"))
 	}
-	mctx.rename = append(mctx.rename, rename{mctx.module.group, name})
+	mctx.rename = append(mctx.rename, rename{mctx.module.group, name, false})
+}
+
+func (mctx *mutatorContext) RenameModule(module Module, name string) {
+	if !mctx.mutator.usesRename {
+		panic(fmt.Errorf("method RenameModule called from mutator that was not marked UsesRename"))
+	}
+	info := mctx.context.moduleInfo[module]
+	if info == nil {
+		mctx.errs = append(mctx.errs, &BlueprintError{
+			Err: fmt.Errorf("cannot rename unknown module to %q", name),
+			Pos: mctx.module.pos,
+		})
+		return
+	}
+	mctx.rename = append(mctx.rename, rename{info.group, name, true})
 }
 
 func (mctx *mutatorContext) CreateModule(factory ModuleFactory, typeName string, props ...interface{}) Module {
@@ -1347,8 +2022,96 @@ func (s *SimpleName) Name() string {
 	return s.Properties.Name
 }
 
+// SimpleEnabled is an embeddable object to implement EnableableModule using a property called "enabled" that
+// defaults to true.  Modules that embed it must also add SimpleEnabled.Properties to their property structure list.
+type SimpleEnabled struct {
+	Properties struct {
+		Enabled *bool
+	}
+}
+
+func (s *SimpleEnabled) Enabled() bool {
+	return proptools.BoolDefault(s.Properties.Enabled, true)
+}
+
 // Load Hooks
 
+// A PropertyValidator is a Module that checks the values of its properties as soon as they have been
+// unpacked from a Blueprints file, before any load hooks or mutators run.  The errors it reports are returned
+// by the parse together with the errors of every other module, so all the invalid properties in the tree are
+// found at once instead of in GenerateBuildActions.  It is not called for modules created by CreateModule.
+type PropertyValidator interface {
+	ValidateProperties(ctx ValidationContext)
+}
+
+// ValidationContext is passed to PropertyValidator.ValidateProperties.
+type ValidationContext interface {
+	// ModuleName returns the name of the module.
+	ModuleName() string
+
+	// ModuleType returns the name of the module type that was used to create the module, as specified in
+	// Context.RegisterModuleType().
+	ModuleType() string
+
+	// BlueprintsFile returns the name of the blueprint file that contains the definition of this
+	// module.
+	BlueprintsFile() string
+
+	// ContainsProperty returns true if the specified property name was set in the module definition.
+	ContainsProperty(name string) bool
+
+	// ModuleErrorf reports an error at the line number of the module type in the module definition.
+	ModuleErrorf(fmt string, args ...interface{})
+
+	// PropertyErrorf reports an error at the line number of a property in the module definition.
+	PropertyErrorf(property, fmt string, args ...interface{})
+
+	// Failed returns true if any errors have been reported.
+	Failed() bool
+}
+
+type validationContext struct {
+	module *moduleInfo
+	errs   []error
+}
+
+var _ ValidationContext = (*validationContext)(nil)
+
+func (v *validationContext) ModuleName() string {
+	return v.module.Name()
+}
+
+func (v *validationContext) ModuleType() string {
+	return v.module.typeName
+}
+
+func (v *validationContext) BlueprintsFile() string {
+	return v.module.relBlueprintsFile
+}
+
+func (v *validationContext) ContainsProperty(name string) bool {
+	_, ok := v.module.propertyPos[name]
+	return ok
+}
+
+func (v *validationContext) ModuleErrorf(format string, args ...interface{}) {
+	v.errs = append(v.errs, &ModuleError{
+		BlueprintError: BlueprintError{
+			Err: fmt.Errorf(format, args...),
+			Pos: v.module.pos,
+		},
+		module: v.module,
+	})
+}
+
+func (v *validationContext) PropertyErrorf(property, format string, args ...interface{}) {
+	v.errs = append(v.errs, propertyErrorf(v.module, property, format, args...))
+}
+
+func (v *validationContext) Failed() bool {
+	return len(v.errs) > 0
+}
+
 type LoadHookContext interface {
 	EarlyModuleContext
 
@@ -1518,7 +2281,7 @@ func CheckBlueprintSyntax(moduleFactories map[string]ModuleFactory, filename str
 	for _, def := range file.Defs {
 		switch def := def.(type) {
 		case *parser.Module:
-			_, moduleErrs := processModuleDef(def, filename, moduleFactories, nil, false)
+			_, _, moduleErrs := processModuleDef(def, filename, moduleFactories, nil, false)
 			errs = append(errs, moduleErrs...)
 
 		default:
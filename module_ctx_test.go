@@ -168,6 +168,32 @@ func TestAddVariationDependencies(t *testing.T) {
 		}
 	})
 
+	t.Run("optional", func(t *testing.T) {
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newModuleCtxTestModule)
+		var results []Module
+		ctx.RegisterBottomUpMutator("deps", func(ctx BottomUpMutatorContext) {
+			if ctx.ModuleName() == "foo" {
+				results = ctx.AddOptionalVariationDependencies(nil, nil, "baz", "bar")
+			}
+		})
+		run(ctx)
+
+		foo := ctx.moduleGroupFromName("foo", nil).moduleByVariantName("")
+		bar := ctx.moduleGroupFromName("bar", nil).moduleByVariantName("")
+
+		if g, w := foo.forwardDeps, []*moduleInfo{bar}; !reflect.DeepEqual(g, w) {
+			t.Fatalf("expected foo deps to be %q, got %q", w, g)
+		}
+
+		if g, w := results, []Module{nil, bar.logicModule}; !reflect.DeepEqual(g, w) {
+			t.Fatalf("expected AddOptionalVariationDependencies return value to be %q, got %q", w, g)
+		}
+
+		if g := foo.missingDeps; len(g) > 0 {
+			t.Fatalf("expected no missing deps, got %q", g)
+		}
+	})
 }
 
 func TestCheckBlueprintSyntax(t *testing.T) {
@@ -258,6 +284,103 @@ func (m *addNinjaDepsTestModule) GenerateBuildActions(ctx ModuleContext) {
 	ctx.AddNinjaFileDeps("GenerateBuildActions")
 }
 
+type validatedTestModule struct {
+	SimpleName
+	properties struct {
+		Link     string
+		Stl      string
+		Sanitize struct {
+			Kinds []string
+		}
+	}
+}
+
+func newValidatedTestModule() (Module, []interface{}) {
+	m := &validatedTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *validatedTestModule) ValidateProperties(ctx ValidationContext) {
+	if !ctx.ContainsProperty("link") {
+		ctx.ModuleErrorf("link must be set")
+	} else if m.properties.Link != "static" && m.properties.Link != "shared" {
+		ctx.PropertyErrorf("link", "must be static or shared, got %q", m.properties.Link)
+	}
+	if m.properties.Stl != "" && m.properties.Stl != "none" {
+		ctx.PropertyErrorf("stl", "must be none, got %q", m.properties.Stl)
+	}
+	for _, kind := range m.properties.Sanitize.Kinds {
+		if kind != "address" {
+			ctx.PropertyErrorf("sanitize.kinds", "unknown sanitizer %q", kind)
+		}
+	}
+}
+
+func (m *validatedTestModule) GenerateBuildActions(ModuleContext) {}
+
+func TestPropertyValidator(t *testing.T) {
+	factories := map[string]ModuleFactory{
+		"test": newValidatedTestModule,
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		errs := CheckBlueprintSyntax(factories, "path/Blueprint", `
+test {
+	name: "test",
+	link: "static",
+	sanitize: {
+		kinds: ["address"],
+	},
+}
+`)
+		expectedErrors(t, errs)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		errs := CheckBlueprintSyntax(factories, "path/Blueprint", `
+test {
+	name: "a",
+	link: "dynamic",
+	stl: "libc++",
+}
+
+test {
+	name: "b",
+	sanitize: {
+		kinds: ["address", "thread"],
+	},
+}
+`)
+		expectedErrors(t, errs,
+			`path/Blueprint:4:6: module "a": link: must be static or shared, got "dynamic"`,
+			`path/Blueprint:5:5: module "a": stl: must be none, got "libc++"`,
+			`path/Blueprint:8:1: module "b": link must be set`,
+			`path/Blueprint:11:8: module "b": sanitize.kinds: unknown sanitizer "thread"`,
+		)
+	})
+
+	t.Run("before mutators", func(t *testing.T) {
+		ctx := NewContext()
+		ctx.RegisterModuleType("test", newValidatedTestModule)
+		ctx.RegisterBottomUpMutator("mutator", func(ctx BottomUpMutatorContext) {
+			t.Errorf("unexpected mutator call on %s", ctx.ModuleName())
+		})
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				test {
+					name: "a",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		if len(errs) == 0 {
+			_, errs = ctx.ResolveDependencies(nil)
+		}
+		expectedErrors(t, errs, `Android.bp:2:5: module "a": link must be set`)
+	})
+}
+
 func addNinjaDepsTestBottomUpMutator(ctx BottomUpMutatorContext) {
 	ctx.AddNinjaFileDeps("BottomUpMutator")
 }
@@ -330,3 +453,222 @@ func TestAddNinjaFileDeps(t *testing.T) {
 	}
 
 }
+
+type outputFilesTestModule struct {
+	SimpleName
+	properties struct {
+		Deps    []string
+		Outputs []string
+		Others  []string
+	}
+	depOutputs []string
+	errs       []string
+}
+
+func newOutputFilesTestModule() (Module, []interface{}) {
+	m := &outputFilesTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *outputFilesTestModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.VisitDirectDeps(func(dep Module) {
+		files, err := ctx.OutputFiles(dep, "")
+		if err != nil {
+			m.errs = append(m.errs, err.Error())
+		}
+		m.depOutputs = append(m.depOutputs, files...)
+	})
+	for _, name := range m.properties.Others {
+		other, _ := ctx.ModuleFromName(name)
+		if _, err := ctx.OutputFiles(other, ""); err != nil {
+			m.errs = append(m.errs, err.Error())
+		}
+	}
+	if len(m.properties.Outputs) > 0 {
+		ctx.AddOutputFiles("", m.properties.Outputs...)
+		ctx.AddOutputFiles("all", m.properties.Outputs...)
+		ctx.AddOutputFiles("all", m.depOutputs...)
+	}
+}
+
+func outputFilesTestDepsMutator(ctx BottomUpMutatorContext) {
+	if m, ok := ctx.Module().(*outputFilesTestModule); ok {
+		ctx.AddDependency(m, nil, m.properties.Deps...)
+	}
+}
+
+type outputFilesTestSingleton struct {
+	outputs []string
+	errs    []string
+}
+
+func (s *outputFilesTestSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.VisitAllModules(func(m Module) {
+		files, err := ctx.OutputFiles(m, "all")
+		if err != nil {
+			s.errs = append(s.errs, err.Error())
+		}
+		s.outputs = append(s.outputs, files...)
+	})
+}
+
+func TestOutputFiles(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test {
+				name: "A",
+				deps: ["B", "C"],
+				outputs: ["a.out"],
+			}
+
+			test {
+				name: "B",
+				outputs: ["b1.out", "b2.out"],
+			}
+
+			test {
+				name: "C",
+			}
+		`),
+	})
+
+	singleton := &outputFilesTestSingleton{}
+	ctx.RegisterModuleType("test", newOutputFilesTestModule)
+	ctx.RegisterBottomUpMutator("deps", outputFilesTestDepsMutator)
+	ctx.RegisterSingletonType("test", func() Singleton { return singleton }, false)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	a := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule
+	if _, err := ctx.ModuleOutputFiles(a, ""); err == nil {
+		t.Errorf("expected error for output files requested before GenerateBuildActions")
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	assertNoErrors(t, errs)
+
+	m := a.(*outputFilesTestModule)
+	if g, w := m.depOutputs, []string{"b1.out", "b2.out"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected outputs of dependencies %q, got %q", w, g)
+	}
+	if g, w := m.errs, []string{`module "C" has no output files with tag ""`}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected errors %q, got %q", w, g)
+	}
+
+	if g, w := singleton.outputs, []string{"a.out", "b1.out", "b2.out", "b1.out", "b2.out"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected outputs in singleton %q, got %q", w, g)
+	}
+	if g, w := singleton.errs, []string{`module "C" has no output files with tag "all"`}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected errors in singleton %q, got %q", w, g)
+	}
+}
+
+func TestOutputFilesOfModuleNotGeneratedYet(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test {
+				name: "A",
+				others: ["B"],
+			}
+
+			test {
+				name: "B",
+				outputs: ["b.out"],
+			}
+		`),
+	})
+	ctx.RegisterModuleType("test", newOutputFilesTestModule)
+	ctx.SetModuleVisitOrder(ModuleVisitOrderAlphabetical)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.PrepareBuildActions(nil)
+	assertNoErrors(t, errs)
+
+	a := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule.(*outputFilesTestModule)
+	if g, w := a.errs, []string{`output files of module "B" requested before its build actions were generated`}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected errors %q, got %q", w, g)
+	}
+}
+
+type reverseDepsTestModule struct {
+	SimpleName
+	properties struct {
+		Deps []string
+	}
+	reverseDeps []string
+}
+
+func newReverseDepsTestModule() (Module, []interface{}) {
+	m := &reverseDepsTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+}
+
+func (m *reverseDepsTestModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.VisitDirectReverseDeps(func(dep Module) {
+		m.reverseDeps = append(m.reverseDeps, ctx.OtherModuleName(dep))
+	})
+}
+
+func reverseDepsTestDepsMutator(ctx BottomUpMutatorContext) {
+	if m, ok := ctx.Module().(*reverseDepsTestModule); ok {
+		ctx.AddDependency(m, nil, m.properties.Deps...)
+	}
+}
+
+func TestVisitDirectReverseDepsFromModuleContext(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			test {
+				name: "A",
+				deps: ["C"],
+			}
+
+			test {
+				name: "B",
+				deps: ["C", "C"],
+			}
+
+			test {
+				name: "C",
+				deps: ["D"],
+			}
+
+			test {
+				name: "D",
+			}
+		`),
+	})
+	ctx.RegisterModuleType("test", newReverseDepsTestModule)
+	ctx.RegisterBottomUpMutator("deps", reverseDepsTestDepsMutator)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.PrepareBuildActions(nil)
+	assertNoErrors(t, errs)
+
+	reverseDeps := func(name string) []string {
+		return ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule.(*reverseDepsTestModule).reverseDeps
+	}
+	for _, tc := range []struct {
+		name string
+		want []string
+	}{
+		{"A", nil},
+		{"B", nil},
+		{"C", []string{"A", "B", "B"}},
+		{"D", []string{"C"}},
+	} {
+		if g := reverseDeps(tc.name); !reflect.DeepEqual(g, tc.want) {
+			t.Errorf("expected reverse dependencies of %s to be %q, got %q", tc.name, tc.want, g)
+		}
+	}
+}
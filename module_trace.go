@@ -0,0 +1,158 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// A ModuleTraceEvent is a single entry in the event log of a ModuleTrace.
+type ModuleTraceEvent struct {
+	// Phase is "parse", the name of the mutator that was running, or "generate".
+	Phase string
+
+	// Variant is the name of the variant of the traced module that the event applies to.
+	Variant string
+
+	// Message is a human readable description of the event.
+	Message string
+}
+
+func (e ModuleTraceEvent) String() string {
+	if e.Variant == "" {
+		return fmt.Sprintf("[%s] %s", e.Phase, e.Message)
+	}
+	return fmt.Sprintf("[%s] (%s) %s", e.Phase, e.Variant, e.Message)
+}
+
+// A ModuleTrace records every event touching a single module, see Context.TraceModule.
+type ModuleTrace struct {
+	name string
+
+	lock   sync.Mutex
+	events []ModuleTraceEvent
+}
+
+// Events returns the events recorded so far, in the order in which they happened.  Events
+// recorded for different variants of the module while a mutator was visiting them in parallel are
+// ordered by the time they were recorded.
+func (t *ModuleTrace) Events() []ModuleTraceEvent {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return slices.Clone(t.events)
+}
+
+func (t *ModuleTrace) add(event ModuleTraceEvent) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.events = append(t.events, event)
+}
+
+// TraceModule starts recording every event that touches the module with the given name: parsing,
+// each mutator that visits it, the variants it is split into, the variations requested by
+// transition mutators, the dependencies that are added to or from it and the generation of its
+// build actions.  It must be called before the Blueprints files are parsed, and returns the
+// ModuleTrace that the events are recorded into.  Calling it again with the same name returns the
+// same ModuleTrace.
+//
+// Tracing is intended for debugging, modules that are not traced pay only the cost of a map
+// lookup.
+func (c *Context) TraceModule(name string) *ModuleTrace {
+	if c.moduleTraces == nil {
+		c.moduleTraces = make(map[string]*ModuleTrace)
+	}
+	if trace, ok := c.moduleTraces[name]; ok {
+		return trace
+	}
+	trace := &ModuleTrace{name: name}
+	c.moduleTraces[name] = trace
+	return trace
+}
+
+// traceModule records an event in the trace for module if it is being traced.
+func (c *Context) traceModule(module *moduleInfo, phase string, format string, args ...interface{}) {
+	if c.moduleTraces == nil {
+		return
+	}
+	if trace, ok := c.moduleTraces[module.Name()]; ok {
+		trace.add(ModuleTraceEvent{
+			Phase:   phase,
+			Variant: module.variant.name,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+}
+
+// traceDirectDeps records the current direct dependencies of module if it is being traced.
+func (c *Context) traceDirectDeps(module *moduleInfo, phase string) {
+	if c.moduleTraces == nil {
+		return
+	}
+	if _, ok := c.moduleTraces[module.Name()]; !ok {
+		return
+	}
+	deps := make([]string, 0, len(module.directDeps))
+	for _, dep := range module.directDeps {
+		deps = append(deps, dep.module.String())
+	}
+	c.traceModule(module, phase, "direct dependencies are %s", deps)
+}
+
+// traceDependency records the dependency edge from module to dep in the traces of both modules.
+func (c *Context) traceDependency(module, dep *moduleInfo, phase string, kind string, tag DependencyTag) {
+	if c.moduleTraces == nil {
+		return
+	}
+	c.traceModule(module, phase, "added %s on %s with tag %T", kind, dep, tag)
+	if dep.Name() != module.Name() {
+		c.traceModule(dep, phase, "%s added from %s with tag %T", kind, module, tag)
+	}
+}
+
+// A Phase describes whether a mutator trace hook set with SetMutatorTraceHook is being called
+// before or after a mutator visits a module.
+type Phase int
+
+const (
+	// PhaseStart is passed to the mutator trace hook before the mutator visits a module.
+	PhaseStart Phase = iota
+	// PhaseEnd is passed to the mutator trace hook after the mutator visits a module.
+	PhaseEnd
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseStart:
+		return "start"
+	case PhaseEnd:
+		return "end"
+	default:
+		return fmt.Sprintf("Phase(%d)", int(p))
+	}
+}
+
+// SetMutatorTraceHook sets a function that is called with PhaseStart immediately before each
+// mutator visits each module, and with PhaseEnd immediately after, on the goroutine that runs the
+// mutator.  It is called for bottom up and top down mutators and for each of the mutators that
+// implement a TransitionMutator, which are named after the TransitionMutator with "_propagate"
+// and "_mutate" suffixes for the passes that call Split and OutgoingTransition and that call
+// Mutate.  Modules are visited in parallel, so the hook must be safe to call concurrently.  It is
+// not called for modules that a mutator skips, and it is not called with PhaseEnd if the mutator
+// panics.  It must be called before ResolveDependencies.
+func (c *Context) SetMutatorTraceHook(hook func(mutatorName string, m Module, phase Phase)) {
+	c.mutatorTraceHook = hook
+}
@@ -15,15 +15,18 @@
 package blueprint
 
 import (
+	"cmp"
 	"fmt"
-	"sort"
+	"path/filepath"
+	"slices"
 	"strings"
 )
 
 // This file exposes the logic of locating a module via a query string, to enable
 // other projects to override it if desired.
 // The default name resolution implementation, SimpleNameInterface,
-// just treats the query string as a module name, and does a simple map lookup.
+// just treats the query string as a module name, and does a simple map lookup
+// in the namespace of the module and the namespaces it imports.
 
 // A ModuleGroup just points to a moduleGroup to allow external packages to refer
 // to a moduleGroup but not use it
@@ -108,10 +111,23 @@ type SkippedModuleInfo struct {
 	reason   string
 }
 
-// a SimpleNameInterface just stores all modules in a map based on name
+// a SimpleNameInterface just stores all modules in a map based on name.  Modules defined in
+// Blueprints files in a directory registered with RegisterNamespace are stored in a separate map
+// for that namespace instead, so they may use the same names as modules in other namespaces.
 type SimpleNameInterface struct {
 	modules        map[string]ModuleGroup
 	skippedModules map[string][]SkippedModuleInfo
+
+	// namespaces holds the namespaces registered by RegisterNamespace, indexed by directory.
+	namespaces map[string]*simpleNamespace
+}
+
+// simpleNamespace is a Namespace registered with SimpleNameInterface.RegisterNamespace.
+type simpleNamespace struct {
+	NamespaceMarker
+	dir     string
+	imports []*simpleNamespace
+	modules map[string]ModuleGroup
 }
 
 func NewSimpleNameInterface() *SimpleNameInterface {
@@ -121,9 +137,102 @@ func NewSimpleNameInterface() *SimpleNameInterface {
 	}
 }
 
+// RegisterNamespace makes the modules defined in Blueprints files in dir and its subdirectories,
+// other than those in the directories of other namespaces, part of a namespace.  Names of modules
+// in a namespace only need to be unique within the namespace.  A dependency of a module in a
+// namespace is looked up in the namespace, then in each of the namespaces in imports in order, and
+// then in the modules that are not in any namespace.  Modules that are not in any namespace can only
+// depend on other modules that are not in any namespace.  A module in any namespace can be referred
+// to from anywhere with the fully qualified name "//dir:name".  The namespaces in imports must have
+// already been registered.
+func (s *SimpleNameInterface) RegisterNamespace(dir string, imports []string) error {
+	dir = filepath.Clean(dir)
+	if dir == "." || !filepath.IsLocal(dir) {
+		return fmt.Errorf("invalid namespace directory %q", dir)
+	}
+	if _, exists := s.namespaces[dir]; exists {
+		return fmt.Errorf("namespace %q already registered", dir)
+	}
+	namespace := &simpleNamespace{
+		dir:     dir,
+		modules: make(map[string]ModuleGroup),
+	}
+	for _, imported := range imports {
+		importedNamespace, exists := s.namespaces[filepath.Clean(imported)]
+		if !exists {
+			return fmt.Errorf("namespace %q imports unknown namespace %q", dir, imported)
+		}
+		namespace.imports = append(namespace.imports, importedNamespace)
+	}
+	if s.namespaces == nil {
+		s.namespaces = make(map[string]*simpleNamespace)
+	}
+	s.namespaces[dir] = namespace
+	return nil
+}
+
+// newWithSameNamespaces returns a new SimpleNameInterface with no modules and the same registered
+// namespaces as s.
+func (s *SimpleNameInterface) newWithSameNamespaces() *SimpleNameInterface {
+	ret := NewSimpleNameInterface()
+	dirs := make([]string, 0, len(s.namespaces))
+	for dir := range s.namespaces {
+		dirs = append(dirs, dir)
+	}
+	// Register each namespace after the namespaces it imports.
+	for len(dirs) > 0 {
+		remaining := dirs[:0]
+		for _, dir := range dirs {
+			namespace := s.namespaces[dir]
+			if slices.ContainsFunc(namespace.imports, func(imported *simpleNamespace) bool {
+				return ret.namespaces[imported.dir] == nil
+			}) {
+				remaining = append(remaining, dir)
+				continue
+			}
+			var imports []string
+			for _, imported := range namespace.imports {
+				imports = append(imports, imported.dir)
+			}
+			if err := ret.RegisterNamespace(dir, imports); err != nil {
+				panic(err)
+			}
+		}
+		dirs = remaining
+	}
+	return ret
+}
+
+// namespaceForPath returns the namespace registered for the directory containing path or the closest
+// of its parent directories, or nil if there is none.
+func (s *SimpleNameInterface) namespaceForPath(path string) *simpleNamespace {
+	if len(s.namespaces) == 0 {
+		return nil
+	}
+	for dir := filepath.Dir(path); dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+		if namespace, ok := s.namespaces[dir]; ok {
+			return namespace
+		}
+	}
+	return nil
+}
+
+// modulesIn returns the map of module names to modules for namespace.
+func (s *SimpleNameInterface) modulesIn(namespace Namespace) map[string]ModuleGroup {
+	if ns, ok := namespace.(*simpleNamespace); ok && ns != nil {
+		return ns.modules
+	}
+	return s.modules
+}
+
 func (s *SimpleNameInterface) NewModule(ctx NamespaceContext, group ModuleGroup, module Module) (namespace Namespace, err []error) {
 	name := group.name
-	if group, present := s.modules[name]; present {
+	modules := s.modules
+	if ns := s.namespaceForPath(ctx.ModulePath()); ns != nil {
+		namespace = ns
+		modules = ns.modules
+	}
+	if group, present := modules[name]; present {
 		return nil, []error{
 			// seven characters at the start of the second line to align with the string "error: "
 			fmt.Errorf("module %q already defined\n"+
@@ -131,9 +240,9 @@ func (s *SimpleNameInterface) NewModule(ctx NamespaceContext, group ModuleGroup,
 		}
 	}
 
-	s.modules[name] = group
+	modules[name] = group
 
-	return nil, []error{}
+	return namespace, []error{}
 }
 
 func (s *SimpleNameInterface) NewSkippedModule(ctx NamespaceContext, name string, info SkippedModuleInfo) {
@@ -144,6 +253,31 @@ func (s *SimpleNameInterface) NewSkippedModule(ctx NamespaceContext, name string
 }
 
 func (s *SimpleNameInterface) ModuleFromName(moduleName string, namespace Namespace) (group ModuleGroup, found bool) {
+	if strings.HasPrefix(moduleName, "//") {
+		dir, name, ok := strings.Cut(strings.TrimPrefix(moduleName, "//"), ":")
+		if !ok {
+			return ModuleGroup{}, false
+		}
+		if dir == "" {
+			group, found = s.modules[name]
+			return group, found
+		}
+		if ns, ok := s.namespaces[dir]; ok {
+			group, found = ns.modules[name]
+		}
+		return group, found
+	}
+
+	if ns, ok := namespace.(*simpleNamespace); ok && ns != nil {
+		if group, found = ns.modules[moduleName]; found {
+			return group, found
+		}
+		for _, imported := range ns.imports {
+			if group, found = imported.modules[moduleName]; found {
+				return group, found
+			}
+		}
+	}
 	group, found = s.modules[moduleName]
 	return group, found
 }
@@ -154,7 +288,8 @@ func (s *SimpleNameInterface) SkippedModuleFromName(moduleName string, namespace
 }
 
 func (s *SimpleNameInterface) Rename(oldName string, newName string, namespace Namespace) (errs []error) {
-	existingGroup, exists := s.modules[newName]
+	modules := s.modulesIn(namespace)
+	existingGroup, exists := modules[newName]
 	if exists {
 		return []error{
 			// seven characters at the start of the second line to align with the string "error: "
@@ -164,12 +299,12 @@ func (s *SimpleNameInterface) Rename(oldName string, newName string, namespace N
 		}
 	}
 
-	group, exists := s.modules[oldName]
+	group, exists := modules[oldName]
 	if !exists {
 		return []error{fmt.Errorf("module %q to renamed to %q doesn't exist", oldName, newName)}
 	}
-	s.modules[newName] = group
-	delete(s.modules, group.name)
+	modules[newName] = group
+	delete(modules, group.name)
 	group.name = newName
 	return nil
 }
@@ -179,15 +314,27 @@ func (s *SimpleNameInterface) AllModules() []ModuleGroup {
 	for _, group := range s.modules {
 		groups = append(groups, group)
 	}
+	for _, namespace := range s.namespaces {
+		for _, group := range namespace.modules {
+			groups = append(groups, group)
+		}
+	}
 
-	duplicateName := ""
-	less := func(i, j int) bool {
-		if groups[i].name == groups[j].name {
-			duplicateName = groups[i].name
+	namespaceDir := func(group ModuleGroup) string {
+		if ns, ok := group.namespace.(*simpleNamespace); ok && ns != nil {
+			return ns.dir
 		}
-		return groups[i].name < groups[j].name
+		return ""
 	}
-	sort.Slice(groups, less)
+
+	duplicateName := ""
+	slices.SortFunc(groups, func(a, b ModuleGroup) int {
+		c := cmp.Or(cmp.Compare(a.name, b.name), cmp.Compare(namespaceDir(a), namespaceDir(b)))
+		if c == 0 {
+			duplicateName = a.name
+		}
+		return c
+	})
 	if duplicateName != "" {
 		// It is permitted to have two moduleGroup's with the same name, but not within the same
 		// Namespace. The SimpleNameInterface should catch this in NewModule, however, so this
@@ -224,9 +371,15 @@ func (s *SimpleNameInterface) MissingDependencyError(depender string, dependerNa
 }
 
 func (s *SimpleNameInterface) GetNamespace(ctx NamespaceContext) Namespace {
+	if ns := s.namespaceForPath(ctx.ModulePath()); ns != nil {
+		return ns
+	}
 	return nil
 }
 
 func (s *SimpleNameInterface) UniqueName(ctx NamespaceContext, name string) (unique string) {
+	if ns := s.namespaceForPath(ctx.ModulePath()); ns != nil {
+		return "//" + ns.dir + ":" + name
+	}
 	return name
 }
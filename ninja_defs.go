@@ -17,6 +17,7 @@ package blueprint
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -45,6 +46,10 @@ func (d Deps) String() string {
 	}
 }
 
+func (d Deps) valid() bool {
+	return d >= DepsNone && d <= DepsMSVC
+}
+
 // A PoolParams object contains the set of parameters that make up a Ninja pool
 // definition.
 type PoolParams struct {
@@ -82,14 +87,16 @@ type BuildParams struct {
 	Deps            Deps              // The format of the dependency file.
 	Description     string            // The description that Ninja will print for the build.
 	Rule            Rule              // The rule to invoke.
-	Outputs         []string          // The list of explicit output targets.
-	ImplicitOutputs []string          // The list of implicit output targets.
-	Inputs          []string          // The list of explicit input dependencies.
-	Implicits       []string          // The list of implicit input dependencies.
-	OrderOnly       []string          // The list of order-only dependencies.
+	Outputs         []string          // The list of explicit output targets, expanded into $out.
+	ImplicitOutputs []string          // The list of implicit output targets, written after "|".
+	Inputs          []string          // The list of explicit input dependencies, expanded into $in.
+	Implicits       []string          // The list of implicit input dependencies, written after "|".
+	OrderOnly       []string          // The list of order-only dependencies, written after "||".
 	Validations     []string          // The list of validations to run when this rule runs.
 	Args            map[string]string // The variable/value pairs to set.
 	Optional        bool              // Skip outputting a default statement
+	Restat          bool              // Whether Ninja should re-stat the build's outputs.
+	Pool            Pool              // The Ninja pool to run the build in instead of the rule's pool.
 }
 
 // A poolDef describes a pool definition.  It does not include the name of the
@@ -168,7 +175,9 @@ func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
 		r.Variables["depfile"] = value
 	}
 
-	if params.Deps != DepsNone {
+	if !params.Deps.valid() {
+		return nil, fmt.Errorf("invalid Deps param %d", params.Deps)
+	} else if params.Deps != DepsNone {
 		r.Variables["deps"] = simpleNinjaString(params.Deps.String())
 	}
 
@@ -267,6 +276,7 @@ type buildDef struct {
 	Args                  map[Variable]*ninjaString
 	Variables             map[string]*ninjaString
 	Optional              bool
+	Pool                  Pool
 }
 
 func formatTags(tags map[string]string, rule Rule) string {
@@ -308,6 +318,13 @@ func parseBuildParams(scope scope, params *BuildParams,
 		return nil, fmt.Errorf("Rule %s is not visible in this scope", rule)
 	}
 
+	if params.Pool != nil {
+		if !scope.IsPoolVisible(params.Pool) {
+			return nil, fmt.Errorf("Pool %s is not visible in this scope", params.Pool)
+		}
+		b.Pool = params.Pool
+	}
+
 	if len(params.Outputs) == 0 {
 		return nil, errors.New("Outputs param has no elements")
 	}
@@ -343,6 +360,21 @@ func parseBuildParams(scope scope, params *BuildParams,
 		return nil, fmt.Errorf("error parsing Validations param: %s", err)
 	}
 
+	err = checkPathCategories(
+		pathCategory{"Outputs", params.Outputs},
+		pathCategory{"ImplicitOutputs", params.ImplicitOutputs})
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkPathCategories(
+		pathCategory{"Inputs", params.Inputs},
+		pathCategory{"Implicits", params.Implicits},
+		pathCategory{"OrderOnly", params.OrderOnly})
+	if err != nil {
+		return nil, err
+	}
+
 	b.Optional = params.Optional
 
 	if params.Depfile != "" {
@@ -353,10 +385,16 @@ func parseBuildParams(scope scope, params *BuildParams,
 		setVariable("depfile", value)
 	}
 
-	if params.Deps != DepsNone {
+	if !params.Deps.valid() {
+		return nil, fmt.Errorf("invalid Deps param %d", params.Deps)
+	} else if params.Deps != DepsNone {
 		setVariable("deps", simpleNinjaString(params.Deps.String()))
 	}
 
+	if params.Restat {
+		setVariable("restat", simpleNinjaString("true"))
+	}
+
 	if params.Description != "" {
 		value, err := parseNinjaString(scope, params.Description)
 		if err != nil {
@@ -397,6 +435,76 @@ func parseBuildParams(scope scope, params *BuildParams,
 	return b, nil
 }
 
+// pathCategory is a named list of paths in a BuildParams, see checkPathCategories.
+type pathCategory struct {
+	name  string
+	paths []string
+}
+
+// checkPathCategories returns an error if a path is listed in more than one of the categories.  It
+// is used to reject a path that is both an explicit and an implicit output, which would make it
+// ambiguous whether it is in $out, and a path that is in more than one of the Inputs, Implicits and
+// OrderOnly params, which would make it ambiguous whether a change to the path should rebuild the
+// outputs and whether it is in $in.
+func checkPathCategories(categories ...pathCategory) error {
+	seen := make(map[string]string)
+	for _, category := range categories {
+		for _, path := range category.paths {
+			if prev, ok := seen[path]; ok && prev != category.name {
+				return fmt.Errorf("%q is in both the %s and %s params", path, prev, category.name)
+			}
+			seen[path] = category.name
+		}
+	}
+	return nil
+}
+
+// checkDepfile returns an error if the build statement uses deps = gcc without a depfile, which
+// ninja rejects.  Each variable may be set either on the build statement or on its rule.
+func (b *buildDef) checkDepfile(ruleDef *ruleDef) error {
+	lookup := func(name string) *ninjaString {
+		if value, ok := b.Variables[name]; ok {
+			return value
+		}
+		if ruleDef != nil {
+			return ruleDef.Variables[name]
+		}
+		return nil
+	}
+
+	if deps := lookup("deps"); deps != nil && deps.str == DepsGCC.String() && lookup("depfile") == nil {
+		return fmt.Errorf("build statement for %q using rule %s has deps = gcc but no depfile",
+			b.OutputStrings, b.Rule)
+	}
+	return nil
+}
+
+// checkRuleArgs returns an error if the rule's command references an argument of the rule that
+// the build statement doesn't set, which ninja would silently expand to an empty string.  The
+// Ninja built-ins in builtinRuleArgs are always set by ninja.  It is only called if
+// SetCheckRuleArgs was called.
+func (b *buildDef) checkRuleArgs(ruleDef *ruleDef) error {
+	if ruleDef == nil || ruleDef.Variables["command"] == nil {
+		return nil
+	}
+
+	for _, v := range ruleDef.Variables["command"].Variables() {
+		arg, ok := v.(*argVariable)
+		if !ok || slices.Contains(builtinRuleArgs, arg.name()) {
+			continue
+		}
+		if _, ok := b.Args[arg]; ok {
+			continue
+		}
+		if _, ok := b.Variables[arg.name()]; ok {
+			continue
+		}
+		return fmt.Errorf("build statement for %q using rule %s doesn't set argument %q used in its command",
+			b.OutputStrings, b.Rule, arg.name())
+	}
+	return nil
+}
+
 func (b *buildDef) WriteTo(nw *ninjaWriter, nameTracker *nameTracker) error {
 	var (
 		comment             = b.Comment
@@ -428,6 +536,13 @@ func (b *buildDef) WriteTo(nw *ninjaWriter, nameTracker *nameTracker) error {
 		return err
 	}
 
+	if b.Pool != nil {
+		err = nw.ScopedAssign("pool", nameTracker.Pool(b.Pool))
+		if err != nil {
+			return err
+		}
+	}
+
 	err = writeVariables(nw, b.Variables, nameTracker)
 	if err != nil {
 		return err
@@ -16,6 +16,7 @@ package blueprint
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -128,6 +129,69 @@ build foo.o: r foo.in
     _arg = arg value
 `,
 	},
+	{
+		input: func(w *ninjaWriter) {
+			def, err := parseBuildParams(newScope(nil), &BuildParams{
+				Rule:     Phony,
+				Outputs:  []string{"foo.o"},
+				Inputs:   []string{"foo.c"},
+				Depfile:  "foo.d",
+				Deps:     DepsGCC,
+				Restat:   true,
+				Optional: true,
+			}, nil)
+			ck(err)
+			ck(def.WriteTo(w, &nameTracker{}))
+		},
+		output: `build foo.o: phony foo.c
+    depfile = foo.d
+    deps = gcc
+    restat = true
+
+`,
+	},
+	{
+		input: func(w *ninjaWriter) {
+			def, err := parseBuildParams(newScope(nil), &BuildParams{
+				Rule:      Phony,
+				Outputs:   []string{"foo.o"},
+				Inputs:    []string{"foo.c"},
+				Implicits: []string{"foo.h", "bar.h"},
+				OrderOnly: []string{"gen_headers"},
+				Optional:  true,
+			}, nil)
+			ck(err)
+			ck(def.WriteTo(w, &nameTracker{}))
+		},
+		output: "build foo.o: phony foo.c | foo.h bar.h || gen_headers\n\n",
+	},
+	{
+		input: func(w *ninjaWriter) {
+			def, err := parseBuildParams(newScope(nil), &BuildParams{
+				Rule:      Phony,
+				Outputs:   []string{"foo.o"},
+				OrderOnly: []string{"gen_headers"},
+				Optional:  true,
+			}, nil)
+			ck(err)
+			ck(def.WriteTo(w, &nameTracker{}))
+		},
+		output: "build foo.o: phony || gen_headers\n\n",
+	},
+	{
+		input: func(w *ninjaWriter) {
+			def, err := parseBuildParams(newScope(nil), &BuildParams{
+				Rule:            Phony,
+				Outputs:         []string{"foo.pb.cc", "foo.pb.h"},
+				ImplicitOutputs: []string{"foo.pb.d"},
+				Inputs:          []string{"foo.proto"},
+				Optional:        true,
+			}, nil)
+			ck(err)
+			ck(def.WriteTo(w, &nameTracker{}))
+		},
+		output: "build foo.pb.cc foo.pb.h | foo.pb.d: phony foo.proto\n\n",
+	},
 }
 
 func TestNinjaWriter(t *testing.T) {
@@ -147,3 +211,110 @@ func testNinjaStrings(s ...string) []*ninjaString {
 	ret, _ := parseNinjaStrings(nil, s)
 	return ret
 }
+
+func TestBuildParamsDeps(t *testing.T) {
+	testCases := []struct {
+		name   string
+		params BuildParams
+		rule   *ruleDef
+		err    string
+	}{
+		{
+			name:   "depfile without deps",
+			params: BuildParams{Depfile: "foo.d"},
+		},
+		{
+			name:   "deps gcc with depfile",
+			params: BuildParams{Depfile: "foo.d", Deps: DepsGCC},
+		},
+		{
+			name:   "deps gcc with depfile from rule",
+			params: BuildParams{Deps: DepsGCC},
+			rule:   &ruleDef{Variables: map[string]*ninjaString{"depfile": simpleNinjaString("foo.d")}},
+		},
+		{
+			name:   "deps msvc without depfile",
+			params: BuildParams{Deps: DepsMSVC},
+		},
+		{
+			name:   "deps gcc without depfile",
+			params: BuildParams{Deps: DepsGCC},
+			err:    `build statement for ["foo.o"] using rule <builtin>:phony has deps = gcc but no depfile`,
+		},
+		{
+			name:   "invalid deps",
+			params: BuildParams{Deps: Deps(10)},
+			err:    `invalid Deps param 10`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.params.Rule = Phony
+			tc.params.Outputs = []string{"foo.o"}
+			def, err := parseBuildParams(newScope(nil), &tc.params, nil)
+			if err == nil {
+				err = def.checkDepfile(tc.rule)
+			}
+			if g, w := fmt.Sprint(err), tc.err; w == "" && err != nil || w != "" && g != w {
+				t.Errorf("expected error %q, got %q", w, g)
+			}
+		})
+	}
+}
+
+func TestBuildParamsPathCategories(t *testing.T) {
+	testCases := []struct {
+		name   string
+		params BuildParams
+		err    string
+	}{
+		{
+			name: "distinct",
+			params: BuildParams{
+				Outputs:         []string{"foo.o"},
+				ImplicitOutputs: []string{"foo.d"},
+				Inputs:          []string{"foo.c"},
+				Implicits:       []string{"foo.h"},
+				OrderOnly:       []string{"gen"},
+			},
+		},
+		{
+			name:   "duplicate within a category",
+			params: BuildParams{Implicits: []string{"foo.h", "foo.h"}},
+		},
+		{
+			name:   "input and implicit",
+			params: BuildParams{Inputs: []string{"foo.c"}, Implicits: []string{"foo.c"}},
+			err:    `"foo.c" is in both the Inputs and Implicits params`,
+		},
+		{
+			name:   "input and order-only",
+			params: BuildParams{Inputs: []string{"foo.c"}, OrderOnly: []string{"foo.c"}},
+			err:    `"foo.c" is in both the Inputs and OrderOnly params`,
+		},
+		{
+			name:   "implicit and order-only",
+			params: BuildParams{Implicits: []string{"foo.h"}, OrderOnly: []string{"foo.h"}},
+			err:    `"foo.h" is in both the Implicits and OrderOnly params`,
+		},
+		{
+			name:   "output and implicit output",
+			params: BuildParams{Outputs: []string{"foo.o", "foo.h"}, ImplicitOutputs: []string{"foo.h"}},
+			err:    `"foo.h" is in both the Outputs and ImplicitOutputs params`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.params.Rule = Phony
+			if tc.params.Outputs == nil {
+				tc.params.Outputs = []string{"foo.o"}
+			}
+			_, err := parseBuildParams(newScope(nil), &tc.params, nil)
+			if g, w := fmt.Sprint(err), tc.err; w == "" && err != nil || w != "" && g != w {
+				t.Errorf("expected error %q, got %q", w, g)
+			}
+		})
+	}
+}
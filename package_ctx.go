@@ -89,6 +89,15 @@ func (p *packageContext) getScope() *basicScope {
 
 var packageContexts = map[string]*packageContext{}
 
+// allPackageContexts returns every package registered with NewPackageContext.
+func allPackageContexts() []*packageContext {
+	pctxs := make([]*packageContext, 0, len(packageContexts))
+	for _, pctx := range packageContexts {
+		pctxs = append(pctxs, pctx)
+	}
+	return pctxs
+}
+
 // NewPackageContext creates a PackageContext object for a given package.  The
 // pkgPath argument should always be set to the full path used to import the
 // package.  This function may only be called from a Go package's init()
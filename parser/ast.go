@@ -16,9 +16,9 @@ package parser
 
 import (
 	"fmt"
-	"os"
 	"strings"
 	"text/scanner"
+	"unicode"
 )
 
 type Node interface {
@@ -307,11 +307,12 @@ func evaluateOperator(scope *Scope, operator rune, left, right Expression) (Expr
 
 	l = l.Copy()
 
+	if _, ok := l.(*Select); !ok && l.Type() != r.Type() {
+		return nil, fmt.Errorf("mismatched types in operator %c: %s and %s", operator, l.Type(), r.Type())
+	}
+
 	switch v := l.(type) {
 	case *String:
-		if _, ok := r.(*String); !ok {
-			fmt.Fprintf(os.Stderr, "not ok")
-		}
 		v.Value += r.(*String).Value
 	case *Int64:
 		v.Value += r.(*Int64).Value
@@ -409,8 +410,7 @@ func (x *Variable) Copy() Expression {
 }
 
 func (x *Variable) Eval(scope *Scope) (Expression, error) {
-	if assignment := scope.Get(x.Name); assignment != nil {
-		assignment.Referenced = true
+	if assignment := scope.reference(x.Name); assignment != nil {
 		return assignment.Value, nil
 	}
 	return nil, fmt.Errorf("undefined variable %s", x.Name)
@@ -421,9 +421,7 @@ func (x *Variable) PrintfInto(value string) error {
 }
 
 func (x *Variable) MarkReferencedVariables(scope *Scope) {
-	if assignment := scope.Get(x.Name); assignment != nil {
-		assignment.Referenced = true
-	}
+	scope.reference(x.Name)
 }
 
 func (x *Variable) String() string {
@@ -621,6 +619,10 @@ func (x *List) Type() Type { return ListType }
 type String struct {
 	LiteralPos scanner.Position
 	Value      string
+
+	// interpolate is set by the parser on string literals that contain "${", and causes Eval to replace
+	// references to variables in the value if the scope has string interpolation enabled.
+	interpolate bool
 }
 
 func (x *String) Pos() scanner.Position { return x.LiteralPos }
@@ -631,8 +633,81 @@ func (x *String) Copy() Expression {
 	return &ret
 }
 
+// Eval replaces "${name}" in a string literal with the value of the string variable name, and "$${" with a
+// literal "${", if the scope has string interpolation enabled with Scope.EnableStringInterpolation.  Strings
+// that were not read from a Blueprints file are returned unmodified.
 func (x *String) Eval(scope *Scope) (Expression, error) {
-	return x, nil
+	if !x.interpolate || scope == nil || !scope.stringInterpolation {
+		return x, nil
+	}
+
+	var sb strings.Builder
+	s := x.Value
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			sb.WriteString(s)
+			break
+		}
+		sb.WriteString(s[:i])
+		s = s[i:]
+
+		switch {
+		case strings.HasPrefix(s, "$${"):
+			sb.WriteString("${")
+			s = s[3:]
+		case strings.HasPrefix(s, "${"):
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				return nil, &ParseError{
+					Err: fmt.Errorf("unterminated variable reference in %q", x.Value),
+					Pos: x.LiteralPos,
+				}
+			}
+			value, err := interpolatedVariable(scope, s[2:end])
+			if err != nil {
+				return nil, &ParseError{Err: err, Pos: x.LiteralPos}
+			}
+			sb.WriteString(value)
+			s = s[end+1:]
+		default:
+			sb.WriteByte('$')
+			s = s[1:]
+		}
+	}
+
+	return &String{
+		LiteralPos: x.LiteralPos,
+		Value:      sb.String(),
+	}, nil
+}
+
+// interpolatedVariable returns the value of the string variable name for a "${name}" reference in a string.
+func interpolatedVariable(scope *Scope, name string) (string, error) {
+	if !isIdentifier(name) {
+		return "", fmt.Errorf("invalid variable name %q in string, use $${ for a literal ${", name)
+	}
+	assignment := scope.reference(name)
+	if assignment == nil {
+		return "", fmt.Errorf("undefined variable %s", name)
+	}
+	str, ok := assignment.Value.(*String)
+	if !ok {
+		return "", fmt.Errorf("variable %s used in string must be a string, got %s", name, assignment.Value.Type())
+	}
+	return str.Value, nil
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c != '_' && !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return true
 }
 
 func (x *String) PrintfInto(value string) error {
@@ -654,6 +729,12 @@ func (x *String) PrintfInto(value string) error {
 }
 
 func (x *String) MarkReferencedVariables(scope *Scope) {
+	if !x.interpolate || scope == nil || !scope.stringInterpolation {
+		return
+	}
+	// Evaluating the string marks the variables it references, errors will be reported when it is evaluated
+	// for real.
+	x.Eval(scope)
 }
 
 func (x *String) String() string {
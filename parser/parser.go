@@ -95,6 +95,12 @@ func ParseAndEval(filename string, r io.Reader, scope *Scope) (file *File, errs
 				}
 				prop.Value = newval
 			}
+			if d.Type == AssertModuleType {
+				if err := evalAssert(d); err != nil {
+					return nil, []error{err}
+				}
+				continue
+			}
 			newDefs = append(newDefs, d)
 		case *Assignment:
 			if err := scope.HandleAssignment(d); err != nil {
@@ -111,6 +117,62 @@ func ParseAndEval(filename string, r io.Reader, scope *Scope) (file *File, errs
 	return file, nil
 }
 
+// AssertModuleType is the type of a pseudo-module that fails ParseAndEval with a custom message when its
+// condition is false, for example:
+//
+//	assert {
+//	    condition: enable_foo,
+//	    message: "enable_foo must be set",
+//	}
+//
+// The condition is evaluated against the variables assigned before the assert.  Assert modules are removed
+// from the evaluated file.
+const AssertModuleType = "assert"
+
+// evalAssert returns an error if the evaluated properties of the assert pseudo-module d are invalid or its
+// condition is false.
+func evalAssert(d *Module) error {
+	var condition *Bool
+	var message *String
+	for _, prop := range d.Properties {
+		var ok bool
+		switch prop.Name {
+		case "condition":
+			if condition, ok = prop.Value.(*Bool); !ok {
+				return &ParseError{
+					Err: fmt.Errorf("assert property %q must be a bool, got %s", prop.Name, prop.Value.Type()),
+					Pos: prop.NamePos,
+				}
+			}
+		case "message":
+			if message, ok = prop.Value.(*String); !ok {
+				return &ParseError{
+					Err: fmt.Errorf("assert property %q must be a string, got %s", prop.Name, prop.Value.Type()),
+					Pos: prop.NamePos,
+				}
+			}
+		default:
+			return &ParseError{
+				Err: fmt.Errorf("unrecognized assert property %q", prop.Name),
+				Pos: prop.NamePos,
+			}
+		}
+	}
+	if condition == nil || message == nil {
+		return &ParseError{
+			Err: fmt.Errorf("assert requires condition and message properties"),
+			Pos: d.TypePos,
+		}
+	}
+	if !condition.Value {
+		return &ParseError{
+			Err: fmt.Errorf("assertion failed: %s", message.Value),
+			Pos: d.TypePos,
+		}
+	}
+	return nil
+}
+
 func Parse(filename string, r io.Reader) (file *File, errs []error) {
 	p := newParser(r)
 	p.scanner.Filename = filename
@@ -285,8 +347,17 @@ func (p *parser) parseModule(typ string, typPos scanner.Position) *Module {
 }
 
 func (p *parser) parsePropertyList(isModule, compat bool) (properties []*Property) {
+	seen := make(map[string]*Property)
+
 	for p.tok == scanner.Ident {
-		properties = append(properties, p.parseProperty(isModule, compat))
+		property, appendPos := p.parseProperty(isModule, compat)
+
+		if appendPos.IsValid() {
+			p.appendProperty(seen, property, appendPos)
+		} else {
+			properties = append(properties, property)
+			seen[property.Name] = property
+		}
 
 		if p.tok != ',' {
 			// There was no comma, so the list is done.
@@ -299,7 +370,31 @@ func (p *parser) parsePropertyList(isModule, compat bool) (properties []*Propert
 	return
 }
 
-func (p *parser) parseProperty(isModule, compat bool) (property *Property) {
+// appendProperty merges a property assigned with += into the property of the same name that was
+// previously declared in the same property list.
+func (p *parser) appendProperty(seen map[string]*Property, property *Property, appendPos scanner.Position) {
+	old, ok := seen[property.Name]
+	if !ok {
+		p.errorf("modified non-existent property %q with +=", property.Name)
+		return
+	}
+
+	oldType, newType := old.Value.Type(), property.Value.Type()
+	if oldType != UnknownType && newType != UnknownType && oldType != newType {
+		p.errorf("cannot append %s to %s property %q", newType, oldType, property.Name)
+		return
+	}
+
+	old.Value = &Operator{
+		Args:        [2]Expression{old.Value, property.Value},
+		Operator:    '+',
+		OperatorPos: appendPos,
+	}
+}
+
+// parseProperty parses a single property.  If the property was assigned with += the position of
+// the operator is returned, otherwise the returned position is invalid.
+func (p *parser) parseProperty(isModule, compat bool) (property *Property, appendPos scanner.Position) {
 	property = new(Property)
 
 	name := p.scanner.TokenText()
@@ -307,7 +402,13 @@ func (p *parser) parseProperty(isModule, compat bool) (property *Property) {
 	p.accept(scanner.Ident)
 	pos := p.scanner.Position
 
-	if isModule {
+	if p.tok == '+' {
+		appendPos = pos
+		p.accept('+')
+		if !p.accept('=') {
+			return
+		}
+	} else if isModule {
 		if compat {
 			if !p.accept(':') {
 				return
@@ -709,8 +810,9 @@ func (p *parser) parseStringValue() *String {
 	}
 
 	value := &String{
-		LiteralPos: p.scanner.Position,
-		Value:      str,
+		LiteralPos:  p.scanner.Position,
+		Value:       str,
+		interpolate: strings.Contains(str, "${"),
 	}
 	p.accept(p.tok)
 	return value
@@ -791,19 +893,34 @@ func (p *parser) parseMapValue() *Map {
 }
 
 type Scope struct {
-	vars              map[string]*Assignment
-	preventInheriting map[string]bool
-	parentScope       *Scope
+	vars                map[string]*Assignment
+	preventInheriting   map[string]bool
+	parentScope         *Scope
+	stringInterpolation bool
+
+	// referenced holds the variables referenced by expressions evaluated in this scope, including
+	// the ones inherited from its parent scope.
+	referenced map[*Assignment]bool
 }
 
 func NewScope(s *Scope) *Scope {
 	return &Scope{
-		vars:              make(map[string]*Assignment),
-		preventInheriting: make(map[string]bool),
-		parentScope:       s,
+		vars:                make(map[string]*Assignment),
+		preventInheriting:   make(map[string]bool),
+		parentScope:         s,
+		stringInterpolation: s != nil && s.stringInterpolation,
+		referenced:          make(map[*Assignment]bool),
 	}
 }
 
+// EnableStringInterpolation causes string literals evaluated in this scope and in scopes later created from
+// it with NewScope to replace "${name}" with the value of the string variable name, and "$${" with a literal
+// "${".  It is off by default, as "${name}" is otherwise passed through unmodified, for example as a
+// reference to a ninja variable.
+func (s *Scope) EnableStringInterpolation() {
+	s.stringInterpolation = true
+}
+
 func (s *Scope) HandleAssignment(assignment *Assignment) error {
 	switch assignment.Assigner {
 	case "+=":
@@ -855,6 +972,36 @@ func (s *Scope) Get(name string) *Assignment {
 	return s.parentScope.Get(name)
 }
 
+// reference returns the variable with the given name like Get and marks it as referenced.
+func (s *Scope) reference(name string) *Assignment {
+	a := s.Get(name)
+	if a != nil {
+		a.Referenced = true
+		s.referenced[a] = true
+	}
+	return a
+}
+
+// ReferencedAssignments returns the variables that were referenced by expressions evaluated in
+// this scope, including the ones inherited from its parent scope, sorted by file name and position.
+// It doesn't include the references made in scopes created from it with NewScope.
+func (s *Scope) ReferencedAssignments() []*Assignment {
+	if s == nil {
+		return nil
+	}
+	ret := make([]*Assignment, 0, len(s.referenced))
+	for a := range s.referenced {
+		ret = append(ret, a)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].NamePos.Filename != ret[j].NamePos.Filename {
+			return ret[i].NamePos.Filename < ret[j].NamePos.Filename
+		}
+		return ret[i].NamePos.Offset < ret[j].NamePos.Offset
+	})
+	return ret
+}
+
 func (s *Scope) GetLocal(name string) *Assignment {
 	if s == nil {
 		return nil
@@ -865,6 +1012,21 @@ func (s *Scope) GetLocal(name string) *Assignment {
 	return nil
 }
 
+// LocalAssignments returns the variables assigned in this scope, not including the ones inherited from its
+// parent scope, sorted by position.  A variable that was modified with += is returned once, as its
+// original assignment.
+func (s *Scope) LocalAssignments() []*Assignment {
+	if s == nil {
+		return nil
+	}
+	ret := make([]*Assignment, 0, len(s.vars))
+	for _, a := range s.vars {
+		ret = append(ret, a)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].NamePos.Offset < ret[j].NamePos.Offset })
+	return ret
+}
+
 // DontInherit prevents this scope from inheriting the given variable from its
 // parent scope.
 func (s *Scope) DontInherit(name string) {
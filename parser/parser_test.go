@@ -891,6 +891,77 @@ func TestParserError(t *testing.T) {
 			`,
 			err: "Found duplicate select pattern binding: bar",
 		},
+		{
+			name: "append to non-existent property",
+			input: `
+			m {
+				srcs += ["a"],
+			}
+			`,
+			err: `modified non-existent property "srcs" with +=`,
+		},
+		{
+			name: "append string to list property",
+			input: `
+			m {
+				srcs: ["a"],
+				srcs += "b",
+			}
+			`,
+			err: `cannot append string to list property "srcs"`,
+		},
+		{
+			name: "append mismatched variable to property",
+			input: `
+			foo = "b"
+			m {
+				srcs: ["a"],
+				srcs += foo,
+			}
+			`,
+			err: "mismatched types in operator +: list and string",
+		},
+		{
+			name: "failed assert",
+			input: `
+			enable_foo = false
+			assert {
+				condition: enable_foo,
+				message: "enable_foo must be set",
+			}
+			`,
+			err: ":3:4: assertion failed: enable_foo must be set",
+		},
+		{
+			name: "assert with non-bool condition",
+			input: `
+			assert {
+				condition: "true",
+				message: "foo",
+			}
+			`,
+			err: `:3:5: assert property "condition" must be a bool, got string`,
+		},
+		{
+			name: "assert without message",
+			input: `
+			assert {
+				condition: true,
+			}
+			`,
+			err: ":2:4: assert requires condition and message properties",
+		},
+		{
+			name: "assert with unknown property",
+			input: `
+			assert {
+				condition: true,
+				message: "foo",
+				name: "bar",
+			}
+			`,
+			err: `:5:5: unrecognized assert property "name"`,
+		},
 		// TODO: test more parser errors
 	}
 
@@ -911,6 +982,247 @@ func TestParserError(t *testing.T) {
 	}
 }
 
+func TestParseAssert(t *testing.T) {
+	input := `
+		enable_foo = true
+		assert {
+			condition: enable_foo,
+			message: "enable_foo must be set",
+		}
+		m {
+			name: "m",
+		}
+	`
+	file, errs := ParseAndEval("", bytes.NewBufferString(input), NewScope(nil))
+	if len(errs) != 0 {
+		t.Fatalf("%s", errors.Join(errs...).Error())
+	}
+
+	if g, w := len(file.Defs), 1; g != w {
+		t.Fatalf("expected %d definitions, got %d: %v", w, g, file.Defs)
+	}
+	if g, w := file.Defs[0].(*Module).Type, "m"; g != w {
+		t.Errorf("expected module type %q, got %q", w, g)
+	}
+}
+
+func TestParseStringInterpolation(t *testing.T) {
+	input := `
+		version = "1.0"
+		dir = "out/${version}"
+		m {
+			name: "lib${version}",
+			srcs: ["${dir}/lib", "$${ninja_var}", "$$${shell_var}", "$in"],
+			raw: ` + "`${version}`" + `,
+		}
+	`
+	scope := NewScope(nil)
+	scope.EnableStringInterpolation()
+	file, errs := ParseAndEval("", bytes.NewBufferString(input), scope)
+	if len(errs) != 0 {
+		t.Fatalf("%s", errors.Join(errs...).Error())
+	}
+
+	mod := file.Defs[0].(*Module)
+	name, _ := mod.GetProperty("name")
+	if g, w := name.Value.(*String).Value, "lib1.0"; g != w {
+		t.Errorf("expected name %q, got %q", w, g)
+	}
+
+	srcs, _ := mod.GetProperty("srcs")
+	var values []string
+	for _, v := range srcs.Value.(*List).Values {
+		values = append(values, v.(*String).Value)
+	}
+	if g, w := values, []string{"out/1.0/lib", "${ninja_var}", "$${shell_var}", "$in"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected srcs %q, got %q", w, g)
+	}
+
+	raw, _ := mod.GetProperty("raw")
+	if g, w := raw.Value.(*String).Value, "1.0"; g != w {
+		t.Errorf("expected raw %q, got %q", w, g)
+	}
+}
+
+func TestParseStringInterpolationDisabled(t *testing.T) {
+	input := `
+		version = "1.0"
+		m {
+			name: "lib${version}",
+			cmd: "$${ninja_var} ${undefined}",
+		}
+	`
+	file, errs := ParseAndEval("", bytes.NewBufferString(input), NewScope(nil))
+	if len(errs) != 0 {
+		t.Fatalf("%s", errors.Join(errs...).Error())
+	}
+
+	mod := file.Defs[0].(*Module)
+	for _, prop := range []struct{ name, value string }{
+		{"name", "lib${version}"},
+		{"cmd", "$${ninja_var} ${undefined}"},
+	} {
+		p, _ := mod.GetProperty(prop.name)
+		if g, w := p.Value.(*String).Value, prop.value; g != w {
+			t.Errorf("expected %s %q, got %q", prop.name, w, g)
+		}
+	}
+}
+
+func TestParseStringInterpolationError(t *testing.T) {
+	testcases := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name: "undefined variable in string",
+			input: `
+			m {
+				srcs: ["${version}/lib"],
+			}
+			`,
+			err: ":3:12: undefined variable version",
+		},
+		{
+			name: "non-string variable in string",
+			input: `
+			version = ["1"]
+			m {
+				name: "lib${version}",
+			}
+			`,
+			err: ":4:11: variable version used in string must be a string, got list",
+		},
+		{
+			name: "unterminated variable in string",
+			input: `
+			m {
+				name: "lib${version",
+			}
+			`,
+			err: `:3:11: unterminated variable reference in "lib${version"`,
+		},
+	}
+
+	for _, tt := range testcases {
+		t.Run(tt.name, func(t *testing.T) {
+			scope := NewScope(nil)
+			scope.EnableStringInterpolation()
+			_, errs := ParseAndEval("", bytes.NewBufferString(tt.input), scope)
+			if len(errs) == 0 {
+				t.Fatalf("missing expected error")
+			}
+			if g, w := errs[0], tt.err; !strings.Contains(g.Error(), w) {
+				t.Errorf("expected error %q, got %q", w, g)
+			}
+			for _, err := range errs[1:] {
+				t.Errorf("got unexpected extra error %q", err)
+			}
+		})
+	}
+}
+
+func TestScopeReferencedAssignments(t *testing.T) {
+	parent := NewScope(nil)
+	_, errs := ParseAndEval("parent.bp", bytes.NewBufferString(`
+		a = ["a"]
+		b = ["b"]
+		c = a
+	`), parent)
+	if len(errs) != 0 {
+		t.Fatalf("%s", errors.Join(errs...).Error())
+	}
+
+	child := NewScope(parent)
+	_, errs = ParseAndEval("child.bp", bytes.NewBufferString(`
+		d = ["d"]
+		m {
+			deps: b + d,
+		}
+	`), child)
+	if len(errs) != 0 {
+		t.Fatalf("%s", errors.Join(errs...).Error())
+	}
+
+	names := func(assignments []*Assignment) []string {
+		var ret []string
+		for _, a := range assignments {
+			ret = append(ret, a.Name)
+		}
+		return ret
+	}
+	if g, w := names(parent.ReferencedAssignments()), []string{"a"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected parent scope references %q, got %q", w, g)
+	}
+	if g, w := names(child.ReferencedAssignments()), []string{"d", "b"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected child scope references %q, got %q", w, g)
+	}
+}
+
+func TestParsePropertyAppend(t *testing.T) {
+	input := `
+		foo = ["c"]
+		m {
+			srcs: ["a"],
+			name: "m",
+			srcs += ["b"],
+			srcs += foo,
+			props: {
+				a: "x",
+				b: ["y"],
+			},
+			props += {
+				b: ["z"],
+				c: true,
+			},
+		}
+	`
+	file, errs := ParseAndEval("", bytes.NewBufferString(input), NewScope(nil))
+	if len(errs) != 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	mod := file.Defs[len(file.Defs)-1].(*Module)
+	if g, w := len(mod.Properties), 3; g != w {
+		t.Fatalf("expected %d properties, got %d: %v", w, g, mod.Properties)
+	}
+
+	srcs, _ := mod.GetProperty("srcs")
+	list, ok := srcs.Value.(*List)
+	if !ok {
+		t.Fatalf("expected srcs to be a list, got %s", srcs.Value)
+	}
+	var values []string
+	for _, v := range list.Values {
+		values = append(values, v.(*String).Value)
+	}
+	if g, w := values, []string{"a", "b", "c"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected srcs %q, got %q", w, g)
+	}
+
+	props, _ := mod.GetProperty("props")
+	m, ok := props.Value.(*Map)
+	if !ok {
+		t.Fatalf("expected props to be a map, got %s", props.Value)
+	}
+	var names []string
+	for _, prop := range m.Properties {
+		names = append(names, prop.Name)
+	}
+	if g, w := names, []string{"a", "b", "c"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected props keys %q, got %q", w, g)
+	}
+	b, _ := m.GetProperty("b")
+	if g, w := len(b.Value.(*List).Values), 2; g != w {
+		t.Errorf("expected props.b to have %d values, got %d", w, g)
+	}
+}
+
 func TestParserEndPos(t *testing.T) {
 	in := `
 		module {
@@ -821,3 +821,31 @@ func TestPrinter(t *testing.T) {
 		})
 	}
 }
+
+func TestPrinterRoundTrip(t *testing.T) {
+	for _, testCase := range validPrinterTestCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			formatted := testCase.output[1:]
+
+			file, errs := Parse("", bytes.NewBufferString(formatted))
+			if len(errs) != 0 {
+				t.Errorf("unexpected errors:")
+				for _, err := range errs {
+					t.Errorf("  %s", err)
+				}
+				t.FailNow()
+			}
+
+			got, err := Print(file)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if string(got) != formatted {
+				t.Errorf("formatted file changed when printed again")
+				t.Errorf("  expected: %s", formatted)
+				t.Errorf("       got: %s", string(got))
+			}
+		})
+	}
+}
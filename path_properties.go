@@ -0,0 +1,261 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"text/scanner"
+
+	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/pathtools"
+	"github.com/google/blueprint/proptools"
+)
+
+// processPathProperties validates and expands the values of the properties of a module that are tagged
+// `blueprint:"path"`.  Each path is relative to the directory of the Blueprints file that defines the module, and
+// must refer to a file or directory inside the source tree.
+//
+// In a []string property, entries that contain a glob pattern are replaced with the sorted list of files that
+// match it, and entries that start with "!" are removed and exclude the paths they match from the rest of the
+// list.  The globs are recorded so that they are returned by Globs.  A glob that matches no files is an error
+// unless SetAllowEmptyPathGlobs was called.  A string property can't hold more than one path, so a glob pattern
+// in one is only checked to be inside the source tree.  Properties set with a select are not processed.
+//
+// It returns an error for each path outside of the source tree or that does not exist, except that paths that do
+// not exist are returned as warnings instead if SetAllowMissingDependencies was called.
+func (c *Context) processPathProperties(rootDir string, module *moduleInfo,
+	moduleDef *parser.Module) (errs, warnings []error) {
+
+	dir := filepath.Dir(module.relBlueprintsFile)
+	for _, props := range module.properties {
+		walkPathProperties(reflect.ValueOf(props).Elem(), "", func(name string, value reflect.Value) {
+			property := findPropertyDef(moduleDef.Properties, name)
+			pos := func(path string) scanner.Position {
+				return pathPropertyPos(module, property, path)
+			}
+
+			var newErrs, newWarnings []error
+			if value.Kind() == reflect.String {
+				newErrs, newWarnings = c.checkPath(rootDir, dir, name, value.String(), pos)
+			} else {
+				var paths []string
+				paths, newErrs, newWarnings = c.expandPaths(rootDir, dir, name, value.Interface().([]string), pos)
+				value.Set(reflect.ValueOf(paths))
+			}
+			errs = append(errs, newErrs...)
+			warnings = append(warnings, newWarnings...)
+		})
+	}
+	return errs, warnings
+}
+
+// expandPaths returns the paths in the value of a []string path property with the globs expanded and the
+// exclusions applied, along with the errors and warnings from checking each path.
+func (c *Context) expandPaths(rootDir, dir, propertyName string, paths []string,
+	pos func(string) scanner.Position) (expanded []string, errs, warnings []error) {
+
+	var excludes []string
+	for _, path := range paths {
+		if exclude, ok := strings.CutPrefix(path, "!"); ok {
+			if err := checkPathInTree(dir, propertyName, exclude, pos(path)); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			excludes = append(excludes, filepath.Join(rootDir, dir, exclude))
+		}
+	}
+
+	excluded := func(path string) bool {
+		return slices.ContainsFunc(excludes, func(exclude string) bool {
+			match, err := pathtools.Match(exclude, path)
+			return err == nil && match
+		})
+	}
+
+	expanded = make([]string, 0, len(paths))
+	for _, path := range paths {
+		if strings.HasPrefix(path, "!") {
+			continue
+		}
+
+		if !pathtools.IsGlob(path) {
+			newErrs, newWarnings := c.checkPath(rootDir, dir, propertyName, path, pos)
+			errs = append(errs, newErrs...)
+			warnings = append(warnings, newWarnings...)
+			if !excluded(filepath.Join(rootDir, dir, path)) {
+				expanded = append(expanded, path)
+			}
+			continue
+		}
+
+		if err := checkPathInTree(dir, propertyName, path, pos(path)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		matches, err := c.glob(filepath.Join(rootDir, dir, path), excludes)
+		if err != nil {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("property %q: %s", propertyName, err),
+				Pos: pos(path),
+			})
+			continue
+		}
+
+		// Directories are returned with a trailing '/', only files are expanded.
+		matches = slices.DeleteFunc(matches, func(match string) bool {
+			return strings.HasSuffix(match, "/")
+		})
+		if len(matches) == 0 && !c.allowEmptyPathGlobs {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("property %q: glob %q matched no files", propertyName, path),
+				Pos: pos(path),
+			})
+			continue
+		}
+
+		slices.Sort(matches)
+		for _, match := range matches {
+			rel, err := filepath.Rel(filepath.Join(rootDir, dir), match)
+			if err != nil {
+				panic(err)
+			}
+			expanded = append(expanded, rel)
+		}
+	}
+	return expanded, errs, warnings
+}
+
+// checkPath returns an error if path, relative to the directory dir of a Blueprints file, is outside of the source
+// tree or does not exist.  The error for a path that does not exist is returned as a warning instead if
+// SetAllowMissingDependencies was called.
+func (c *Context) checkPath(rootDir, dir, propertyName, path string,
+	pos func(string) scanner.Position) (errs, warnings []error) {
+
+	if err := checkPathInTree(dir, propertyName, path, pos(path)); err != nil {
+		return []error{err}, nil
+	}
+
+	if pathtools.IsGlob(path) {
+		return nil, nil
+	}
+
+	exists, _, err := c.fs.Exists(filepath.Join(rootDir, dir, path))
+	if err != nil {
+		return []error{&BlueprintError{
+			Err: fmt.Errorf("property %q: %s", propertyName, err),
+			Pos: pos(path),
+		}}, nil
+	}
+	if !exists {
+		err := &BlueprintError{
+			Err: fmt.Errorf("property %q: path %q does not exist", propertyName, path),
+			Pos: pos(path),
+		}
+		if c.allowMissingDependencies {
+			return nil, []error{err}
+		}
+		return []error{err}, nil
+	}
+	return nil, nil
+}
+
+// checkPathInTree returns an error if path, relative to the directory dir of a Blueprints file, is outside of the
+// source tree.
+func checkPathInTree(dir, propertyName, path string, pos scanner.Position) error {
+	relPath := filepath.Join(dir, path)
+	if filepath.IsAbs(path) || relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return &BlueprintError{
+			Err: fmt.Errorf("property %q: path %q is outside of the source tree", propertyName, path),
+			Pos: pos,
+		}
+	}
+	return nil
+}
+
+// walkPathProperties calls visit with the dotted property name and the settable value of each string or []string
+// field tagged `blueprint:"path"` in structValue or in the structs nested in it.  Fields behind nil pointers are
+// not visited as they were not set.
+func walkPathProperties(structValue reflect.Value, prefix string, visit func(name string, value reflect.Value)) {
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if proptools.ShouldSkipProperty(field) {
+			continue
+		}
+
+		name := prefix
+		if !proptools.IsEmbedded(field) {
+			name += proptools.PropertyNameForField(field.Name)
+		}
+
+		fieldValue := structValue.Field(i)
+		for (fieldValue.Kind() == reflect.Ptr || fieldValue.Kind() == reflect.Interface) && !fieldValue.IsNil() {
+			fieldValue = fieldValue.Elem()
+		}
+
+		switch {
+		case fieldValue.Kind() == reflect.Struct && !proptools.IsConfigurable(fieldValue.Type()):
+			if !proptools.IsEmbedded(field) {
+				name += "."
+			}
+			walkPathProperties(fieldValue, name, visit)
+		case !proptools.HasTag(field, "blueprint", "path"):
+		case fieldValue.Kind() == reflect.String,
+			fieldValue.Kind() == reflect.Slice && fieldValue.Type().Elem().Kind() == reflect.String:
+			visit(name, fieldValue)
+		}
+	}
+}
+
+// pathPropertyPos returns the position of path in the definition of a path property, or the position of the
+// property or the module if it can't be found, for example because the value was set by the module factory.
+func pathPropertyPos(module *moduleInfo, property *parser.Property, path string) scanner.Position {
+	if property == nil {
+		return module.pos
+	}
+	values := []parser.Expression{property.Value}
+	if list, ok := property.Value.(*parser.List); ok {
+		values = list.Values
+	}
+	for _, value := range values {
+		if s, ok := value.(*parser.String); ok && s.Value == path {
+			return s.LiteralPos
+		}
+	}
+	return property.ColonPos
+}
+
+// findPropertyDef returns the property with the given name, where the names of properties inside map properties
+// are separated by '.', or nil if it is not set.
+func findPropertyDef(properties []*parser.Property, name string) *parser.Property {
+	first, rest, nested := strings.Cut(name, ".")
+	for _, property := range properties {
+		if property.Name != first {
+			continue
+		}
+		if !nested {
+			return property
+		}
+		if m, ok := property.Value.(*parser.Map); ok {
+			return findPropertyDef(m.Properties, rest)
+		}
+		return nil
+	}
+	return nil
+}
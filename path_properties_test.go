@@ -0,0 +1,178 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+type pathPropertiesTestModule struct {
+	SimpleName
+	properties struct {
+		Srcs   []string `blueprint:"path"`
+		Config *string  `blueprint:"path"`
+		Target struct {
+			Host struct {
+				Data []string `blueprint:"path"`
+			}
+		}
+		Names []string
+	}
+}
+
+func newPathPropertiesTestModule() (Module, []interface{}) {
+	m := &pathPropertiesTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties, &m.properties}
+}
+
+func (m *pathPropertiesTestModule) GenerateBuildActions(ModuleContext) {}
+
+func TestPathProperties(t *testing.T) {
+	run := func(t *testing.T, allowMissing bool) (errs, warnings []string) {
+		ctx := NewContext()
+		ctx.RegisterModuleType("path_module", newPathPropertiesTestModule)
+		ctx.SetAllowMissingDependencies(allowMissing)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				path_module {
+					name: "A",
+					srcs: ["sub/a.c", "sub/*.c"],
+					names: ["missing"],
+				}
+			`),
+			"sub/Android.bp": []byte(`
+				path_module {
+					name: "B",
+					srcs: ["a.c", "b.c", "../sub/a.c"],
+					config: "../../config.json",
+					target: {
+						host: {
+							data: ["data"],
+						},
+					},
+				}
+			`),
+			"sub/a.c":       nil,
+			"sub/data/file": nil,
+		})
+		_, parseErrs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		for _, err := range parseErrs {
+			errs = append(errs, err.Error())
+		}
+		for _, warning := range ctx.Warnings() {
+			warnings = append(warnings, warning.Error())
+		}
+		return errs, warnings
+	}
+
+	t.Run("error", func(t *testing.T) {
+		errs, warnings := run(t, false)
+		want := []string{
+			`sub/Android.bp:4:20: property "srcs": path "b.c" does not exist`,
+			`sub/Android.bp:5:14: property "config": path "../../config.json" is outside of the source tree`,
+		}
+		if !reflect.DeepEqual(errs, want) {
+			t.Errorf("expected errors %q, got %q", want, errs)
+		}
+		if len(warnings) > 0 {
+			t.Errorf("expected no warnings, got %q", warnings)
+		}
+	})
+
+	t.Run("allow missing dependencies", func(t *testing.T) {
+		errs, warnings := run(t, true)
+		if want := []string{
+			`sub/Android.bp:5:14: property "config": path "../../config.json" is outside of the source tree`,
+		}; !reflect.DeepEqual(errs, want) {
+			t.Errorf("expected errors %q, got %q", want, errs)
+		}
+		if want := []string{
+			`sub/Android.bp:4:20: property "srcs": path "b.c" does not exist`,
+		}; !reflect.DeepEqual(warnings, want) {
+			t.Errorf("expected warnings %q, got %q", want, warnings)
+		}
+	})
+}
+
+func TestPathPropertiesGlobs(t *testing.T) {
+	run := func(t *testing.T, allowEmpty bool) (*Context, []string) {
+		ctx := NewContext()
+		ctx.RegisterModuleType("path_module", newPathPropertiesTestModule)
+		ctx.SetAllowEmptyPathGlobs(allowEmpty)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				path_module {
+					name: "A",
+					srcs: ["z.c", "*.c", "!b.c", "y.c", "!y.c", "gen/*.c", "!gen/h.c"],
+				}
+
+				path_module {
+					name: "B",
+					srcs: ["*.cpp"],
+				}
+			`),
+			"a.c":     nil,
+			"b.c":     nil,
+			"c.c":     nil,
+			"y.c":     nil,
+			"z.c":     nil,
+			"gen/g.c": nil,
+			"gen/h.c": nil,
+		})
+		_, parseErrs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		var errs []string
+		for _, err := range parseErrs {
+			errs = append(errs, err.Error())
+		}
+		return ctx, errs
+	}
+
+	srcs := func(ctx *Context, name string) []string {
+		module := ctx.moduleGroupFromName(name, nil).modules.firstModule()
+		return module.logicModule.(*pathPropertiesTestModule).properties.Srcs
+	}
+
+	t.Run("error", func(t *testing.T) {
+		_, errs := run(t, false)
+		want := []string{
+			`Android.bp:9:13: property "srcs": glob "*.cpp" matched no files`,
+		}
+		if !reflect.DeepEqual(errs, want) {
+			t.Errorf("expected errors %q, got %q", want, errs)
+		}
+	})
+
+	t.Run("allow empty", func(t *testing.T) {
+		ctx, errs := run(t, true)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors %q", errs)
+		}
+		if g, w := srcs(ctx, "A"), []string{"z.c", "a.c", "c.c", "z.c", "gen/g.c"}; !reflect.DeepEqual(g, w) {
+			t.Errorf("expected srcs of A %q, got %q", w, g)
+		}
+		if g := srcs(ctx, "B"); len(g) != 0 {
+			t.Errorf("expected empty srcs of B, got %q", g)
+		}
+
+		var globs []string
+		for _, g := range ctx.Globs() {
+			globs = append(globs, g.Pattern)
+		}
+		if w := []string{"*.c", "*.cpp", "gen/*.c"}; !reflect.DeepEqual(globs, w) {
+			t.Errorf("expected globs %q, got %q", w, globs)
+		}
+	})
+}
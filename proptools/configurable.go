@@ -910,6 +910,7 @@ type configurableReflection interface {
 	isEmpty() bool
 	printfInto(value string) error
 	toExpression() (*parser.Expression, error)
+	jsonValue() (any, error)
 }
 
 // Same as configurableReflection, but since initialize needs to take a pointer
@@ -991,6 +992,46 @@ func (c Configurable[T]) toExpression() (*parser.Expression, error) {
 	return &result2, nil
 }
 
+// jsonValue returns the value of the configurable for PropertiesToJSON.
+func (c Configurable[T]) jsonValue() (any, error) {
+	for curr := c.inner; curr != nil; curr = curr.next {
+		if len(curr.single.conditions) > 0 {
+			expr, err := c.toExpression()
+			if err != nil {
+				return nil, err
+			}
+			text, err := parser.PrintExpression(*expr)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{"select": strings.TrimSpace(string(text))}, nil
+		}
+	}
+
+	evaluator := &jsonConfigurableEvaluator{}
+	value := c.evaluate(c.propertyName, evaluator)
+	if evaluator.err != nil {
+		return nil, evaluator.err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return *value, nil
+}
+
+// jsonConfigurableEvaluator evaluates configurables that don't depend on the configuration.
+type jsonConfigurableEvaluator struct {
+	err error
+}
+
+func (e *jsonConfigurableEvaluator) EvaluateConfiguration(condition ConfigurableCondition, property string) ConfigurableValue {
+	panic(fmt.Errorf("unexpected condition %s in property %q", condition.String(), property))
+}
+
+func (e *jsonConfigurableEvaluator) PropertyErrorf(property, format string, args ...interface{}) {
+	e.err = fmt.Errorf(format, args...)
+}
+
 func appendPostprocessors[T ConfigurableElements](a, b [][]postProcessor[T], newBase int) [][]postProcessor[T] {
 	var result [][]postProcessor[T]
 	for i := 0; i < len(a); i++ {
@@ -151,6 +151,13 @@ func ExtendMatchingProperties(dst []interface{}, src interface{},
 	return extendMatchingProperties(dst, src, filter, order)
 }
 
+// Order is how the value of a property in src is combined with the value of the property in dst.
+//
+// The order used for an individual property can be changed with a tag on the dst property:
+//   - `blueprint:"replace"` makes Append act as Replace, and makes Prepend and Prepend_replace keep a list, map or
+//     pointer property that is set in dst instead of combining it with the value from src.  For example a module
+//     whose defaults are prepended to it can clear a list property it inherits by setting it to [].
+//   - `blueprint:"prepend"` makes Append act as Prepend, so that the value from src comes first.
 type Order int
 
 const (
@@ -439,6 +446,21 @@ func extendPropertiesRecursive(dstValues []reflect.Value, srcValue reflect.Value
 				} else if order == Prepend {
 					order = Prepend_replace
 				}
+			} else if HasTag(dstField, "blueprint", "replace") {
+				if order == Append {
+					order = Replace
+				} else if order == Prepend || order == Prepend_replace {
+					switch dstFieldValue.Kind() {
+					case reflect.Slice, reflect.Map, reflect.Ptr:
+						if !dstFieldValue.IsNil() {
+							// The property is set in dst, keep it instead of combining it with src.
+							continue
+						}
+					}
+					order = Prepend_replace
+				}
+			} else if HasTag(dstField, "blueprint", "prepend") && order == Append {
+				order = Prepend
 			}
 
 			ExtendBasicType(dstFieldValue, srcFieldValue, order)
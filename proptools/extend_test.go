@@ -1421,6 +1421,114 @@ func appendPropertiesTestCases() []appendPropertyTestCase {
 				}(),
 			},
 		},
+		{
+			name: "Append tagged replace",
+			dst: &struct {
+				L []string          `blueprint:"replace"`
+				M map[string]string `blueprint:"replace"`
+				P *string           `blueprint:"replace"`
+				U []string
+			}{
+				L: []string{"a"},
+				M: map[string]string{"a": "1", "b": "2"},
+				P: StringPtr("a"),
+				U: []string{"x"},
+			},
+			src: &struct {
+				L []string          `blueprint:"replace"`
+				M map[string]string `blueprint:"replace"`
+				P *string           `blueprint:"replace"`
+				U []string
+			}{
+				L: []string{"b"},
+				M: map[string]string{"b": "3"},
+				P: StringPtr("b"),
+				U: []string{"y"},
+			},
+			out: &struct {
+				L []string          `blueprint:"replace"`
+				M map[string]string `blueprint:"replace"`
+				P *string           `blueprint:"replace"`
+				U []string
+			}{
+				L: []string{"b"},
+				M: map[string]string{"b": "3"},
+				P: StringPtr("b"),
+				U: []string{"x", "y"},
+			},
+		},
+		{
+			name: "Prepend tagged replace",
+			dst: &struct {
+				L []string          `blueprint:"replace"`
+				N []string          `blueprint:"replace"`
+				M map[string]string `blueprint:"replace"`
+				P *string           `blueprint:"replace"`
+				U []string
+			}{
+				L: []string{},
+				M: map[string]string{"a": "1"},
+				P: StringPtr("a"),
+				U: []string{"x"},
+			},
+			src: &struct {
+				L []string          `blueprint:"replace"`
+				N []string          `blueprint:"replace"`
+				M map[string]string `blueprint:"replace"`
+				P *string           `blueprint:"replace"`
+				U []string
+			}{
+				L: []string{"b"},
+				N: []string{"c"},
+				M: map[string]string{"a": "2", "b": "3"},
+				P: StringPtr("b"),
+				U: []string{"y"},
+			},
+			out: &struct {
+				L []string          `blueprint:"replace"`
+				N []string          `blueprint:"replace"`
+				M map[string]string `blueprint:"replace"`
+				P *string           `blueprint:"replace"`
+				U []string
+			}{
+				L: []string{},
+				N: []string{"c"},
+				M: map[string]string{"a": "1"},
+				P: StringPtr("a"),
+				U: []string{"y", "x"},
+			},
+			order: Prepend,
+		},
+		{
+			name: "Append tagged prepend",
+			dst: &struct {
+				L []string          `blueprint:"prepend"`
+				M map[string]string `blueprint:"prepend"`
+				P *string           `blueprint:"prepend"`
+			}{
+				L: []string{"a"},
+				M: map[string]string{"a": "1"},
+				P: StringPtr("a"),
+			},
+			src: &struct {
+				L []string          `blueprint:"prepend"`
+				M map[string]string `blueprint:"prepend"`
+				P *string           `blueprint:"prepend"`
+			}{
+				L: []string{"b"},
+				M: map[string]string{"a": "2", "b": "3"},
+				P: StringPtr("b"),
+			},
+			out: &struct {
+				L []string          `blueprint:"prepend"`
+				M map[string]string `blueprint:"prepend"`
+				P *string           `blueprint:"prepend"`
+			}{
+				L: []string{"b", "a"},
+				M: map[string]string{"a": "1", "b": "3"},
+				P: StringPtr("a"),
+			},
+		},
 	}
 }
 
@@ -1485,6 +1593,37 @@ func TestExtendProperties(t *testing.T) {
 	}
 }
 
+func TestPrependTaggedReplaceClearsList(t *testing.T) {
+	type props struct {
+		Srcs   []string `blueprint:"replace"`
+		Cflags []string
+	}
+
+	file, errs := parser.ParseAndEval("", strings.NewReader(`m { srcs: [], cflags: ["-Wall"] }`), parser.NewScope(nil))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %q", errs)
+	}
+	module := &props{}
+	if _, errs := UnpackProperties(file.Defs[0].(*parser.Module).Properties, module); len(errs) > 0 {
+		t.Fatalf("unexpected unpack errors: %q", errs)
+	}
+
+	defaults := &props{
+		Srcs:   []string{"a.c"},
+		Cflags: []string{"-O2"},
+	}
+	if err := PrependProperties(module, defaults, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(module.Srcs) != 0 {
+		t.Errorf("expected srcs to be cleared, got %q", module.Srcs)
+	}
+	if g, w := module.Cflags, []string{"-O2", "-Wall"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected cflags %q, got %q", w, g)
+	}
+}
+
 type appendMatchingPropertiesTestCase struct {
 	name   string
 	dst    []interface{}
@@ -0,0 +1,113 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// PropertiesToJSON returns the values of a list of property structs as an indented JSON list with an object
+// for each struct that maps property names to values.  Unexported fields are skipped and the fields of embedded
+// structs are merged into the embedding struct.  The value of a field tagged `blueprint:"mutated"` is wrapped in
+// an object of the form {"mutated": true, "value": ...}, as it can only have been set by Go code.  A configurable
+// property that doesn't depend on the configuration is encoded as its value, and one that does is encoded as an
+// object of the form {"select": "..."} containing the property in Blueprints file syntax.
+func PropertiesToJSON(props []interface{}) ([]byte, error) {
+	var result []map[string]any
+	for _, prop := range props {
+		propValue := reflect.ValueOf(prop)
+		if !isStructPtr(propValue.Type()) {
+			return nil, fmt.Errorf("properties must be *struct, got %s", propValue.Type())
+		}
+		out := make(map[string]any)
+		if err := structToJSONValue(propValue.Elem(), out); err != nil {
+			return nil, err
+		}
+		result = append(result, out)
+	}
+	return json.MarshalIndent(result, "", "  ")
+}
+
+func structToJSONValue(structValue reflect.Value, out map[string]any) error {
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" {
+			// This is an unexported field, so just skip it.
+			continue
+		}
+		fieldValue := structValue.Field(i)
+
+		if IsEmbedded(field) {
+			for fieldValue.Kind() == reflect.Interface || fieldValue.Kind() == reflect.Ptr {
+				if fieldValue.IsNil() {
+					break
+				}
+				fieldValue = fieldValue.Elem()
+			}
+			if fieldValue.Kind() == reflect.Struct {
+				if err := structToJSONValue(fieldValue, out); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		value, err := fieldToJSONValue(fieldValue)
+		if err != nil {
+			return fmt.Errorf("%s: %w", PropertyNameForField(field.Name), err)
+		}
+		if HasTag(field, "blueprint", "mutated") {
+			value = map[string]any{"mutated": true, "value": value}
+		}
+		out[PropertyNameForField(field.Name)] = value
+	}
+	return nil
+}
+
+func fieldToJSONValue(value reflect.Value) (any, error) {
+	if isConfigurable(value.Type()) {
+		return value.Interface().(configurableReflection).jsonValue()
+	}
+
+	switch value.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if value.IsNil() {
+			return nil, nil
+		}
+		return fieldToJSONValue(value.Elem())
+	case reflect.Struct:
+		out := make(map[string]any)
+		err := structToJSONValue(value, out)
+		return out, err
+	case reflect.Slice:
+		if value.IsNil() {
+			return nil, nil
+		}
+		list := make([]any, value.Len())
+		for i := range list {
+			var err error
+			list[i], err = fieldToJSONValue(value.Index(i))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	default:
+		return value.Interface(), nil
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proptools
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+type JSONTestEmbedded struct {
+	Embedded_prop *string
+}
+
+func TestPropertiesToJSON(t *testing.T) {
+	testCases := []struct {
+		name  string
+		props []interface{}
+		want  string
+	}{
+		{
+			name: "simple",
+			props: []interface{}{
+				&struct {
+					S      *string
+					B      bool
+					L      []string
+					Unset  *int64
+					Nested struct {
+						I int64
+					}
+					unexported string
+				}{
+					S:          StringPtr("foo"),
+					B:          true,
+					L:          []string{"a", "b"},
+					unexported: "bar",
+				},
+			},
+			want: `[{"b": true, "l": ["a", "b"], "nested": {"i": 0}, "s": "foo", "unset": null}]`,
+		},
+		{
+			name: "embedded",
+			props: []interface{}{
+				&struct {
+					JSONTestEmbedded
+					Outer_prop *string
+				}{
+					JSONTestEmbedded: JSONTestEmbedded{Embedded_prop: StringPtr("embedded")},
+					Outer_prop:       StringPtr("outer"),
+				},
+			},
+			want: `[{"embedded_prop": "embedded", "outer_prop": "outer"}]`,
+		},
+		{
+			name: "mutated",
+			props: []interface{}{
+				&struct {
+					Mutated string `blueprint:"mutated"`
+				}{
+					Mutated: "foo",
+				},
+				&struct {
+					Other *bool
+				}{},
+			},
+			want: `[{"mutated": {"mutated": true, "value": "foo"}}, {"other": null}]`,
+		},
+		{
+			name: "configurable",
+			props: []interface{}{
+				&struct {
+					Simple     Configurable[string]
+					Unset      Configurable[[]string]
+					Select     Configurable[string]
+					Empty_list Configurable[[]string]
+				}{
+					Simple: NewSimpleConfigurable("foo"),
+					Unset:  NewConfigurable[[]string](nil, nil),
+					Select: NewConfigurable(
+						[]ConfigurableCondition{NewConfigurableCondition("soong_config_variable", []string{"my_namespace", "my_variable"})},
+						[]ConfigurableCase[string]{
+							NewConfigurableCase([]ConfigurablePattern{NewStringConfigurablePattern("a")}, StringPtr("bar")),
+							NewConfigurableCase([]ConfigurablePattern{NewDefaultConfigurablePattern()}, StringPtr("baz")),
+						}),
+					Empty_list: NewSimpleConfigurable([]string{}),
+				},
+			},
+			want: `[{
+				"empty_list": [],
+				"select": {"select": "select(soong_config_variable(\"my_namespace\", \"my_variable\"), {\n    \"a\": \"bar\",\n    default: \"baz\",\n})"},
+				"simple": "foo",
+				"unset": null
+			}]`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := PropertiesToJSON(tc.props)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			var want bytes.Buffer
+			if err := json.Indent(&want, []byte(tc.want), "", "  "); err != nil {
+				t.Fatalf("invalid expected JSON: %s", err)
+			}
+			var gotCompact, wantCompact bytes.Buffer
+			json.Compact(&gotCompact, got)
+			json.Compact(&wantCompact, want.Bytes())
+			if gotCompact.String() != wantCompact.String() {
+				t.Errorf("unexpected JSON\nwant: %s\n got: %s", want.String(), got)
+			}
+		})
+	}
+}
+
+func TestPropertiesToJSONErrors(t *testing.T) {
+	_, err := PropertiesToJSON([]interface{}{struct{}{}})
+	if err == nil {
+		t.Errorf("expected error for non-pointer property struct")
+	}
+}
@@ -39,6 +39,20 @@ func HasTag(field reflect.StructField, name, value string) bool {
 	return false
 }
 
+// deprecatedTag returns the message of a `blueprint:"deprecated"` or `blueprint:"deprecated:<message>"` tag on a
+// StructField, and whether the field has the tag.
+func deprecatedTag(field reflect.StructField) (string, bool) {
+	for _, value := range strings.Split(field.Tag.Get("blueprint"), ",") {
+		if value == "deprecated" {
+			return "", true
+		}
+		if message, ok := strings.CutPrefix(value, "deprecated:"); ok {
+			return message, true
+		}
+	}
+	return "", false
+}
+
 // PropertyIndexesWithTag returns the indexes of all properties (in the form used by reflect.Value.FieldByIndex) that
 // are tagged with the given key and value, including ones found in embedded structs or pointers to structs.
 func PropertyIndexesWithTag(ps interface{}, key, value string) [][]int {
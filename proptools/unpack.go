@@ -47,6 +47,7 @@ type packedProperty struct {
 type unpackContext struct {
 	propertyMap map[string]*packedProperty
 	errs        []error
+	warnings    []error
 }
 
 // UnpackProperties populates the list of runtime values ("property structs") from the parsed properties.
@@ -71,10 +72,20 @@ type unpackContext struct {
 // The same property can initialize fields in multiple runtime values. It is an error if any property
 // value was not used to initialize at least one field.
 func UnpackProperties(properties []*parser.Property, objects ...interface{}) (map[string]*parser.Property, []error) {
+	result, _, errs := UnpackPropertiesWithWarnings(properties, objects...)
+	return result, errs
+}
+
+// UnpackPropertiesWithWarnings is like UnpackProperties, but also returns an *UnpackError warning for
+// each property that was set on a field tagged `blueprint:"deprecated:<message>"`.  The deprecated
+// properties are unpacked normally.  The message is optional, and can't contain commas.
+func UnpackPropertiesWithWarnings(properties []*parser.Property,
+	objects ...interface{}) (map[string]*parser.Property, []error, []error) {
+
 	var unpackContext unpackContext
 	unpackContext.propertyMap = make(map[string]*packedProperty)
 	if !unpackContext.buildPropertyMap("", properties) {
-		return nil, unpackContext.errs
+		return nil, nil, unpackContext.errs
 	}
 
 	for _, obj := range objects {
@@ -85,7 +96,7 @@ func UnpackProperties(properties []*parser.Property, objects ...interface{}) (ma
 		}
 		unpackContext.unpackToStruct("", valueObject.Elem())
 		if len(unpackContext.errs) >= maxUnpackErrors {
-			return nil, unpackContext.errs
+			return nil, nil, unpackContext.errs
 		}
 	}
 
@@ -101,9 +112,9 @@ func UnpackProperties(properties []*parser.Property, objects ...interface{}) (ma
 		}
 	}
 	if len(unusedNames) == 0 && len(unpackContext.errs) == 0 {
-		return result, nil
+		return result, unpackContext.warnings, nil
 	}
-	return nil, unpackContext.reportUnusedNames(unusedNames)
+	return nil, nil, unpackContext.reportUnusedNames(unusedNames)
 }
 
 func (ctx *unpackContext) reportUnusedNames(unusedNames []string) []error {
@@ -301,6 +312,14 @@ func (ctx *unpackContext) unpackToStruct(namePrefix string, structValue reflect.
 			continue
 		}
 
+		if message, ok := deprecatedTag(field); ok {
+			err := fmt.Errorf("property %q is deprecated", propertyName)
+			if message != "" {
+				err = fmt.Errorf("%w: %s", err, message)
+			}
+			ctx.warnings = append(ctx.warnings, &UnpackError{err, property.ColonPos})
+		}
+
 		if isConfigurable(fieldValue.Type()) {
 			// configurableType is the reflect.Type representation of a Configurable[whatever],
 			// while configuredType is the reflect.Type of the "whatever".
@@ -1409,6 +1409,55 @@ func TestUnpackErrors(t *testing.T) {
 	}
 }
 
+func TestUnpackWarnings(t *testing.T) {
+	r := bytes.NewBufferString(`
+		m {
+			dep: ["a"],
+			nested: {
+				old: true,
+			},
+			deps: ["b"],
+		}
+	`)
+	file, errs := parser.ParseAndEval("", r, parser.NewScope(nil))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected parse errors: %q", errs)
+	}
+
+	props := &struct {
+		Dep    []string `blueprint:"deprecated:use deps instead"`
+		Deps   []string
+		Nested struct {
+			Old *bool `blueprint:"deprecated"`
+		}
+		Unset string `blueprint:"deprecated:unused"`
+	}{}
+
+	_, warnings, errs := UnpackPropertiesWithWarnings(file.Defs[0].(*parser.Module).Properties, props)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %q", errs)
+	}
+
+	var got []string
+	for _, warning := range warnings {
+		got = append(got, warning.Error())
+	}
+	want := []string{
+		`<input>:3:7: property "dep" is deprecated: use deps instead`,
+		`<input>:5:8: property "nested.old" is deprecated`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected warnings %q, got %q", want, got)
+	}
+
+	if g, w := props.Dep, []string{"a"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected deprecated property to be unpacked as %q, got %q", w, g)
+	}
+	if props.Nested.Old == nil || !*props.Nested.Old {
+		t.Errorf("expected deprecated nested property to be unpacked")
+	}
+}
+
 func BenchmarkUnpackProperties(b *testing.B) {
 	run := func(b *testing.B, props []interface{}, input string) {
 		b.ReportAllocs()
@@ -255,18 +255,15 @@ var _ OtherModuleProviderContext = BottomUpMutatorContext(nil)
 var _ OtherModuleProviderContext = TopDownMutatorContext(nil)
 
 // OtherModuleProvider reads the provider for the given module.  If the provider has been set the value is
-// returned and the boolean is true.  If it has not been set the zero value of the provider's type  is returned
-// and the boolean is false.  The value returned may be a deep copy of the value originally passed to SetProvider.
+// returned and the boolean is true.  If it has not been set, or it was set through the untyped SetProvider
+// method to a value of a different type, the zero value of the provider's type  is returned and the boolean
+// is false.  The value returned may be a deep copy of the value originally passed to SetProvider.
 //
 // OtherModuleProviderContext is a helper interface that accepts ModuleContext, BottomUpMutatorContext, or
 // TopDownMutatorContext.
 func OtherModuleProvider[K any](ctx OtherModuleProviderContext, module Module, provider ProviderKey[K]) (K, bool) {
 	value, ok := ctx.OtherModuleProvider(module, provider)
-	if !ok {
-		var k K
-		return k, false
-	}
-	return value.(K), ok
+	return typedProviderValue[K](value, ok)
 }
 
 // SingletonModuleProviderContext is a helper interface that is a subset of Context and SingletonContext for use in
@@ -279,17 +276,50 @@ var _ SingletonModuleProviderContext = &Context{}
 var _ SingletonModuleProviderContext = SingletonContext(nil)
 
 // SingletonModuleProvider reads the provider for the given module.  If the provider has been set the value is
-// returned and the boolean is true.  If it has not been set the zero value of the provider's type  is returned
-// and the boolean is false.  The value returned may be a deep copy of the value originally passed to SetProvider.
+// returned and the boolean is true.  If it has not been set, or it was set through the untyped SetProvider
+// method to a value of a different type, the zero value of the provider's type  is returned and the boolean
+// is false.  The value returned may be a deep copy of the value originally passed to SetProvider.
 //
 // SingletonModuleProviderContext is a helper interface that accepts Context or SingletonContext.
 func SingletonModuleProvider[K any](ctx SingletonModuleProviderContext, module Module, provider ProviderKey[K]) (K, bool) {
 	value, ok := ctx.ModuleProvider(module, provider)
-	if !ok {
-		var k K
-		return k, false
-	}
-	return value.(K), ok
+	return typedProviderValue[K](value, ok)
+}
+
+// MergedProviderContext is a helper interface that is a subset of Context and SingletonContext for use in
+// MergedProvider.
+type MergedProviderContext interface {
+	SingletonModuleProviderContext
+	VisitAllModuleVariants(module Module, visit func(Module))
+}
+
+var _ MergedProviderContext = &Context{}
+var _ MergedProviderContext = SingletonContext(nil)
+
+// MergedProvider reads the provider for every variant of the given module and folds the values that have been set
+// into one with merge, in the order the variants are visited by VisitAllModuleVariants.  If the provider has been
+// set on only one variant its value is returned without calling merge.  If it has not been set on any variant the
+// zero value of the provider's type is returned and the boolean is false.  The values passed to merge may be deep
+// copies of the values originally passed to SetProvider, and should not be modified.
+//
+// MergedProviderContext is a helper interface that accepts Context or SingletonContext.
+func MergedProvider[K any](ctx MergedProviderContext, module Module, provider ProviderKey[K],
+	merge func(a, b K) K) (K, bool) {
+
+	var merged K
+	found := false
+	ctx.VisitAllModuleVariants(module, func(variant Module) {
+		value, ok := SingletonModuleProvider(ctx, variant, provider)
+		if !ok {
+			return
+		}
+		if found {
+			merged = merge(merged, value)
+		} else {
+			merged, found = value, true
+		}
+	})
+	return merged, found
 }
 
 // ModuleProviderContext is a helper interface that is a subset of ModuleContext, BottomUpMutatorContext, or
@@ -304,18 +334,26 @@ var _ ModuleProviderContext = BottomUpMutatorContext(nil)
 var _ ModuleProviderContext = TopDownMutatorContext(nil)
 
 // ModuleProvider reads the provider for the current module.  If the provider has been set the value is
-// returned and the boolean is true.  If it has not been set the zero value of the provider's type  is returned
-// and the boolean is false.  The value returned may be a deep copy of the value originally passed to SetProvider.
+// returned and the boolean is true.  If it has not been set, or it was set through the untyped SetProvider
+// method to a value of a different type, the zero value of the provider's type  is returned and the boolean
+// is false.  The value returned may be a deep copy of the value originally passed to SetProvider.
 //
 // ModuleProviderContext is a helper interface that accepts ModuleContext, BottomUpMutatorContext, or
 // TopDownMutatorContext.
 func ModuleProvider[K any](ctx ModuleProviderContext, provider ProviderKey[K]) (K, bool) {
 	value, ok := ctx.Provider(provider)
+	return typedProviderValue[K](value, ok)
+}
+
+// typedProviderValue converts a value returned by one of the untyped provider methods to the type of
+// the provider, returning the zero value and false if it is not set or has a different type.
+func typedProviderValue[K any](value any, ok bool) (K, bool) {
 	if !ok {
 		var k K
 		return k, false
 	}
-	return value.(K), ok
+	k, ok := value.(K)
+	return k, ok
 }
 
 // SetProviderContext is a helper interface that is a subset of ModuleContext, BottomUpMutatorContext, or
@@ -17,8 +17,10 @@ package blueprint
 import (
 	"fmt"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 )
 
 type providerTestModule struct {
@@ -50,6 +52,7 @@ var providerTestMutatorInfoProvider = NewMutatorProvider[*providerTestMutatorInf
 var providerTestGenerateBuildActionsInfoProvider = NewProvider[*providerTestGenerateBuildActionsInfo]()
 var providerTestUnsetInfoProvider = NewMutatorProvider[providerTestUnsetInfo]("provider_mutator")
 var providerTestUnusedMutatorProvider = NewMutatorProvider[*struct{ unused string }]("nonexistent_mutator")
+var providerTestMismatchedTypeProvider = NewMutatorProvider[string]("provider_mutator")
 
 func (p *providerTestModule) GenerateBuildActions(ctx ModuleContext) {
 	unset, ok := ModuleProvider(ctx, providerTestUnsetInfoProvider)
@@ -100,11 +103,23 @@ func providerTestMutator(ctx BottomUpMutatorContext) {
 	SetProvider(ctx, providerTestMutatorInfoProvider, &providerTestMutatorInfo{
 		Values: values,
 	})
+
+	// Set a value of the wrong type through the untyped method.
+	ctx.SetProvider(providerTestMismatchedTypeProvider, 42)
 }
 
 func providerTestAfterMutator(ctx BottomUpMutatorContext) {
 	// Verify reading providerTestUnusedMutatorProvider doesn't panic
 	_, _ = ModuleProvider(ctx, providerTestMutatorInfoProvider)
+
+	// Verify reading a value of the wrong type returns false instead of panicking, but can still be
+	// read through the untyped method.
+	if v, ok := ModuleProvider(ctx, providerTestMismatchedTypeProvider); ok || v != "" {
+		panic(fmt.Errorf("expected zero value and false for mismatched type, got %q, %t", v, ok))
+	}
+	if v, ok := ctx.Provider(providerTestMismatchedTypeProvider); !ok || v != 42 {
+		panic(fmt.Errorf("expected untyped value 42, got %v, %t", v, ok))
+	}
 }
 
 func TestProviders(t *testing.T) {
@@ -169,6 +184,150 @@ func TestProviders(t *testing.T) {
 	}
 }
 
+var parallelGenerateTestProvider = NewProvider[string]()
+
+type parallelGenerateTestModule struct {
+	SimpleName
+	properties struct {
+		Deps          []string
+		Wait_for      string
+		Read_provider string
+	}
+	started map[string]chan struct{}
+	modules map[string]Module
+}
+
+func (p *parallelGenerateTestModule) GenerateBuildActions(ctx ModuleContext) {
+	close(p.started[ctx.ModuleName()])
+	SetProvider(ctx, parallelGenerateTestProvider, ctx.ModuleName())
+	if p.properties.Read_provider != "" {
+		ctx.OtherModuleProvider(p.modules[p.properties.Read_provider], parallelGenerateTestProvider)
+	}
+	if p.properties.Wait_for != "" {
+		select {
+		case <-p.started[p.properties.Wait_for]:
+		case <-time.After(10 * time.Second):
+			ctx.ModuleErrorf("timed out waiting for %s to start GenerateBuildActions", p.properties.Wait_for)
+		}
+	}
+}
+
+func parallelGenerateTestDepsMutator(ctx BottomUpMutatorContext) {
+	if p, ok := ctx.Module().(*parallelGenerateTestModule); ok {
+		ctx.AddDependency(ctx.Module(), nil, p.properties.Deps...)
+	}
+}
+
+func TestParallelGenerateBuildActions(t *testing.T) {
+	t.Run("providers", func(t *testing.T) {
+		ctx := NewContext()
+		ctx.RegisterModuleType("provider_module", newProviderTestModule)
+		ctx.RegisterBottomUpMutator("provider_deps_mutator", providerTestDepsMutator)
+		ctx.RegisterBottomUpMutator("provider_mutator", providerTestMutator)
+		ctx.SetParallelGenerateBuildActions(true)
+
+		bp := &strings.Builder{}
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(bp, "provider_module { name: \"m%d\", deps: [\"m%d\"] }\n", i, i+1)
+		}
+		fmt.Fprintf(bp, "provider_module { name: \"m100\" }\n")
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(bp.String()),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.PrepareBuildActions(nil)
+		assertNoErrors(t, errs)
+
+		for i := 0; i < 100; i++ {
+			m := ctx.moduleGroupFromName(fmt.Sprintf("m%d", i), nil).moduleByVariantName("").logicModule.(*providerTestModule)
+			if g, w := m.generateBuildActionsProviderValues, []string{fmt.Sprintf("m%d", i+1)}; !reflect.DeepEqual(g, w) {
+				t.Errorf("expected m%d.generateBuildActionsProviderValues %q, got %q", i, w, g)
+			}
+		}
+	})
+
+	t.Run("unordered", func(t *testing.T) {
+		started := map[string]chan struct{}{
+			"A": make(chan struct{}),
+			"B": make(chan struct{}),
+		}
+		ctx := NewContext()
+		ctx.RegisterModuleType("parallel_module", func() (Module, []interface{}) {
+			m := &parallelGenerateTestModule{started: started}
+			return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+		})
+		ctx.RegisterBottomUpMutator("deps", parallelGenerateTestDepsMutator)
+		ctx.SetParallelGenerateBuildActions(true)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				parallel_module {
+					name: "A",
+					deps: ["B"],
+				}
+
+				parallel_module {
+					name: "B",
+					wait_for: "A",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.PrepareBuildActions(nil)
+		assertNoErrors(t, errs)
+	})
+
+	t.Run("not a dependency", func(t *testing.T) {
+		started := map[string]chan struct{}{
+			"A": make(chan struct{}),
+			"B": make(chan struct{}),
+		}
+		modules := make(map[string]Module)
+		ctx := NewContext()
+		ctx.RegisterModuleType("parallel_module", func() (Module, []interface{}) {
+			m := &parallelGenerateTestModule{started: started, modules: modules}
+			return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+		})
+		ctx.RegisterBottomUpMutator("deps", parallelGenerateTestDepsMutator)
+		ctx.SetParallelGenerateBuildActions(true)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				parallel_module {
+					name: "A",
+					read_provider: "B",
+				}
+
+				parallel_module {
+					name: "B",
+					read_provider: "A",
+				}
+			`),
+		})
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies(nil)
+		assertNoErrors(t, errs)
+		for _, name := range []string{"A", "B"} {
+			modules[name] = ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule
+		}
+
+		// Waiting for each other's providers would deadlock, so both modules report an error.
+		_, errs = ctx.PrepareBuildActions(nil)
+		if len(errs) != 2 {
+			t.Fatalf("expected 2 errors, got %q", errs)
+		}
+		for _, err := range errs {
+			if !strings.Contains(err.Error(), "which is not a direct or transitive dependency") {
+				t.Errorf("unexpected error %q", err)
+			}
+		}
+	})
+}
+
 type invalidProviderUsageMutatorInfo string
 type invalidProviderUsageGenerateBuildActionsInfo string
 
@@ -423,3 +582,65 @@ func TestInvalidProvidersUsage(t *testing.T) {
 		})
 	}
 }
+
+type mergedProviderTestModule struct {
+	SimpleName
+}
+
+var mergedProviderTestProvider = NewProvider[[]string]()
+
+func (m *mergedProviderTestModule) GenerateBuildActions(ctx ModuleContext) {
+	if ctx.ModuleSubDir() != "x86" {
+		SetProvider(ctx, mergedProviderTestProvider, []string{ctx.ModuleName() + "." + ctx.ModuleSubDir()})
+	}
+}
+
+func TestMergedProvider(t *testing.T) {
+	ctx := newContext()
+	ctx.RegisterModuleType("merged_provider_module", func() (Module, []interface{}) {
+		m := &mergedProviderTestModule{}
+		return m, []interface{}{&m.SimpleName.Properties}
+	})
+	ctx.RegisterTransitionMutator("transition", configTransitionMutator{})
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			merged_provider_module {
+				name: "A",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	if len(errs) == 0 {
+		_, errs = ctx.ResolveDependencies([]string{"arm", "x86", "arm64"})
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	assertNoErrors(t, errs)
+
+	module := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule
+	merges := 0
+	merged, ok := MergedProvider(ctx, module, mergedProviderTestProvider, func(a, b []string) []string {
+		merges++
+		return append(slices.Clone(a), b...)
+	})
+	if !ok {
+		t.Fatalf("expected merged provider to be set")
+	}
+	if g, w := merged, []string{"A.arm", "A.arm64"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected merged provider %q, got %q", w, g)
+	}
+	if merges != 1 {
+		t.Errorf("expected merge to be called once, got %d", merges)
+	}
+
+	unset, ok := MergedProvider(ctx, module, providerTestGenerateBuildActionsInfoProvider,
+		func(a, b *providerTestGenerateBuildActionsInfo) *providerTestGenerateBuildActionsInfo {
+			t.Errorf("unexpected call to merge")
+			return a
+		})
+	if ok || unset != nil {
+		t.Errorf("expected zero value and false for unset provider, got %v, %t", unset, ok)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// A ModuleSchema describes the properties that can be set on a module type in a Blueprints file.
+type ModuleSchema struct {
+	// Properties lists the properties in the order they are declared in the property structs returned by the
+	// module factory.
+	Properties []PropertySchema
+}
+
+// A PropertySchema describes a single property of a module type.
+type PropertySchema struct {
+	// Name is the name of the property in a Blueprints file.  Properties inside struct properties are named
+	// with the names of the enclosing properties separated by '.', for example "target.android.srcs".
+	Name string
+
+	// Type is the Go type of the field that holds the property.
+	Type reflect.Type
+
+	// Tags are the values of the `blueprint` struct tag of the field, if any.
+	Tags []string
+}
+
+// ModuleTypeSchemas returns a mapping from module type name to the schema of the properties accepted by that
+// module type, derived from the property structs returned by its factory.  Properties in embedded structs are
+// included as if they were declared in the embedding struct, and properties tagged `blueprint:"mutated"` are
+// omitted as they cannot be set in a Blueprints file.
+func (c *Context) ModuleTypeSchemas() map[string]ModuleSchema {
+	ret := make(map[string]ModuleSchema, len(c.moduleFactories))
+	for moduleType, factory := range c.moduleFactories {
+		_, props := factory()
+		var schema ModuleSchema
+		seen := make(map[string]bool)
+		for _, prop := range props {
+			schema.Properties = appendPropertySchemas(schema.Properties, seen, reflect.ValueOf(prop).Elem(), "")
+		}
+		ret[moduleType] = schema
+	}
+	return ret
+}
+
+// appendPropertySchemas appends the schemas of the properties in structValue, prefixed with prefix, to schemas.
+// Properties that are already in seen are skipped, as the same property may appear in multiple property structs.
+func appendPropertySchemas(schemas []PropertySchema, seen map[string]bool, structValue reflect.Value,
+	prefix string) []PropertySchema {
+
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if proptools.ShouldSkipProperty(field) {
+			continue
+		}
+
+		name := prefix
+		if !proptools.IsEmbedded(field) {
+			name += proptools.PropertyNameForField(field.Name)
+		}
+
+		fieldValue := structValue.Field(i)
+		for fieldValue.Kind() == reflect.Interface || fieldValue.Kind() == reflect.Ptr {
+			if !fieldValue.IsNil() {
+				fieldValue = fieldValue.Elem()
+			} else if fieldValue.Kind() == reflect.Ptr && fieldValue.Type().Elem().Kind() == reflect.Struct {
+				fieldValue = reflect.New(fieldValue.Type().Elem()).Elem()
+			} else {
+				break
+			}
+		}
+
+		if fieldValue.Kind() == reflect.Struct && !proptools.IsConfigurable(fieldValue.Type()) {
+			if !proptools.IsEmbedded(field) {
+				name += "."
+			}
+			schemas = appendPropertySchemas(schemas, seen, fieldValue, name)
+			continue
+		}
+
+		if proptools.IsEmbedded(field) || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		var tags []string
+		if tag := field.Tag.Get("blueprint"); tag != "" {
+			tags = strings.Split(tag, ",")
+		}
+		schemas = append(schemas, PropertySchema{
+			Name: name,
+			Type: field.Type,
+			Tags: tags,
+		})
+	}
+	return schemas
+}
@@ -0,0 +1,81 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+)
+
+type schemaTestModule struct {
+	SimpleName
+	properties struct {
+		Srcs   proptools.Configurable[[]string]
+		Cflags []string `blueprint:"replace,deprecated"`
+		Target struct {
+			Host *struct {
+				Enabled *bool
+			}
+		}
+		Installed bool `blueprint:"mutated"`
+	}
+	embeddedProperties struct {
+		SchemaTestEmbedded
+		Name *string
+	}
+}
+
+type SchemaTestEmbedded struct {
+	Stem *string
+}
+
+func newSchemaTestModule() (Module, []interface{}) {
+	m := &schemaTestModule{}
+	return m, []interface{}{&m.SimpleName.Properties, &m.properties, &m.embeddedProperties}
+}
+
+func (m *schemaTestModule) GenerateBuildActions(ModuleContext) {}
+
+func TestModuleTypeSchemas(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("schema_module", newSchemaTestModule)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	schemas := ctx.ModuleTypeSchemas()
+	if g, w := len(schemas), 2; g != w {
+		t.Fatalf("expected %d module types, got %d: %v", w, g, schemas)
+	}
+
+	want := []PropertySchema{
+		{Name: "name", Type: reflect.TypeOf("")},
+		{Name: "srcs", Type: reflect.TypeOf(proptools.Configurable[[]string]{})},
+		{Name: "cflags", Type: reflect.TypeOf([]string(nil)), Tags: []string{"replace", "deprecated"}},
+		{Name: "target.host.enabled", Type: reflect.TypeOf((*bool)(nil))},
+		{Name: "stem", Type: reflect.TypeOf((*string)(nil))},
+	}
+	if g := schemas["schema_module"].Properties; !reflect.DeepEqual(g, want) {
+		t.Errorf("incorrect schema for schema_module\nwant: %v\n got: %v", want, g)
+	}
+
+	var names []string
+	for _, prop := range schemas["foo_module"].Properties {
+		names = append(names, prop.Name)
+	}
+	if g, w := names, []string{"deps", "ignored_deps", "name"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("expected foo_module properties %q, got %q", w, g)
+	}
+}
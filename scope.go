@@ -16,6 +16,7 @@ package blueprint
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -247,6 +248,11 @@ func (s *basicScope) AddRule(r Rule) error {
 type localScope struct {
 	namePrefix string
 	scope      *basicScope
+
+	// globals holds the variables set by Context.SetNinjaVariable, and globalsScope is the scope
+	// that makes them visible between the local scope and the current package scope.
+	globals      *basicScope
+	globalsScope *basicScope
 }
 
 func newLocalScope(parent *basicScope, namePrefix string) *localScope {
@@ -261,7 +267,17 @@ func newLocalScope(parent *basicScope, namePrefix string) *localScope {
 // a function defined in a different Go package and have that function retain
 // access to all of the package-scoped variables of its own package.
 func (s *localScope) ReparentTo(pctx PackageContext) {
-	s.scope.parent = pctx.getScope()
+	parent := pctx.getScope()
+	if s.globals != nil {
+		if s.globalsScope == nil || s.globalsScope.parent != parent {
+			s.globalsScope = &basicScope{
+				parent:    parent,
+				variables: s.globals.variables,
+			}
+		}
+		parent = s.globalsScope
+	}
+	s.scope.parent = parent
 }
 
 func (s *localScope) LookupVariable(name string) (Variable, error) {
@@ -276,6 +292,20 @@ func (s *localScope) IsPoolVisible(pool Pool) bool {
 	return s.scope.IsPoolVisible(pool)
 }
 
+// addSharedPool makes p visible in the scope under its name.
+func (s *localScope) addSharedPool(p *sharedPool) error {
+	if s.scope.pools[p.name_] == p {
+		return nil
+	}
+	return s.scope.AddPool(p)
+}
+
+// replaceLocalRule makes r visible in the scope under its name in place of the rule that was
+// previously added with that name.
+func (s *localScope) replaceLocalRule(r *localRule) {
+	s.scope.rules[r.name_] = r
+}
+
 func (s *localScope) AddLocalVariable(name, value string) (*localVariable,
 	error) {
 
@@ -348,6 +378,60 @@ func (s *localScope) AddLocalRule(name string, params *RuleParams,
 	return r, nil
 }
 
+// A globalVariable is a variable set by Context.SetNinjaVariable.  It is written to the top level
+// of the ninja file under its own name.
+type globalVariable struct {
+	name_  string
+	value_ *ninjaString
+}
+
+func (g *globalVariable) packageContext() *packageContext {
+	return nil
+}
+
+func (g *globalVariable) name() string {
+	return g.name_
+}
+
+func (g *globalVariable) fullName(pkgNames map[*packageContext]string) string {
+	return g.name_
+}
+
+func (g *globalVariable) value(VariableFuncContext, interface{}) (*ninjaString, error) {
+	return g.value_, nil
+}
+
+func (g *globalVariable) String() string {
+	return "<global var>:" + g.name_
+}
+
+// A sharedPool is a pool created by ModuleContext.Pool.  Every module that creates a pool with the
+// same name gets the same sharedPool, which is written to the ninja file once under its own name.
+type sharedPool struct {
+	name_ string
+	def_  *poolDef
+}
+
+func (p *sharedPool) packageContext() *packageContext {
+	return nil
+}
+
+func (p *sharedPool) name() string {
+	return p.name_
+}
+
+func (p *sharedPool) fullName(pkgNames map[*packageContext]string) string {
+	return p.name_
+}
+
+func (p *sharedPool) def(config interface{}) (*poolDef, error) {
+	return p.def_, nil
+}
+
+func (p *sharedPool) String() string {
+	return "<shared pool>:" + p.name_
+}
+
 type localVariable struct {
 	fullName_ string
 	name_     string
@@ -410,6 +494,60 @@ func (r *localRule) String() string {
 	return "<local rule>:" + r.fullName_
 }
 
+// internKey returns a string that identifies the name and definition of the rule, so that identical
+// rules defined by different modules can be shared.  It returns false if the rule references
+// variables that are local to its module.
+func (r *localRule) internKey() (string, bool) {
+	sb := &strings.Builder{}
+	sb.WriteString(r.name_)
+
+	argNames := make([]string, 0, len(r.argNames))
+	for argName := range r.argNames {
+		argNames = append(argNames, argName)
+	}
+	sort.Strings(argNames)
+	fmt.Fprintf(sb, "\x00%q\x00%q", argNames, r.def_.Comment)
+
+	if r.def_.Pool != nil {
+		fmt.Fprintf(sb, "\x00pool=%s", r.def_.Pool)
+	}
+
+	writeNinjaStrings := func(label string, strs []*ninjaString) bool {
+		sb.WriteString("\x00" + label)
+		for _, str := range strs {
+			sb.WriteString("\x00" + str.str)
+			if str.variables == nil {
+				continue
+			}
+			for _, ref := range *str.variables {
+				if _, ok := ref.variable.(*localVariable); ok {
+					return false
+				}
+				fmt.Fprintf(sb, "\x00%d:%d:%v", ref.start, ref.end, ref.variable)
+			}
+		}
+		return true
+	}
+
+	if !writeNinjaStrings("deps", r.def_.CommandDeps) ||
+		!writeNinjaStrings("order_only", r.def_.CommandOrderOnly) {
+		return "", false
+	}
+
+	variableNames := make([]string, 0, len(r.def_.Variables))
+	for name := range r.def_.Variables {
+		variableNames = append(variableNames, name)
+	}
+	sort.Strings(variableNames)
+	for _, name := range variableNames {
+		if !writeNinjaStrings(name, []*ninjaString{r.def_.Variables[name]}) {
+			return "", false
+		}
+	}
+
+	return sb.String(), true
+}
+
 type nameTracker struct {
 	variables map[Variable]string
 	rules     map[Rule]string
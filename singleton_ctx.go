@@ -16,6 +16,7 @@ package blueprint
 
 import (
 	"fmt"
+	"reflect"
 
 	"github.com/google/blueprint/pathtools"
 )
@@ -41,6 +42,14 @@ type SingletonContext interface {
 	// more information.
 	ModuleSubDir(module Module) string
 
+	// ModuleVariantsForMutator returns the variations that the transition mutator with the given name created for
+	// the given module.  See Context.ModuleVariantsForMutator for more information.
+	ModuleVariantsForMutator(module Module, mutatorName string) []string
+
+	// ModuleVariations returns the variation of the given Module for each mutator, keyed by mutator name.  See
+	// Context.ModuleVariations for more information.
+	ModuleVariations(module Module) map[string]string
+
 	// ModuleType returns the type of the given Module.  See BaseModuleContext.ModuleType for more information.
 	ModuleType(module Module) string
 
@@ -54,6 +63,10 @@ type SingletonContext interface {
 	// GenerateBuildActions pass for the provider on the module.
 	ModuleProvider(module Module, provider AnyProviderKey) (any, bool)
 
+	// OutputFiles returns the output files declared by a module with ModuleContext.AddOutputFiles for the
+	// given tag.  It returns an error if the module did not declare any output files with the tag.
+	OutputFiles(module Module, tag string) ([]string, error)
+
 	// ModuleErrorf reports an error at the line number of the module type in the module definition.
 	ModuleErrorf(module Module, format string, args ...interface{})
 
@@ -106,6 +119,11 @@ type SingletonContext interface {
 	// true calls visit.
 	VisitAllModulesIf(pred func(Module) bool, visit func(Module))
 
+	// VisitAllModulesByType calls visit for each defined variant of each module that has the same
+	// concrete type as example.  The variants of a module are visited consecutively in variant order,
+	// so ModuleName, ModuleSubDir and ModuleVariations can be used to group them.
+	VisitAllModulesByType(example Module, visit func(Module))
+
 	// VisitDirectDeps calls visit for each direct dependency of the Module.  If there are
 	// multiple direct dependencies on the same module visit will be called multiple times on
 	// that module and OtherModuleDependencyTag will return a different tag for each.
@@ -123,6 +141,29 @@ type SingletonContext interface {
 	// function, it may be invalidated by future mutators.
 	VisitDirectDepsIf(module Module, pred func(Module) bool, visit func(Module))
 
+	// VisitDirectDepsWithTag calls visit for each direct dependency of the Module that was added
+	// with a dependency tag equal to tag.
+	//
+	// The Module passed to the visit function should not be retained outside of the visit
+	// function, it may be invalidated by future mutators.
+	VisitDirectDepsWithTag(module Module, tag DependencyTag, visit func(Module))
+
+	// VisitDirectDepsWithTagName calls visit for each direct dependency of the Module that was
+	// added with a dependency tag whose name, as registered with Context.RegisterDependencyTag or
+	// returned by NamedDependencyTag.Name, is name.
+	//
+	// The Module passed to the visit function should not be retained outside of the visit
+	// function, it may be invalidated by future mutators.
+	VisitDirectDepsWithTagName(module Module, name string, visit func(Module))
+
+	// VisitDirectReverseDeps calls visit for each module that has a direct dependency on the
+	// Module.  If a module has multiple direct dependencies on the Module visit will be called
+	// multiple times on that module.
+	//
+	// The Module passed to the visit function should not be retained outside of the visit
+	// function, it may be invalidated by future mutators.
+	VisitDirectReverseDeps(module Module, visit func(Module))
+
 	// VisitDepsDepthFirst calls visit for each transitive dependency, traversing the dependency tree in depth first
 	// order. visit will only be called once for any given module, even if there are multiple paths through the
 	// dependency tree to the module or multiple direct dependencies with different tags.
@@ -208,6 +249,14 @@ func (s *singletonContext) ModuleSubDir(logicModule Module) string {
 	return s.context.ModuleSubDir(getWrappedModule(logicModule))
 }
 
+func (s *singletonContext) ModuleVariantsForMutator(logicModule Module, mutatorName string) []string {
+	return s.context.ModuleVariantsForMutator(getWrappedModule(logicModule), mutatorName)
+}
+
+func (s *singletonContext) ModuleVariations(logicModule Module) map[string]string {
+	return s.context.ModuleVariations(getWrappedModule(logicModule))
+}
+
 func (s *singletonContext) ModuleType(logicModule Module) string {
 	return s.context.ModuleType(getWrappedModule(logicModule))
 }
@@ -216,6 +265,10 @@ func (s *singletonContext) ModuleProvider(logicModule Module, provider AnyProvid
 	return s.context.ModuleProvider(getWrappedModule(logicModule), provider)
 }
 
+func (s *singletonContext) OutputFiles(logicModule Module, tag string) ([]string, error) {
+	return s.context.ModuleOutputFiles(getWrappedModule(logicModule), tag)
+}
+
 func (s *singletonContext) BlueprintFile(logicModule Module) string {
 	return s.context.BlueprintFile(logicModule)
 }
@@ -347,6 +400,13 @@ func (s *singletonContext) VisitAllModulesIf(pred func(Module) bool,
 	s.context.VisitAllModulesIf(pred, visit)
 }
 
+func (s *singletonContext) VisitAllModulesByType(example Module, visit func(Module)) {
+	typ := reflect.TypeOf(example)
+	s.context.VisitAllModulesIf(func(m Module) bool {
+		return reflect.TypeOf(m) == typ
+	}, visit)
+}
+
 func (s *singletonContext) VisitDirectDeps(module Module, visit func(Module)) {
 	s.context.VisitDirectDeps(module, visit)
 }
@@ -355,6 +415,18 @@ func (s *singletonContext) VisitDirectDepsIf(module Module, pred func(Module) bo
 	s.context.VisitDirectDepsIf(module, pred, visit)
 }
 
+func (s *singletonContext) VisitDirectDepsWithTag(module Module, tag DependencyTag, visit func(Module)) {
+	s.context.VisitDirectDepsWithTag(module, tag, visit)
+}
+
+func (s *singletonContext) VisitDirectDepsWithTagName(module Module, name string, visit func(Module)) {
+	s.context.VisitDirectDepsWithTagName(module, name, visit)
+}
+
+func (s *singletonContext) VisitDirectReverseDeps(module Module, visit func(Module)) {
+	s.context.VisitDirectReverseDeps(module, visit)
+}
+
 func (s *singletonContext) VisitDepsDepthFirst(module Module,
 	visit func(Module)) {
 
@@ -15,9 +15,17 @@
 package blueprint
 
 import (
+	"bytes"
+	"encoding/gob"
 	"fmt"
+	"maps"
 	"slices"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/blueprint/proptools"
 )
 
 // TransitionMutator implements a top-down mechanism where a module tells its
@@ -103,11 +111,82 @@ type TransitionMutator interface {
 	Mutate(ctx BottomUpMutatorContext, variation string)
 }
 
+// A VariantSpec is a variation returned by TransitionMutatorWithData.SplitWithData along with
+// data attached to it.
+type VariantSpec struct {
+	// Name is the name of the variation.
+	Name string
+
+	// Data is arbitrary data attached to the variation.  It is returned by the SplitData method of
+	// the contexts passed to OutgoingTransition, IncomingTransition and Mutate for this variation.
+	Data any
+}
+
+// A VariantPropertiesModule is a Module with properties that only apply to some of the variants created by
+// a TransitionMutator, for example a "target: { arm64: { deps: [...] } }" property that only applies to the
+// "arm64" variation of an "arch" mutator.  Before Mutate is called on a variant, the property structs
+// returned by VariantProperties for the mutator and the variation of the variant are appended to the
+// property structs of the module with proptools.AppendMatchingProperties.  The variant specific values
+// therefore take precedence over the base values: lists are appended to the base lists, non-nil pointers
+// replace the base values and bools are OR-ed with them.  Every property in the returned structs must
+// also be in one of the property structs of the module.
+type VariantPropertiesModule interface {
+	Module
+
+	// VariantProperties returns the property structs that apply to the variation of the transition
+	// mutator named mutator, or nil if there are none.
+	VariantProperties(mutator, variation string) []interface{}
+}
+
+// TransitionMutatorWithData can be implemented by a TransitionMutator that needs to attach data,
+// for example a struct describing an architecture, to the variations it creates.  If it is
+// implemented SplitWithData is called instead of Split, and the data can be retrieved with
+// SplitData instead of maintaining a map from variation names to data.
+type TransitionMutatorWithData interface {
+	TransitionMutator
+
+	// SplitWithData is like Split, but returns data along with each variation.
+	SplitWithData(ctx BaseModuleContext) []VariantSpec
+}
+
+// TransitionMutatorWithVariantCreated can be implemented by a TransitionMutator that needs to
+// initialize the variants it creates before any mutator, including its own Mutate, is run on them.
+// OnVariantCreated is called once for each variant created from a module split by Split or
+// SplitWithData, and for each variant created on demand by an incoming transition.  It is not called
+// on modules that are not split.
+type TransitionMutatorWithVariantCreated interface {
+	TransitionMutator
+
+	// OnVariantCreated is called with the new variant of a module and the variation it was created for.
+	OnVariantCreated(ctx BaseModuleContext, variation string)
+}
+
+// TransitionMutatorWithDefault can be implemented by a TransitionMutator whose Split returns
+// several variations for a module, one of which should be used by dependencies that don't select
+// any of them.
+type TransitionMutatorWithDefault interface {
+	TransitionMutator
+
+	// DefaultVariation returns the variation that replaces the result of IncomingTransition for the
+	// module when IncomingTransition returns the empty variation or a variation that is not
+	// returned by Split, or nil to use the result of IncomingTransition unchanged.
+	DefaultVariation(ctx BaseModuleContext) *string
+}
+
 type IncomingTransitionContext interface {
 	// Module returns the target of the dependency edge for which the transition
 	// is being computed
 	Module() Module
 
+	// SourceModule returns the module that requested the dependency edge for which the transition
+	// is being computed, so that the target can choose its variation based on the properties of the
+	// module that depends on it.  For a variant created by
+	// BottomUpMutatorContext.AddReverseVariationDependencyCreatingVariant it is the module that added
+	// the reverse dependency.  The source module is being visited by a mutator in parallel, so it
+	// must not be modified, and only properties set before the transition mutator ran should be
+	// read from it.
+	SourceModule() Module
+
 	// Config returns the config object that was passed to
 	// Context.PrepareBuildActions.
 	Config() interface{}
@@ -126,6 +205,17 @@ type IncomingTransitionContext interface {
 	// to support creating variants on demand.
 	IsAddingDependency() bool
 
+	// SplitData returns the data that TransitionMutatorWithData.SplitWithData attached to the
+	// incoming variation of the target of the dependency edge, or nil if the target's split does
+	// not contain that variation or the mutator does not implement TransitionMutatorWithData.
+	SplitData() any
+
+	// SkipIncoming drops the dependency edge for which the transition is being computed instead of
+	// redirecting it to another variation.  The value returned by IncomingTransition is ignored and
+	// no variation is created for it.  The dependency is not visible to the depending module, and
+	// dependencies added to the same target after the mutator has run are dropped as well.
+	SkipIncoming()
+
 	// ModuleErrorf reports an error at the line number of the module type in the module definition.
 	ModuleErrorf(fmt string, args ...interface{})
 
@@ -139,9 +229,22 @@ type OutgoingTransitionContext interface {
 	Module() Module
 
 	// DepTag() Returns the dependency tag through which this dependency is
-	// reached
+	// reached.  For dependencies added with AddVariationDependencies or related methods
+	// after the mutator has run it is the tag passed to that method.  It is nil when
+	// called from OtherModuleDependencyVariantExists and related methods.
 	DepTag() DependencyTag
 
+	// DepName returns the name of the target of the dependency edge for which the transition is
+	// being computed.
+	DepName() string
+
+	// DepModule returns the target of the dependency edge for which the transition is being
+	// computed, as it was before the transition mutator ran on it, or nil if there is no such
+	// variant of the target.  The variant of the target that will be used for the dependency has
+	// not been chosen yet.  The target may be visited by a mutator in parallel, so it must not be
+	// modified.
+	DepModule() Module
+
 	// Config returns the config object that was passed to
 	// Context.PrepareBuildActions.
 	Config() interface{}
@@ -154,6 +257,11 @@ type OutgoingTransitionContext interface {
 	// This method shouldn't be used directly, prefer the type-safe android.ModuleProvider instead.
 	Provider(provider AnyProviderKey) (any, bool)
 
+	// SplitData returns the data that TransitionMutatorWithData.SplitWithData attached to the
+	// source variation of the source of the dependency edge, or nil if the mutator does not
+	// implement TransitionMutatorWithData.
+	SplitData() any
+
 	// ModuleErrorf reports an error at the line number of the module type in the module definition.
 	ModuleErrorf(fmt string, args ...interface{})
 
@@ -161,11 +269,368 @@ type OutgoingTransitionContext interface {
 	PropertyErrorf(property, fmt string, args ...interface{})
 }
 
+// A CacheStore persists opaque blobs across runs, for example in a long-lived build server.  It
+// is called concurrently from multiple goroutines and must be safe for concurrent use.
+type CacheStore interface {
+	// Get returns the blob stored for key, or false if there is none.
+	Get(key string) ([]byte, bool)
+
+	// Put stores a blob for key, replacing any existing blob.
+	Put(key string, value []byte)
+}
+
+// VersionedTransitionMutator can be implemented by a TransitionMutator that uses a persistent
+// cache.  The value returned by CacheVersion is included in the cache key, and should be changed
+// whenever the logic of Split, OutgoingTransition or IncomingTransition changes in a way that
+// would invalidate previously cached decisions.
+type VersionedTransitionMutator interface {
+	TransitionMutator
+	CacheVersion() string
+}
+
+// BuildAllVariantsModule can be implemented by a Module to request that a transition mutator
+// split it into every variation of that mutator seen anywhere in the build, in addition to the
+// variations returned by Split and requested by its dependents.  It is typically backed by a
+// build_all_variants property.
+type BuildAllVariantsModule interface {
+	Module
+
+	// BuildAllVariants returns true if the module should be built in every variation of the
+	// transition mutator with the given name.
+	BuildAllVariants(mutator string) bool
+}
+
 type transitionMutatorImpl struct {
 	name                        string
 	mutator                     TransitionMutator
 	variantCreatingMutatorIndex int
 	inputVariants               map[*moduleGroup][]*moduleInfo
+	cache                       CacheStore
+
+	// inputModules and outputVariants count the modules visited by the bottom up pass and the
+	// variants it produced from them, for VariantStats.
+	inputModules, outputVariants atomic.Int64
+
+	// after and before hold the names of the transition mutators that this mutator was ordered
+	// after or before with TransitionMutatorHandle.After and TransitionMutatorHandle.Before.
+	after, before []string
+
+	// usesAliasVariations is set by TransitionMutatorHandle.UsesAliasVariations.
+	usesAliasVariations bool
+
+	// splits holds a *transitionSplit for each module visited by the propagate pass of a
+	// TransitionMutatorWithData or TransitionMutatorWithDefault, indexed by *moduleInfo.
+	splits sync.Map
+
+	// seenVariations collects every variation assigned to any module during the propagate pass,
+	// and hasBuildAllVariants is set if any module implements BuildAllVariantsModule.  Both are
+	// protected by seenVariationsLock.
+	seenVariationsLock  sync.Mutex
+	seenVariations      map[string]bool
+	hasBuildAllVariants bool
+
+	// allVariations is the sorted snapshot of seenVariations from the previous propagate pass that
+	// is added to the variations of modules that implement BuildAllVariantsModule.
+	allVariations []string
+
+	// configHash is the hash of the config included in the persistent cache keys, computed once
+	// per ResolveDependencies by hashConfig.  configHashErr is set if the config can't be hashed.
+	// Both are protected by configHashLock.
+	configHashLock sync.Mutex
+	configHashed   bool
+	configHash     uint64
+	configHashErr  error
+}
+
+// transitionSplit holds the result of calling Split or SplitWithData and DefaultVariation on a
+// module.  They may be called when computing the incoming transition of a dependency, before the
+// propagate pass visits the dependency, so they are computed at most once per module.
+type transitionSplit struct {
+	once             sync.Once
+	specs            []VariantSpec
+	defaultVariation *string
+}
+
+// reset clears the state left by a previous ResolveDependencies.
+func (t *transitionMutatorImpl) reset() {
+	t.inputVariants = nil
+	t.splits.Clear()
+	t.inputModules.Store(0)
+	t.outputVariants.Store(0)
+	t.configHashed = false
+	t.clearPropagateState()
+}
+
+// memoizesSplit returns true if the mutator needs the result of Split for a module outside of
+// the propagate pass visiting that module.
+func (t *transitionMutatorImpl) memoizesSplit() bool {
+	switch t.mutator.(type) {
+	case TransitionMutatorWithData, TransitionMutatorWithDefault:
+		return true
+	}
+	return false
+}
+
+// split returns the variations and default variation of module, calling Split or SplitWithData
+// and DefaultVariation if they have not been called yet.  Errors are only returned to the first
+// caller.
+func (t *transitionMutatorImpl) split(ctx BaseModuleContext) *transitionSplit {
+	v, _ := t.splits.LoadOrStore(ctx.moduleInfo(), &transitionSplit{})
+	split := v.(*transitionSplit)
+	split.once.Do(func() {
+		if mutator, ok := t.mutator.(TransitionMutatorWithData); ok {
+			split.specs = mutator.SplitWithData(ctx)
+		} else {
+			for _, variation := range t.mutator.Split(ctx) {
+				split.specs = append(split.specs, VariantSpec{Name: variation})
+			}
+		}
+		if mutator, ok := t.mutator.(TransitionMutatorWithDefault); ok {
+			split.defaultVariation = mutator.DefaultVariation(ctx)
+		}
+	})
+	return split
+}
+
+// applyDefaultVariation returns the default variation of module if variation is empty or not one of
+// the variations returned by Split for module, and variation otherwise.  Module may be a module that
+// the propagate pass has or will visit.
+func (t *transitionMutatorImpl) applyDefaultVariation(context *Context, config any, module *moduleInfo,
+	variation string) (string, []error) {
+
+	if _, ok := t.mutator.(TransitionMutatorWithDefault); !ok {
+		return variation, nil
+	}
+
+	ctx := &baseModuleContext{
+		context:      context,
+		config:       config,
+		module:       module,
+		splitMutator: t,
+	}
+	split := t.split(ctx)
+	if split.defaultVariation == nil {
+		return variation, ctx.errs
+	}
+	if variation != "" && slices.ContainsFunc(split.specs, func(spec VariantSpec) bool {
+		return spec.Name == variation
+	}) {
+		return variation, ctx.errs
+	}
+	return *split.defaultVariation, ctx.errs
+}
+
+// splitData returns the data attached by SplitWithData to the given variation of module.  Module
+// may be a variant created by the mutator, or a module that the propagate pass has or will visit.
+func (t *transitionMutatorImpl) splitData(context *Context, config any, module *moduleInfo, variation string) (any, []error) {
+	if _, ok := t.mutator.(TransitionMutatorWithData); !ok {
+		return nil, nil
+	}
+
+	if data, ok := module.splitData[t.name]; ok {
+		if module.variant.variations.get(t.name) != variation {
+			return nil, nil
+		}
+		return data, nil
+	}
+
+	ctx := &baseModuleContext{
+		context:      context,
+		config:       config,
+		module:       module,
+		splitMutator: t,
+	}
+	return variantSpecData(t.split(ctx).specs, variation), ctx.errs
+}
+
+// setSplitData attaches the data for variation to a variant created by the mutator.
+func (t *transitionMutatorImpl) setSplitData(module *moduleInfo, specs []VariantSpec, variation string) {
+	// The map may be shared with other variants of the module, copy it before modifying it.
+	module.splitData = maps.Clone(module.splitData)
+	if module.splitData == nil {
+		module.splitData = make(map[string]any)
+	}
+	module.splitData[t.name] = variantSpecData(specs, variation)
+}
+
+func variantSpecData(specs []VariantSpec, variation string) any {
+	for _, spec := range specs {
+		if spec.Name == variation {
+			return spec.Data
+		}
+	}
+	return nil
+}
+
+// transitionCacheEntry is the persisted form of the decisions made by the top down pass of a
+// transition mutator for a single module.
+type transitionCacheEntry struct {
+	Variations          []string
+	OutgoingTransitions [][]string
+	OutgoingVariations  [][]string
+}
+
+// transitionCacheKeyInput contains everything that can affect the decisions made by the top down
+// pass of a transition mutator for a single module.
+type transitionCacheKeyInput struct {
+	Mutator            string
+	Version            string
+	ConfigHash         uint64
+	Name               string
+	Variant            string
+	PropertiesHash     uint64
+	RequiredVariations []string
+	MarkedVariations   []string
+	AllVariations      []string
+	Deps               []transitionCacheKeyDep
+}
+
+type transitionCacheKeyDep struct {
+	Name           string
+	Variant        string
+	PropertiesHash uint64
+	TagType        string
+	TagHash        uint64
+}
+
+// hashConfig returns the hash of config, which is computed the first time it is called during
+// each ResolveDependencies.
+func (t *transitionMutatorImpl) hashConfig(config any) (uint64, error) {
+	t.configHashLock.Lock()
+	defer t.configHashLock.Unlock()
+	if !t.configHashed {
+		t.configHash, t.configHashErr = proptools.CalculateHash(config)
+		t.configHashed = true
+	}
+	return t.configHash, t.configHashErr
+}
+
+// cacheKey returns the persistent cache key for the decisions made for module, or false if the
+// module can't be cached because some of its inputs are not hashable.
+func (t *transitionMutatorImpl) cacheKey(module *moduleInfo, config any) (string, bool) {
+	if _, ok := t.mutator.(TransitionMutatorWithData); ok {
+		// The data returned by SplitWithData can't be persisted.
+		return "", false
+	}
+
+	version := ""
+	if v, ok := t.mutator.(VersionedTransitionMutator); ok {
+		version = v.CacheVersion()
+	}
+
+	configHash, err := t.hashConfig(config)
+	if err != nil {
+		return "", false
+	}
+
+	propertiesHash, err := proptools.CalculateHash(module.properties)
+	if err != nil {
+		return "", false
+	}
+
+	input := transitionCacheKeyInput{
+		Mutator:            t.name,
+		Version:            version,
+		ConfigHash:         configHash,
+		Name:               module.Name(),
+		Variant:            module.variant.name,
+		PropertiesHash:     propertiesHash,
+		RequiredVariations: slices.Sorted(slices.Values(module.transitionVariations)),
+		MarkedVariations:   slices.Sorted(slices.Values(module.markedVariations[t.name])),
+	}
+
+	if t.buildAllVariants(module) {
+		input.AllVariations = t.allVariations
+	}
+
+	for _, dep := range module.directDeps {
+		depPropertiesHash, err := proptools.CalculateHash(dep.module.properties)
+		if err != nil {
+			return "", false
+		}
+		tagHash, err := proptools.CalculateHash(dep.tag)
+		if err != nil {
+			return "", false
+		}
+		input.Deps = append(input.Deps, transitionCacheKeyDep{
+			Name:           dep.module.Name(),
+			Variant:        dep.module.variant.name,
+			PropertiesHash: depPropertiesHash,
+			TagType:        fmt.Sprintf("%T", dep.tag),
+			TagHash:        tagHash,
+		})
+	}
+
+	hash, err := proptools.CalculateHash(input)
+	if err != nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s-%s-%x", t.name, module.Name(), hash), true
+}
+
+// restoreFromCache loads the cached decisions for module, returning false on a cache miss.
+func (t *transitionMutatorImpl) restoreFromCache(key string, module *moduleInfo) bool {
+	data, ok := t.cache.Get(key)
+	if !ok {
+		return false
+	}
+
+	var entry transitionCacheEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		// Treat corrupt entries as a cache miss, they will be overwritten.
+		return false
+	}
+
+	if len(entry.Variations) == 0 || len(entry.OutgoingTransitions) != len(entry.Variations) ||
+		len(entry.OutgoingVariations) != len(entry.Variations) {
+		return false
+	}
+	for i, transitions := range entry.OutgoingTransitions {
+		if len(transitions) != len(module.directDeps) || len(entry.OutgoingVariations[i]) != len(module.directDeps) {
+			return false
+		}
+	}
+
+	module.transitionVariations = entry.Variations
+	module.outgoingTransitionCache = entry.OutgoingTransitions
+	module.outgoingVariationCache = entry.OutgoingVariations
+	return true
+}
+
+func (t *transitionMutatorImpl) storeToCache(key string, module *moduleInfo) {
+	entry := transitionCacheEntry{
+		Variations:          module.transitionVariations,
+		OutgoingTransitions: module.outgoingTransitionCache,
+		OutgoingVariations:  module.outgoingVariationCache,
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&entry); err != nil {
+		panic(fmt.Errorf("failed to encode transition cache entry for %s: %w", module, err))
+	}
+	t.cache.Put(key, buf.Bytes())
+}
+
+// skippedVariation is stored in place of the variation of a dependency edge that was dropped by
+// IncomingTransitionContext.SkipIncoming.  It can't conflict with a real variation name as it
+// contains a NUL character.
+const skippedVariation = "\x00skipped"
+
+// dropSkippedDeps removes the dependency edges of a variant whose transition was skipped.
+// transitions contains the final variation of each dependency, indexed like directDeps.
+func (t *transitionMutatorImpl) dropSkippedDeps(c *Context, module *moduleInfo, transitions []string) {
+	if !slices.Contains(transitions, skippedVariation) {
+		return
+	}
+	directDeps := make([]depInfo, 0, len(module.directDeps))
+	for i, dep := range module.directDeps {
+		if transitions[i] != skippedVariation {
+			directDeps = append(directDeps, dep)
+		}
+	}
+	module.directDeps = directDeps
+	atomic.AddUint32(&c.needsUpdateDependencies, 1)
 }
 
 // Adds each argument in items to l if it's not already there.
@@ -180,6 +645,11 @@ func addToStringListIfNotPresent(l []string, items ...string) []string {
 }
 
 func (t *transitionMutatorImpl) addRequiredVariation(m *moduleInfo, variation string) {
+	if m.skippedMutators[t.name] {
+		// The mutator won't run on the module, which will only have a single variant.
+		return
+	}
+
 	m.requiredVariationsLock.Lock()
 	defer m.requiredVariationsLock.Unlock()
 
@@ -194,9 +664,98 @@ func (t *transitionMutatorImpl) addRequiredVariation(m *moduleInfo, variation st
 	m.transitionVariations = addToStringListIfNotPresent(m.transitionVariations, variation)
 }
 
+// buildAllVariants returns true if the module should be split into every variation seen anywhere
+// in the build.
+func (t *transitionMutatorImpl) buildAllVariants(module *moduleInfo) bool {
+	m, ok := module.logicModule.(BuildAllVariantsModule)
+	return ok && m.BuildAllVariants(t.name)
+}
+
+// recordVariations adds the variations of module to the set of variations seen by the current
+// propagate pass.
+func (t *transitionMutatorImpl) recordVariations(module *moduleInfo, buildAllVariants bool) {
+	t.seenVariationsLock.Lock()
+	defer t.seenVariationsLock.Unlock()
+
+	if t.seenVariations == nil {
+		t.seenVariations = make(map[string]bool)
+	}
+	for _, variation := range module.transitionVariations {
+		t.seenVariations[variation] = true
+	}
+	t.hasBuildAllVariants = t.hasBuildAllVariants || buildAllVariants
+}
+
+// needsRepropagate is called after each propagate pass.  It returns true if a module implements
+// BuildAllVariantsModule and the propagate pass saw variations that were not known when it
+// started, in which case the propagate pass must be run again so that those modules and their
+// dependencies pick up the new variations.  The set of variations only grows between passes, so
+// this converges.  The state it uses is cleared by clearPropagateState once the propagate passes
+// are done.
+func (t *transitionMutatorImpl) needsRepropagate() bool {
+	t.seenVariationsLock.Lock()
+	defer t.seenVariationsLock.Unlock()
+
+	var allVariations []string
+	for variation := range t.seenVariations {
+		// The empty variation means the module is not split, it is not a variation value.
+		if variation != "" {
+			allVariations = append(allVariations, variation)
+		}
+	}
+	sort.Strings(allVariations)
+
+	if t.hasBuildAllVariants && !slices.Equal(allVariations, t.allVariations) {
+		t.allVariations = allVariations
+		return true
+	}
+	return false
+}
+
+// clearPropagateState clears the variations collected by the propagate passes, including when they
+// stopped early because of an error.
+func (t *transitionMutatorImpl) clearPropagateState() {
+	t.seenVariationsLock.Lock()
+	defer t.seenVariationsLock.Unlock()
+
+	t.seenVariations = nil
+	t.hasBuildAllVariants = false
+	t.allVariations = nil
+}
+
 func (t *transitionMutatorImpl) topDownMutator(mctx TopDownMutatorContext) {
-	module := mctx.(*mutatorContext).module
-	mutatorSplits := t.mutator.Split(mctx)
+	mc := mctx.(*mutatorContext)
+	mc.splitMutator = t
+	module := mc.module
+	buildAllVariants := t.buildAllVariants(module)
+	defer func() {
+		t.recordVariations(module, buildAllVariants)
+	}()
+
+	var cacheKey string
+	cacheable := false
+	if t.cache != nil {
+		cacheKey, cacheable = t.cacheKey(module, mc.config)
+		if cacheable && t.restoreFromCache(cacheKey, module) {
+			for _, srcVariationTransitionCache := range module.outgoingTransitionCache {
+				for depIndex, dep := range module.directDeps {
+					if srcVariationTransitionCache[depIndex] != skippedVariation {
+						t.addRequiredVariation(dep.module, srcVariationTransitionCache[depIndex])
+					}
+				}
+			}
+			return
+		}
+	}
+
+	var mutatorSplits []string
+	if t.memoizesSplit() {
+		for _, spec := range t.split(mctx).specs {
+			mutatorSplits = append(mutatorSplits, spec.Name)
+		}
+	} else {
+		mutatorSplits = t.mutator.Split(mctx)
+	}
 	if mutatorSplits == nil || len(mutatorSplits) == 0 {
 		panic(fmt.Errorf("transition mutator %s returned no splits for module %s", t.name, mctx.ModuleName()))
 	}
@@ -207,28 +766,48 @@ func (t *transitionMutatorImpl) topDownMutator(mctx TopDownMutatorContext) {
 	// so no locking is necessary.
 	// Sort the module transitions, but keep the mutatorSplits in the order returned
 	// by Split, as the order can be significant when inter-variant dependencies are
-	// used.
+	// used.  Modules that build all variants get every variation seen by the previous
+	// propagate pass, sorted along with the requested variations.
+	if buildAllVariants {
+		module.transitionVariations = addToStringListIfNotPresent(module.transitionVariations, t.allVariations...)
+	}
 	sort.Strings(module.transitionVariations)
 	module.transitionVariations = addToStringListIfNotPresent(mutatorSplits, module.transitionVariations...)
 
 	outgoingTransitionCache := make([][]string, len(module.transitionVariations))
+	outgoingVariationCache := make([][]string, len(module.transitionVariations))
 	for srcVariationIndex, srcVariation := range module.transitionVariations {
 		srcVariationTransitionCache := make([]string, len(module.directDeps))
+		srcVariationOutgoingCache := make([]string, len(module.directDeps))
 		for depIndex, dep := range module.directDeps {
-			finalVariation := t.transition(mctx)(mctx.moduleInfo(), srcVariation, dep.module, dep.tag)
+			outgoingVariation, finalVariation := t.transitionEdge(mctx, mctx.moduleInfo(), srcVariation, dep.module, dep.tag)
 			srcVariationTransitionCache[depIndex] = finalVariation
-			t.addRequiredVariation(dep.module, finalVariation)
+			srcVariationOutgoingCache[depIndex] = outgoingVariation
+			if finalVariation != skippedVariation {
+				t.addRequiredVariation(dep.module, finalVariation)
+			}
 		}
 		outgoingTransitionCache[srcVariationIndex] = srcVariationTransitionCache
+		outgoingVariationCache[srcVariationIndex] = srcVariationOutgoingCache
 	}
 	module.outgoingTransitionCache = outgoingTransitionCache
+	module.outgoingVariationCache = outgoingVariationCache
+	mc.context.traceModule(module, mc.mutator.name, "requires variations %q, outgoing transitions %q",
+		module.transitionVariations, module.outgoingTransitionCache)
+
+	if cacheable && !mctx.Failed() {
+		t.storeToCache(cacheKey, module)
+	}
 }
 
 type transitionContextImpl struct {
 	context     *Context
+	mutator     *transitionMutatorImpl
 	source      *moduleInfo
 	dep         *moduleInfo
+	depName     string // the name of the target when dep is nil
 	depTag      DependencyTag
+	variation   string
 	postMutator bool
 	config      interface{}
 	errs        []error
@@ -268,46 +847,125 @@ func (c *outgoingTransitionContextImpl) Module() Module {
 	return c.source.logicModule
 }
 
+func (c *outgoingTransitionContextImpl) DepName() string {
+	if c.dep != nil {
+		return c.dep.Name()
+	}
+	return c.depName
+}
+
+func (c *outgoingTransitionContextImpl) DepModule() Module {
+	if c.dep == nil {
+		return nil
+	}
+	return c.dep.logicModule
+}
+
 func (c *outgoingTransitionContextImpl) Provider(provider AnyProviderKey) (any, bool) {
 	return c.context.provider(c.source, provider.provider())
 }
 
+func (c *outgoingTransitionContextImpl) SplitData() any {
+	data, errs := c.mutator.splitData(c.context, c.config, c.source, c.variation)
+	for _, err := range errs {
+		c.error(err)
+	}
+	return data
+}
+
 type incomingTransitionContextImpl struct {
 	transitionContextImpl
+	skip bool
 }
 
 func (c *incomingTransitionContextImpl) Module() Module {
 	return c.dep.logicModule
 }
 
+func (c *incomingTransitionContextImpl) SourceModule() Module {
+	return c.source.logicModule
+}
+
 func (c *incomingTransitionContextImpl) Provider(provider AnyProviderKey) (any, bool) {
 	return c.context.provider(c.dep, provider.provider())
 }
 
+func (c *incomingTransitionContextImpl) SkipIncoming() {
+	c.skip = true
+}
+
+func (c *incomingTransitionContextImpl) SplitData() any {
+	data, errs := c.mutator.splitData(c.context, c.config, c.dep, c.variation)
+	for _, err := range errs {
+		c.error(err)
+	}
+	return data
+}
+
 func (t *transitionMutatorImpl) transition(mctx BaseModuleContext) Transition {
 	return func(source *moduleInfo, sourceVariation string, dep *moduleInfo, depTag DependencyTag) string {
-		tc := transitionContextImpl{
-			context: mctx.base().context,
-			source:  source,
-			dep:     dep,
-			depTag:  depTag,
-			config:  mctx.Config(),
-		}
-		outCtx := &outgoingTransitionContextImpl{tc}
-		outgoingVariation := t.mutator.OutgoingTransition(outCtx, sourceVariation)
-		for _, err := range outCtx.errs {
-			mctx.error(err)
-		}
-		if mctx.Failed() {
-			return outgoingVariation
-		}
-		inCtx := &incomingTransitionContextImpl{tc}
-		finalVariation := t.mutator.IncomingTransition(inCtx, outgoingVariation)
-		for _, err := range inCtx.errs {
+		_, finalVariation := t.transitionEdge(mctx, source, sourceVariation, dep, depTag)
+		return finalVariation
+	}
+}
+
+// transitionEdge applies the outgoing and incoming transitions to a dependency edge, and returns the
+// variation returned by OutgoingTransition and the final variation of the dependency.
+func (t *transitionMutatorImpl) transitionEdge(mctx BaseModuleContext, source *moduleInfo, sourceVariation string,
+	dep *moduleInfo, depTag DependencyTag) (string, string) {
+
+	if dep.skippedMutators[t.name] {
+		return "", ""
+	}
+	tc := transitionContextImpl{
+		context:   mctx.base().context,
+		mutator:   t,
+		source:    source,
+		dep:       dep,
+		depTag:    depTag,
+		variation: sourceVariation,
+		config:    mctx.Config(),
+	}
+	transitionErrors := func(errs []error) {
+		for _, err := range errs {
 			mctx.error(err)
 		}
-		return finalVariation
+		source.transitionErrs += len(errs)
+	}
+	outCtx := &outgoingTransitionContextImpl{tc}
+	outgoingVariation := t.mutator.OutgoingTransition(outCtx, sourceVariation)
+	transitionErrors(outCtx.errs)
+	if mctx.Failed() {
+		return outgoingVariation, outgoingVariation
+	}
+	tc.variation = outgoingVariation
+	inCtx := &incomingTransitionContextImpl{transitionContextImpl: tc}
+	finalVariation := t.mutator.IncomingTransition(inCtx, outgoingVariation)
+	transitionErrors(inCtx.errs)
+	if inCtx.skip {
+		return outgoingVariation, skippedVariation
+	}
+	finalVariation, errs := t.applyDefaultVariation(tc.context, tc.config, dep, finalVariation)
+	transitionErrors(errs)
+	return outgoingVariation, finalVariation
+}
+
+// recordEdgeTransition sets the transition of the mutator on the dependency edge, replacing any
+// transition previously recorded for the mutator.  The transitions may be shared with other
+// variants of the source, so they are copied before they are modified.
+func (t *transitionMutatorImpl) recordEdgeTransition(dep *depInfo, sourceVariation, outgoingVariation,
+	finalVariation string) {
+
+	transition := EdgeTransition{
+		Mutator:           t.name,
+		SourceVariation:   sourceVariation,
+		OutgoingVariation: outgoingVariation,
+		FinalVariation:    finalVariation,
 	}
+	transitions := slices.DeleteFunc(slices.Clone(dep.transitions), func(e EdgeTransition) bool {
+		return e.Mutator == t.name
+	})
+	dep.transitions = append(transitions, transition)
 }
 
 func (t *transitionMutatorImpl) bottomUpMutator(mctx BottomUpMutatorContext) {
@@ -317,8 +975,10 @@ func (t *transitionMutatorImpl) bottomUpMutator(mctx BottomUpMutatorContext) {
 	// computation of the variations required by a given module.
 	variations := mc.module.transitionVariations
 	outgoingTransitionCache := mc.module.outgoingTransitionCache
+	outgoingVariationCache := mc.module.outgoingVariationCache
 	mc.module.transitionVariations = nil
 	mc.module.outgoingTransitionCache = nil
+	mc.module.outgoingVariationCache = nil
 	mc.module.currentTransitionMutator = ""
 
 	if len(variations) < 1 {
@@ -326,19 +986,244 @@ func (t *transitionMutatorImpl) bottomUpMutator(mctx BottomUpMutatorContext) {
 			mctx.ModuleName(), t.name))
 	}
 
+	t.inputModules.Add(1)
+	t.outputVariants.Add(int64(len(variations)))
+
+	var specs []VariantSpec
+	_, withData := t.mutator.(TransitionMutatorWithData)
+	if withData {
+		if split, ok := t.splits.Load(mc.module); ok {
+			specs = split.(*transitionSplit).specs
+		}
+	}
+
 	if len(variations) == 1 && variations[0] == "" {
 		// Module is not split, just apply the transition
 		mc.context.convertDepsToVariation(mc.module, 0,
 			chooseDepByIndexes(mc.mutator.name, outgoingTransitionCache))
+		for j := range mc.module.directDeps {
+			t.recordEdgeTransition(&mc.module.directDeps[j], "", outgoingVariationCache[0][j],
+				outgoingTransitionCache[0][j])
+		}
+		t.dropSkippedDeps(mc.context, mc.module, outgoingTransitionCache[0])
+		mc.context.traceDirectDeps(mc.module, mc.mutator.name)
+		if withData {
+			t.setSplitData(mc.module, specs, "")
+		}
 	} else {
 		mc.createVariationsWithTransition(variations, outgoingTransitionCache)
+		for i, newModule := range mc.newVariations {
+			for j := range newModule.directDeps {
+				t.recordEdgeTransition(&newModule.directDeps[j], variations[i], outgoingVariationCache[i][j],
+					outgoingTransitionCache[i][j])
+			}
+			t.dropSkippedDeps(mc.context, newModule, outgoingTransitionCache[i])
+		}
+		if withData {
+			for i, newModule := range mc.newVariations {
+				t.setSplitData(newModule, specs, variations[i])
+			}
+		}
+		for i, newModule := range mc.newVariations {
+			mc.errs = append(mc.errs, t.variantCreated(mc.context, mc.config, newModule, variations[i])...)
+		}
+	}
+}
+
+// variantCreated calls OnVariantCreated on the mutator if it implements
+// TransitionMutatorWithVariantCreated, and returns any errors it reported.
+func (t *transitionMutatorImpl) variantCreated(c *Context, config any, module *moduleInfo, variation string) []error {
+	m, ok := t.mutator.(TransitionMutatorWithVariantCreated)
+	if !ok {
+		return nil
+	}
+	ctx := &baseModuleContext{
+		context: c,
+		config:  config,
+		module:  module,
 	}
+	m.OnVariantCreated(ctx, variation)
+	return ctx.errs
 }
 
 func (t *transitionMutatorImpl) mutateMutator(mctx BottomUpMutatorContext) {
 	module := mctx.(*mutatorContext).module
 	currentVariation := module.variant.variations.get(t.name)
+	mc := mctx.(*mutatorContext)
+	mc.splitData = module.splitData[t.name]
+	if m, ok := module.logicModule.(VariantPropertiesModule); ok {
+		for _, props := range m.VariantProperties(t.name, currentVariation) {
+			err := proptools.AppendMatchingProperties(module.properties, props, nil)
+			if propErr, ok := err.(*proptools.ExtendPropertyError); ok {
+				mctx.PropertyErrorf(propErr.Property, "%s", propErr.Err)
+			} else if err != nil {
+				mctx.ModuleErrorf("%s", err)
+			}
+		}
+	}
 	t.mutator.Mutate(mctx, currentVariation)
+	mc.splitData = nil
+}
+
+// createVariantOnDemand returns the variant of group that matches requested after applying the
+// incoming transition of the mutator, creating it if necessary.  The mutator must be the most
+// recent variant creating mutator, so that the variations of requested for all other mutators
+// match a variant that was an input to the mutator.  It returns nil if no input variant matches or
+// the incoming transition dropped the dependency, in which case requested is modified to hold
+// skippedVariation.
+func (t *transitionMutatorImpl) createVariantOnDemand(c *Context, config any, mutator *mutatorInfo,
+	source *moduleInfo, group *moduleGroup, requested variationMap) (*moduleInfo, []error) {
+
+	others := requested.clone()
+	others.delete(t.name)
+	matches := func(m *moduleInfo) bool {
+		v := m.variant.variations.clone()
+		v.delete(t.name)
+		return v.equal(others)
+	}
+
+	// Find the module as it was before the mutator split it.  If it wasn't split it is still in the
+	// module group.
+	var input *moduleInfo
+	for _, m := range t.inputVariants[group] {
+		if matches(m) {
+			input = m
+			break
+		}
+	}
+	if input == nil {
+		for _, m := range group.modules {
+			if matches(m) && m.variant.variations.get(t.name) == "" {
+				input = m
+				break
+			}
+		}
+	}
+	if input == nil {
+		return nil, nil
+	}
+
+	ctx := &incomingTransitionContextImpl{
+		transitionContextImpl: transitionContextImpl{context: c, mutator: t, source: source, dep: input,
+			variation: requested.get(t.name), postMutator: true, config: config},
+	}
+	variation := t.mutator.IncomingTransition(ctx, requested.get(t.name))
+	if len(ctx.errs) > 0 {
+		return nil, ctx.errs
+	}
+	if ctx.skip {
+		requested.set(t.name, skippedVariation)
+		return nil, nil
+	}
+	variation, defaultErrs := t.applyDefaultVariation(c, config, input, variation)
+	if len(defaultErrs) > 0 {
+		return nil, defaultErrs
+	}
+
+	for _, m := range group.modules {
+		if matches(m) && m.variant.variations.get(t.name) == variation {
+			return m, nil
+		}
+	}
+
+	if variation == "" {
+		return nil, nil
+	}
+
+	logicModule, properties := c.cloneLogicModule(input)
+	newModule := newVariantModule(input, logicModule, properties, c.newVariant(input, t.name, variation))
+	newModule.obsoletedByNewVariants = false
+	newModule.splitModules = nil
+	newModule.newDirectDeps = nil
+	newModule.startedMutator = mutator.index
+	newModule.finishedMutator = mutator.index
+	t.outputVariants.Add(1)
+
+	if _, ok := t.mutator.(TransitionMutatorWithData); ok {
+		var specs []VariantSpec
+		if split, ok := t.splits.Load(input); ok {
+			specs = split.(*transitionSplit).specs
+		}
+		t.setSplitData(newModule, specs, variation)
+	}
+
+	if errs := t.variantCreated(c, config, newModule, variation); len(errs) > 0 {
+		return nil, errs
+	}
+
+	var mutateMutator *mutatorInfo
+	for _, m := range c.mutatorInfo {
+		if m.name == t.name+"_mutate" {
+			mutateMutator = m
+		}
+	}
+
+	mctx := &mutatorContext{
+		baseModuleContext: baseModuleContext{
+			context: c,
+			config:  config,
+			module:  newModule,
+		},
+		mutator: mutateMutator,
+	}
+
+	// Dependencies of the input variant on variants created by the mutator are converted using the
+	// outgoing transition of the new variant.
+	transitions := make([]string, len(newModule.directDeps))
+	var errs []error
+	for i, dep := range newModule.directDeps {
+		if dep.module.variant.variations.get(t.name) == "" {
+			t.recordEdgeTransition(&newModule.directDeps[i], variation, "", "")
+			continue
+		}
+		var outgoingVariation string
+		outgoingVariation, transitions[i] = t.transitionEdge(mctx, newModule, variation, dep.module, dep.tag)
+		t.recordEdgeTransition(&newModule.directDeps[i], variation, outgoingVariation, transitions[i])
+		if transitions[i] == skippedVariation {
+			continue
+		}
+		want := dep.module.variant.variations.clone()
+		want.set(t.name, transitions[i])
+		var newDep *moduleInfo
+		for _, m := range dep.module.group.modules {
+			if m.variant.variations.equal(want) {
+				newDep = m
+				break
+			}
+		}
+		if newDep == nil {
+			errs = append(errs, &BlueprintError{
+				Err: fmt.Errorf("failed to find variation %q for module %q needed by %q",
+					transitions[i], dep.module.Name(), newModule.Name()),
+				Pos: newModule.pos,
+			})
+			continue
+		}
+		newModule.directDeps[i].module = newDep
+	}
+	if len(mctx.errs) > 0 || len(errs) > 0 {
+		return nil, append(errs, mctx.errs...)
+	}
+	t.dropSkippedDeps(c, newModule, transitions)
+
+	if hook := c.mutatorTraceHook; hook != nil {
+		hook(mutateMutator.name, newModule.logicModule, PhaseStart)
+		t.mutateMutator(mctx)
+		hook(mutateMutator.name, newModule.logicModule, PhaseEnd)
+	} else {
+		t.mutateMutator(mctx)
+	}
+	if len(mctx.errs) > 0 {
+		return nil, mctx.errs
+	}
+	newModule.newDirectDeps = nil
+
+	group.modules = append(group.modules, newModule)
+	c.moduleInfo[newModule.logicModule] = newModule
+	c.needsUpdateDependencies++
+	c.traceModule(newModule, mutator.name, "created on demand by %s", t.name)
+
+	return newModule, nil
 }
 
 type TransitionMutatorHandle interface {
@@ -347,10 +1232,41 @@ type TransitionMutatorHandle interface {
 	// of the source module, and only use the variants explicitly requested by the
 	// AddFarVariationDependencies call.
 	NeverFar() TransitionMutatorHandle
+
+	// NeverFarFor is like NeverFar, but only applies to far variation dependencies whose requested
+	// variations are all for the given mutators.  Far variation dependencies that request a
+	// variation for any other mutator ignore the variations created by this mutator as usual.
+	// NeverFar is equivalent to listing every mutator.  At least one mutator name must be given.
+	NeverFarFor(mutatorNames ...string) TransitionMutatorHandle
+
+	// WithPersistentCache causes the decisions made by Split, OutgoingTransition and
+	// IncomingTransition for each module to be stored in the given CacheStore, and to be reused
+	// instead of calling those methods when the module, its dependencies, the config and the
+	// mutator version returned by VersionedTransitionMutator.CacheVersion are unchanged.  The
+	// config is compared by its hash computed with proptools.CalculateHash, and nothing is cached
+	// if it can't be hashed.  Mutate is always called.  The cache is not used by contexts created
+	// with Context.CloneForConfig.
+	WithPersistentCache(store CacheStore) TransitionMutatorHandle
+
+	// After causes this mutator to run after the named transition mutator, regardless of the
+	// order in which they were registered.  Transition mutators without an ordering constraint
+	// between them run in registration order.  ResolveDependencies reports an error if the named
+	// mutator is not a registered transition mutator or the ordering constraints form a cycle.
+	After(mutatorName string) TransitionMutatorHandle
+
+	// Before causes this mutator to run before the named transition mutator, regardless of the
+	// order in which they were registered.  It is otherwise the same as After.
+	Before(mutatorName string) TransitionMutatorHandle
+
+	// UsesAliasVariations marks the mutator as calling BottomUpMutatorContext.CreateAliasVariation
+	// from Mutate, which prevents coalescing the Mutate pass with adjacent mutators so that the
+	// aliases are visible to all dependencies added by later mutators.
+	UsesAliasVariations() TransitionMutatorHandle
 }
 
 type transitionMutatorHandle struct {
 	inner MutatorHandle
+	impl  *transitionMutatorImpl
 }
 
 var _ TransitionMutatorHandle = (*transitionMutatorHandle)(nil)
@@ -360,13 +1276,250 @@ func (h *transitionMutatorHandle) NeverFar() TransitionMutatorHandle {
 	return h
 }
 
+func (h *transitionMutatorHandle) NeverFarFor(mutatorNames ...string) TransitionMutatorHandle {
+	if len(mutatorNames) == 0 {
+		panic(fmt.Errorf("NeverFarFor called with no mutator names for transition mutator %q, use NeverFar instead",
+			h.impl.name))
+	}
+	h.inner.setNeverFarFor(mutatorNames)
+	return h
+}
+
+func (h *transitionMutatorHandle) WithPersistentCache(store CacheStore) TransitionMutatorHandle {
+	h.impl.cache = store
+	return h
+}
+
+func (h *transitionMutatorHandle) After(mutatorName string) TransitionMutatorHandle {
+	h.impl.after = append(h.impl.after, mutatorName)
+	return h
+}
+
+func (h *transitionMutatorHandle) Before(mutatorName string) TransitionMutatorHandle {
+	h.impl.before = append(h.impl.before, mutatorName)
+	return h
+}
+
+func (h *transitionMutatorHandle) UsesAliasVariations() TransitionMutatorHandle {
+	h.impl.usesAliasVariations = true
+	return h
+}
+
+// orderTransitionMutators reorders the registered transition mutators to satisfy the constraints
+// added with TransitionMutatorHandle.After and TransitionMutatorHandle.Before, see
+// orderedMutators.
+func (c *Context) orderTransitionMutators() []error {
+	mutators, errs := c.orderedMutators()
+	if len(errs) > 0 {
+		return errs
+	}
+	copy(c.mutatorInfo, mutators)
+	for i, mutator := range c.mutatorInfo {
+		mutator.index = i
+	}
+	return nil
+}
+
+// orderedMutators returns the registered mutators in the order they will run, with the transition
+// mutators reordered to satisfy the constraints added with TransitionMutatorHandle.After and
+// TransitionMutatorHandle.Before.  Each transition mutator is registered as three consecutive
+// mutators, which are moved together into the positions previously occupied by transition
+// mutators, leaving all other mutators in place.  Transition mutators without a constraint between
+// them keep their registration order.
+func (c *Context) orderedMutators() ([]*mutatorInfo, []error) {
+	var impls []*transitionMutatorImpl
+	var slots []int
+	constrained := false
+	for i, mutator := range c.mutatorInfo {
+		if impl := mutator.propagatesTransitionMutator; impl != nil {
+			impls = append(impls, impl)
+			slots = append(slots, i)
+			constrained = constrained || len(impl.after) > 0 || len(impl.before) > 0
+		}
+	}
+	if !constrained {
+		return c.mutatorInfo, nil
+	}
+
+	byName := make(map[string]int, len(impls))
+	for i, impl := range impls {
+		byName[impl.name] = i
+	}
+
+	// edges[i] holds the transition mutators that must run after impls[i].
+	edges := make([][]int, len(impls))
+	var errs []error
+	addEdge := func(from, to, constrainedBy string) {
+		fromIndex, fromOk := byName[from]
+		toIndex, toOk := byName[to]
+		if !fromOk || !toOk {
+			unknown := from
+			if fromOk {
+				unknown = to
+			}
+			errs = append(errs, fmt.Errorf("transition mutator %q is ordered relative to %q, which is not a registered transition mutator",
+				constrainedBy, unknown))
+			return
+		}
+		edges[fromIndex] = append(edges[fromIndex], toIndex)
+	}
+	for _, impl := range impls {
+		for _, after := range impl.after {
+			addEdge(after, impl.name, impl.name)
+		}
+		for _, before := range impl.before {
+			addEdge(impl.name, before, impl.name)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	// Topologically sort the transition mutators, always picking the earliest registered mutator
+	// whose predecessors have all been placed so that unconstrained mutators keep their
+	// registration order.
+	inDegree := make([]int, len(impls))
+	for _, successors := range edges {
+		for _, j := range successors {
+			inDegree[j]++
+		}
+	}
+	placed := make([]bool, len(impls))
+	order := make([]int, 0, len(impls))
+	for len(order) < len(impls) {
+		next := -1
+		for i := range impls {
+			if !placed[i] && inDegree[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			// Every remaining mutator must run after another remaining mutator, follow the
+			// constraints backwards from any of them until one repeats to find a cycle.
+			var cycle []string
+			var visited []int
+			for i := slices.Index(placed, false); !slices.Contains(visited, i); {
+				visited = append(visited, i)
+				for j := range impls {
+					if !placed[j] && slices.Contains(edges[j], i) {
+						i = j
+						break
+					}
+				}
+				if slices.Contains(visited, i) {
+					for _, j := range visited[slices.Index(visited, i):] {
+						cycle = append(cycle, impls[j].name)
+					}
+					cycle = append(cycle, impls[i].name)
+				}
+			}
+			slices.Reverse(cycle)
+			return nil, []error{fmt.Errorf("cycle in transition mutator ordering: %s",
+				strings.Join(cycle, " -> "))}
+		}
+		placed[next] = true
+		order = append(order, next)
+		for _, j := range edges[next] {
+			inDegree[j]--
+		}
+	}
+
+	mutators := slices.Clone(c.mutatorInfo)
+	for i, slot := range slots {
+		copy(mutators[slot:slot+3], c.mutatorInfo[slots[order[i]]:slots[order[i]]+3])
+	}
+
+	return mutators, nil
+}
+
 func (c *Context) RegisterTransitionMutator(name string, mutator TransitionMutator) TransitionMutatorHandle {
 	impl := &transitionMutatorImpl{name: name, mutator: mutator}
 
-	c.RegisterTopDownMutator(name+"_propagate", impl.topDownMutator)
+	c.RegisterTopDownMutator(name+"_propagate", impl.topDownMutator).setPropagatesTransitionMutator(impl)
 	bottomUpHandle := c.RegisterBottomUpMutator(name, impl.bottomUpMutator).setTransitionMutator(impl)
-	c.RegisterBottomUpMutator(name+"_mutate", impl.mutateMutator)
-	return &transitionMutatorHandle{inner: bottomUpHandle}
+	c.RegisterBottomUpMutator(name+"_mutate", impl.mutateMutator).setMutatesTransitionMutator(impl)
+	return &transitionMutatorHandle{inner: bottomUpHandle, impl: impl}
+}
+
+// A VariantStat describes the number of variants created by a transition mutator.
+type VariantStat struct {
+	// InputModules is the number of modules the mutator was applied to.
+	InputModules int64
+
+	// OutputVariants is the number of variants the mutator produced from them, including variants
+	// created by AddReverseVariationDependencyCreatingVariant.  A module that was not split counts
+	// as a single variant.
+	OutputVariants int64
+}
+
+// VariantStats returns a VariantStat for each transition mutator that has run, indexed by the name
+// of the mutator.
+func (c *Context) VariantStats() map[string]VariantStat {
+	stats := make(map[string]VariantStat, len(c.transitionMutators))
+	for _, mutator := range c.mutatorInfo {
+		t := mutator.transitionMutator
+		if t == nil || c.disabledMutators[mutator.registeredName()] ||
+			len(c.finishedMutators) <= mutator.index || !c.finishedMutators[mutator.index] {
+			continue
+		}
+		stats[t.name] = VariantStat{
+			InputModules:   t.inputModules.Load(),
+			OutputVariants: t.outputVariants.Load(),
+		}
+	}
+	return stats
+}
+
+// A TransitionInfo describes how the variations of the target of a dependency edge were chosen.
+type TransitionInfo struct {
+	// Transitions contains an entry for each transition mutator, in the order they ran.
+	Transitions []EdgeTransition
+}
+
+// An EdgeTransition describes how a single transition mutator chose the variation of the target
+// of a dependency edge.
+type EdgeTransition struct {
+	// Mutator is the name of the transition mutator.
+	Mutator string
+
+	// SourceVariation is the variation of the source of the edge.
+	SourceVariation string
+
+	// OutgoingVariation is the variation returned by OutgoingTransition on the source.
+	OutgoingVariation string
+
+	// FinalVariation is the variation of the target, after IncomingTransition.
+	FinalVariation string
+}
+
+// WalkDepsWithTransitionInfo is like ModuleContext.WalkDeps, but also passes the TransitionInfo describing how
+// the variations of each child were chosen.  The transitions are recorded when each dependency is resolved, so
+// a dependency added with explicitly requested variations, including a reverse dependency, reports the
+// requested variation as its outgoing variation.  It can only be called after ResolveDependencies.
+func (c *Context) WalkDepsWithTransitionInfo(logicModule Module,
+	visit func(child, parent Module, info TransitionInfo) bool) {
+
+	topModule := c.moduleInfo[logicModule]
+	c.walkDeps(topModule, false, func(dep depInfo, parent *moduleInfo) bool {
+		return visit(dep.module.logicModule, parent.logicModule, TransitionInfo{slices.Clone(dep.transitions)})
+	}, nil)
+}
+
+// explicitEdgeTransitions returns the transitions of a dependency edge from source on dep whose
+// variations were chosen explicitly, without applying any transitions.
+func (c *Context) explicitEdgeTransitions(source, dep *moduleInfo) []EdgeTransition {
+	var transitions []EdgeTransition
+	for _, t := range c.transitionMutators {
+		variation := dep.variant.variations.get(t.name)
+		transitions = append(transitions, EdgeTransition{
+			Mutator:           t.name,
+			SourceVariation:   source.variant.variations.get(t.name),
+			OutgoingVariation: variation,
+			FinalVariation:    variation,
+		})
+	}
+	return transitions
 }
 
 // This function is called for every dependency edge to determine which
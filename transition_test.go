@@ -15,11 +15,19 @@
 package blueprint
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"reflect"
 	"regexp"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+
+	"github.com/google/blueprint/proptools"
 )
 
 func testTransitionCommon(bp string, neverFar bool, ctxHook func(*Context)) (*Context, []error) {
@@ -29,6 +37,7 @@ func testTransitionCommon(bp string, neverFar bool, ctxHook func(*Context)) (*Co
 	})
 
 	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterBottomUpMutator("skip_mutators", skipMutatorsMutator)
 	handle := ctx.RegisterTransitionMutator("transition", transitionTestMutator{})
 	if neverFar {
 		handle.NeverFar()
@@ -238,6 +247,392 @@ func TestTransition(t *testing.T) {
 	checkTransitionMutate(t, H_h, "h")
 }
 
+func TestModuleVariantsForMutator(t *testing.T) {
+	ctx, errs := testTransition(fmt.Sprintf(testTransitionBp, "", ""))
+	assertNoErrors(t, errs)
+
+	check := func(name, variant, mutator string, expected []string) {
+		t.Helper()
+		got := ctx.ModuleVariantsForMutator(getTransitionModule(ctx, name, variant), mutator)
+		if !slices.Equal(got, expected) {
+			t.Errorf("unexpected %s variants of %s, expected %q got %q", mutator, name, expected, got)
+		}
+	}
+
+	check("A", "a", "transition", []string{"b", "a"})
+	check("C", "", "transition", []string{"", "a", "b", "c"})
+	check("F", "", "transition", []string{""})
+	check("A", "a", "deps", nil)
+	check("A", "a", "transition_mutate", nil)
+
+	if got := ctx.ModuleVariantsForMutator(&transitionModule{}, "transition"); got != nil {
+		t.Errorf("expected nil for an unknown module, got %q", got)
+	}
+
+	ctx, errs = testTransitionCommon(`
+		transition_module {
+			name: "A",
+			split: ["a"],
+		}
+	`, false, func(ctx *Context) {
+		ctx.DisableMutators("transition")
+	})
+	assertNoErrors(t, errs)
+	check("A", "", "transition", nil)
+}
+
+func TestModuleVariations(t *testing.T) {
+	ctx, errs := testTransitionCommon(`
+		transition_module {
+			name: "A",
+			split: ["a"],
+		}
+
+		transition_module {
+			name: "B",
+		}
+	`, false, func(ctx *Context) {
+		ctx.RegisterTransitionMutator("other", fixedSplitTransitionMutator{variations: []string{"x"}})
+	})
+	assertNoErrors(t, errs)
+
+	check := func(name, variant string, expected map[string]string) {
+		t.Helper()
+		got := ctx.ModuleVariations(getTransitionModule(ctx, name, variant))
+		if !maps.Equal(got, expected) {
+			t.Errorf("unexpected variations of %s(%s), expected %q got %q", name, variant, expected, got)
+		}
+	}
+
+	check("A", "a_x", map[string]string{"transition": "a", "other": "x"})
+	check("B", "x", map[string]string{"other": "x"})
+}
+
+func TestTransitionDisabledModule(t *testing.T) {
+	bp := `
+		transition_module {
+			name: "A",
+			split: ["a", "b"],
+			deps: ["B"],
+		}
+
+		transition_module {
+			name: "B",
+			split: ["c"],
+			enabled: false,
+		}
+	`
+
+	t.Run("disabled", func(t *testing.T) {
+		ctx, errs := testTransitionCommon(bp, false, nil)
+		assertNoErrors(t, errs)
+
+		checkTransitionVariants(t, ctx, "A", []string{"a", "b"})
+		checkTransitionVariants(t, ctx, "B", []string{""})
+		checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B()")
+		checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "b"), "B()")
+	})
+
+	t.Run("include disabled", func(t *testing.T) {
+		ctx, errs := testTransitionCommon(bp, false, func(ctx *Context) {
+			ctx.SetIncludeDisabledModules(true)
+		})
+		assertNoErrors(t, errs)
+
+		checkTransitionVariants(t, ctx, "A", []string{"a", "b"})
+		checkTransitionVariants(t, ctx, "B", []string{"c", "a", "b"})
+	})
+}
+
+func TestModulePropertiesJSON(t *testing.T) {
+	ctx, errs := testTransitionCommon(`
+		transition_module {
+			name: "A",
+			split: ["a"],
+			deps: ["B"],
+		}
+
+		transition_module {
+			name: "B",
+		}
+	`, false, nil)
+	assertNoErrors(t, errs)
+
+	data, err := ctx.ModulePropertiesJSON(getTransitionModule(ctx, "A", "a"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var got []map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid JSON %q: %s", data, err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 property structs, got %d: %s", len(got), data)
+	}
+	check := func(property string, expected any) {
+		t.Helper()
+		if !reflect.DeepEqual(got[0][property], expected) {
+			t.Errorf("unexpected value for %q, expected %#v got %#v", property, expected, got[0][property])
+		}
+	}
+	check("deps", []any{"B"})
+	check("split", []any{"a"})
+	check("outgoing", nil)
+	check("mutated", map[string]any{"mutated": true, "value": "a"})
+	if name := got[1]["name"]; name != "A" {
+		t.Errorf("unexpected name, expected %q got %#v", "A", name)
+	}
+
+	if _, err := ctx.ModulePropertiesJSON(&transitionModule{}); err == nil {
+		t.Errorf("expected error for module not in the context")
+	}
+}
+
+func TestModuleMetadata(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+				split: ["a", "b"],
+			}
+
+			transition_module {
+				name: "B",
+			}
+		`),
+	})
+	ctx.RegisterBottomUpMutator("owner", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "A" {
+			mctx.SetModuleMetadata("owner", "team-a")
+		}
+	})
+	ctx.RegisterTransitionMutator("transition", transitionTestMutator{})
+	ctx.RegisterBottomUpMutator("variant", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "A" {
+			mctx.SetModuleMetadata("variant", mctx.Module().(*transitionModule).properties.Mutated)
+		}
+	})
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	check := func(module Module, expected map[string]string) {
+		t.Helper()
+		if g := ctx.ModuleMetadata(module); !reflect.DeepEqual(g, expected) {
+			t.Errorf("expected metadata %v, got %v", expected, g)
+		}
+	}
+	check(getTransitionModule(ctx, "A", "a"), map[string]string{"owner": "team-a", "variant": "a"})
+	check(getTransitionModule(ctx, "A", "b"), map[string]string{"owner": "team-a", "variant": "b"})
+	check(getTransitionModule(ctx, "B", ""), nil)
+
+	graph := &strings.Builder{}
+	ctx.PrintJSONGraphAndActions(graph, &strings.Builder{})
+	var modules []JsonModule
+	if err := json.Unmarshal([]byte(graph.String()), &modules); err != nil {
+		t.Fatalf("invalid JSON graph: %s", err)
+	}
+	got := make(map[string]map[string]string)
+	for _, m := range modules {
+		got[m.Name+"("+m.Variant+")"] = m.Metadata
+	}
+	expected := map[string]map[string]string{
+		"A(a)": {"owner": "team-a", "variant": "a"},
+		"A(b)": {"owner": "team-a", "variant": "b"},
+		"B()":  nil,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected metadata in JSON graph %v, got %v", expected, got)
+	}
+}
+
+func TestTransitionVariantProperties(t *testing.T) {
+	ctx, errs := testTransition(`
+		transition_module {
+			name: "A",
+			split: ["a", "b", "c"],
+			post_transition_deps: ["B"],
+			stem: "base",
+			target: {
+				a: {
+					post_transition_deps: ["C"],
+					stem: "a",
+				},
+				b: {
+					stem: "b",
+				},
+			},
+		}
+
+		transition_module {
+			name: "B",
+			split: ["a", "b", "c"],
+		}
+
+		transition_module {
+			name: "C",
+			split: ["a", "b", "c"],
+			stem: "base",
+			target: {
+				a: {
+					post_transition_deps: ["B"],
+				},
+			},
+		}
+	`)
+	assertNoErrors(t, errs)
+
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(a)", "C(a)")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "b"), "B(b)")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "c"), "B(c)")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "C", "a"), "B(a)")
+
+	if g := proptools.String(getTransitionModule(ctx, "C", "a").properties.Stem); g != "base" {
+		t.Errorf("expected stem %q for C(a), got %q", "base", g)
+	}
+
+	for variant, stem := range map[string]string{"a": "a", "b": "b", "c": "base"} {
+		if g := proptools.String(getTransitionModule(ctx, "A", variant).properties.Stem); g != stem {
+			t.Errorf("expected stem %q for variant %q, got %q", stem, variant, g)
+		}
+	}
+}
+
+func TestLateVariationDependencies(t *testing.T) {
+	run := func(deps ...string) (*Context, []error) {
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				transition_module {
+					name: "A",
+					split: ["a", "b"],
+				}
+
+				transition_module {
+					name: "B",
+					split: ["a", "b"],
+				}
+
+				transition_module {
+					name: "C",
+				}
+			`),
+		})
+		ctx.RegisterBottomUpMutator("late_deps", func(mctx BottomUpMutatorContext) {
+			if mctx.ModuleName() == "A" {
+				mctx.AddLateVariationDependencies([]Variation{{Mutator: "transition", Variation: "b"}},
+					walkerDepsTag{follow: true}, deps...)
+			}
+		})
+		ctx.RegisterTransitionMutator("transition", transitionTestMutator{})
+		ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+
+	t.Run("variations", func(t *testing.T) {
+		ctx, errs := run("B", "B:a")
+		assertNoErrors(t, errs)
+		checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(b)", "B(a)")
+		checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "b"), "B(b)", "B(a)")
+	})
+
+	t.Run("missing variant", func(t *testing.T) {
+		_, errs := run("C")
+		assertOneErrorMatches(t, errs, `dependency "C" of "A" missing variant:\n  transition:b\navailable variants:\n  <empty variant>`)
+	})
+}
+
+type transitionVariantsSingleton struct {
+	variants []string
+}
+
+func (s *transitionVariantsSingleton) GenerateBuildActions(ctx SingletonContext) {
+	ctx.VisitAllModules(func(m Module) {
+		s.variants = append(s.variants, ctx.ModuleName(m)+"("+ctx.ModuleSubDir(m)+")")
+	})
+}
+
+func TestSingletonOrder(t *testing.T) {
+	bp := `
+		transition_module {
+			name: "A",
+			split: ["b", "a"],
+		}
+	`
+
+	run := func(after ...string) (*transitionVariantsSingleton, []error) {
+		singleton := &transitionVariantsSingleton{}
+		ctx, errs := testTransitionCommon(bp, false, func(ctx *Context) {
+			ctx.RegisterSingletonType("variants", func() Singleton { return singleton }, false,
+				SingletonOrder{After: after})
+		})
+		assertNoErrors(t, errs)
+		_, errs = ctx.PrepareBuildActions(nil)
+		return singleton, errs
+	}
+
+	t.Run("after transition", func(t *testing.T) {
+		singleton, errs := run("transition")
+		assertNoErrors(t, errs)
+		if g, w := singleton.variants, []string{"A(b)", "A(a)"}; !slices.Equal(g, w) {
+			t.Errorf("expected variants %q, got %q", w, g)
+		}
+	})
+
+	t.Run("unknown mutator", func(t *testing.T) {
+		_, errs := run("arch")
+		assertOneErrorMatches(t, errs, `singleton "variants" must run after mutator "arch", which is not registered`)
+	})
+}
+
+func TestTransitionVisitDirectReverseDeps(t *testing.T) {
+	ctx, errs := testTransition(fmt.Sprintf(testTransitionBp, "", ""))
+	assertNoErrors(t, errs)
+
+	check := func(name, variant string, expected ...string) {
+		t.Helper()
+		var got []string
+		ctx.VisitDirectReverseDeps(getTransitionModule(ctx, name, variant), func(m Module) {
+			got = append(got, ctx.ModuleName(m)+"("+ctx.ModuleSubDir(m)+")")
+		})
+		if !slices.Equal(got, expected) {
+			t.Errorf("unexpected %s(%s) reverse dependencies, got %q expected %q", name, variant, got, expected)
+		}
+	}
+
+	check("A", "a")
+	check("B", "b", "A(b)")
+	check("C", "a", "A(a)")
+	check("C", "c", "B()", "B(a)", "B(b)")
+	check("D", "d", "C()", "C(a)", "C(b)", "C(c)")
+}
+
+func TestOutgoingTransitionDepName(t *testing.T) {
+	ctx, errs := testTransition(fmt.Sprintf(testTransitionBp,
+		`outgoing_for: ["C:b", "F:f"],
+		post_transition_deps: ["C", "F"],`,
+		""))
+	assertNoErrors(t, errs)
+
+	// B chooses variant b of C instead of its usual outgoing c, both for its pre-declared dependency
+	// and for the one added after the mutator.  F forces its "" variant in IncomingTransition.
+	checkTransitionVariants(t, ctx, "C", []string{"", "a", "b"})
+	checkTransitionVariants(t, ctx, "F", []string{""})
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", "a"), "C(b)", "C(b)", "F()")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", "b"), "C(b)", "C(b)", "F()")
+}
+
 func TestPostTransitionDeps(t *testing.T) {
 	ctx, errs := testTransition(fmt.Sprintf(testTransitionBp,
 		`post_transition_deps: ["C", "D:late", "E:d", "F"],`,
@@ -302,32 +697,53 @@ func TestPostTransitionDeps(t *testing.T) {
 	checkTransitionMutate(t, H_h, "h")
 }
 
-func TestPostTransitionReverseDeps(t *testing.T) {
-	ctx, errs := testTransition(`
+func TestPostTransitionDepsReturnedModules(t *testing.T) {
+	var added []string
+	ctx, errs := testTransitionCommon(`
 		transition_module {
 			name: "A",
-			split: ["a1", "a2"],
+			split: ["a"],
 		}
 
 		transition_module {
 			name: "B",
-			split: ["a1", "a2"],
-			post_transition_reverse_deps: ["A"],
+			split: ["a"],
 		}
-	`)
-	assertNoErrors(t, errs)
 
-	checkTransitionVariants(t, ctx, "A", []string{"a1", "a2"})
-	checkTransitionVariants(t, ctx, "B", []string{"a1", "a2"})
+		transition_module {
+			name: "C",
+			split: ["c"],
+		}
+	`, false, func(ctx *Context) {
+		ctx.SetAllowMissingDependencies(true)
+		ctx.RegisterBottomUpMutator("add_deps", func(mctx BottomUpMutatorContext) {
+			if mctx.ModuleName() != "A" {
+				return
+			}
+			deps := mctx.AddVariationDependencies(nil, walkerDepsTag{follow: true}, "B", "missing", "C", "B")
+			for _, dep := range deps {
+				if dep == nil {
+					added = append(added, "nil")
+				} else {
+					added = append(added, fmt.Sprintf("%s(%s)", mctx.OtherModuleName(dep),
+						dep.(*transitionModule).properties.Mutated))
+				}
+			}
+		})
+	})
+	assertNoErrors(t, errs)
 
-	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a1"), "B(a1)")
-	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a2"), "B(a2)")
-	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", "a1"))
-	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", "a2"))
+	// C has no "a" variant, so it is missing as well.
+	expected := []string{"B(a)", "nil", "nil", "B(a)"}
+	if !slices.Equal(added, expected) {
+		t.Errorf("expected AddVariationDependencies to return %q, got %q", expected, added)
+	}
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(a)", "B(a)")
 }
 
-func TestPostTransitionReverseVariationDeps(t *testing.T) {
-	ctx, errs := testTransition(`
+func TestAddVariationDependencyIfExists(t *testing.T) {
+	var added []string
+	ctx, errs := testTransitionCommon(`
 		transition_module {
 			name: "A",
 			split: ["a"],
@@ -335,64 +751,1380 @@ func TestPostTransitionReverseVariationDeps(t *testing.T) {
 
 		transition_module {
 			name: "B",
-			split: ["b"],
-			post_transition_reverse_variation_deps: ["A(a)"],
+			split: ["a", "b"],
 		}
-	`)
+
+		transition_module {
+			name: "C",
+			split: ["c"],
+		}
+	`, false, func(ctx *Context) {
+		ctx.RegisterBottomUpMutator("add_deps", func(mctx BottomUpMutatorContext) {
+			if mctx.ModuleName() != "A" {
+				return
+			}
+			for _, name := range []string{"B", "C", "missing"} {
+				dep := mctx.AddVariationDependencyIfExists(nil, walkerDepsTag{follow: true}, name)
+				if dep == nil {
+					added = append(added, "nil")
+				} else {
+					added = append(added, fmt.Sprintf("%s(%s)", mctx.OtherModuleName(dep),
+						dep.(*transitionModule).properties.Mutated))
+				}
+			}
+		})
+	})
 	assertNoErrors(t, errs)
 
-	checkTransitionVariants(t, ctx, "A", []string{"a"})
-	checkTransitionVariants(t, ctx, "B", []string{"b"})
+	expected := []string{"B(a)", "nil", "nil"}
+	if !slices.Equal(added, expected) {
+		t.Errorf("expected AddVariationDependencyIfExists to return %q, got %q", expected, added)
+	}
+	a := getTransitionModule(ctx, "A", "a")
+	checkTransitionDeps(t, ctx, a, "B(a)")
+	if missing := ctx.moduleInfo[a].missingDeps; len(missing) > 0 {
+		t.Errorf("expected no missing deps, got %q", missing)
+	}
+}
 
-	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(b)")
-	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", "b"))
+type fixedSplitTransitionMutator struct {
+	noopTransitionMutator
+	variations []string
 }
 
-func TestFarVariationDep(t *testing.T) {
-	ctx, errs := testTransition(`
+func (m fixedSplitTransitionMutator) Split(ctx BaseModuleContext) []string {
+	return m.variations
+}
+
+func TestMutatorOrderDOT(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTransitionMutator("arch", fixedSplitTransitionMutator{variations: []string{"x86"}}).
+		After("os")
+	ctx.RegisterTopDownMutator("top_down", func(TopDownMutatorContext) {})
+	ctx.RegisterTransitionMutator("os", fixedSplitTransitionMutator{variations: []string{"linux"}})
+	ctx.DisableMutators("top_down")
+
+	buf := &strings.Builder{}
+	if err := ctx.MutatorOrderDOT(buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph mutators {
+	node [shape=box];
+	"blueprint_deps" [label="blueprint_deps\nbottom-up"];
+	"deps" [label="deps\nbottom-up"];
+	"os" [label="os\ntransition"];
+	"top_down" [label="top_down\ntop-down", style=dashed];
+	"arch" [label="arch\ntransition"];
+	"blueprint_deps" -> "deps";
+	"deps" -> "os";
+	"os" -> "top_down";
+	"top_down" -> "arch";
+	"os" -> "arch" [style=dashed, label="after"];
+}
+`
+	if g := buf.String(); g != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, g)
+	}
+
+	if g, w := ctx.mutatorInfo[1].name, "deps"; g != w {
+		t.Errorf("expected MutatorOrderDOT not to reorder the mutators, got %q", g)
+	}
+	if g, w := ctx.mutatorInfo[2].registeredName(), "arch"; g != w {
+		t.Errorf("expected MutatorOrderDOT not to reorder the mutators, got %q at index 2, want %q", g, w)
+	}
+
+	ctx.RegisterTransitionMutator("cycle", fixedSplitTransitionMutator{variations: []string{"c"}}).
+		After("arch").Before("os")
+	if err := ctx.MutatorOrderDOT(buf); err == nil || !strings.Contains(err.Error(), "cycle in transition mutator ordering") {
+		t.Errorf("expected a cycle error, got %v", err)
+	}
+}
+
+func TestTransitionMutatorOrdering(t *testing.T) {
+	bp := `
 		transition_module {
 			name: "A",
 			split: ["a"],
-			deps: ["B"],
 		}
-		transition_module {
-			name: "B",
-			split: ["", "a"],
-		}
-		transition_module {
-			name: "C",
-			split: ["c"],
-			post_transition_far_deps: ["D"],
+	`
+
+	t.Run("after", func(t *testing.T) {
+		ctx, errs := testTransitionCommon(bp, false, func(ctx *Context) {
+			ctx.RegisterTransitionMutator("arch", fixedSplitTransitionMutator{variations: []string{"x86"}}).
+				After("os")
+			ctx.RegisterTransitionMutator("os", fixedSplitTransitionMutator{variations: []string{"linux"}})
+		})
+		assertNoErrors(t, errs)
+
+		var names []string
+		for _, m := range ctx.transitionMutators {
+			names = append(names, m.name)
 		}
-		transition_module {
-			name: "D",
-			split: ["", "c"],
+		if g, w := names, []string{"transition", "os", "arch"}; !slices.Equal(g, w) {
+			t.Errorf("expected transition mutators %q, got %q", w, g)
 		}
-	`)
-	assertNoErrors(t, errs)
+		getTransitionModule(ctx, "A", "a_linux_x86")
+	})
 
-	checkTransitionVariants(t, ctx, "A", []string{"a"})
-	checkTransitionVariants(t, ctx, "B", []string{"", "a"})
-	checkTransitionVariants(t, ctx, "C", []string{"c"})
-	checkTransitionVariants(t, ctx, "D", []string{"", "c"})
+	t.Run("before", func(t *testing.T) {
+		ctx, errs := testTransitionCommon(bp, false, func(ctx *Context) {
+			ctx.RegisterTransitionMutator("arch", fixedSplitTransitionMutator{variations: []string{"x86"}})
+			ctx.RegisterTransitionMutator("os", fixedSplitTransitionMutator{variations: []string{"linux"}}).
+				Before("transition")
+		})
+		assertNoErrors(t, errs)
+		getTransitionModule(ctx, "A", "x86_linux_a")
+	})
 
-	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(a)")
-	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "C", "c"), "D()")
+	t.Run("cycle", func(t *testing.T) {
+		_, errs := testTransitionCommon(bp, false, func(ctx *Context) {
+			ctx.RegisterTransitionMutator("arch", fixedSplitTransitionMutator{variations: []string{"x86"}}).
+				After("os")
+			ctx.RegisterTransitionMutator("os", fixedSplitTransitionMutator{variations: []string{"linux"}}).
+				After("arch")
+		})
+		assertOneErrorMatches(t, errs, `cycle in transition mutator ordering: (arch -> os -> arch|os -> arch -> os)`)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, errs := testTransitionCommon(bp, false, func(ctx *Context) {
+			ctx.RegisterTransitionMutator("arch", fixedSplitTransitionMutator{variations: []string{"x86"}}).
+				After("deps")
+		})
+		assertOneErrorMatches(t, errs,
+			`transition mutator "arch" is ordered relative to "deps", which is not a registered transition mutator`)
+	})
 }
 
-func TestNeverFarFarVariationDep(t *testing.T) {
-	ctx, errs := testTransitionNeverFar(`
+func TestVariantStats(t *testing.T) {
+	ctx, errs := testTransitionCommon(`
 		transition_module {
 			name: "A",
-			split: ["a"],
-			deps: ["B"],
+			split: ["a", "b", "c"],
 		}
+
 		transition_module {
 			name: "B",
-			split: ["", "a"],
 		}
-		transition_module {
-			name: "C",
+	`, false, func(ctx *Context) {
+		ctx.RegisterTransitionMutator("os", fixedSplitTransitionMutator{variations: []string{"linux", "darwin"}})
+	})
+	assertNoErrors(t, errs)
+
+	expected := map[string]VariantStat{
+		"transition": {InputModules: 2, OutputVariants: 4},
+		"os":         {InputModules: 4, OutputVariants: 8},
+	}
+	if g := ctx.VariantStats(); !reflect.DeepEqual(g, expected) {
+		t.Errorf("expected variant stats %v, got %v", expected, g)
+	}
+}
+
+func TestVariantStatsOnlyRunMutators(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+				split: ["a", "b"],
+			}
+		`),
+	})
+	ctx.RegisterTransitionMutator("transition", transitionTestMutator{})
+	ctx.RegisterTransitionMutator("os", fixedSplitTransitionMutator{variations: []string{"linux", "darwin"}})
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+	ctx.DisableMutators("os")
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+
+	if g := ctx.VariantStats(); len(g) != 0 {
+		t.Errorf("expected no variant stats before ResolveDependencies, got %v", g)
+	}
+
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	expected := map[string]VariantStat{
+		"transition": {InputModules: 1, OutputVariants: 2},
+	}
+	if g := ctx.VariantStats(); !reflect.DeepEqual(g, expected) {
+		t.Errorf("expected variant stats %v, got %v", expected, g)
+	}
+}
+
+func TestResolveDependenciesDryRun(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(fmt.Sprintf(testTransitionBp, "", "")),
+	})
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTransitionMutator("transition", transitionTestMutator{})
+	ctx.RegisterBottomUpMutator("post_transition_deps", postTransitionDepsMutator).UsesReverseDependencies()
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+
+	edges, errs := ctx.ResolveDependenciesDryRun(nil)
+	assertNoErrors(t, errs)
+
+	var got []string
+	for _, edge := range edges {
+		got = append(got, fmt.Sprintf("%s(%s) -> %s(%s)", edge.From, edge.FromVariant, edge.To, edge.ToVariant))
+	}
+	expected := []string{
+		"A(b) -> B(b)",
+		"A(b) -> C(b)",
+		"A(a) -> B(a)",
+		"A(a) -> C(a)",
+		"B() -> C(c)",
+		"B(a) -> C(c)",
+		"B(b) -> C(c)",
+		"C() -> D(d)",
+		"C(a) -> D(d)",
+		"C(b) -> D(d)",
+		"C(c) -> D(d)",
+		"D() -> E()",
+		"D(d) -> E(d)",
+	}
+	if !slices.Equal(got, expected) {
+		t.Errorf("expected edges:\n  %s\ngot:\n  %s", strings.Join(expected, "\n  "), strings.Join(got, "\n  "))
+	}
+
+	// The context was not modified by the dry run, so it can be resolved for real.
+	if ctx.moduleGroupFromName("A", nil).modules.firstModule().variant.name != "" {
+		t.Errorf("expected A to not be split by the dry run")
+	}
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+	checkTransitionVariants(t, ctx, "A", []string{"b", "a"})
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(a)", "C(a)")
+}
+
+// configTransitionMutator splits every module into the variations listed in the config.
+type configTransitionMutator struct{}
+
+func (configTransitionMutator) Split(ctx BaseModuleContext) []string {
+	return ctx.Config().([]string)
+}
+
+func (configTransitionMutator) OutgoingTransition(ctx OutgoingTransitionContext, sourceVariation string) string {
+	return sourceVariation
+}
+
+func (configTransitionMutator) IncomingTransition(ctx IncomingTransitionContext, incomingVariation string) string {
+	return incomingVariation
+}
+
+func (configTransitionMutator) Mutate(ctx BottomUpMutatorContext, variation string) {}
+
+func TestCloneForConfig(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+				deps: ["B"],
+			}
+
+			transition_module {
+				name: "B",
+			}
+		`),
+	})
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTransitionMutator("transition", configTransitionMutator{})
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+
+	configs := [][]string{{"x", "y"}, {"z"}}
+	clones := make([]*Context, len(configs))
+	cloneErrs := make([]error, len(configs))
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clones[i], cloneErrs[i] = ctx.CloneForConfig(config)
+		}()
+	}
+	wg.Wait()
+
+	for i, config := range configs {
+		if cloneErrs[i] != nil {
+			t.Fatalf("unexpected error for config %q: %s", config, cloneErrs[i])
+		}
+		clone := clones[i]
+		checkTransitionVariants(t, clone, "A", config)
+		checkTransitionVariants(t, clone, "B", config)
+		for _, variation := range config {
+			checkTransitionDeps(t, clone, getTransitionModule(clone, "A", variation), "B("+variation+")")
+		}
+	}
+
+	// The original context was not modified, so it can be resolved for real.
+	if ctx.moduleGroupFromName("A", nil).modules.firstModule().variant.name != "" {
+		t.Errorf("expected A to not be split by CloneForConfig")
+	}
+	_, errs = ctx.ResolveDependencies([]string{"w"})
+	assertNoErrors(t, errs)
+	checkTransitionVariants(t, ctx, "A", []string{"w"})
+
+	if _, err := ctx.CloneForConfig([]string{"v"}); err == nil {
+		t.Errorf("expected an error calling CloneForConfig after ResolveDependencies")
+	}
+}
+
+func TestCloneForConfigSettings(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+			}
+		`),
+	})
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	store := &mapCacheStore{}
+	ctx.RegisterTransitionMutator("transition", configTransitionMutator{}).
+		WithPersistentCache(store).UsesAliasVariations()
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	ctx.SetIgnoreUnknownModuleTypes(true)
+	ctx.SetAllowMissingDependencies(true)
+	ctx.SetAllowEmptyPathGlobs(true)
+	ctx.SetErrorOnDuplicateOutputs(true)
+	ctx.SetCheckRuleArgs(true)
+	ctx.SetInternRules(true)
+	ctx.SetErrorOnAmbiguousFarDependencies(true)
+	ctx.SetStringInterpolation(true)
+	ctx.SetVariantSeparator("-")
+	ctx.SetDependencyPathRoot("A")
+	ctx.DisableMutators("deps")
+	ctx.SetDeterministicDependencyOrder(true)
+	ctx.SetModuleVisitOrder(ModuleVisitOrderAlphabetical)
+	ctx.SetParallelGenerateBuildActions(true)
+	ctx.SetIncludeDisabledModules(true)
+	ctx.SetDependencyNameResolver(func(from Module, name string) string { return name })
+	ctx.RegisterDependencyTag("dep", walkerDepsTag{})
+	if err := ctx.SetNinjaVariable("toolchain", "clang"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ctx.AddIncludeTags("tag")
+	ctx.AddSourceRootDirs("dir")
+	ctx.SetIncrementalAnalysis(true)
+	ctx.SetIncrementalEnabled(true)
+	ctx.AddManifestRegenerationRule("regen", nil)
+	ctx.SetBeforePrepareBuildActionsHook(func() error { return nil })
+	ctx.SetMutatorTraceHook(func(string, Module, Phase) {})
+	trace := ctx.TraceModule("A")
+	snapshot := ctx.SnapshotAfterMutator("transition")
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	ctx.SetModuleListFile("modules.list")
+
+	clone, err := ctx.CloneForConfig([]string{"x"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	funcPointer := func(f interface{}) uintptr { return reflect.ValueOf(f).Pointer() }
+
+	settings := []struct {
+		name            string
+		original, clone interface{}
+	}{
+		{"ignoreUnknownModuleTypes", ctx.ignoreUnknownModuleTypes, clone.ignoreUnknownModuleTypes},
+		{"allowMissingDependencies", ctx.allowMissingDependencies, clone.allowMissingDependencies},
+		{"allowEmptyPathGlobs", ctx.allowEmptyPathGlobs, clone.allowEmptyPathGlobs},
+		{"errorOnDuplicateOutputs", ctx.errorOnDuplicateOutputs, clone.errorOnDuplicateOutputs},
+		{"checkRuleArgs", ctx.checkRuleArgs, clone.checkRuleArgs},
+		{"internRules", ctx.internRules, clone.internRules},
+		{"errorOnAmbiguousFarDependencies", ctx.errorOnAmbiguousFarDependencies, clone.errorOnAmbiguousFarDependencies},
+		{"stringInterpolation", ctx.stringInterpolation, clone.stringInterpolation},
+		{"variantSeparator", ctx.variantSeparator, clone.variantSeparator},
+		{"dependencyPathRoot", ctx.dependencyPathRoot, clone.dependencyPathRoot},
+		{"disabledMutators", ctx.disabledMutators, clone.disabledMutators},
+		{"deterministicDependencyOrder", ctx.deterministicDependencyOrder, clone.deterministicDependencyOrder},
+		{"moduleVisitOrder", ctx.moduleVisitOrder, clone.moduleVisitOrder},
+		{"parallelGenerateBuildActions", ctx.parallelGenerateBuildActions, clone.parallelGenerateBuildActions},
+		{"includeDisabledModules", ctx.includeDisabledModules, clone.includeDisabledModules},
+		{"dependencyNameResolver", funcPointer(ctx.dependencyNameResolver), funcPointer(clone.dependencyNameResolver)},
+		{"dependencyTagNames", ctx.dependencyTagNames, clone.dependencyTagNames},
+		{"dependencyTagsByName", ctx.dependencyTagsByName, clone.dependencyTagsByName},
+		{"ninjaVariables", ctx.ninjaVariables, clone.ninjaVariables},
+		{"moduleListFile", ctx.moduleListFile, clone.moduleListFile},
+		{"srcDir", ctx.srcDir, clone.srcDir},
+		{"fs", ctx.fs, clone.fs},
+		{"includeTags", ctx.includeTags, clone.includeTags},
+		{"sourceRootDirs", ctx.sourceRootDirs, clone.sourceRootDirs},
+		{"incrementalAnalysis", ctx.incrementalAnalysis, clone.incrementalAnalysis},
+		{"incrementalEnabled", ctx.incrementalEnabled, clone.incrementalEnabled},
+		{"manifestRegeneration", ctx.manifestRegeneration, clone.manifestRegeneration},
+		{"BeforePrepareBuildActionsHook", funcPointer(ctx.BeforePrepareBuildActionsHook), funcPointer(clone.BeforePrepareBuildActionsHook)},
+		{"mutatorTraceHook", funcPointer(ctx.mutatorTraceHook), funcPointer(clone.mutatorTraceHook)},
+		{"moduleTraces", ctx.moduleTraces, clone.moduleTraces},
+		{"graphSnapshots", slices.Sorted(maps.Keys(ctx.graphSnapshots)), slices.Sorted(maps.Keys(clone.graphSnapshots))},
+	}
+	for _, setting := range settings {
+		if !reflect.DeepEqual(setting.original, setting.clone) {
+			t.Errorf("expected %s of the clone to be %v, got %v", setting.name, setting.original, setting.clone)
+		}
+	}
+
+	var impl *transitionMutatorImpl
+	for _, mutator := range clone.mutatorInfo {
+		if mutator.propagatesTransitionMutator != nil {
+			impl = mutator.propagatesTransitionMutator
+		}
+	}
+	if impl.cache != nil {
+		t.Errorf("expected the clone to not use the persistent cache")
+	}
+	if !impl.usesAliasVariations {
+		t.Errorf("expected the clone to keep UsesAliasVariations")
+	}
+
+	if clone.TraceModule("A") != trace {
+		t.Errorf("expected the clone to record into the trace of the original")
+	}
+	if clone.SnapshotAfterMutator("transition") == snapshot {
+		t.Errorf("expected the clone to take its own snapshot")
+	}
+}
+
+func TestResolveDependenciesForRoots(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+				deps: ["B"],
+			}
+
+			transition_module {
+				name: "B",
+				deps: ["C"],
+			}
+
+			transition_module {
+				name: "C",
+			}
+
+			transition_module {
+				name: "D",
+				deps: ["E"],
+			}
+
+			transition_module {
+				name: "E",
+			}
+		`),
+	})
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTransitionMutator("transition", configTransitionMutator{})
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+
+	if errs := ctx.ResolveDependenciesForRoots([]string{"F"}, []string{"x"}); len(errs) != 1 ||
+		errs[0].Error() != `root module "F" does not exist` {
+		t.Errorf("expected an error for an unknown root, got %q", errs)
+	}
+
+	errs = ctx.ResolveDependenciesForRoots([]string{"A"}, []string{"x", "y"})
+	assertNoErrors(t, errs)
+
+	for _, name := range []string{"A", "B", "C"} {
+		checkTransitionVariants(t, ctx, name, []string{"x", "y"})
+	}
+	for _, name := range []string{"D", "E"} {
+		if ctx.moduleGroupFromName(name, nil) != nil {
+			t.Errorf("expected %s to not be resolved", name)
+		}
+	}
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "x"), "B(x)")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", "y"), "C(y)")
+
+	if errs := ctx.ResolveDependenciesForRoots([]string{"A"}, []string{"x"}); len(errs) == 0 {
+		t.Errorf("expected an error calling ResolveDependenciesForRoots after ResolveDependencies")
+	}
+}
+
+func TestResolveDependenciesForRootsReverseDeps(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+				deps: ["B"],
+				post_transition_deps: ["C:x"],
+			}
+
+			transition_module {
+				name: "B",
+			}
+
+			transition_module {
+				name: "C",
+			}
+
+			transition_module {
+				name: "R",
+				deps: ["S"],
+				post_transition_reverse_deps: ["A"],
+			}
+
+			transition_module {
+				name: "S",
+			}
+
+			transition_module {
+				name: "D",
+			}
+		`),
+	})
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTransitionMutator("transition", configTransitionMutator{})
+	ctx.RegisterBottomUpMutator("post_transition_deps", postTransitionDepsMutator).UsesReverseDependencies()
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+
+	errs = ctx.ResolveDependenciesForRoots([]string{"A"}, []string{"x"})
+	assertNoErrors(t, errs)
+
+	// C is only named as "C:x", so it is found by resolving the kept modules.  R adds a reverse
+	// dependency on A, so it and its dependencies are kept.
+	for _, name := range []string{"A", "B", "C", "R", "S"} {
+		checkTransitionVariants(t, ctx, name, []string{"x"})
+	}
+	if ctx.moduleGroupFromName("D", nil) != nil {
+		t.Errorf("expected D to not be resolved")
+	}
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "x"), "B(x)", "C(x)", "R(x)")
+}
+
+func TestModuleExistsAndHasVariant(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+			}
+
+			transition_module {
+				name: "B",
+			}
+		`),
+	})
+	ctx.RegisterTransitionMutator("transition", configTransitionMutator{})
+	var got []string
+	ctx.RegisterBottomUpMutator("query", func(ctx BottomUpMutatorContext) {
+		if ctx.ModuleName() != "A" || ctx.Module() != ctx.PrimaryModule() {
+			return
+		}
+		got = append(got,
+			fmt.Sprint(ctx.ModuleExists("B")),
+			fmt.Sprint(ctx.ModuleExists("missing")),
+			fmt.Sprint(ctx.ModuleHasVariant("B", "transition", "y")),
+			fmt.Sprint(ctx.ModuleHasVariant("B", "transition", "z")),
+			fmt.Sprint(ctx.ModuleHasVariant("B", "other", "")),
+			fmt.Sprint(ctx.ModuleHasVariant("missing", "transition", "x")))
+	})
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+	ctx.SetAllowMissingDependencies(true)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies([]string{"x", "y"})
+	assertNoErrors(t, errs)
+
+	if w := []string{"true", "false", "true", "false", "true", "false"}; !slices.Equal(got, w) {
+		t.Errorf("expected %q, got %q", w, got)
+	}
+	a := getTransitionModule(ctx, "A", "x")
+	checkTransitionDeps(t, ctx, a)
+	if missing := ctx.moduleInfo[a].missingDeps; len(missing) > 0 {
+		t.Errorf("expected no missing dependencies, got %q", missing)
+	}
+}
+
+func TestFindModules(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "libfoo",
+			}
+
+			transition_module {
+				name: "libbar",
+			}
+
+			transition_module {
+				name: "foo_test",
+			}
+		`),
+	})
+	ctx.RegisterTransitionMutator("transition", configTransitionMutator{})
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies([]string{"x", "y"})
+	assertNoErrors(t, errs)
+
+	describe := func(modules []Module) []string {
+		var ret []string
+		for _, m := range modules {
+			ret = append(ret, m.Name()+":"+ctx.ModuleSubDir(m))
+		}
+		return ret
+	}
+
+	if g, w := describe(ctx.FindModules(regexp.MustCompile("^lib"))),
+		[]string{"libbar:x", "libbar:y", "libfoo:x", "libfoo:y"}; !slices.Equal(g, w) {
+		t.Errorf("expected FindModules to return %q, got %q", w, g)
+	}
+	if g, w := describe(ctx.FindModules(regexp.MustCompile("foo"))),
+		[]string{"foo_test:x", "foo_test:y", "libfoo:x", "libfoo:y"}; !slices.Equal(g, w) {
+		t.Errorf("expected FindModules to return %q, got %q", w, g)
+	}
+	if g := ctx.FindModules(regexp.MustCompile("^missing$")); len(g) != 0 {
+		t.Errorf("expected FindModules to return no modules, got %q", describe(g))
+	}
+
+	if g, w := describe(ctx.FindModuleVariants("libfoo")), []string{"libfoo:x", "libfoo:y"}; !slices.Equal(g, w) {
+		t.Errorf("expected FindModuleVariants to return %q, got %q", w, g)
+	}
+	if g := ctx.FindModuleVariants("lib"); g != nil {
+		t.Errorf("expected FindModuleVariants to return nil, got %q", describe(g))
+	}
+}
+
+func TestVariantSeparator(t *testing.T) {
+	run := func(t *testing.T, sep string, mutators ...string) []string {
+		t.Helper()
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				transition_module {
+					name: "A",
+				}
+			`),
+		})
+		for _, mutator := range mutators {
+			ctx.RegisterTransitionMutator(mutator, configTransitionMutator{})
+		}
+		ctx.RegisterModuleType("transition_module", newTransitionModule)
+		if sep != "" {
+			ctx.SetVariantSeparator(sep)
+		}
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies([]string{"x", "y"})
+		assertNoErrors(t, errs)
+
+		var subDirs []string
+		for _, m := range ctx.FindModuleVariants("A") {
+			subDirs = append(subDirs, ctx.ModuleSubDir(m))
+		}
+		return subDirs
+	}
+
+	if g, w := run(t, "", "os", "arch"), []string{"x_x", "x_y", "y_x", "y_y"}; !slices.Equal(g, w) {
+		t.Errorf("expected default variants %q, got %q", w, g)
+	}
+	if g, w := run(t, "-", "os", "arch"), []string{"x-x", "x-y", "y-x", "y-y"}; !slices.Equal(g, w) {
+		t.Errorf("expected variants %q, got %q", w, g)
+	}
+	if g, w := run(t, "-", "os"), []string{"x", "y"}; !slices.Equal(g, w) {
+		t.Errorf("expected single axis variants %q, got %q", w, g)
+	}
+
+	for _, sep := range []string{"", "/", "a b", "$", ":"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected SetVariantSeparator(%q) to panic", sep)
+				}
+			}()
+			newContext().SetVariantSeparator(sep)
+		}()
+	}
+}
+
+func TestDisableMutators(t *testing.T) {
+	run := func(t *testing.T, disabled ...string) (*Context, []error) {
+		t.Helper()
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				transition_module {
+					name: "A",
+					deps: ["B"],
+				}
+
+				transition_module {
+					name: "B",
+				}
+			`),
+		})
+		ctx.RegisterBottomUpMutator("deps", depsMutator)
+		ctx.RegisterTransitionMutator("os", configTransitionMutator{})
+		ctx.RegisterTransitionMutator("arch", configTransitionMutator{}).After("os")
+		ctx.RegisterModuleType("transition_module", newTransitionModule)
+		ctx.DisableMutators(disabled...)
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies([]string{"x", "y"})
+		return ctx, errs
+	}
+
+	variants := func(ctx *Context, name string) []string {
+		var ret []string
+		for _, m := range ctx.FindModuleVariants(name) {
+			ret = append(ret, ctx.ModuleSubDir(m))
+		}
+		return ret
+	}
+
+	deps := func(ctx *Context, name string) []string {
+		var ret []string
+		ctx.VisitDirectDeps(ctx.FindModuleVariants(name)[0], func(m Module) {
+			ret = append(ret, m.Name())
+		})
+		return ret
+	}
+
+	t.Run("none", func(t *testing.T) {
+		ctx, errs := run(t)
+		assertNoErrors(t, errs)
+		if g, w := variants(ctx, "A"), []string{"x_x", "x_y", "y_x", "y_y"}; !slices.Equal(g, w) {
+			t.Errorf("expected variants %q, got %q", w, g)
+		}
+		if g, w := deps(ctx, "A"), []string{"B"}; !slices.Equal(g, w) {
+			t.Errorf("expected dependencies %q, got %q", w, g)
+		}
+	})
+
+	t.Run("transition", func(t *testing.T) {
+		ctx, errs := run(t, "os")
+		assertNoErrors(t, errs)
+		if g, w := variants(ctx, "A"), []string{"x", "y"}; !slices.Equal(g, w) {
+			t.Errorf("expected variants %q, got %q", w, g)
+		}
+		for _, name := range []string{"os_propagate", "os", "os_mutate"} {
+			if !ctx.HasMutatorFinished(name) {
+				t.Errorf("expected disabled mutator %q to be finished", name)
+			}
+		}
+		var warnings []string
+		for _, w := range ctx.Warnings() {
+			warnings = append(warnings, w.Error())
+		}
+		if w := []string{`transition mutator "arch" is ordered relative to disabled mutator "os"`}; !slices.Equal(warnings, w) {
+			t.Errorf("expected warnings %q, got %q", w, warnings)
+		}
+	})
+
+	t.Run("deps", func(t *testing.T) {
+		ctx, errs := run(t, "deps", "arch")
+		assertNoErrors(t, errs)
+		if g := deps(ctx, "A"); len(g) > 0 {
+			t.Errorf("expected no dependencies, got %q", g)
+		}
+		if len(ctx.Warnings()) > 0 {
+			t.Errorf("expected no warnings, got %q", ctx.Warnings())
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, errs := run(t, "os_propagate", "missing")
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		if w := []string{`cannot disable unknown mutator "missing"`,
+			`cannot disable unknown mutator "os_propagate"`}; !slices.Equal(got, w) {
+			t.Errorf("expected errors %q, got %q", w, got)
+		}
+	})
+}
+
+// sourceTransitionMutator splits C into shared and static variants, and chooses the variant of C
+// for each dependency from the stem property of the depending module.
+type sourceTransitionMutator struct{}
+
+func (sourceTransitionMutator) Split(ctx BaseModuleContext) []string {
+	if ctx.ModuleName() == "C" {
+		return []string{"shared", "static"}
+	}
+	return []string{""}
+}
+
+func (sourceTransitionMutator) OutgoingTransition(ctx OutgoingTransitionContext, sourceVariation string) string {
+	return ""
+}
+
+func (sourceTransitionMutator) IncomingTransition(ctx IncomingTransitionContext, incomingVariation string) string {
+	if ctx.Module().Name() != "C" {
+		return ""
+	}
+	if stem := ctx.SourceModule().(*transitionModule).properties.Stem; stem != nil {
+		return *stem
+	}
+	return "shared"
+}
+
+func (sourceTransitionMutator) Mutate(ctx BottomUpMutatorContext, variation string) {}
+
+func TestIncomingTransitionSourceModule(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+				deps: ["C"],
+				stem: "static",
+			}
+
+			transition_module {
+				name: "B",
+				deps: ["C"],
+			}
+
+			transition_module {
+				name: "C",
+			}
+		`),
+	})
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTransitionMutator("transition", sourceTransitionMutator{})
+	ctx.RegisterBottomUpMutator("late", func(ctx BottomUpMutatorContext) {
+		if ctx.ModuleName() == "A" {
+			ctx.AddVariationDependencies(nil, walkerDepsTag{follow: true}, "C")
+		}
+	})
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	checkTransitionVariants(t, ctx, "C", []string{"shared", "static"})
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", ""), "C(static)", "C(static)")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", ""), "C(shared)")
+}
+
+func TestSkipMutator(t *testing.T) {
+	ctx, errs := testTransition(`
+		transition_module {
+			name: "A",
+			split: ["a", "b"],
+			deps: ["F"],
+			post_transition_deps: ["G"],
+		}
+
+		transition_module {
+			name: "F",
+			split: ["f"],
+			incoming: "f",
+			skip_mutators: ["transition"],
+		}
+
+		transition_module {
+			name: "G",
+			incoming: "g",
+			skip_mutators: ["transition", "post_transition_deps"],
+			post_transition_deps: ["missing"],
+		}
+	`)
+	assertNoErrors(t, errs)
+
+	checkTransitionVariants(t, ctx, "A", []string{"a", "b"})
+	// F and G are not split, and Mutate is not called on them.
+	checkTransitionVariants(t, ctx, "F", []string{""})
+	checkTransitionVariants(t, ctx, "G", []string{""})
+	checkTransitionMutate(t, getTransitionModule(ctx, "F", ""), "")
+	checkTransitionMutate(t, getTransitionModule(ctx, "G", ""), "")
+
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "F()", "G()")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "b"), "F()", "G()")
+}
+
+func TestSkipMutatorAlreadyRun(t *testing.T) {
+	_, errs := testTransition(`
+		transition_module {
+			name: "A",
+			skip_mutators: ["deps"],
+		}
+	`)
+	assertOneErrorMatches(t, errs, `SkipMutator called from mutator "skip_mutators" with mutator "deps" that has already run`)
+}
+
+func TestMutatorTraceHook(t *testing.T) {
+	var lock sync.Mutex
+	var mutators []string
+	running := make(map[Module]string)
+	_, errs := testTransitionCommon(`
+		transition_module {
+			name: "A",
+			split: ["a", "b"],
+		}
+
+		transition_module {
+			name: "B",
+		}
+	`, false, func(ctx *Context) {
+		ctx.SetMutatorTraceHook(func(mutatorName string, m Module, phase Phase) {
+			lock.Lock()
+			defer lock.Unlock()
+			switch phase {
+			case PhaseStart:
+				if running[m] != "" {
+					t.Errorf("mutator %s started on %s while %s was running", mutatorName, m.Name(), running[m])
+				}
+				running[m] = mutatorName
+				if m.Name() == "A" && !slices.Contains(mutators, mutatorName) {
+					mutators = append(mutators, mutatorName)
+				}
+			case PhaseEnd:
+				if running[m] != mutatorName {
+					t.Errorf("mutator %s ended on %s while %q was running", mutatorName, m.Name(), running[m])
+				}
+				delete(running, m)
+			}
+		})
+	})
+	assertNoErrors(t, errs)
+
+	expected := []string{"deps", "skip_mutators", "transition_propagate", "transition", "transition_mutate",
+		"post_transition_deps"}
+	if !slices.Equal(mutators, expected) {
+		t.Errorf("expected mutators %q to visit A, got %q", expected, mutators)
+	}
+	if len(running) > 0 {
+		t.Errorf("expected all mutators to have ended, still running %v", running)
+	}
+
+	for phase, expected := range map[Phase]string{PhaseStart: "start", PhaseEnd: "end", 2: "Phase(2)"} {
+		if g := phase.String(); g != expected {
+			t.Errorf("expected phase %d to be %q, got %q", int(phase), expected, g)
+		}
+	}
+}
+
+func TestTopDownMutatorAfterTransition(t *testing.T) {
+	var lock sync.Mutex
+	ctx, errs := testTransitionCommon(fmt.Sprintf(testTransitionBp, `post_transition_deps: ["F"],`, ""),
+		false, func(ctx *Context) {
+			ctx.RegisterTopDownMutator("push", func(mctx TopDownMutatorContext) {
+				m := mctx.Module().(*transitionModule)
+				from := fmt.Sprintf("%s(%s)", mctx.ModuleName(), m.properties.Mutated)
+				mctx.VisitDirectDeps(func(dep Module) {
+					lock.Lock()
+					defer lock.Unlock()
+					d := dep.(*transitionModule)
+					d.properties.Pushed_from = append(d.properties.Pushed_from, from)
+				})
+			}).MutatesDependencies()
+		})
+	assertNoErrors(t, errs)
+
+	checkPushedFrom := func(name, variant string, expected ...string) {
+		t.Helper()
+		got := getTransitionModule(ctx, name, variant).properties.Pushed_from
+		slices.Sort(got)
+		if !slices.Equal(got, expected) {
+			t.Errorf("expected %s(%s) to be pushed from %q, got %q", name, variant, expected, got)
+		}
+	}
+
+	checkPushedFrom("A", "a")
+	checkPushedFrom("B", "a", "A(a)")
+	checkPushedFrom("C", "a", "A(a)")
+	checkPushedFrom("C", "c", "B()", "B(a)", "B(b)")
+	checkPushedFrom("D", "d", "C()", "C(a)", "C(b)", "C(c)")
+	// F was added by post_transition_deps after the transition mutator ran.
+	checkPushedFrom("F", "", "B()", "B(a)", "B(b)")
+}
+
+func TestPostTransitionDepsEdgeMetrics(t *testing.T) {
+	ctx, errs := testTransition(fmt.Sprintf(testTransitionBp,
+		`post_transition_deps: ["C", "D:late", "E:d", "F"],`,
+		`post_transition_deps: ["H"],`))
+	assertNoErrors(t, errs)
+
+	B_a := getTransitionModule(ctx, "B", "a")
+	C_c := getTransitionModule(ctx, "C", "c")
+	D_d := getTransitionModule(ctx, "D", "d")
+	H_h := getTransitionModule(ctx, "H", "h")
+
+	if g, w := ctx.OutDegree(B_a), 5; g != w {
+		t.Errorf("expected out-degree of B(a) to be %d, got %d", w, g)
+	}
+	// C(c) is depended on twice by each of B(), B(a) and B(b).
+	if g, w := ctx.InDegree(C_c), 6; g != w {
+		t.Errorf("expected in-degree of C(c) to be %d, got %d", w, g)
+	}
+	// D(d) is depended on by C(), C(a), C(b), C(c), B(), B(a) and B(b).
+	if g, w := ctx.InDegree(D_d), 7; g != w {
+		t.Errorf("expected in-degree of D(d) to be %d, got %d", w, g)
+	}
+	if g, w := ctx.InDegree(H_h), 1; g != w {
+		t.Errorf("expected in-degree of H(h) to be %d, got %d", w, g)
+	}
+
+	// A(a) and A(b) have 2 edges each, B(), B(a) and B(b) have 5 each, C(), C(a), C(b) and C(c)
+	// have 1 each, D() and D(d) have 1 each and G has 1.
+	if g, w := ctx.EdgeCount(), 2*2+3*5+4*1+2*1+1; g != w {
+		t.Errorf("expected %d edges, got %d", w, g)
+	}
+}
+
+func TestPostTransitionDepsWalkTransitionInfo(t *testing.T) {
+	ctx, errs := testTransition(fmt.Sprintf(testTransitionBp,
+		`post_transition_deps: ["C", "D:late", "E:d", "F"],`,
+		`post_transition_deps: ["H"],`))
+	assertNoErrors(t, errs)
+
+	var got []string
+	ctx.WalkDepsWithTransitionInfo(getTransitionModule(ctx, "A", "a"), func(child, parent Module, info TransitionInfo) bool {
+		if len(info.Transitions) != 1 || info.Transitions[0].Mutator != "transition" {
+			t.Fatalf("unexpected transitions %v", info.Transitions)
+		}
+		tr := info.Transitions[0]
+		got = append(got, fmt.Sprintf("%s(%s)->%s(%s): %q %q %q",
+			ctx.ModuleName(parent), ctx.ModuleSubDir(parent), ctx.ModuleName(child), ctx.ModuleSubDir(child),
+			tr.SourceVariation, tr.OutgoingVariation, tr.FinalVariation))
+		return true
+	})
+
+	expected := []string{
+		`A(a)->B(a): "a" "a" "a"`,
+		// C(c) rewritten by OutgoingTransition on B
+		`B(a)->C(c): "a" "c" "c"`,
+		// D(d) rewritten by IncomingTransition on D
+		`C(c)->D(d): "c" "c" "d"`,
+		`D(d)->E(d): "d" "d" "d"`,
+		// F() rewritten by OutgoingTransition on B and then IncomingTransition on F
+		`B(a)->F(): "a" "c" ""`,
+		`A(a)->C(a): "a" "a" "a"`,
+	}
+	if !slices.Equal(got, expected) {
+		t.Errorf("unexpected transition info:\n got: %q\nwant: %q", got, expected)
+	}
+}
+
+func TestPostTransitionDepsWalkTransitionInfoExplicit(t *testing.T) {
+	ctx, errs := testTransition(fmt.Sprintf(testTransitionBp,
+		`post_transition_deps: ["C", "D:late", "E:d", "F"],`,
+		`post_transition_deps: ["H"],`))
+	assertNoErrors(t, errs)
+
+	var got []string
+	ctx.WalkDepsWithTransitionInfo(getTransitionModule(ctx, "B", "a"), func(child, parent Module, info TransitionInfo) bool {
+		tr := info.Transitions[0]
+		got = append(got, fmt.Sprintf("%s(%s): %q %q %q", ctx.ModuleName(child), ctx.ModuleSubDir(child),
+			tr.SourceVariation, tr.OutgoingVariation, tr.FinalVariation))
+		return false
+	})
+
+	expected := []string{
+		// C(c) is both a dependency and a post transition dependency of B
+		`C(c): "a" "c" "c"`,
+		`C(c): "a" "c" "c"`,
+		// D(d) was added by D:late and rewritten by IncomingTransition on D
+		`D(d): "a" "late" "d"`,
+		// E(d) was added with an explicitly requested variation, which is reported as the outgoing variation
+		`E(d): "a" "d" "d"`,
+		`F(): "a" "c" ""`,
+	}
+	if !slices.Equal(got, expected) {
+		t.Errorf("unexpected transition info:\n got: %q\nwant: %q", got, expected)
+	}
+}
+
+func TestPostTransitionDepsTraceModule(t *testing.T) {
+	var trace *ModuleTrace
+	_, errs := testTransitionCommon(fmt.Sprintf(testTransitionBp,
+		`post_transition_deps: ["C", "D:late", "E:d", "F"],`,
+		`post_transition_deps: ["H"],`), false, func(ctx *Context) {
+		trace = ctx.TraceModule("B")
+	})
+	assertNoErrors(t, errs)
+
+	if got := trace.Events()[0].String(); got != "[parse] defined at Android.bp:8:4" {
+		t.Errorf("unexpected first event %q", got)
+	}
+
+	// Variants are visited in parallel, only check the events of a single variant.
+	var got []string
+	for _, event := range trace.Events() {
+		if event.Variant == "a" {
+			got = append(got, event.String())
+		}
+	}
+	expected := []string{
+		`[transition] (a) direct dependencies are [module "C" variant "c"]`,
+		`[transition_mutate] (a) visited by bottom up mutator`,
+		`[post_transition_deps] (a) visited by bottom up mutator`,
+		`[post_transition_deps] (a) added dependency on module "C" variant "c" with tag blueprint.walkerDepsTag`,
+		`[post_transition_deps] (a) added dependency on module "D" variant "d" with tag blueprint.walkerDepsTag`,
+		`[post_transition_deps] (a) added dependency on module "E" variant "d" with tag blueprint.walkerDepsTag`,
+		`[post_transition_deps] (a) added dependency on module "F" with tag blueprint.walkerDepsTag`,
+	}
+	if !slices.Equal(got, expected) {
+		t.Errorf("unexpected trace for B(a):\n got: %q\nwant: %q", got, expected)
+	}
+
+	// The propagate pass records the variations that B requests from its dependencies.
+	var propagate []string
+	for _, event := range trace.Events() {
+		if event.Phase == "transition_propagate" {
+			propagate = append(propagate, event.Message)
+		}
+	}
+	expectedPropagate := []string{
+		"visited by top down mutator",
+		`requires variations ["" "a" "b"], outgoing transitions [["c"] ["c"] ["c"]]`,
+	}
+	if !slices.Equal(propagate, expectedPropagate) {
+		t.Errorf("unexpected transition_propagate trace for B:\n got: %q\nwant: %q", propagate, expectedPropagate)
+	}
+}
+
+func TestSkipIncomingTransition(t *testing.T) {
+	ctx, errs := testTransition(`
+		transition_module {
+			name: "A",
+			deps: ["B"],
+			split: ["a", "b"],
+			post_transition_deps: ["B"],
+		}
+
+		transition_module {
+			name: "B",
+			skip_incoming: ["b"],
+		}
+
+		transition_module {
+			name: "C",
+			deps: ["D"],
+		}
+
+		transition_module {
+			name: "D",
+			skip_incoming: [""],
+		}
+	`)
+	assertNoErrors(t, errs)
+
+	// No b variant is created for B.
+	checkTransitionVariants(t, ctx, "B", []string{"", "a"})
+	checkTransitionVariants(t, ctx, "D", []string{""})
+
+	// The dependency from the b variant of A on B is dropped, including the one added after the
+	// transition mutator.
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(a)", "B(a)")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "b"))
+
+	// Dependencies are also dropped from modules that are not split.
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "C", ""))
+	if got := ctx.InDegree(getTransitionModule(ctx, "D", "")); got != 0 {
+		t.Errorf("expected no reverse dependencies on D, got %d", got)
+	}
+}
+
+func TestPostTransitionReverseDeps(t *testing.T) {
+	ctx, errs := testTransition(`
+		transition_module {
+			name: "A",
+			split: ["a1", "a2"],
+		}
+
+		transition_module {
+			name: "B",
+			split: ["a1", "a2"],
+			post_transition_reverse_deps: ["A"],
+		}
+	`)
+	assertNoErrors(t, errs)
+
+	checkTransitionVariants(t, ctx, "A", []string{"a1", "a2"})
+	checkTransitionVariants(t, ctx, "B", []string{"a1", "a2"})
+
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a1"), "B(a1)")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a2"), "B(a2)")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", "a1"))
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", "a2"))
+}
+
+func TestPostTransitionReverseVariationDeps(t *testing.T) {
+	ctx, errs := testTransition(`
+		transition_module {
+			name: "A",
+			split: ["a"],
+		}
+
+		transition_module {
+			name: "B",
+			split: ["b"],
+			post_transition_reverse_variation_deps: ["A(a)"],
+		}
+	`)
+	assertNoErrors(t, errs)
+
+	checkTransitionVariants(t, ctx, "A", []string{"a"})
+	checkTransitionVariants(t, ctx, "B", []string{"b"})
+
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(b)")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", "b"))
+}
+
+func TestPostTransitionReverseVariationDepsErrors(t *testing.T) {
+	t.Run("multiple axes", func(t *testing.T) {
+		ctx, errs := testTransition(`
+			transition_module {
+				name: "A",
+				split: ["a"],
+			}
+
+			transition_module {
+				name: "B",
+				split: ["b"],
+				post_transition_reverse_variation_deps: ["A(transition:a)"],
+			}
+		`)
+		assertNoErrors(t, errs)
+		checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(b)")
+	})
+
+	t.Run("missing variant", func(t *testing.T) {
+		_, errs := testTransition(`
+			transition_module {
+				name: "A",
+				split: ["a"],
+			}
+
+			transition_module {
+				name: "B",
+				split: ["b"],
+				post_transition_reverse_variation_deps: ["A(c)"],
+			}
+		`)
+		assertOneErrorMatches(t, errs, `^Android.bp:7:4: reverse dependency "A" of "B" missing variant:\s*transition:c\s*`+
+			`available variants:\s*transition:a\n       Android.bp:2:4 <-- "A" defined here$`)
+	})
+
+	t.Run("unknown mutator", func(t *testing.T) {
+		_, errs := testTransition(`
+			transition_module {
+				name: "A",
+				split: ["a"],
+			}
+
+			transition_module {
+				name: "B",
+				split: ["b"],
+				post_transition_reverse_variation_deps: ["A(transition:a,arch:arm64)"],
+			}
+		`)
+		assertOneErrorMatches(t, errs, `^Android.bp:7:4: reverse dependency "A" of "B" requests variation "arm64" `+
+			`of "arch", which is not a transition mutator that has already run$`)
+	})
+}
+
+func TestParseNameAndVariations(t *testing.T) {
+	testCases := []struct {
+		in         string
+		name       string
+		variations []Variation
+		err        bool
+	}{
+		{in: "A(a)", name: "A", variations: []Variation{{"transition", "a"}}},
+		{in: "A()", name: "A", variations: []Variation{{"transition", ""}}},
+		{in: "A(transition:a, arch:arm64)", name: "A",
+			variations: []Variation{{"transition", "a"}, {"arch", "arm64"}}},
+		{in: "A", err: true},
+		{in: "(a)", err: true},
+		{in: "A(a)b", err: true},
+		{in: "A(:a)", err: true},
+		{in: "A(m:a:b)", err: true},
+	}
+	for _, tc := range testCases {
+		name, variations, err := parseNameAndVariations(tc.in)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected error", tc.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", tc.in, err)
+		} else if name != tc.name || !reflect.DeepEqual(variations, tc.variations) {
+			t.Errorf("%q: expected %q %v, got %q %v", tc.in, tc.name, tc.variations, name, variations)
+		}
+	}
+}
+
+func TestFarVariationDep(t *testing.T) {
+	ctx, errs := testTransition(`
+		transition_module {
+			name: "A",
+			split: ["a"],
+			deps: ["B"],
+		}
+		transition_module {
+			name: "B",
+			split: ["", "a"],
+		}
+		transition_module {
+			name: "C",
+			split: ["c"],
+			post_transition_far_deps: ["D"],
+		}
+		transition_module {
+			name: "D",
+			split: ["", "c"],
+		}
+	`)
+	assertNoErrors(t, errs)
+
+	checkTransitionVariants(t, ctx, "A", []string{"a"})
+	checkTransitionVariants(t, ctx, "B", []string{"", "a"})
+	checkTransitionVariants(t, ctx, "C", []string{"c"})
+	checkTransitionVariants(t, ctx, "D", []string{"", "c"})
+
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(a)")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "C", "c"), "D()")
+}
+
+func TestNeverFarFarVariationDep(t *testing.T) {
+	ctx, errs := testTransitionNeverFar(`
+		transition_module {
+			name: "A",
+			split: ["a"],
+			deps: ["B"],
+		}
+		transition_module {
+			name: "B",
+			split: ["", "a"],
+		}
+		transition_module {
+			name: "C",
 			split: ["c"],
 			post_transition_far_deps: ["D"],
 		}
@@ -412,52 +2144,663 @@ func TestNeverFarFarVariationDep(t *testing.T) {
 	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "C", "c"), "D(c)")
 }
 
-func TestPostTransitionReverseDepsErrorOnMissingDep(t *testing.T) {
+type noopTransitionMutator struct{}
+
+func (noopTransitionMutator) Split(ctx BaseModuleContext) []string {
+	return []string{""}
+}
+
+func (noopTransitionMutator) OutgoingTransition(ctx OutgoingTransitionContext, sourceVariation string) string {
+	return sourceVariation
+}
+
+func (noopTransitionMutator) IncomingTransition(ctx IncomingTransitionContext, incomingVariation string) string {
+	return incomingVariation
+}
+
+func (noopTransitionMutator) Mutate(ctx BottomUpMutatorContext, variation string) {
+}
+
+func TestNeverFarForFarVariationDep(t *testing.T) {
+	run := func(neverFarFor ...string) *Context {
+		t.Helper()
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				transition_module {
+					name: "C",
+					split: ["c"],
+				}
+				transition_module {
+					name: "D",
+					split: ["", "c"],
+				}
+			`),
+		})
+		ctx.RegisterTransitionMutator("transition", transitionTestMutator{}).NeverFarFor(neverFarFor...)
+		ctx.RegisterTransitionMutator("other", noopTransitionMutator{})
+		ctx.RegisterBottomUpMutator("far_deps", func(mctx BottomUpMutatorContext) {
+			if mctx.ModuleName() == "C" {
+				mctx.AddFarVariationDependencies(nil, walkerDepsTag{follow: true}, "D")
+				mctx.AddFarVariationDependencies([]Variation{{Mutator: "other", Variation: ""}},
+					walkerDepsTag{follow: true}, "D")
+			}
+		})
+		ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies(nil)
+		assertNoErrors(t, errs)
+		return ctx
+	}
+
+	// Never far for far dependencies that request "other" variations.
+	ctx := run("other")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "C", "c"), "D(c)", "D(c)")
+
+	// Far for far dependencies that request "other" variations.
+	ctx = run("arch")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "C", "c"), "D(c)", "D()")
+}
+
+func TestNeverFarForNoMutators(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("expected NeverFarFor with no mutator names to panic")
+		}
+		want := `NeverFarFor called with no mutator names for transition mutator "transition", use NeverFar instead`
+		if err, ok := r.(error); !ok || err.Error() != want {
+			t.Errorf("expected panic %q, got %q", want, r)
+		}
+	}()
+
+	ctx := newContext()
+	ctx.RegisterTransitionMutator("transition", transitionTestMutator{}).NeverFarFor()
+}
+
+func TestFarVariationDepSubset(t *testing.T) {
+	runWithAmbiguityErrors := func(variations []Variation, errorOnAmbiguous bool) (*Context, []error) {
+		t.Helper()
+		ctx := newContext()
+		ctx.SetErrorOnAmbiguousFarDependencies(errorOnAmbiguous)
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				transition_module {
+					name: "C",
+					split: ["c"],
+				}
+				transition_module {
+					name: "D",
+					split: ["", "c"],
+				}
+			`),
+		})
+		ctx.RegisterTransitionMutator("transition", transitionTestMutator{})
+		ctx.RegisterTransitionMutator("other", fixedSplitTransitionMutator{variations: []string{"x", "y"}})
+		ctx.RegisterBottomUpMutator("far_deps", func(mctx BottomUpMutatorContext) {
+			if mctx.ModuleName() == "C" {
+				mctx.AddFarVariationDependencies(variations, walkerDepsTag{follow: true}, "D")
+			}
+		})
+		ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+	run := func(variations []Variation) (*Context, []error) {
+		t.Helper()
+		return runWithAmbiguityErrors(variations, false)
+	}
+
+	t.Run("closest", func(t *testing.T) {
+		ctx, errs := run([]Variation{{Mutator: "other", Variation: "y"}})
+		assertNoErrors(t, errs)
+		checkTransitionDeps(t, ctx, getTransitionModule(ctx, "C", "c_x"), "D(y)")
+	})
+
+	t.Run("exact", func(t *testing.T) {
+		ctx, errs := run([]Variation{{Mutator: "transition", Variation: "c"}, {Mutator: "other", Variation: "y"}})
+		assertNoErrors(t, errs)
+		checkTransitionDeps(t, ctx, getTransitionModule(ctx, "C", "c_x"), "D(c_y)")
+	})
+
+	t.Run("ambiguous", func(t *testing.T) {
+		ctx, errs := run([]Variation{{Mutator: "transition", Variation: "c"}})
+		assertNoErrors(t, errs)
+		checkTransitionDeps(t, ctx, getTransitionModule(ctx, "C", "c_x"), "D(c_x)")
+	})
+
+	t.Run("ambiguous error", func(t *testing.T) {
+		_, errs := runWithAmbiguityErrors([]Variation{{Mutator: "transition", Variation: "c"}}, true)
+		assertOneErrorMatches(t, errs, `far dependency "D" of "C" matches multiple variants equally well:\s*transition:c\s*candidates:\s*transition:c,other:x\s*transition:c,other:y`)
+	})
+}
+
+func TestExplainFarMatch(t *testing.T) {
+	bp := `
+		transition_module {
+			name: "C",
+			split: ["c"],
+		}
+		transition_module {
+			name: "D",
+			split: ["", "c"],
+		}
+	`
+
+	t.Run("closest", func(t *testing.T) {
+		ctx, errs := testTransition(bp)
+		assertNoErrors(t, errs)
+		got := ctx.ExplainFarMatch(getTransitionModule(ctx, "C", "c"), "D")
+		want := "far dependency \"D\" of \"C\" requests:\n" +
+			"  <empty variant>\n" +
+			"candidates:\n" +
+			"  <empty variant>: exact match\n" +
+			"  transition:c: 1 extra variations\n" +
+			"selected: <empty variant>\n"
+		if got != want {
+			t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+		}
+	})
+
+	t.Run("requested", func(t *testing.T) {
+		ctx, errs := testTransition(bp)
+		assertNoErrors(t, errs)
+		got := ctx.ExplainFarMatch(getTransitionModule(ctx, "C", "c"), "D",
+			Variation{Mutator: "transition", Variation: "c"})
+		want := "far dependency \"D\" of \"C\" requests:\n" +
+			"  transition:c\n" +
+			"candidates:\n" +
+			"  <empty variant>: does not match\n" +
+			"  transition:c: exact match\n" +
+			"selected: transition:c\n"
+		if got != want {
+			t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+		}
+	})
+
+	t.Run("never far", func(t *testing.T) {
+		ctx, errs := testTransitionNeverFar(bp)
+		assertNoErrors(t, errs)
+		got := ctx.ExplainFarMatch(getTransitionModule(ctx, "C", "c"), "D")
+		want := "far dependency \"D\" of \"C\" requests:\n" +
+			"  transition:c\n" +
+			"candidates:\n" +
+			"  <empty variant>: does not match\n" +
+			"  transition:c: exact match\n" +
+			"selected: transition:c\n"
+		if got != want {
+			t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+		}
+	})
+
+	t.Run("unknown module", func(t *testing.T) {
+		ctx, errs := testTransition(bp)
+		assertNoErrors(t, errs)
+		m := &transitionModule{}
+		m.SimpleName.Properties.Name = "X"
+		got := ctx.ExplainFarMatch(m, "D")
+		if want := "far dependency \"D\" of \"X\": module \"X\" is not known to the Context"; got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		ctx, errs := testTransition(bp)
+		assertNoErrors(t, errs)
+		got := ctx.ExplainFarMatch(getTransitionModule(ctx, "C", "c"), "E")
+		if want := "far dependency \"E\" of \"C\": no module named \"E\""; got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	})
+}
+
+type requiredSplitTransitionMutator struct {
+	noopTransitionMutator
+}
+
+func (requiredSplitTransitionMutator) Split(ctx BaseModuleContext) []string {
+	return append([]string{""}, ctx.RequiredVariations()...)
+}
+
+func TestRequireVariation(t *testing.T) {
+	run := func(mutator string, unknownModule bool) (*Context, []error) {
+		t.Helper()
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				transition_module {
+					name: "A",
+					deps: ["B", "C"],
+				}
+				transition_module {
+					name: "B",
+				}
+				transition_module {
+					name: "C",
+				}
+			`),
+		})
+		ctx.RegisterBottomUpMutator("deps", depsMutator).MutatesDependencies()
+		ctx.RegisterBottomUpMutator("require", func(mctx BottomUpMutatorContext) {
+			if mctx.ModuleName() == "A" {
+				mctx.VisitDirectDeps(func(dep Module) {
+					if dep.Name() == "B" {
+						if unknownModule {
+							unknown := &transitionModule{}
+							unknown.SimpleName.Properties.Name = "X"
+							dep = unknown
+						}
+						mctx.RequireVariation(dep, mutator, "host")
+						mctx.RequireVariation(dep, mutator, "host")
+					}
+				})
+			}
+		})
+		ctx.RegisterTransitionMutator("transition", requiredSplitTransitionMutator{})
+		ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+
+	t.Run("marked", func(t *testing.T) {
+		ctx, errs := run("transition", false)
+		assertNoErrors(t, errs)
+		checkTransitionVariants(t, ctx, "A", []string{""})
+		checkTransitionVariants(t, ctx, "B", []string{"", "host"})
+		checkTransitionVariants(t, ctx, "C", []string{""})
+	})
+
+	t.Run("unknown mutator", func(t *testing.T) {
+		_, errs := run("deps", false)
+		assertOneErrorMatches(t, errs, `RequireVariation called with unknown transition mutator "deps"`)
+	})
+
+	t.Run("unknown module", func(t *testing.T) {
+		_, errs := run("transition", true)
+		assertOneErrorMatches(t, errs, `RequireVariation called with module "X" that is not known to the Context`)
+	})
+}
+
+type aliasTransitionMutator struct {
+	fixedSplitTransitionMutator
+	from, to string
+}
+
+func (m aliasTransitionMutator) Mutate(ctx BottomUpMutatorContext, variation string) {
+	if ctx.ModuleName() == "B" {
+		ctx.CreateAliasVariation(m.from, m.to)
+	}
+}
+
+func TestCreateAliasVariation(t *testing.T) {
+	run := func(from, to string) (*Context, []error) {
+		t.Helper()
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				transition_module {
+					name: "A",
+				}
+				transition_module {
+					name: "B",
+				}
+			`),
+		})
+		ctx.RegisterTransitionMutator("transition", aliasTransitionMutator{
+			fixedSplitTransitionMutator: fixedSplitTransitionMutator{variations: []string{"a", "b"}},
+			from:                        from,
+			to:                          to,
+		}).UsesAliasVariations()
+		ctx.RegisterBottomUpMutator("alias_deps", func(mctx BottomUpMutatorContext) {
+			if mctx.ModuleName() == "A" {
+				mctx.AddVariationDependencies([]Variation{{Mutator: "transition", Variation: "c"}},
+					walkerDepsTag{follow: true}, "B")
+				mctx.AddFarVariationDependencies([]Variation{{Mutator: "transition", Variation: "c"}},
+					walkerDepsTag{follow: true}, "B")
+			}
+		})
+		ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies(nil)
+		return ctx, errs
+	}
+
+	t.Run("alias", func(t *testing.T) {
+		ctx, errs := run("c", "b")
+		assertNoErrors(t, errs)
+		checkTransitionVariants(t, ctx, "B", []string{"a", "b"})
+		checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(b)", "B(b)")
+		checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "b"), "B(b)", "B(b)")
+	})
+
+	t.Run("existing variation", func(t *testing.T) {
+		_, errs := run("a", "b")
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), `cannot create alias "a", it is a variation of mutator "transition"`) {
+			t.Errorf("expected existing variation error, got %q", errs)
+		}
+	})
+
+	t.Run("missing target", func(t *testing.T) {
+		_, errs := run("c", "d")
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), `cannot create alias "c" for missing variation "d" of mutator "transition"`) {
+			t.Errorf("expected missing variation error, got %q", errs)
+		}
+	})
+}
+
+func TestPostTransitionReverseDepsErrorOnMissingDep(t *testing.T) {
+	_, errs := testTransition(`
+		transition_module {
+			name: "A",
+			split: ["a"],
+		}
+
+		transition_module {
+			name: "B",
+			split: ["b"],
+			post_transition_reverse_deps: ["A"],
+		}
+	`)
+	assertOneErrorMatches(t, errs, `reverse dependency "A" of "B" missing variant:\s*transition:b\s*available variants:\s*transition:a`)
+}
+
+func TestPostTransitionReverseDepsCreatingVariant(t *testing.T) {
+	var laterMutatorVisits sync.Map
+	ctx, errs := testTransitionCommon(`
+		transition_module {
+			name: "A",
+			split: ["a"],
+			deps: ["C"],
+		}
+
+		transition_module {
+			name: "B",
+			split: ["b"],
+			post_transition_reverse_creating_deps: ["A"],
+		}
+
+		transition_module {
+			name: "C",
+			split: ["a", "b"],
+		}
+	`, false, func(ctx *Context) {
+		ctx.RegisterBottomUpMutator("later", func(mctx BottomUpMutatorContext) {
+			laterMutatorVisits.Store(mctx.ModuleName()+"("+mctx.(*mutatorContext).module.variant.name+")", true)
+		})
+	})
+	assertNoErrors(t, errs)
+
+	checkTransitionVariants(t, ctx, "A", []string{"a", "b"})
+
+	A_b := getTransitionModule(ctx, "A", "b")
+	B_b := getTransitionModule(ctx, "B", "b")
+	checkTransitionDeps(t, ctx, A_b, "C(b)", "B(b)")
+	checkTransitionMutate(t, A_b, "b")
+	checkTransitionMutate(t, getTransitionModule(ctx, "A", "a"), "a")
+	checkTransitionMutate(t, B_b, "b")
+
+	if _, ok := laterMutatorVisits.Load("A(b)"); !ok {
+		t.Errorf("expected the created variant to be visited by later mutators")
+	}
+}
+
+type variantCreatedTransitionMutator struct {
+	transitionTestMutator
+	lock    sync.Mutex
+	created map[string]int
+}
+
+func (m *variantCreatedTransitionMutator) OnVariantCreated(ctx BaseModuleContext, variation string) {
+	module := ctx.Module().(*transitionModule)
+	if module.properties.Mutated != "" {
+		ctx.ModuleErrorf("OnVariantCreated called after Mutate")
+	}
+	module.properties.Stem = proptools.StringPtr("created_" + variation)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.created[ctx.ModuleName()+"("+variation+")"]++
+}
+
+func TestTransitionOnVariantCreated(t *testing.T) {
+	mutator := &variantCreatedTransitionMutator{created: make(map[string]int)}
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+				split: ["a"],
+			}
+
+			transition_module {
+				name: "B",
+				split: ["b"],
+				post_transition_reverse_creating_deps: ["A"],
+			}
+
+			transition_module {
+				name: "C",
+			}
+
+			transition_module {
+				name: "D",
+				split: ["", "d"],
+			}
+		`),
+	})
+	ctx.RegisterTransitionMutator("transition", mutator)
+	ctx.RegisterBottomUpMutator("post_transition_deps", postTransitionDepsMutator).UsesReverseDependencies()
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	// A(b) is created on demand by the reverse dependency from B(b), and C is not split.
+	expected := map[string]int{"A(a)": 1, "A(b)": 1, "B(b)": 1, "D()": 1, "D(d)": 1}
+	if !reflect.DeepEqual(mutator.created, expected) {
+		t.Errorf("expected OnVariantCreated calls %v, got %v", expected, mutator.created)
+	}
+
+	for _, v := range []struct{ name, variant, stem string }{
+		{"A", "a", "created_a"},
+		{"A", "b", "created_b"},
+		{"B", "b", "created_b"},
+		{"C", "", ""},
+		{"D", "", "created_"},
+		{"D", "d", "created_d"},
+	} {
+		m := getTransitionModule(ctx, v.name, v.variant)
+		if g := proptools.String(m.properties.Stem); g != v.stem {
+			t.Errorf("expected stem %q for %s(%s), got %q", v.stem, v.name, v.variant, g)
+		}
+	}
+}
+
+type outgoingVariationTag struct {
+	BaseDependencyTag
+	variation string
+}
+
+type depTagTransitionMutator struct {
+	transitionTestMutator
+}
+
+func (m depTagTransitionMutator) OutgoingTransition(ctx OutgoingTransitionContext, sourceVariation string) string {
+	if tag, ok := ctx.DepTag().(outgoingVariationTag); ok {
+		return tag.variation
+	}
+	return m.transitionTestMutator.OutgoingTransition(ctx, sourceVariation)
+}
+
+func TestOutgoingTransitionDepTag(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+				split: ["a"],
+				deps: ["C"],
+			}
+
+			transition_module {
+				name: "B",
+				split: ["a"],
+			}
+
+			transition_module {
+				name: "C",
+				split: ["a", "b"],
+			}
+		`),
+	})
+	ctx.RegisterBottomUpMutator("deps", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "B" {
+			mctx.AddDependency(mctx.Module(), outgoingVariationTag{variation: "b"}, "C")
+		} else {
+			depsMutator(mctx)
+		}
+	})
+	ctx.RegisterTransitionMutator("transition", depTagTransitionMutator{})
+	ctx.RegisterBottomUpMutator("post_transition_deps", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "A" {
+			mctx.AddVariationDependencies(nil, outgoingVariationTag{variation: "b"}, "C")
+		}
+	})
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	// The dependency added after the mutator ran sees the tag passed to AddVariationDependencies.
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "C(a)", "C(b)")
+	// The dependency that existed when the mutator ran sees the tag it was added with.
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", "a"), "C(b)")
+}
+
+func TestErrorInIncomingTransition(t *testing.T) {
+	_, errs := testTransition(`
+		transition_module {
+			name: "A",
+			split: ["a"],
+			deps: ["B"],
+		}
+		transition_module {
+			name: "B",
+			split: ["a"],
+			incoming_transition_error: "my incoming transition error",
+		}
+	`)
+	assertOneErrorMatches(t, errs, "my incoming transition error")
+}
+
+func TestErrorInOutgoingTransition(t *testing.T) {
 	_, errs := testTransition(`
 		transition_module {
 			name: "A",
 			split: ["a"],
+			deps: ["B"],
+			outgoing_transition_error: "my outgoing transition error",
 		}
-
 		transition_module {
 			name: "B",
-			split: ["b"],
-			post_transition_reverse_deps: ["A"],
+			split: ["a"],
 		}
 	`)
-	assertOneErrorMatches(t, errs, `reverse dependency "A" of "B" missing variant:\s*transition:b\s*available variants:\s*transition:a`)
+	assertOneErrorMatches(t, errs, "my outgoing transition error")
 }
 
-func TestErrorInIncomingTransition(t *testing.T) {
+func TestErrorsInTransitionsOfIndependentModules(t *testing.T) {
 	_, errs := testTransition(`
 		transition_module {
 			name: "A",
-			split: ["a"],
-			deps: ["B"],
+			split: ["a", "b"],
+			deps: ["B", "D"],
+			outgoing_transition_error: "A outgoing transition error",
 		}
 		transition_module {
 			name: "B",
 			split: ["a"],
-			incoming_transition_error: "my incoming transition error",
+		}
+		transition_module {
+			name: "C",
+			split: ["a", "b"],
+			deps: ["D"],
+		}
+		transition_module {
+			name: "D",
+			split: ["a"],
+			incoming_transition_error: "D incoming transition error",
+		}
+		transition_module {
+			name: "E",
+			deps: ["D"],
 		}
 	`)
-	assertOneErrorMatches(t, errs, "my incoming transition error")
+
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	slices.Sort(messages)
+	expected := []string{
+		`Android.bp:17:3: module "D": Error: A outgoing transition error`,
+		`Android.bp:17:3: module "D": Error: D incoming transition error`,
+		`Android.bp:8:3: module "B": Error: A outgoing transition error`,
+	}
+	if !slices.Equal(messages, expected) {
+		t.Errorf("expected errors:\n  %s\ngot:\n  %s", strings.Join(expected, "\n  "), strings.Join(messages, "\n  "))
+	}
 }
 
-func TestErrorInOutgoingTransition(t *testing.T) {
+func TestErrorsInTransitionsOfAddedDependencies(t *testing.T) {
 	_, errs := testTransition(`
 		transition_module {
 			name: "A",
 			split: ["a"],
-			deps: ["B"],
-			outgoing_transition_error: "my outgoing transition error",
+			post_transition_deps: ["C"],
 		}
 		transition_module {
 			name: "B",
 			split: ["a"],
+			post_transition_deps: ["D"],
+		}
+		transition_module {
+			name: "C",
+			split: ["a"],
+			incoming_transition_error: "C incoming transition error",
+		}
+		transition_module {
+			name: "D",
+			split: ["a"],
+			incoming_transition_error: "D incoming transition error",
 		}
 	`)
-	assertOneErrorMatches(t, errs, "my outgoing transition error")
+
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	slices.Sort(messages)
+	expected := []string{
+		`Android.bp:12:3: module "C": Error: C incoming transition error`,
+		`Android.bp:17:3: module "D": Error: D incoming transition error`,
+	}
+	if !slices.Equal(messages, expected) {
+		t.Errorf("expected errors:\n  %s\ngot:\n  %s", strings.Join(expected, "\n  "), strings.Join(messages, "\n  "))
+	}
 }
 
 func TestPostTransitionReverseDepsAllowMissingDeps(t *testing.T) {
@@ -490,6 +2833,114 @@ available variants:
 	}
 }
 
+func TestPostTransitionDepsMissingVariantError(t *testing.T) {
+	_, errs := testTransition(fmt.Sprintf(testTransitionBp,
+		`post_transition_deps: ["E:missing"],`, ""))
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %q", errs)
+	}
+
+	var missingVariantErr *MissingVariantError
+	if !errors.As(errs[0], &missingVariantErr) {
+		t.Fatalf("expected *MissingVariantError, got %T", errs[0])
+	}
+
+	if g, w := missingVariantErr.DepName, "E"; g != w {
+		t.Errorf("expected DepName %q, got %q", w, g)
+	}
+	if g, w := missingVariantErr.Requested, []Variation{{"transition", "missing"}}; !slices.Equal(g, w) {
+		t.Errorf("expected Requested %q, got %q", w, g)
+	}
+	wantAvailable := [][]Variation{nil, {{"transition", "d"}}}
+	if g, w := missingVariantErr.Available, wantAvailable; !slices.EqualFunc(g, w, slices.Equal) {
+		t.Errorf("expected Available %q, got %q", w, g)
+	}
+	if g, w := missingVariantErr.Suggestions(), []string{"transition:d"}; !slices.Equal(g, w) {
+		t.Errorf("expected Suggestions %q, got %q", w, g)
+	}
+}
+
+func TestDependencyVariantShorthand(t *testing.T) {
+	bp := `
+		transition_module {
+			name: "A",
+			split: ["a"],
+		}
+
+		transition_module {
+			name: "B",
+			split: ["b", "c"],
+		}
+	`
+	run := func(deps func(mctx BottomUpMutatorContext)) (*Context, []error) {
+		return testTransitionCommon(bp, false, func(ctx *Context) {
+			ctx.RegisterBottomUpMutator("variant_deps", func(mctx BottomUpMutatorContext) {
+				if mctx.ModuleName() == "A" {
+					deps(mctx)
+				}
+			})
+		})
+	}
+
+	t.Run("add", func(t *testing.T) {
+		ctx, errs := run(func(mctx BottomUpMutatorContext) {
+			mctx.AddDependency(mctx.Module(), walkerDepsTag{follow: true}, "B:c")
+			mctx.AddVariationDependencies([]Variation{{"transition", "c"}}, walkerDepsTag{follow: true}, "B:b")
+		})
+		assertNoErrors(t, errs)
+		checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(c)", "B(b)")
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, errs := run(func(mctx BottomUpMutatorContext) {
+			mctx.AddDependency(mctx.Module(), walkerDepsTag{follow: true}, "B:missing")
+		})
+		expectedError := `Android.bp:2:3: dependency "B" of "A" missing variant:
+  transition:missing
+available variants:
+  transition:b
+  transition:c`
+		if len(errs) != 1 || errs[0].Error() != expectedError {
+			t.Errorf("expected error %q, got %q", expectedError, errs)
+		}
+	})
+}
+
+func TestSplitDependencyVariant(t *testing.T) {
+	c := &Context{transitionMutators: []*transitionMutatorImpl{{name: "first"}, {name: "last"}}}
+	testCases := []struct {
+		dep            string
+		wantName       string
+		wantVariations []Variation
+	}{
+		{dep: "foo", wantName: "foo", wantVariations: []Variation{{"other", "x"}}},
+		{dep: "foo:bar", wantName: "foo", wantVariations: []Variation{{"other", "x"}, {"last", "bar"}}},
+		{dep: "foo:", wantName: "foo", wantVariations: []Variation{{"other", "x"}, {"last", ""}}},
+		{dep: "//ns:foo", wantName: "//ns:foo", wantVariations: []Variation{{"other", "x"}}},
+		{dep: "//ns:foo:bar", wantName: "//ns:foo", wantVariations: []Variation{{"other", "x"}, {"last", "bar"}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.dep, func(t *testing.T) {
+			name, variations, err := c.splitDependencyVariant(tc.dep, []Variation{{"other", "x"}})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if name != tc.wantName {
+				t.Errorf("expected name %q, got %q", tc.wantName, name)
+			}
+			if !slices.Equal(variations, tc.wantVariations) {
+				t.Errorf("expected variations %q, got %q", tc.wantVariations, variations)
+			}
+		})
+	}
+
+	_, _, err := (&Context{}).splitDependencyVariant("foo:bar", nil)
+	expectedError := `dependency "foo" selects variant "bar" before any transition mutator has run`
+	if err == nil || err.Error() != expectedError {
+		t.Errorf("expected error %q, got %q", expectedError, err)
+	}
+}
+
 func TestIsAddingDependency(t *testing.T) {
 	ctx, errs := testTransition(`
 		transition_module {
@@ -521,6 +2972,333 @@ func TestIsAddingDependency(t *testing.T) {
 	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", "b1"), "C(c2)")
 }
 
+type mapCacheStore struct {
+	sync.Mutex
+	blobs map[string][]byte
+}
+
+func (s *mapCacheStore) Get(key string) ([]byte, bool) {
+	s.Lock()
+	defer s.Unlock()
+	blob, ok := s.blobs[key]
+	return blob, ok
+}
+
+func (s *mapCacheStore) Put(key string, value []byte) {
+	s.Lock()
+	defer s.Unlock()
+	if s.blobs == nil {
+		s.blobs = make(map[string][]byte)
+	}
+	s.blobs[key] = value
+}
+
+type countingTransitionMutator struct {
+	transitionTestMutator
+	calls *atomic.Int64
+}
+
+func (m countingTransitionMutator) Split(ctx BaseModuleContext) []string {
+	m.calls.Add(1)
+	return m.transitionTestMutator.Split(ctx)
+}
+
+func (m countingTransitionMutator) OutgoingTransition(ctx OutgoingTransitionContext, sourceVariation string) string {
+	m.calls.Add(1)
+	return m.transitionTestMutator.OutgoingTransition(ctx, sourceVariation)
+}
+
+func (m countingTransitionMutator) IncomingTransition(ctx IncomingTransitionContext, incomingVariation string) string {
+	m.calls.Add(1)
+	return m.transitionTestMutator.IncomingTransition(ctx, incomingVariation)
+}
+
+func TestTransitionPersistentCache(t *testing.T) {
+	store := &mapCacheStore{}
+
+	run := func(bp string) (*Context, int64) {
+		t.Helper()
+		var calls atomic.Int64
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(bp),
+		})
+		ctx.RegisterBottomUpMutator("deps", depsMutator)
+		ctx.RegisterTransitionMutator("transition", countingTransitionMutator{calls: &calls}).
+			WithPersistentCache(store)
+		ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies(nil)
+		assertNoErrors(t, errs)
+		return ctx, calls.Load()
+	}
+
+	bp := fmt.Sprintf(testTransitionBp, "", "")
+
+	_, calls := run(bp)
+	if calls == 0 {
+		t.Fatalf("expected transition callbacks on the first run")
+	}
+
+	ctx, calls := run(bp)
+	if calls != 0 {
+		t.Errorf("expected no transition callbacks on a cache hit, got %d", calls)
+	}
+
+	checkTransitionVariants(t, ctx, "A", []string{"b", "a"})
+	checkTransitionVariants(t, ctx, "C", []string{"", "a", "b", "c"})
+	checkTransitionVariants(t, ctx, "D", []string{"", "d"})
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(a)", "C(a)")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "B", "b"), "C(c)")
+	checkTransitionMutate(t, getTransitionModule(ctx, "C", "c"), "c")
+
+	// Changing a module invalidates its entry and those of the modules that depend on it.
+	_, calls = run(strings.Replace(bp, `incoming: "d"`, `incoming: "e"`, 1))
+	if calls == 0 {
+		t.Errorf("expected transition callbacks after a module changed")
+	}
+}
+
+func TestTransitionPersistentCacheConfig(t *testing.T) {
+	store := &mapCacheStore{}
+
+	run := func(config []string) *Context {
+		t.Helper()
+		ctx := newContext()
+		ctx.MockFileSystem(map[string][]byte{
+			"Android.bp": []byte(`
+				transition_module {
+					name: "A",
+				}
+			`),
+		})
+		ctx.RegisterTransitionMutator("transition", configTransitionMutator{}).WithPersistentCache(store)
+		ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+		_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+		assertNoErrors(t, errs)
+		_, errs = ctx.ResolveDependencies(config)
+		assertNoErrors(t, errs)
+		return ctx
+	}
+
+	checkTransitionVariants(t, run([]string{"x"}), "A", []string{"x"})
+	// The decisions cached for the first config are not reused for a different config.
+	checkTransitionVariants(t, run([]string{"y", "z"}), "A", []string{"y", "z"})
+	checkTransitionVariants(t, run([]string{"x"}), "A", []string{"x"})
+}
+
+func TestTransitionBuildAllVariants(t *testing.T) {
+	ctx, errs := testTransition(`
+		transition_module {
+			name: "A",
+			deps: ["B", "D"],
+			split: ["b", "a"],
+		}
+
+		transition_module {
+			name: "B",
+		}
+
+		transition_module {
+			name: "D",
+			incoming: "d",
+		}
+
+		transition_module {
+			name: "X",
+			deps: ["Y"],
+			split: ["x"],
+			build_all_variants: true,
+		}
+
+		transition_module {
+			name: "Y",
+		}
+	`)
+	assertNoErrors(t, errs)
+
+	// X keeps its own split first, followed by every other variation seen in the build, sorted.
+	checkTransitionVariants(t, ctx, "X", []string{"x", "a", "b", "d"})
+	// Y gets all the variations of X through the normal transitions.
+	checkTransitionVariants(t, ctx, "Y", []string{"", "a", "b", "d", "x"})
+	// Modules that don't build all variants are unaffected.
+	checkTransitionVariants(t, ctx, "B", []string{"", "a", "b"})
+	checkTransitionVariants(t, ctx, "D", []string{"", "d"})
+
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "X", "d"), "Y(d)")
+	checkTransitionMutate(t, getTransitionModule(ctx, "X", "a"), "a")
+}
+
+func TestBuildAllVariantsErrorClearsState(t *testing.T) {
+	var ctx *Context
+	_, errs := testTransitionCommon(`
+		transition_module {
+			name: "X",
+			deps: ["Y"],
+			split: ["x"],
+			build_all_variants: true,
+			outgoing_transition_error: "X outgoing transition error",
+		}
+
+		transition_module {
+			name: "Y",
+		}
+	`, false, func(c *Context) {
+		ctx = c
+	})
+	if len(errs) == 0 {
+		t.Fatal("expected an error")
+	}
+
+	// The variations collected by the propagate pass are cleared even though it failed.
+	for _, mutator := range ctx.mutatorInfo {
+		if impl := mutator.propagatesTransitionMutator; impl != nil {
+			if impl.seenVariations != nil || impl.hasBuildAllVariants || impl.allVariations != nil {
+				t.Errorf("expected the propagate state of %s to be cleared, got %q %v %q", impl.name,
+					slices.Sorted(maps.Keys(impl.seenVariations)), impl.hasBuildAllVariants, impl.allVariations)
+			}
+		}
+	}
+}
+
+type splitDataTransitionMutator struct {
+	transitionTestMutator
+	seen *sync.Map
+}
+
+func (m splitDataTransitionMutator) SplitWithData(ctx BaseModuleContext) []VariantSpec {
+	var specs []VariantSpec
+	for _, variation := range m.Split(ctx) {
+		specs = append(specs, VariantSpec{Name: variation, Data: ctx.ModuleName() + "/" + variation})
+	}
+	return specs
+}
+
+func (m splitDataTransitionMutator) OutgoingTransition(ctx OutgoingTransitionContext, sourceVariation string) string {
+	m.seen.Store("outgoing "+ctx.Module().Name()+"("+sourceVariation+")", ctx.SplitData())
+	return m.transitionTestMutator.OutgoingTransition(ctx, sourceVariation)
+}
+
+func (m splitDataTransitionMutator) IncomingTransition(ctx IncomingTransitionContext, incomingVariation string) string {
+	m.seen.Store("incoming "+ctx.Module().Name()+"("+incomingVariation+")", ctx.SplitData())
+	return m.transitionTestMutator.IncomingTransition(ctx, incomingVariation)
+}
+
+func (m splitDataTransitionMutator) Mutate(ctx BottomUpMutatorContext, variation string) {
+	ctx.Module().(*transitionModule).properties.Mutated = fmt.Sprint(ctx.SplitData())
+}
+
+func TestTransitionSplitWithData(t *testing.T) {
+	seen := &sync.Map{}
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+				deps: ["B"],
+				split: ["b", "a"],
+			}
+
+			transition_module {
+				name: "B",
+				split: ["a"],
+			}
+		`),
+	})
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTransitionMutator("transition", splitDataTransitionMutator{seen: seen})
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	checkTransitionVariants(t, ctx, "B", []string{"a", "b"})
+
+	checkTransitionMutate(t, getTransitionModule(ctx, "A", "a"), "A/a")
+	checkTransitionMutate(t, getTransitionModule(ctx, "A", "b"), "A/b")
+	checkTransitionMutate(t, getTransitionModule(ctx, "B", "a"), "B/a")
+	// The b variant of B was only requested by A, it has no data.
+	checkTransitionMutate(t, getTransitionModule(ctx, "B", "b"), "<nil>")
+
+	expected := map[string]any{
+		"outgoing A(a)": "A/a",
+		"outgoing A(b)": "A/b",
+		// IncomingTransition on B is called before the propagate pass visits B.
+		"incoming B(a)": "B/a",
+		"incoming B(b)": nil,
+	}
+	for key, want := range expected {
+		got, ok := seen.Load(key)
+		if !ok {
+			t.Errorf("missing call %s", key)
+		} else if got != want {
+			t.Errorf("unexpected SplitData in %s, expected %v got %v", key, want, got)
+		}
+	}
+}
+
+type defaultTransitionMutator struct {
+	transitionTestMutator
+}
+
+func (defaultTransitionMutator) DefaultVariation(ctx BaseModuleContext) *string {
+	return ctx.Module().(*transitionModule).properties.Default_variation
+}
+
+func TestTransitionDefaultVariation(t *testing.T) {
+	ctx := newContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			transition_module {
+				name: "A",
+				deps: ["B", "C", "D"],
+				post_transition_deps: ["B"],
+				split: ["a", "b"],
+			}
+
+			transition_module {
+				name: "B",
+				split: ["x", "y"],
+				default_variation: "y",
+			}
+
+			transition_module {
+				name: "C",
+				split: ["a", "c"],
+				default_variation: "c",
+			}
+
+			transition_module {
+				name: "D",
+				split: ["d"],
+			}
+		`),
+	})
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	ctx.RegisterTransitionMutator("transition", defaultTransitionMutator{})
+	ctx.RegisterBottomUpMutator("post_transition_deps", postTransitionDepsMutator)
+	ctx.RegisterModuleType("transition_module", newTransitionModule)
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	checkTransitionVariants(t, ctx, "A", []string{"a", "b"})
+	checkTransitionVariants(t, ctx, "B", []string{"x", "y"})
+	checkTransitionVariants(t, ctx, "C", []string{"a", "c"})
+	// D has no default variation, the incoming variations are added to its split.
+	checkTransitionVariants(t, ctx, "D", []string{"d", "a", "b"})
+
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "a"), "B(y)", "C(a)", "D(a)", "B(y)")
+	checkTransitionDeps(t, ctx, getTransitionModule(ctx, "A", "b"), "B(y)", "C(c)", "D(b)", "B(y)")
+}
+
 type transitionTestMutator struct{}
 
 func (transitionTestMutator) Split(ctx BaseModuleContext) []string {
@@ -534,6 +3312,14 @@ func (transitionTestMutator) OutgoingTransition(ctx OutgoingTransitionContext, s
 	if err := ctx.Module().(*transitionModule).properties.Outgoing_transition_error; err != nil {
 		ctx.ModuleErrorf("Error: %s", *err)
 	}
+	if dep := ctx.DepModule(); dep != nil && dep.Name() != ctx.DepName() {
+		ctx.ModuleErrorf("DepModule %q does not match DepName %q", dep.Name(), ctx.DepName())
+	}
+	for _, outgoingFor := range ctx.Module().(*transitionModule).properties.Outgoing_for {
+		if dep, variation, _ := strings.Cut(outgoingFor, ":"); dep == ctx.DepName() {
+			return variation
+		}
+	}
 	if outgoing := ctx.Module().(*transitionModule).properties.Outgoing; outgoing != nil {
 		return *outgoing
 	}
@@ -544,6 +3330,9 @@ func (transitionTestMutator) IncomingTransition(ctx IncomingTransitionContext, i
 	if err := ctx.Module().(*transitionModule).properties.Incoming_transition_error; err != nil {
 		ctx.ModuleErrorf("Error: %s", *err)
 	}
+	if slices.Contains(ctx.Module().(*transitionModule).properties.Skip_incoming, incomingVariation) {
+		ctx.SkipIncoming()
+	}
 	if ctx.IsAddingDependency() {
 		if incoming := ctx.Module().(*transitionModule).properties.Post_transition_incoming; incoming != nil {
 			return *incoming
@@ -561,31 +3350,63 @@ func (transitionTestMutator) Mutate(ctx BottomUpMutatorContext, variation string
 
 type transitionModule struct {
 	SimpleName
+	SimpleEnabled
 	properties struct {
 		Deps                                   []string
 		Post_transition_deps                   []string
+		Skip_mutators                          []string
 		Post_transition_far_deps               []string
 		Post_transition_reverse_deps           []string
 		Post_transition_reverse_variation_deps []string
+		Post_transition_reverse_creating_deps  []string
 		Split                                  []string
 		Outgoing                               *string
+		Outgoing_for                           []string
 		Incoming                               *string
 		Post_transition_incoming               *string
 		Outgoing_transition_error              *string
 		Incoming_transition_error              *string
+		Build_all_variants                     *bool
+		Skip_incoming                          []string
+		Default_variation                      *string
+		Stem                                   *string
+
+		Target struct {
+			A struct {
+				Post_transition_deps []string
+				Stem                 *string
+			}
+			B struct {
+				Stem *string
+			}
+		}
 
-		Mutated string `blueprint:"mutated"`
+		Mutated     string   `blueprint:"mutated"`
+		Pushed_from []string `blueprint:"mutated"`
 	}
 }
 
 func newTransitionModule() (Module, []interface{}) {
 	m := &transitionModule{}
-	return m, []interface{}{&m.properties, &m.SimpleName.Properties}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleEnabled.Properties}
 }
 
 func (f *transitionModule) GenerateBuildActions(ModuleContext) {
 }
 
+func (f *transitionModule) VariantProperties(mutator, variation string) []interface{} {
+	if mutator != "transition" {
+		return nil
+	}
+	switch variation {
+	case "a":
+		return []interface{}{&f.properties.Target.A}
+	case "b":
+		return []interface{}{&f.properties.Target.B}
+	}
+	return nil
+}
+
 func (f *transitionModule) Deps() []string {
 	return f.properties.Deps
 }
@@ -594,18 +3415,45 @@ func (f *transitionModule) IgnoreDeps() []string {
 	return nil
 }
 
-var nameAndVariantRegexp = regexp.MustCompile(`([a-zA-Z0-9_]+)\(([a-zA-Z0-9_]+)\)`)
+func (f *transitionModule) BuildAllVariants(mutator string) bool {
+	return proptools.Bool(f.properties.Build_all_variants)
+}
 
-func postTransitionDepsMutator(mctx BottomUpMutatorContext) {
+// parseNameAndVariations parses a dependency of the form "name(variant)", which selects a variation of
+// the "transition" mutator, or "name(mutator:variant,mutator2:variant2)".
+func parseNameAndVariations(dep string) (string, []Variation, error) {
+	name, rest, ok := strings.Cut(dep, "(")
+	list, ok2 := strings.CutSuffix(rest, ")")
+	if !ok || !ok2 || name == "" {
+		return "", nil, fmt.Errorf("invalid dependency %q, expected module_name(variant) or "+
+			"module_name(mutator:variant,...)", dep)
+	}
+	var variations []Variation
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		mutator, variation, ok := strings.Cut(v, ":")
+		if !ok {
+			mutator, variation = "transition", v
+		}
+		if mutator == "" || strings.ContainsAny(variation, ":()") {
+			return "", nil, fmt.Errorf("invalid variation %q in dependency %q", v, dep)
+		}
+		variations = append(variations, Variation{Mutator: mutator, Variation: variation})
+	}
+	return name, variations, nil
+}
+
+func skipMutatorsMutator(mctx BottomUpMutatorContext) {
 	if m, ok := mctx.Module().(*transitionModule); ok {
-		for _, dep := range m.properties.Post_transition_deps {
-			module, variation, _ := strings.Cut(dep, ":")
-			var variations []Variation
-			if variation != "" {
-				variations = append(variations, Variation{"transition", variation})
-			}
-			mctx.AddVariationDependencies(variations, walkerDepsTag{follow: true}, module)
+		for _, name := range m.properties.Skip_mutators {
+			mctx.SkipMutator(name)
 		}
+	}
+}
+
+func postTransitionDepsMutator(mctx BottomUpMutatorContext) {
+	if m, ok := mctx.Module().(*transitionModule); ok {
+		mctx.AddVariationDependencies(nil, walkerDepsTag{follow: true}, m.properties.Post_transition_deps...)
 		for _, dep := range m.properties.Post_transition_far_deps {
 			mctx.AddFarVariationDependencies(nil, walkerDepsTag{follow: true}, dep)
 		}
@@ -613,13 +3461,14 @@ func postTransitionDepsMutator(mctx BottomUpMutatorContext) {
 			mctx.AddReverseDependency(m, walkerDepsTag{follow: true}, dep)
 		}
 		for _, dep := range m.properties.Post_transition_reverse_variation_deps {
-			match := nameAndVariantRegexp.FindStringSubmatch(dep)
-			if len(match) == 0 || match[0] != dep {
-				panic(fmt.Sprintf("Invalid Post_transition_reverse_variation_deps: %q. Expected module_name(variant)", dep))
+			name, variations, err := parseNameAndVariations(dep)
+			if err != nil {
+				panic(err)
 			}
-			mctx.AddReverseVariationDependency([]Variation{
-				{Mutator: "transition", Variation: match[2]},
-			}, walkerDepsTag{follow: true}, match[1])
+			mctx.AddReverseVariationDependency(variations, walkerDepsTag{follow: true}, name)
+		}
+		for _, dep := range m.properties.Post_transition_reverse_creating_deps {
+			mctx.AddReverseVariationDependencyCreatingVariant(nil, walkerDepsTag{follow: true}, dep)
 		}
 	}
 }
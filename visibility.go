@@ -0,0 +1,128 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// A VisibilityModule is a Module that restricts which other modules may depend on it.  Visibility returns a list
+// of rules, and a module may only add a dependency on it if it matches at least one of them.  A package is the
+// directory of a Blueprints file relative to the source directory, and the rules are:
+//
+//   - "//visibility:public": any module.
+//   - "//visibility:private": only modules in the same package.
+//   - "//pkg:__pkg__": modules in package pkg.
+//   - "//pkg:__subpackages__": modules in package pkg or any package below it.
+//   - ":__pkg__" and ":__subpackages__": the same, relative to the package of the module.
+//
+// Modules in the same package and other variants of the same module are always allowed.  An empty list of rules
+// means the module is public.  Visibility is checked when a dependency, reverse dependency or order-only
+// dependency is added and when a dependency is replaced, so it should not be modified by mutators.
+type VisibilityModule interface {
+	Module
+
+	Visibility() []string
+}
+
+// SimpleVisibility is an embeddable object to implement VisibilityModule using a property called "visibility".
+// Modules that embed it must also add SimpleVisibility.Properties to their property structure list.
+type SimpleVisibility struct {
+	Properties struct {
+		Visibility []string
+	}
+}
+
+func (s *SimpleVisibility) Visibility() []string {
+	return s.Properties.Visibility
+}
+
+// checkVisibility returns an error if module is not allowed to depend on dep by the visibility rules of dep.
+func (c *Context) checkVisibility(module, dep *moduleInfo) error {
+	visibilityModule, ok := dep.logicModule.(VisibilityModule)
+	if !ok || module.group == dep.group {
+		return nil
+	}
+	rules := visibilityModule.Visibility()
+	if len(rules) == 0 {
+		return nil
+	}
+
+	pkg := visibilityPackage(module)
+	depPkg := visibilityPackage(dep)
+	if pkg == depPkg {
+		return nil
+	}
+
+	for _, rule := range rules {
+		visible, err := visibilityRuleMatches(rule, depPkg, pkg)
+		if err != nil {
+			return c.moduleErrorf(dep, "%s", err)
+		}
+		if visible {
+			return nil
+		}
+	}
+
+	return &BlueprintError{
+		// seven characters at the start of the second line to align with the string "error: "
+		Err: fmt.Errorf("%q depends on %q, which is not visible to it\n"+
+			"       %s <-- %q defined here with visibility %q",
+			module.Name(), dep.Name(), dep.pos, dep.Name(), rules),
+		Pos: module.pos,
+	}
+}
+
+// visibilityPackage returns the package of module for visibility rules, which is the directory of its
+// Blueprints file, or "" for the root directory.
+func visibilityPackage(module *moduleInfo) string {
+	dir := filepath.Dir(module.relBlueprintsFile)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// visibilityRuleMatches returns true if rule, a visibility rule of a module in package depPkg, allows a module in
+// package pkg to depend on it.
+func visibilityRuleMatches(rule, depPkg, pkg string) (bool, error) {
+	switch rule {
+	case "//visibility:public":
+		return true, nil
+	case "//visibility:private":
+		return pkg == depPkg, nil
+	}
+
+	rulePkg, target, ok := strings.Cut(rule, ":")
+	if !ok || (rulePkg != "" && !strings.HasPrefix(rulePkg, "//")) {
+		return false, fmt.Errorf("invalid visibility rule %q", rule)
+	}
+	if rulePkg == "" {
+		rulePkg = depPkg
+	} else {
+		rulePkg = strings.TrimPrefix(rulePkg, "//")
+	}
+
+	switch target {
+	case "__pkg__":
+		return pkg == rulePkg, nil
+	case "__subpackages__":
+		return rulePkg == "" || pkg == rulePkg || strings.HasPrefix(pkg, rulePkg+"/"), nil
+	default:
+		return false, fmt.Errorf("invalid visibility rule %q, expected a target of __pkg__ or __subpackages__", rule)
+	}
+}
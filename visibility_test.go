@@ -0,0 +1,216 @@
+// Copyright 2026 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+type visibilityTestModule struct {
+	SimpleName
+	SimpleVisibility
+	properties struct {
+		Deps            []string
+		Reverse_deps    []string
+		Order_only_deps []string
+		Replaces        []string
+	}
+}
+
+func newVisibilityTestModule() (Module, []interface{}) {
+	m := &visibilityTestModule{}
+	return m, []interface{}{&m.properties, &m.SimpleName.Properties, &m.SimpleVisibility.Properties}
+}
+
+func (m *visibilityTestModule) GenerateBuildActions(ModuleContext) {}
+
+func runVisibilityTest(t *testing.T, fs map[string][]byte) []error {
+	t.Helper()
+	var fileList []string
+	for f := range fs {
+		fileList = append(fileList, f)
+	}
+	slices.Sort(fileList)
+
+	ctx := NewContext()
+	ctx.MockFileSystem(fs)
+	ctx.RegisterModuleType("visibility_module", newVisibilityTestModule)
+	ctx.RegisterBottomUpMutator("deps", func(mctx BottomUpMutatorContext) {
+		m := mctx.Module().(*visibilityTestModule)
+		mctx.AddDependency(m, nil, m.properties.Deps...)
+	})
+	ctx.RegisterBottomUpMutator("edges", func(mctx BottomUpMutatorContext) {
+		m := mctx.Module().(*visibilityTestModule)
+		for _, name := range m.properties.Reverse_deps {
+			mctx.AddReverseDependency(m, nil, name)
+		}
+		mctx.AddOrderOnlyDependency(m.properties.Order_only_deps...)
+		for _, name := range m.properties.Replaces {
+			mctx.ReplaceDependencies(name)
+		}
+	}).UsesReverseDependencies().UsesReplaceDependencies()
+
+	_, errs := ctx.ParseFileList(".", fileList, nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	return errs
+}
+
+func TestVisibility(t *testing.T) {
+	testCases := []struct {
+		name       string
+		visibility string
+		err        string
+	}{
+		{
+			name:       "unset",
+			visibility: ``,
+		},
+		{
+			name:       "public",
+			visibility: `"//visibility:public"`,
+		},
+		{
+			name:       "private",
+			visibility: `"//visibility:private"`,
+			err: `a/b/Android.bp:2:5: "user" depends on "lib", which is not visible to it
+       lib/Android.bp:2:5 <-- "lib" defined here with visibility ["//visibility:private"]`,
+		},
+		{
+			name:       "pkg",
+			visibility: `"//a/b:__pkg__"`,
+		},
+		{
+			name:       "other pkg",
+			visibility: `"//a:__pkg__", "//c/d:__pkg__"`,
+			err: `a/b/Android.bp:2:5: "user" depends on "lib", which is not visible to it
+       lib/Android.bp:2:5 <-- "lib" defined here with visibility ["//a:__pkg__" "//c/d:__pkg__"]`,
+		},
+		{
+			name:       "subpackages",
+			visibility: `"//a:__subpackages__"`,
+		},
+		{
+			name:       "root subpackages",
+			visibility: `"//:__subpackages__"`,
+		},
+		{
+			name:       "prefix is not a parent package",
+			visibility: `"//a/b/c:__subpackages__", "//a/bb:__subpackages__"`,
+			err: `a/b/Android.bp:2:5: "user" depends on "lib", which is not visible to it
+       lib/Android.bp:2:5 <-- "lib" defined here with visibility ["//a/b/c:__subpackages__" "//a/bb:__subpackages__"]`,
+		},
+		{
+			name:       "relative",
+			visibility: `":__subpackages__"`,
+			err: `a/b/Android.bp:2:5: "user" depends on "lib", which is not visible to it
+       lib/Android.bp:2:5 <-- "lib" defined here with visibility [":__subpackages__"]`,
+		},
+		{
+			name:       "invalid",
+			visibility: `"//a/b"`,
+			err:        `lib/Android.bp:2:5: module "lib": invalid visibility rule "//a/b"`,
+		},
+		{
+			name:       "invalid target",
+			visibility: `"//a/b:foo"`,
+			err:        `lib/Android.bp:2:5: module "lib": invalid visibility rule "//a/b:foo", expected a target of __pkg__ or __subpackages__`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := runVisibilityTest(t, map[string][]byte{
+				"lib/Android.bp": []byte(fmt.Sprintf(`
+				visibility_module {
+					name: "lib",
+					visibility: [%s],
+				}
+
+				visibility_module {
+					name: "lib_user",
+					deps: ["lib"],
+				}
+				`, tc.visibility)),
+				"a/b/Android.bp": []byte(`
+				visibility_module {
+					name: "user",
+					deps: ["lib"],
+				}
+				`),
+			})
+			if tc.err == "" {
+				assertNoErrors(t, errs)
+			} else if len(errs) != 1 || errs[0].Error() != tc.err {
+				t.Errorf("expected error:\n%s\ngot:\n%q", tc.err, errs)
+			}
+		})
+	}
+}
+
+func TestVisibilityOtherEdges(t *testing.T) {
+	const err = `a/b/Android.bp:2:5: "user" depends on "lib", which is not visible to it
+       lib/Android.bp:2:5 <-- "lib" defined here with visibility ["//visibility:private"]`
+
+	testCases := []struct {
+		name string
+		lib  string
+		user string
+	}{
+		{
+			name: "reverse dependency",
+			lib:  `reverse_deps: ["user"],`,
+		},
+		{
+			name: "order-only dependency",
+			user: `order_only_deps: ["lib"],`,
+		},
+		{
+			name: "replaced dependency",
+			lib:  `replaces: ["old"],`,
+			user: `deps: ["old"],`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := runVisibilityTest(t, map[string][]byte{
+				"lib/Android.bp": []byte(fmt.Sprintf(`
+				visibility_module {
+					name: "lib",
+					visibility: ["//visibility:private"],
+					%s
+				}
+
+				visibility_module {
+					name: "old",
+					deps: ["lib"],
+				}
+				`, tc.lib)),
+				"a/b/Android.bp": []byte(fmt.Sprintf(`
+				visibility_module {
+					name: "user",
+					%s
+				}
+				`, tc.user)),
+			})
+			if len(errs) != 1 || errs[0].Error() != err {
+				t.Errorf("expected error:\n%s\ngot:\n%q", err, errs)
+			}
+		})
+	}
+}
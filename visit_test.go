@@ -15,18 +15,24 @@
 package blueprint
 
 import (
+	"encoding/json"
 	"fmt"
+	"slices"
+	"strings"
 	"testing"
 )
 
 type visitModule struct {
 	SimpleName
 	properties struct {
-		Visit                 []string
-		VisitDepsDepthFirst   string `blueprint:"mutated"`
-		VisitDepsDepthFirstIf string `blueprint:"mutated"`
-		VisitDirectDeps       string `blueprint:"mutated"`
-		VisitDirectDepsIf     string `blueprint:"mutated"`
+		Visit                  []string
+		Visit_other            []string
+		Visit_named            []string
+		VisitDepsDepthFirst    string `blueprint:"mutated"`
+		VisitDepsDepthFirstIf  string `blueprint:"mutated"`
+		VisitDirectDeps        string `blueprint:"mutated"`
+		VisitDirectDepsIf      string `blueprint:"mutated"`
+		VisitDirectDepsWithTag string `blueprint:"mutated"`
 	}
 }
 
@@ -44,9 +50,25 @@ type visitTag struct {
 
 var visitTagDep visitTag
 
+type visitOtherTag struct {
+	BaseDependencyTag
+}
+
+var visitOtherTagDep visitOtherTag
+
+type visitNamedTag struct {
+	BaseDependencyTag
+}
+
+func (visitNamedTag) Name() string { return "named" }
+
+var visitNamedTagDep visitNamedTag
+
 func visitDepsMutator(ctx BottomUpMutatorContext) {
 	if m, ok := ctx.Module().(*visitModule); ok {
 		ctx.AddDependency(ctx.Module(), visitTagDep, m.properties.Visit...)
+		ctx.AddDependency(ctx.Module(), visitOtherTagDep, m.properties.Visit_other...)
+		ctx.AddDependency(ctx.Module(), visitNamedTagDep, m.properties.Visit_named...)
 	}
 }
 
@@ -162,6 +184,260 @@ func TestVisit(t *testing.T) {
 	assertString(t, eModule.properties.VisitDirectDepsIf, "FF")
 }
 
+func TestVisitDirectDepsWithTag(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("visit_module", newVisitModule)
+	ctx.RegisterBottomUpMutator("visit_deps", visitDepsMutator)
+	ctx.RegisterBottomUpMutator("visit_with_tag", func(ctx BottomUpMutatorContext) {
+		m := ctx.Module().(*visitModule)
+		ctx.VisitDirectDepsWithTag(visitOtherTagDep, func(dep Module) {
+			if ctx.OtherModuleDependencyTag(dep) != visitOtherTagDep {
+				panic(fmt.Errorf("unexpected dependency tag on %q", ctx.OtherModuleName(dep)))
+			}
+			m.properties.VisitDirectDepsWithTag += ctx.OtherModuleName(dep)
+		})
+	})
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			visit_module {
+				name: "A",
+				visit: ["B", "C"],
+				visit_other: ["D", "B"],
+			}
+
+			visit_module {
+				name: "B",
+			}
+
+			visit_module {
+				name: "C",
+			}
+
+			visit_module {
+				name: "D",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	a := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule.(*visitModule)
+	assertString(t, a.properties.VisitDirectDepsWithTag, "DB")
+
+	var visited string
+	ctx.VisitDirectDepsWithTag(a, visitTagDep, func(dep Module) {
+		visited += ctx.ModuleName(dep)
+	})
+	assertString(t, visited, "BC")
+}
+
+type visitListTag struct {
+	BaseDependencyTag
+	names []string
+}
+
+func TestVisitDirectDepsWithTagNotComparable(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("visit_module", newVisitModule)
+	ctx.RegisterBottomUpMutator("visit_deps", func(ctx BottomUpMutatorContext) {
+		if ctx.ModuleName() == "A" {
+			ctx.AddDependency(ctx.Module(), visitListTag{names: []string{"b"}}, "B")
+			ctx.AddDependency(ctx.Module(), visitListTag{names: []string{"c"}}, "C")
+			ctx.AddDependency(ctx.Module(), visitTagDep, "D")
+		}
+	})
+	ctx.RegisterBottomUpMutator("visit_with_tag", func(ctx BottomUpMutatorContext) {
+		m := ctx.Module().(*visitModule)
+		ctx.VisitDirectDepsWithTag(visitListTag{names: []string{"b"}}, func(dep Module) {
+			m.properties.VisitDirectDepsWithTag += ctx.OtherModuleName(dep)
+		})
+	})
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			visit_module {
+				name: "A",
+			}
+
+			visit_module {
+				name: "B",
+			}
+
+			visit_module {
+				name: "C",
+			}
+
+			visit_module {
+				name: "D",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	a := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule.(*visitModule)
+	assertString(t, a.properties.VisitDirectDepsWithTag, "B")
+
+	var visited string
+	ctx.VisitDirectDepsWithTag(a, visitListTag{names: []string{"c"}}, func(dep Module) {
+		visited += ctx.ModuleName(dep)
+	})
+	assertString(t, visited, "C")
+}
+
+func TestVisitDirectDepsWithTagName(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("visit_module", newVisitModule)
+	ctx.RegisterDependencyTag("other", visitOtherTagDep)
+	ctx.RegisterBottomUpMutator("visit_deps", visitDepsMutator)
+	ctx.RegisterBottomUpMutator("visit_with_tag", func(ctx BottomUpMutatorContext) {
+		m := ctx.Module().(*visitModule)
+		ctx.VisitDirectDepsWithTagName("other", func(dep Module) {
+			if ctx.OtherModuleDependencyTag(dep) != visitOtherTagDep {
+				panic(fmt.Errorf("unexpected dependency tag on %q", ctx.OtherModuleName(dep)))
+			}
+			m.properties.VisitDirectDepsWithTag += ctx.OtherModuleName(dep)
+		})
+	})
+
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			visit_module {
+				name: "A",
+				visit: ["B"],
+				visit_other: ["D", "B"],
+				visit_named: ["C"],
+			}
+
+			visit_module {
+				name: "B",
+			}
+
+			visit_module {
+				name: "C",
+			}
+
+			visit_module {
+				name: "D",
+			}
+		`),
+	})
+
+	_, errs := ctx.ParseBlueprintsFiles("Android.bp", nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	a := ctx.moduleGroupFromName("A", nil).modules.firstModule().logicModule.(*visitModule)
+	assertString(t, a.properties.VisitDirectDepsWithTag, "DB")
+
+	var visited string
+	ctx.VisitDirectDepsWithTagName(a, "named", func(dep Module) {
+		visited += ctx.ModuleName(dep)
+	})
+	assertString(t, visited, "C")
+
+	if tag, ok := ctx.DependencyTagByName("other"); !ok || tag != visitOtherTagDep {
+		t.Errorf("expected tag %#v registered as \"other\", got %#v", visitOtherTagDep, tag)
+	}
+	if _, ok := ctx.DependencyTagByName("named"); ok {
+		t.Errorf("expected no tag registered as \"named\"")
+	}
+	assertString(t, ctx.DependencyTagName(visitTagDep), "")
+
+	graph := &strings.Builder{}
+	ctx.PrintJSONGraphAndActions(graph, &strings.Builder{})
+	var modules []JsonModule
+	if err := json.Unmarshal([]byte(graph.String()), &modules); err != nil {
+		t.Fatalf("invalid JSON graph: %s", err)
+	}
+	var tagNames []string
+	for _, m := range modules {
+		if m.Name == "A" {
+			for _, dep := range m.Deps {
+				tagNames = append(tagNames, dep.Name+":"+dep.TagName)
+			}
+		}
+	}
+	if g, w := tagNames, []string{"B:", "D:other", "B:other", "C:named"}; !slices.Equal(g, w) {
+		t.Errorf("expected dependency tag names in JSON graph %q, got %q", w, g)
+	}
+}
+
+func TestVisitDirectReverseDeps(t *testing.T) {
+	ctx := setupVisitTest(t)
+
+	visitReverseDeps := func(name string) string {
+		var visited string
+		m := ctx.moduleGroupFromName(name, nil).modules.firstModule().logicModule
+		ctx.VisitDirectReverseDeps(m, func(dep Module) {
+			visited += ctx.ModuleName(dep)
+		})
+		return visited
+	}
+
+	assertString(t, visitReverseDeps("A"), "")
+	assertString(t, visitReverseDeps("B"), "A")
+	assertString(t, visitReverseDeps("D"), "BC")
+	assertString(t, visitReverseDeps("F"), "EE")
+}
+
+func TestVisitDirectReverseDepsNamespaces(t *testing.T) {
+	ctx := NewContext()
+	ctx.MockFileSystem(map[string][]byte{
+		"Android.bp": []byte(`
+			foo_module {
+				name: "libfoo",
+			}
+
+			foo_module {
+				name: "user",
+				deps: ["libfoo", "libfoo"],
+			}
+		`),
+		"vendor/a/Android.bp": []byte(`
+			foo_module {
+				name: "user",
+				deps: ["//:libfoo"],
+			}
+		`),
+		"vendor/b/Android.bp": []byte(`
+			foo_module {
+				name: "user",
+				deps: ["//:libfoo"],
+			}
+		`),
+	})
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterBottomUpMutator("deps", depsMutator)
+	if err := ctx.RegisterNamespace("vendor/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.RegisterNamespace("vendor/b"); err != nil {
+		t.Fatal(err)
+	}
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp", "vendor/a/Android.bp", "vendor/b/Android.bp"}, nil)
+	assertNoErrors(t, errs)
+	_, errs = ctx.ResolveDependencies(nil)
+	assertNoErrors(t, errs)
+
+	var visited []string
+	ctx.VisitDirectReverseDeps(ctx.moduleGroupFromName("libfoo", nil).modules.firstModule().logicModule,
+		func(dep Module) {
+			visited = append(visited, ctx.ModuleDir(dep)+":"+ctx.ModuleName(dep))
+		})
+	if g, w := visited, []string{"vendor/a:user", "vendor/b:user", ".:user", ".:user"}; !slices.Equal(g, w) {
+		t.Errorf("expected reverse dependencies %q, got %q", w, g)
+	}
+}
+
 func assertString(t *testing.T, got, expected string) {
 	if got != expected {
 		t.Errorf("expected %q got %q", expected, got)